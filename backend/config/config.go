@@ -3,6 +3,9 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -16,6 +19,82 @@ type Config struct {
 	JWTSecret  string
 	Port       string
 	WebhookURL string
+
+	// Access tokens are signed with the RSA or Ed25519 private key in the
+	// JWTSigningKey PEM file, or else with JWTSecret; the public keys in
+	// JWTVerificationKeys are accepted too while keys are rotated
+	JWTSigningKey       string
+	JWTVerificationKeys []string
+
+	// Accounts are locked for LoginLockoutDuration after LoginMaxFailures
+	// consecutive failed logins, and IP addresses are refused logins after
+	// LoginIPMaxFailures failures within that duration. Each failure doubles
+	// the wait before the account's next attempt, starting at LoginDelay.
+	LoginMaxFailures     int
+	LoginIPMaxFailures   int
+	LoginLockoutDuration time.Duration
+	LoginDelay           time.Duration
+
+	// Behind a reverse proxy, the client address used for the login limits
+	// is read from ProxyHeader, but only on requests coming from one of
+	// TrustedProxies (IP addresses or CIDR ranges). Without trusted proxies
+	// the address of the connection is used.
+	TrustedProxies []string
+	ProxyHeader    string
+
+	// Password reset links are PasswordResetURL with the token appended as
+	// #token=..., valid for PasswordResetTTL
+	PasswordResetURL string
+	PasswordResetTTL time.Duration
+
+	// Outgoing mail: Mailer is log, file (appended to MailFile) or smtp.
+	// SMTPTimeout bounds a whole SMTP conversation.
+	Mailer       string
+	MailFile     string
+	MailFrom     string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	SMTPTimeout  time.Duration
+
+	// Issuer shown by authenticator apps for two-factor codes
+	TOTPIssuer string
+
+	// Lifetime of access tokens, and of the refresh tokens that renew them
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	// Webhook delivery
+	WebhookSecret       string
+	WebhookMaxAttempts  int
+	WebhookTimeout      time.Duration
+	WebhookPollInterval time.Duration
+
+	// Items without a reorder point are reported as low stock at or below
+	// this stock level
+	LowStockThreshold int
+
+	// Currency that reports and base-currency totals are expressed in
+	BaseCurrency string
+
+	// Format of purchase order, return and stock transfer numbers, e.g.
+	// PO/{YYYY}/{MM}/{SEQ:4}
+	PurchaseNumberFormat       string
+	PurchaseReturnNumberFormat string
+	StockTransferNumberFormat  string
+
+	// How often items at or below their reorder point are checked and
+	// draft purchases suggested for them; zero disables the check
+	ReorderCheckInterval time.Duration
+
+	// Three-way match tolerances in percent, e.g. 2.5
+	MatchQtyTolerance   string
+	MatchPriceTolerance string
+
+	// Initial administrator, created on startup if it does not exist
+	AdminUsername string
+	AdminPassword string
 }
 
 var AppConfig *Config
@@ -32,9 +111,58 @@ func LoadConfig() {
 		DBUser:     getEnv("DB_USER", "postgres"),
 		DBPassword: getEnv("DB_PASSWORD", "postgres"),
 		DBName:     getEnv("DB_NAME", "procurement_db"),
-		JWTSecret:  getEnv("JWT_SECRET", "secret"),
+		JWTSecret:  getEnv("JWT_SECRET", ""),
 		Port:       getEnv("PORT", "3000"),
 		WebhookURL: getEnv("WEBHOOK_URL", ""),
+
+		JWTSigningKey:       getEnv("JWT_SIGNING_KEY", ""),
+		JWTVerificationKeys: getEnvList("JWT_VERIFICATION_KEYS"),
+
+		LoginMaxFailures:     getEnvInt("LOGIN_MAX_FAILURES", 5),
+		LoginIPMaxFailures:   getEnvInt("LOGIN_IP_MAX_FAILURES", 20),
+		LoginLockoutDuration: getEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		LoginDelay:           getEnvDuration("LOGIN_DELAY", time.Second),
+
+		TrustedProxies: getEnvList("TRUSTED_PROXIES"),
+		ProxyHeader:    getEnv("PROXY_HEADER", "X-Real-IP"),
+
+		PasswordResetURL: getEnv("PASSWORD_RESET_URL", "http://localhost:8080/reset-password.html"),
+		PasswordResetTTL: getEnvDuration("PASSWORD_RESET_TTL", time.Hour),
+
+		Mailer:       strings.ToLower(getEnv("MAILER", "log")),
+		MailFile:     getEnv("MAIL_FILE", "mail.log"),
+		MailFrom:     getEnv("MAIL_FROM", ""),
+		SMTPHost:     getEnv("SMTP_HOST", ""),
+		SMTPPort:     getEnv("SMTP_PORT", "587"),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+		SMTPTimeout:  getEnvDuration("SMTP_TIMEOUT", 30*time.Second),
+
+		TOTPIssuer: getEnv("TOTP_ISSUER", "Procurement System"),
+
+		AccessTokenTTL:  getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL: getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+
+		WebhookSecret:       getEnv("WEBHOOK_SECRET", ""),
+		WebhookMaxAttempts:  getEnvInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		WebhookTimeout:      getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		WebhookPollInterval: getEnvDuration("WEBHOOK_POLL_INTERVAL", 5*time.Second),
+
+		LowStockThreshold: getEnvInt("LOW_STOCK_THRESHOLD", 10),
+
+		BaseCurrency: strings.ToUpper(getEnv("BASE_CURRENCY", "IDR")),
+
+		PurchaseNumberFormat:       getEnv("PURCHASE_NUMBER_FORMAT", "PO/{YYYY}/{MM}/{SEQ:4}"),
+		PurchaseReturnNumberFormat: getEnv("PURCHASE_RETURN_NUMBER_FORMAT", "RTN/{YYYY}/{MM}/{SEQ:4}"),
+		StockTransferNumberFormat:  getEnv("STOCK_TRANSFER_NUMBER_FORMAT", "TRF/{YYYY}/{MM}/{SEQ:4}"),
+
+		ReorderCheckInterval: getEnvDuration("REORDER_CHECK_INTERVAL", time.Hour),
+
+		MatchQtyTolerance:   getEnv("MATCH_QTY_TOLERANCE", "0"),
+		MatchPriceTolerance: getEnv("MATCH_PRICE_TOLERANCE", "0"),
+
+		AdminUsername: getEnv("ADMIN_USERNAME", ""),
+		AdminPassword: getEnv("ADMIN_PASSWORD", ""),
 	}
 }
 
@@ -44,3 +172,42 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvList splits a comma-separated variable, skipping empty entries
+func getEnvList(key string) []string {
+	var list []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Warning: %s must be an integer, using default %d", key, defaultValue)
+		return defaultValue
+	}
+	return n
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Warning: %s must be a duration such as 30s or 15m, using default %s", key, defaultValue)
+		return defaultValue
+	}
+	return d
+}
@@ -5,7 +5,9 @@ import (
 	"log"
 	"procurement-system/config"
 	"procurement-system/models"
+	"strings"
 
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -13,6 +15,11 @@ import (
 
 var DB *gorm.DB
 
+// reclaimAdmin is set when Migrate demoted the users from before roles were
+// enforced, so that Seed takes back an existing account named
+// ADMIN_USERNAME instead of trusting whoever registered it
+var reclaimAdmin bool
+
 func Connect() {
 	dsn := fmt.Sprintf(
 		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
@@ -35,15 +42,328 @@ func Connect() {
 }
 
 func Migrate() {
+	// Purchases created before the approval workflow already moved stock
+	legacyPurchases := DB.Migrator().HasTable(&models.Purchasing{}) &&
+		!DB.Migrator().HasColumn(&models.Purchasing{}, "Status")
+
+	// Purchases received before goods receipts existed were received in full
+	legacyReceipts := DB.Migrator().HasTable(&models.PurchasingDetail{}) &&
+		!DB.Migrator().HasColumn(&models.PurchasingDetail{}, "ReceivedQty")
+
+	// Lines created before prices were snapshotted only stored the subtotal
+	legacyLinePrices := DB.Migrator().HasTable(&models.PurchasingDetail{}) &&
+		!DB.Migrator().HasColumn(&models.PurchasingDetail{}, "UnitPrice")
+
+	// Suppliers and purchases created before multi-currency support are in
+	// the base currency
+	legacySupplierCurrency := DB.Migrator().HasTable(&models.Supplier{}) &&
+		!DB.Migrator().HasColumn(&models.Supplier{}, "Currency")
+	legacyPurchaseCurrency := DB.Migrator().HasTable(&models.Purchasing{}) &&
+		!DB.Migrator().HasColumn(&models.Purchasing{}, "Currency")
+
+	// Purchases created before the tax engine only stored the grand total
+	legacyPurchaseTotals := DB.Migrator().HasTable(&models.Purchasing{}) &&
+		!DB.Migrator().HasColumn(&models.Purchasing{}, "Subtotal")
+	legacyTaxBase := DB.Migrator().HasTable(&models.PurchasingDetail{}) &&
+		!DB.Migrator().HasColumn(&models.PurchasingDetail{}, "TaxBase")
+
+	// Invoices recorded before three-way matching stay payable
+	legacyInvoices := DB.Migrator().HasTable(&models.SupplierInvoice{}) &&
+		!DB.Migrator().HasColumn(&models.SupplierInvoice{}, "MatchStatus")
+
+	// Stock that existed before the ledger is booked as an opening balance
+	newLedger := !DB.Migrator().HasTable(&models.StockMovement{})
+
+	// Stock, movements and purchases from before warehouses belong to the
+	// default warehouse
+	legacyMovements := DB.Migrator().HasTable(&models.StockMovement{}) &&
+		!DB.Migrator().HasColumn(&models.StockMovement{}, "WarehouseID")
+	legacyPurchaseWarehouse := DB.Migrator().HasTable(&models.Purchasing{}) &&
+		!DB.Migrator().HasColumn(&models.Purchasing{}, "WarehouseID")
+	newItemStocks := !DB.Migrator().HasTable(&models.ItemStock{})
+
+	// Items already bought from a supplier are listed in its catalog
+	newCatalog := !DB.Migrator().HasTable(&models.SupplierItem{})
+
+	// Users created before roles were enforced chose their own role, which
+	// the old "user" column default gives away
+	legacyRoles := DB.Migrator().HasTable(&models.User{}) &&
+		strings.Contains(columnDefault("users", "role"), "'user'")
+
+	// Only one warehouse may be the default. Before an index enforced it,
+	// concurrent updates could leave several; the oldest one keeps the flag.
+	if DB.Migrator().HasTable(&models.Warehouse{}) && !DB.Migrator().HasIndex(&models.Warehouse{}, "idx_warehouses_default") {
+		DB.Exec(`UPDATE warehouses SET is_default = false
+			WHERE is_default AND deleted_at IS NULL AND id <> (
+				SELECT MIN(id) FROM warehouses WHERE is_default AND deleted_at IS NULL)`)
+	}
+
+	convertMoneyColumns()
+
 	err := DB.AutoMigrate(
 		&models.User{},
+		&models.RefreshToken{},
+		&models.RevokedToken{},
+		&models.LoginAttempt{},
+		&models.PasswordResetToken{},
+		&models.RecoveryCode{},
+		&models.LoginChallenge{},
+		&models.TwoFactorRole{},
+		&models.TaxCode{},
 		&models.Supplier{},
+		&models.ExchangeRate{},
+		&models.Warehouse{},
 		&models.Item{},
+		&models.ItemStock{},
+		&models.SupplierItem{},
+		&models.DocumentSequence{},
 		&models.Purchasing{},
 		&models.PurchasingDetail{},
+		&models.PurchasingStatus{},
+		&models.GoodsReceipt{},
+		&models.GoodsReceiptDetail{},
+		&models.PurchaseReturn{},
+		&models.PurchaseReturnDetail{},
+		&models.StockTransfer{},
+		&models.StockTransferDetail{},
+		&models.SupplierInvoice{},
+		&models.SupplierInvoiceLine{},
+		&models.MatchVariance{},
+		&models.SupplierPayment{},
+		&models.StockMovement{},
+		&models.WebhookDelivery{},
+		&models.WebhookSubscription{},
 	)
 	if err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
+
+	if legacyPurchases {
+		DB.Model(&models.Purchasing{}).Where("1 = 1").Update("status", models.PurchaseStatusClosed)
+	}
+
+	if legacyReceipts {
+		DB.Model(&models.PurchasingDetail{}).
+			Where("purchasing_id IN (?)", DB.Model(&models.Purchasing{}).Select("id").Where("status IN ?", []string{models.PurchaseStatusReceived, models.PurchaseStatusClosed})).
+			Update("received_qty", gorm.Expr("qty"))
+	}
+
+	if legacySupplierCurrency {
+		DB.Model(&models.Supplier{}).Where("1 = 1").Update("currency", config.AppConfig.BaseCurrency)
+	}
+
+	if legacyPurchaseCurrency {
+		DB.Model(&models.Purchasing{}).Where("1 = 1").Updates(map[string]interface{}{
+			"currency":         config.AppConfig.BaseCurrency,
+			"exchange_rate":    models.OneRate,
+			"base_grand_total": gorm.Expr("grand_total"),
+		})
+	}
+
+	if legacyLinePrices {
+		DB.Model(&models.PurchasingDetail{}).Where("qty > 0").Updates(map[string]interface{}{
+			"unit_price": gorm.Expr("ROUND(sub_total / qty, 2)"),
+			"line_total": gorm.Expr("sub_total"),
+		})
+	}
+
+	if legacyTaxBase {
+		DB.Model(&models.PurchasingDetail{}).Where("1 = 1").
+			Update("tax_base", gorm.Expr("sub_total - discount"))
+	}
+
+	if legacyPurchaseTotals {
+		DB.Exec(`UPDATE purchasings p SET
+				subtotal = t.sub_total,
+				discount_total = t.discount,
+				tax_total = t.tax
+			FROM (SELECT purchasing_id, SUM(sub_total) AS sub_total, SUM(discount) AS discount, SUM(tax) AS tax
+				FROM purchasing_details GROUP BY purchasing_id) t
+			WHERE t.purchasing_id = p.id`)
+	}
+
+	if legacyInvoices {
+		DB.Model(&models.SupplierInvoice{}).Where("1 = 1").Updates(map[string]interface{}{
+			"match_status": models.MatchStatusMatched,
+			"matched_at":   gorm.Expr("created_at"),
+			"approved_at":  gorm.Expr("created_at"),
+		})
+	}
+
+	if newLedger {
+		DB.Exec(`INSERT INTO stock_movements (item_id, type, qty, balance_after, reference_type, note, created_at)
+			SELECT id, ?, stock, stock, ?, 'Opening balance', NOW() FROM items WHERE stock <> 0`,
+			models.MovementAdjustment, models.MovementRefOpeningBalance)
+	}
+
+	// Stock is always kept somewhere: make sure there is a default warehouse
+	var main models.Warehouse
+	if err := DB.Where("is_default = ?", true).First(&main).Error; err != nil {
+		main = models.Warehouse{Code: models.DefaultWarehouseCode, Name: "Main Warehouse", IsDefault: true}
+		if err := DB.Create(&main).Error; err != nil {
+			log.Fatal("Failed to create default warehouse:", err)
+		}
+	}
+
+	if newLedger || legacyMovements {
+		DB.Exec(`UPDATE stock_movements SET warehouse_id = ?, warehouse_balance_after = balance_after
+			WHERE warehouse_id IS NULL`, main.ID)
+	}
+
+	if newItemStocks {
+		DB.Exec(`INSERT INTO item_stocks (item_id, warehouse_id, stock, updated_at)
+			SELECT id, ?, stock, NOW() FROM items WHERE stock <> 0`, main.ID)
+	}
+
+	if legacyPurchaseWarehouse {
+		DB.Model(&models.Purchasing{}).Where("warehouse_id IS NULL").Update("warehouse_id", main.ID)
+	}
+
+	if newCatalog {
+		DB.Exec(`INSERT INTO supplier_items (supplier_id, item_id, unit_price, currency, min_order_qty, lead_time_days, created_at, updated_at)
+			SELECT DISTINCT p.supplier_id, d.item_id, i.price, ?, 1, 0, NOW(), NOW()
+			FROM purchasing_details d
+			JOIN purchasings p ON p.id = d.purchasing_id
+			JOIN items i ON i.id = d.item_id AND i.deleted_at IS NULL`, config.AppConfig.BaseCurrency)
+	}
+
+	// Self-chosen roles are not trusted: every user from before roles were
+	// enforced starts over as a viewer and must be promoted by an admin.
+	// The administrator is restored by Seed.
+	if legacyRoles {
+		result := DB.Model(&models.User{}).Unscoped().
+			Where("role <> ?", models.DefaultRole).
+			Update("role", models.DefaultRole)
+		if result.Error != nil {
+			log.Fatal("Failed to demote existing users:", result.Error)
+		}
+		if result.RowsAffected > 0 {
+			log.Printf("Demoted %d existing users to %s", result.RowsAffected, models.DefaultRole)
+		}
+		reclaimAdmin = true
+	}
+
+	// Roles that are not known grant nothing; store them as the default
+	DB.Model(&models.User{}).
+		Where("role NOT IN ?", models.Roles).
+		Update("role", models.DefaultRole)
+
 	log.Println("Database migrated successfully")
 }
+
+// columnDefault returns the default expression of a table column, or an
+// empty string if it has none
+func columnDefault(table, column string) string {
+	var def *string
+	DB.Raw(`SELECT column_default FROM information_schema.columns
+		WHERE table_schema = CURRENT_SCHEMA() AND table_name = ? AND column_name = ?`,
+		table, column).Scan(&def)
+	if def == nil {
+		return ""
+	}
+	return *def
+}
+
+// moneyColumns lists every table column holding a models.Money amount
+var moneyColumns = []struct{ table, column string }{
+	{"items", "price"},
+	{"supplier_items", "unit_price"},
+	{"purchasings", "grand_total"},
+	{"purchasing_details", "unit_price"},
+	{"purchasing_details", "sub_total"},
+	{"purchasing_details", "discount"},
+	{"purchasing_details", "tax"},
+	{"purchasing_details", "line_total"},
+}
+
+// convertMoneyColumns converts amounts that are still stored as floating
+// point to fixed-point numeric, rounding each value to two decimals
+func convertMoneyColumns() {
+	for _, mc := range moneyColumns {
+		var dataType string
+		DB.Raw(`SELECT data_type FROM information_schema.columns
+			WHERE table_schema = CURRENT_SCHEMA() AND table_name = ? AND column_name = ?`,
+			mc.table, mc.column).Scan(&dataType)
+		if dataType != "double precision" && dataType != "real" {
+			continue
+		}
+
+		sql := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s USING ROUND(%s::numeric, 2)",
+			mc.table, mc.column, models.MoneyColumn, mc.column)
+		if err := DB.Exec(sql).Error; err != nil {
+			log.Fatalf("Failed to convert %s.%s to %s: %v", mc.table, mc.column, models.MoneyColumn, err)
+		}
+		log.Printf("Converted %s.%s to %s", mc.table, mc.column, models.MoneyColumn)
+	}
+}
+
+// Seed creates the system user and the initial administrator configured
+// through ADMIN_USERNAME / ADMIN_PASSWORD if they do not exist yet. Right
+// after the users from before roles were enforced have been demoted, an
+// existing account named ADMIN_USERNAME is taken back instead: it may have
+// been registered by anyone, so its password is reset to ADMIN_PASSWORD.
+func Seed() {
+	seedSystemUser()
+
+	username := config.AppConfig.AdminUsername
+	password := config.AppConfig.AdminPassword
+	if username == "" || password == "" {
+		return
+	}
+
+	var existing models.User
+	found := DB.Unscoped().Where("username = ?", username).Limit(1).Find(&existing).RowsAffected > 0
+	if found && !reclaimAdmin {
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Fatal("Failed to hash admin password:", err)
+	}
+
+	if found {
+		err := DB.Unscoped().Model(&existing).Updates(map[string]interface{}{
+			"password":      string(hashedPassword),
+			"role":          models.RoleAdmin,
+			"email":         nil,
+			"failed_logins": 0,
+			"locked_until":  nil,
+			"deleted_at":    nil,
+		}).Error
+		if err != nil {
+			log.Fatal("Failed to reclaim admin user:", err)
+		}
+		log.Printf("Admin user '%s' reclaimed: its password was reset to ADMIN_PASSWORD", username)
+		return
+	}
+
+	admin := models.User{
+		Username: username,
+		Password: string(hashedPassword),
+		Role:     models.RoleAdmin,
+	}
+	if result := DB.Create(&admin); result.Error != nil {
+		log.Fatal("Failed to create admin user:", result.Error)
+	}
+	log.Printf("Admin user '%s' created", username)
+}
+
+// seedSystemUser creates the user background jobs act as. Its password is
+// not a bcrypt hash, so no password can log in as it.
+func seedSystemUser() {
+	var count int64
+	DB.Model(&models.User{}).Unscoped().Where("username = ?", models.SystemUsername).Count(&count)
+	if count > 0 {
+		return
+	}
+
+	system := models.User{
+		Username: models.SystemUsername,
+		Password: "!",
+		Role:     models.RoleViewer,
+	}
+	if result := DB.Create(&system); result.Error != nil {
+		log.Fatal("Failed to create system user:", result.Error)
+	}
+}
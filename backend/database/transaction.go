@@ -0,0 +1,47 @@
+package database
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// maxTransactionAttempts is how often a transaction is attempted before a
+// serialization failure or deadlock is returned to the caller
+const maxTransactionAttempts = 5
+
+// Transaction runs fn inside a database transaction. When Postgres aborts
+// the transaction because of a serialization failure or a deadlock, the
+// whole transaction is retried with a short randomized backoff, so fn must
+// not have side effects outside tx.
+func Transaction(fn func(tx *gorm.DB) error) error {
+	var err error
+	for attempt := 1; attempt <= maxTransactionAttempts; attempt++ {
+		err = DB.Transaction(fn)
+		if !isRetryable(err) {
+			return err
+		}
+
+		backoff := time.Duration(attempt*attempt) * 10 * time.Millisecond
+		time.Sleep(backoff + time.Duration(rand.Int63n(int64(backoff))))
+	}
+	return err
+}
+
+// isRetryable reports whether err is a transient concurrency failure
+func isRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+
+	switch pgErr.Code {
+	case "40001", // serialization_failure
+		"40P01": // deadlock_detected
+		return true
+	}
+	return false
+}
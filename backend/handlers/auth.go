@@ -1,20 +1,19 @@
 package handlers
 
 import (
-	"procurement-system/config"
+	"errors"
 	"procurement-system/database"
 	"procurement-system/models"
-	"time"
+	"procurement-system/services"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 type RegisterRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
-	Role     string `json:"role"`
+	Email    string `json:"email"`
 }
 
 type LoginRequest struct {
@@ -22,6 +21,19 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+type LoginTwoFactorRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type UpdateProfileRequest struct {
+	Email string `json:"email"`
+}
+
 // Register creates a new user
 func Register(c *fiber.Ctx) error {
 	var req RegisterRequest
@@ -40,11 +52,8 @@ func Register(c *fiber.Ctx) error {
 		})
 	}
 
-	if len(req.Password) < 6 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "Password must be at least 6 characters",
-		})
+	if err := services.CheckPassword(req.Password); err != nil {
+		return serviceError(c, err, "Invalid password")
 	}
 
 	// Check if user already exists
@@ -57,7 +66,7 @@ func Register(c *fiber.Ctx) error {
 	}
 
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hashedPassword, err := services.HashPassword(req.Password)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"success": false,
@@ -65,24 +74,22 @@ func Register(c *fiber.Ctx) error {
 		})
 	}
 
-	// Set default role if not provided
-	role := req.Role
-	if role == "" {
-		role = "user"
-	}
-
-	// Create user
+	// Self-registered users always start with the least privileged role;
+	// admins promote them through the users endpoints
 	user := models.User{
 		Username: req.Username,
-		Password: string(hashedPassword),
-		Role:     role,
+		Password: hashedPassword,
+		Role:     models.DefaultRole,
 	}
 
-	if result := database.DB.Create(&user); result.Error != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to create user",
-		})
+	err = database.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return services.SetUserEmail(tx, &user, req.Email)
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to create user")
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
@@ -91,12 +98,15 @@ func Register(c *fiber.Ctx) error {
 		"data": fiber.Map{
 			"id":       user.ID,
 			"username": user.Username,
+			"email":    user.Email,
 			"role":     user.Role,
 		},
 	})
 }
 
-// Login authenticates a user and returns JWT token
+// Login authenticates a user and starts a session, returning a short-lived
+// access token and a refresh token. Users with two-factor authentication get
+// a challenge token instead, to be sent with a code to LoginTwoFactor.
 func Login(c *fiber.Ctx) error {
 	var req LoginRequest
 	if err := c.BodyParser(&req); err != nil {
@@ -114,55 +124,245 @@ func Login(c *fiber.Ctx) error {
 		})
 	}
 
-	// Find user
-	var user models.User
-	if result := database.DB.Where("username = ?", req.Username).First(&user); result.Error != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+	// Refused logins are recorded in the login history, so the transaction
+	// is committed on them as well
+	var user *models.User
+	var tokens services.TokenPair
+	var challenge *services.LoginChallenge
+	var refusal error
+	err := database.Transaction(func(tx *gorm.DB) error {
+		var err error
+		user, err = services.Authenticate(tx, req.Username, req.Password, clientInfo(c))
+		if isLoginRefusal(err) {
+			refusal = err
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if user.TOTPEnabled {
+			challenge, err = services.StartLoginChallenge(tx, user)
+			return err
+		}
+		tokens, err = services.IssueTokens(tx, *user, "", clientInfo(c))
+		return err
+	})
+	if err == nil {
+		err = refusal
+	}
+	if err != nil {
+		return serviceError(c, err, "Failed to log in")
+	}
+
+	if challenge != nil {
+		return c.JSON(fiber.Map{
+			"success": true,
+			"message": "Enter the code from your authenticator app",
+			"data": fiber.Map{
+				"two_factor_required": true,
+				"challenge_token":     challenge.Token,
+				"expires_at":          challenge.ExpiresAt,
+			},
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Login successful",
+		"data":    sessionData(tokens, *user),
+	})
+}
+
+// LoginTwoFactor completes the login of a user with two-factor
+// authentication, given the challenge token from Login and a code from their
+// authenticator app or a recovery code
+func LoginTwoFactor(c *fiber.Ctx) error {
+	var req LoginTwoFactorRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"success": false,
-			"message": "Invalid username or password",
+			"message": "Invalid request body",
 		})
 	}
 
-	// Check password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+	if req.ChallengeToken == "" || req.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Challenge token and code are required",
+		})
+	}
+
+	// As in Login, refused attempts are committed to the login history
+	var user *models.User
+	var tokens services.TokenPair
+	var refusal error
+	err := database.Transaction(func(tx *gorm.DB) error {
+		var err error
+		user, err = services.CompleteLoginChallenge(tx, req.ChallengeToken, req.Code, clientInfo(c))
+		if isLoginRefusal(err) {
+			refusal = err
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		tokens, err = services.IssueTokens(tx, *user, "", clientInfo(c))
+		return err
+	})
+	if err == nil {
+		err = refusal
+	}
+	if err != nil {
+		return serviceError(c, err, "Failed to log in")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Login successful",
+		"data":    sessionData(tokens, *user),
+	})
+}
+
+// Refresh exchanges a refresh token for a new access token and a new
+// refresh token. Presenting a refresh token that was already used revokes
+// its whole session.
+func Refresh(c *fiber.Ctx) error {
+	var req RefreshRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"success": false,
-			"message": "Invalid username or password",
+			"message": "Invalid request body",
 		})
 	}
 
-	// Generate JWT token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id":  user.ID,
-		"username": user.Username,
-		"role":     user.Role,
-		"exp":      time.Now().Add(time.Hour * 24).Unix(), // Token expires in 24 hours
+	var tokens services.TokenPair
+	var user models.User
+	err := database.Transaction(func(tx *gorm.DB) error {
+		var err error
+		tokens, user, err = services.RefreshSession(tx, req.RefreshToken, clientInfo(c))
+		return err
 	})
 
-	tokenString, err := token.SignedString([]byte(config.AppConfig.JWTSecret))
+	var reuse *services.TokenReuseError
+	if errors.As(err, &reuse) {
+		revokeErr := database.Transaction(func(tx *gorm.DB) error {
+			return services.RevokeSession(tx, reuse.SessionID)
+		})
+		if revokeErr != nil {
+			return serviceError(c, revokeErr, "Failed to revoke session")
+		}
+		err = &services.UnauthorizedError{Message: "Refresh token has already been used; the session has been revoked"}
+	}
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		return serviceError(c, err, "Failed to refresh token")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Token refreshed",
+		"data":    sessionData(tokens, user),
+	})
+}
+
+// Logout ends the current session, revoking its refresh tokens and the
+// access token the request was made with
+func Logout(c *fiber.Ctx) error {
+	err := database.Transaction(func(tx *gorm.DB) error {
+		return services.RevokeSession(tx, c.Locals("sessionID").(string))
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to log out")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Logged out successfully",
+	})
+}
+
+// LogoutAll ends every session of the current user
+func LogoutAll(c *fiber.Ctx) error {
+	err := database.Transaction(func(tx *gorm.DB) error {
+		return services.RevokeUserSessions(tx, c.Locals("userID").(uint))
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to log out")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "All sessions logged out successfully",
+	})
+}
+
+// GetJWKS publishes the public keys access tokens are signed with, so that
+// other services can verify them
+func GetJWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(services.JWKS())
+}
+
+// sessionData is the response to a login or refresh
+func sessionData(tokens services.TokenPair, user models.User) fiber.Map {
+	return fiber.Map{
+		"token":                     tokens.AccessToken,
+		"expires_at":                tokens.ExpiresAt,
+		"refresh_token":             tokens.RefreshToken,
+		"refresh_expires_at":        tokens.RefreshExpiresAt,
+		"two_factor_setup_required": tokens.TwoFactorSetupRequired,
+		"user": fiber.Map{
+			"id":       user.ID,
+			"username": user.Username,
+			"role":     user.Role,
+		},
+	}
+}
+
+// isLoginRefusal reports whether err refuses a login attempt that has been
+// recorded in the login history
+func isLoginRefusal(err error) bool {
+	var unauthorized *services.UnauthorizedError
+	var tooMany *services.TooManyRequestsError
+	return errors.As(err, &unauthorized) || errors.As(err, &tooMany)
+}
+
+// clientInfo describes the client a request comes from
+func clientInfo(c *fiber.Ctx) services.ClientInfo {
+	return services.ClientInfo{
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	}
+}
+
+// GetProfile returns the current user's profile
+func GetProfile(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uint)
+
+	var user models.User
+	if result := database.DB.First(&user, userID); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"success": false,
-			"message": "Failed to generate token",
+			"message": "User not found",
 		})
 	}
 
 	return c.JSON(fiber.Map{
 		"success": true,
-		"message": "Login successful",
 		"data": fiber.Map{
-			"token": tokenString,
-			"user": fiber.Map{
-				"id":       user.ID,
-				"username": user.Username,
-				"role":     user.Role,
-			},
+			"id":           user.ID,
+			"username":     user.Username,
+			"email":        user.Email,
+			"role":         user.Role,
+			"totp_enabled": user.TOTPEnabled,
 		},
 	})
 }
 
-// GetProfile returns the current user's profile
-func GetProfile(c *fiber.Ctx) error {
+// UpdateProfile changes the current user's email address, where password
+// reset links are sent
+func UpdateProfile(c *fiber.Ctx) error {
 	userID := c.Locals("userID").(uint)
 
 	var user models.User
@@ -173,11 +373,28 @@ func GetProfile(c *fiber.Ctx) error {
 		})
 	}
 
+	var req UpdateProfileRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request body",
+		})
+	}
+
+	err := database.Transaction(func(tx *gorm.DB) error {
+		return services.SetUserEmail(tx, &user, req.Email)
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to update profile")
+	}
+
 	return c.JSON(fiber.Map{
 		"success": true,
+		"message": "Profile updated successfully",
 		"data": fiber.Map{
 			"id":       user.ID,
 			"username": user.Username,
+			"email":    user.Email,
 			"role":     user.Role,
 		},
 	})
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"procurement-system/models"
+	"procurement-system/services"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// serviceError converts an error returned by the services package into a
+// JSON response. Unexpected errors are reported with the fallback message.
+func serviceError(c *fiber.Ctx, err error, fallback string) error {
+	var validationErr *services.ValidationError
+	if errors.As(err, &validationErr) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": validationErr.Message,
+		})
+	}
+
+	// Sums of stored amounts can still exceed what a column holds
+	if errors.Is(err, models.ErrOutOfRange) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Amount is out of range",
+		})
+	}
+
+	var notFoundErr *services.NotFoundError
+	if errors.As(err, &notFoundErr) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": notFoundErr.Message,
+		})
+	}
+
+	var conflictErr *services.ConflictError
+	if errors.As(err, &conflictErr) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"success": false,
+			"message": conflictErr.Message,
+		})
+	}
+
+	var unauthorizedErr *services.UnauthorizedError
+	if errors.As(err, &unauthorizedErr) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success": false,
+			"message": unauthorizedErr.Message,
+		})
+	}
+
+	var tooManyErr *services.TooManyRequestsError
+	if errors.As(err, &tooManyErr) {
+		// Round up so that clients never retry too early
+		seconds := int(math.Ceil(tooManyErr.RetryAfter.Seconds()))
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"success": false,
+			"message": tooManyErr.Message,
+		})
+	}
+
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"success": false,
+		"message": fallback,
+	})
+}
@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"procurement-system/database"
+	"procurement-system/models"
+	"procurement-system/services"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type ExchangeRateRequest struct {
+	Currency      string      `json:"currency"`
+	EffectiveDate string      `json:"effective_date"`
+	Rate          models.Rate `json:"rate"`
+}
+
+// exchangeRateSortColumns are the columns exchange rates can be sorted by
+var exchangeRateSortColumns = map[string]string{
+	"id":             "id",
+	"currency":       "currency",
+	"effective_date": "effective_date",
+}
+
+// GetAllExchangeRates returns a page of exchange rates, newest first.
+// Filters: currency, date_from, date_to.
+func GetAllExchangeRates(c *fiber.Ctx) error {
+	params, err := parseListParams(c, exchangeRateSortColumns, "-effective_date")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	query := database.DB.Model(&models.ExchangeRate{})
+
+	if currency := c.Query("currency"); currency != "" {
+		query = query.Where("currency = ?", strings.ToUpper(currency))
+	}
+
+	if from, ok, err := queryDate(c, "date_from"); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	} else if ok {
+		query = query.Where("effective_date >= ?", from.Format("2006-01-02"))
+	}
+
+	if to, ok, err := queryDate(c, "date_to"); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	} else if ok {
+		query = query.Where("effective_date <= ?", to.Format("2006-01-02"))
+	}
+
+	rates, meta, err := paginate(query, params, func(rate models.ExchangeRate) uint { return rate.ID })
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to fetch exchange rates",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    rates,
+		"meta":    meta,
+	})
+}
+
+// GetExchangeRate returns a single exchange rate by ID
+func GetExchangeRate(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var rate models.ExchangeRate
+	if result := database.DB.First(&rate, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Exchange rate not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    rate,
+	})
+}
+
+// CreateExchangeRate adds the rate of a currency from an effective date
+func CreateExchangeRate(c *fiber.Ctx) error {
+	var req ExchangeRateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request body",
+		})
+	}
+
+	var rate models.ExchangeRate
+	if err := applyExchangeRateRequest(&rate, req); err != nil {
+		return serviceError(c, err, "Invalid exchange rate")
+	}
+
+	if err := saveExchangeRate(&rate); err != nil {
+		return serviceError(c, err, "Failed to create exchange rate")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"message": "Exchange rate created successfully",
+		"data":    rate,
+	})
+}
+
+// UpdateExchangeRate corrects an existing exchange rate. Purchases keep the
+// rate they were created with.
+func UpdateExchangeRate(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var rate models.ExchangeRate
+	if result := database.DB.First(&rate, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Exchange rate not found",
+		})
+	}
+
+	var req ExchangeRateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request body",
+		})
+	}
+
+	if err := applyExchangeRateRequest(&rate, req); err != nil {
+		return serviceError(c, err, "Invalid exchange rate")
+	}
+
+	if err := saveExchangeRate(&rate); err != nil {
+		return serviceError(c, err, "Failed to update exchange rate")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Exchange rate updated successfully",
+		"data":    rate,
+	})
+}
+
+// DeleteExchangeRate removes an exchange rate
+func DeleteExchangeRate(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var rate models.ExchangeRate
+	if result := database.DB.First(&rate, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Exchange rate not found",
+		})
+	}
+
+	if result := database.DB.Delete(&rate); result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to delete exchange rate",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Exchange rate deleted successfully",
+	})
+}
+
+// ImportExchangeRates loads rates from a CSV file uploaded as the "file"
+// form field or sent as the raw request body (text/csv)
+func ImportExchangeRates(c *fiber.Ctx) error {
+	var body io.Reader = bytes.NewReader(c.Body())
+	if file, err := c.FormFile("file"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"message": "Failed to read uploaded file",
+			})
+		}
+		defer f.Close()
+		body = f
+	}
+
+	var imported int
+	err := database.Transaction(func(tx *gorm.DB) error {
+		var err error
+		imported, err = services.ImportExchangeRates(tx, body)
+		return err
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to import exchange rates")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": fmt.Sprintf("%d exchange rates imported", imported),
+		"data":    fiber.Map{"imported": imported},
+	})
+}
+
+// applyExchangeRateRequest copies a request onto rate and validates it
+func applyExchangeRateRequest(rate *models.ExchangeRate, req ExchangeRateRequest) error {
+	date, err := time.Parse("2006-01-02", req.EffectiveDate)
+	if err != nil {
+		return &services.ValidationError{Message: "effective_date must be a date in YYYY-MM-DD format"}
+	}
+
+	rate.Currency = strings.ToUpper(strings.TrimSpace(req.Currency))
+	rate.EffectiveDate = date
+	rate.Rate = req.Rate
+
+	return services.ValidateExchangeRate(rate)
+}
+
+// saveExchangeRate saves rate unless another rate exists for the same
+// currency and date
+func saveExchangeRate(rate *models.ExchangeRate) error {
+	return database.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(&models.ExchangeRate{}).
+			Where("currency = ? AND effective_date = ? AND id <> ?", rate.Currency, rate.EffectiveDate.Format("2006-01-02"), rate.ID).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return &services.ConflictError{Message: fmt.Sprintf("A rate for %s on %s already exists", rate.Currency, rate.EffectiveDate.Format("2006-01-02"))}
+		}
+
+		return tx.Save(rate).Error
+	})
+}
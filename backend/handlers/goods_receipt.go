@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"procurement-system/database"
+	"procurement-system/models"
+	"procurement-system/services"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type ReceiptItemRequest struct {
+	PurchasingDetailID uint `json:"purchasing_detail_id"`
+	Qty                int  `json:"qty"`
+}
+
+type CreateReceiptRequest struct {
+	Note  string               `json:"note"`
+	Items []ReceiptItemRequest `json:"items"`
+}
+
+// GetPurchaseReceipts returns all goods receipts of a purchase
+func GetPurchaseReceipts(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var purchase models.Purchasing
+	if result := database.DB.First(&purchase, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Purchase not found",
+		})
+	}
+
+	var receipts []models.GoodsReceipt
+	if result := database.DB.Preload("User").Preload("Details.Item").Where("purchasing_id = ?", purchase.ID).Order("id").Find(&receipts); result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to fetch receipts",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    receipts,
+	})
+}
+
+// CreatePurchaseReceipt records a (possibly partial) delivery for an ordered purchase
+func CreatePurchaseReceipt(c *fiber.Ctx) error {
+	var req CreateReceiptRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request body",
+		})
+	}
+
+	lines := make([]services.ReceiptLine, 0, len(req.Items))
+	for _, item := range req.Items {
+		lines = append(lines, services.ReceiptLine{
+			PurchasingDetailID: item.PurchasingDetailID,
+			Qty:                item.Qty,
+		})
+	}
+
+	return receiveGoods(c, lines, req.Note)
+}
+
+// ReceivePurchase receives every outstanding quantity of an ordered purchase in one delivery
+func ReceivePurchase(c *fiber.Ctx) error {
+	var req PurchaseTransitionRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"message": "Invalid request body",
+			})
+		}
+	}
+
+	return receiveGoods(c, nil, req.Note)
+}
+
+// receiveGoods records a goods receipt for the purchase identified by :id.
+// A nil lines slice receives everything that is still outstanding.
+func receiveGoods(c *fiber.Ctx, lines []services.ReceiptLine, note string) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Purchase not found",
+		})
+	}
+
+	userID := c.Locals("userID").(uint)
+
+	var receipt *models.GoodsReceipt
+	err = database.Transaction(func(tx *gorm.DB) error {
+		var err error
+		receipt, err = services.ReceivePurchaseGoods(tx, uint(id), lines, time.Now(), userID, note)
+		return err
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to record goods receipt")
+	}
+
+	var completeReceipt models.GoodsReceipt
+	database.DB.Preload("User").Preload("Details.Item").First(&completeReceipt, receipt.ID)
+
+	var updatedPurchase models.Purchasing
+	preloadPurchase(database.DB).First(&updatedPurchase, id)
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"message": "Goods received successfully",
+		"data": fiber.Map{
+			"receipt":  completeReceipt,
+			"purchase": updatedPurchase,
+		},
+	})
+}
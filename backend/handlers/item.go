@@ -1,28 +1,125 @@
 package handlers
 
 import (
+	"fmt"
 	"procurement-system/database"
 	"procurement-system/models"
+	"procurement-system/services"
+	"procurement-system/webhooks"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type CreateItemRequest struct {
-	Name  string  `json:"name"`
-	Stock int     `json:"stock"`
-	Price float64 `json:"price"`
+	Name        string       `json:"name"`
+	Stock       int          `json:"stock"`
+	WarehouseID uint         `json:"warehouse_id"`
+	Price       models.Money `json:"price"`
+	TaxCodeID   *uint        `json:"tax_code_id"`
+
+	ReorderPoint        int   `json:"reorder_point"`
+	SafetyStock         int   `json:"safety_stock"`
+	ReorderQty          int   `json:"reorder_qty"`
+	PreferredSupplierID *uint `json:"preferred_supplier_id"`
 }
 
 type UpdateItemRequest struct {
-	Name  string  `json:"name"`
-	Stock int     `json:"stock"`
-	Price float64 `json:"price"`
+	Name      string       `json:"name"`
+	Price     models.Money `json:"price"`
+	TaxCodeID *uint        `json:"tax_code_id"`
+
+	ReorderPoint        int   `json:"reorder_point"`
+	SafetyStock         int   `json:"safety_stock"`
+	ReorderQty          int   `json:"reorder_qty"`
+	PreferredSupplierID *uint `json:"preferred_supplier_id"`
+}
+
+type CreateStockMovementRequest struct {
+	Type        string `json:"type"`
+	WarehouseID uint   `json:"warehouse_id"`
+	Qty         int    `json:"qty"`
+	Note        string `json:"note"`
+}
+
+// itemSortColumns are the columns items can be sorted by
+var itemSortColumns = map[string]string{
+	"id":         "id",
+	"name":       "name",
+	"stock":      "stock",
+	"price":      "price",
+	"created_at": "created_at",
 }
 
-// GetAllItems returns all items
+// stockMovementSortColumns are the columns stock movements can be sorted by
+var stockMovementSortColumns = map[string]string{
+	"id":         "id",
+	"type":       "type",
+	"created_at": "created_at",
+}
+
+// GetAllItems returns a page of items with their stock per warehouse.
+// Filters: name (substring), low_stock=true (at or below the reorder point,
+// or LOW_STOCK_THRESHOLD without one), min_stock, max_stock, warehouse_id
+// (items in stock there), preferred_supplier_id.
 func GetAllItems(c *fiber.Ctx) error {
-	var items []models.Item
-	if result := database.DB.Find(&items); result.Error != nil {
+	params, err := parseListParams(c, itemSortColumns, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	query := database.DB.Model(&models.Item{})
+
+	if name := c.Query("name"); name != "" {
+		query = query.Where("name ILIKE ?", likePattern(name))
+	}
+
+	if c.QueryBool("low_stock") {
+		query = query.Scopes(services.LowStockCondition)
+	}
+
+	if id, ok, err := queryUint(c, "preferred_supplier_id"); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	} else if ok {
+		query = query.Where("preferred_supplier_id = ?", id)
+	}
+
+	if stock, ok, err := queryUint(c, "min_stock"); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	} else if ok {
+		query = query.Where("stock >= ?", stock)
+	}
+
+	if stock, ok, err := queryUint(c, "max_stock"); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	} else if ok {
+		query = query.Where("stock <= ?", stock)
+	}
+
+	if id, ok, err := queryUint(c, "warehouse_id"); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	} else if ok {
+		query = query.Where("id IN (?)", database.DB.Model(&models.ItemStock{}).Select("item_id").Where("warehouse_id = ? AND stock > 0", id))
+	}
+
+	items, meta, err := paginate(query, params, func(item models.Item) uint { return item.ID }, preloadItem)
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"success": false,
 			"message": "Failed to fetch items",
@@ -32,6 +129,7 @@ func GetAllItems(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{
 		"success": true,
 		"data":    items,
+		"meta":    meta,
 	})
 }
 
@@ -40,7 +138,7 @@ func GetItem(c *fiber.Ctx) error {
 	id := c.Params("id")
 
 	var item models.Item
-	if result := database.DB.First(&item, id); result.Error != nil {
+	if result := preloadItem(database.DB).First(&item, id); result.Error != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"success": false,
 			"message": "Item not found",
@@ -71,7 +169,7 @@ func CreateItem(c *fiber.Ctx) error {
 		})
 	}
 
-	if req.Price < 0 {
+	if req.Price.IsNegative() {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"success": false,
 			"message": "Price cannot be negative",
@@ -85,17 +183,54 @@ func CreateItem(c *fiber.Ctx) error {
 		})
 	}
 
-	item := models.Item{
-		Name:  req.Name,
-		Stock: req.Stock,
-		Price: req.Price,
-	}
+	userID := c.Locals("userID").(uint)
 
-	if result := database.DB.Create(&item); result.Error != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to create item",
-		})
+	// Initial stock is booked through the ledger as an opening adjustment
+	var item models.Item
+	err := database.Transaction(func(tx *gorm.DB) error {
+		if err := services.CheckTaxCode(tx, req.TaxCodeID); err != nil {
+			return err
+		}
+
+		item = models.Item{
+			Name:                req.Name,
+			Price:               req.Price,
+			TaxCodeID:           req.TaxCodeID,
+			ReorderPoint:        req.ReorderPoint,
+			SafetyStock:         req.SafetyStock,
+			ReorderQty:          req.ReorderQty,
+			PreferredSupplierID: req.PreferredSupplierID,
+		}
+		if err := services.CheckReorderSettings(tx, &item); err != nil {
+			return err
+		}
+		if err := tx.Create(&item).Error; err != nil {
+			return err
+		}
+
+		if req.Stock > 0 {
+			_, err := services.MoveStock(tx, services.StockChange{
+				ItemID:        item.ID,
+				WarehouseID:   req.WarehouseID,
+				Type:          models.MovementAdjustment,
+				Qty:           req.Stock,
+				ReferenceType: models.MovementRefItem,
+				ReferenceID:   item.ID,
+				UserID:        userID,
+				Note:          "Initial stock",
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := preloadItem(tx).First(&item, item.ID).Error; err != nil {
+			return err
+		}
+		return webhooks.Publish(tx, webhooks.EventItemCreated, webhooks.ItemPayload(item))
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to create item")
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
@@ -105,18 +240,12 @@ func CreateItem(c *fiber.Ctx) error {
 	})
 }
 
-// UpdateItem updates an existing item
+// UpdateItem updates the details and replenishment settings of an item.
+// Stock is not changed here: it only changes through stock movements and
+// transfers, which name the warehouse they affect.
 func UpdateItem(c *fiber.Ctx) error {
 	id := c.Params("id")
 
-	var item models.Item
-	if result := database.DB.First(&item, id); result.Error != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"success": false,
-			"message": "Item not found",
-		})
-	}
-
 	var req UpdateItemRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -133,40 +262,145 @@ func UpdateItem(c *fiber.Ctx) error {
 		})
 	}
 
-	if req.Price < 0 {
+	if req.Price.IsNegative() {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"success": false,
 			"message": "Price cannot be negative",
 		})
 	}
 
-	if req.Stock < 0 {
+	var item models.Item
+	err := database.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&item, id).Error; err != nil {
+			return &services.NotFoundError{Message: "Item not found"}
+		}
+
+		if err := services.CheckTaxCode(tx, req.TaxCodeID); err != nil {
+			return err
+		}
+
+		item.ReorderPoint = req.ReorderPoint
+		item.SafetyStock = req.SafetyStock
+		item.ReorderQty = req.ReorderQty
+		item.PreferredSupplierID = req.PreferredSupplierID
+		if err := services.CheckReorderSettings(tx, &item); err != nil {
+			return err
+		}
+
+		err := tx.Model(&item).Updates(map[string]interface{}{
+			"name":                  req.Name,
+			"price":                 req.Price,
+			"tax_code_id":           req.TaxCodeID,
+			"reorder_point":         req.ReorderPoint,
+			"safety_stock":          req.SafetyStock,
+			"reorder_qty":           req.ReorderQty,
+			"preferred_supplier_id": req.PreferredSupplierID,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		if err := preloadItem(tx).First(&item, item.ID).Error; err != nil {
+			return err
+		}
+		return webhooks.Publish(tx, webhooks.EventItemUpdated, webhooks.ItemPayload(item))
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to update item")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Item updated successfully",
+		"data":    item,
+	})
+}
+
+// DeleteItem soft deletes an item
+func DeleteItem(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var item models.Item
+	if result := database.DB.First(&item, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Item not found",
+		})
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&item).Error; err != nil {
+			return err
+		}
+		return webhooks.Publish(tx, webhooks.EventItemDeleted, webhooks.ItemPayload(item))
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to delete item",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Item deleted successfully",
+	})
+}
+
+// GetItemMovements returns a page of the stock ledger of an item, newest
+// first. Filters: warehouse_id.
+func GetItemMovements(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var item models.Item
+	if result := database.DB.First(&item, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Item not found",
+		})
+	}
+
+	params, err := parseListParams(c, stockMovementSortColumns, "-id")
+	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"success": false,
-			"message": "Stock cannot be negative",
+			"message": err.Error(),
+		})
+	}
+
+	query := database.DB.Model(&models.StockMovement{}).Where("item_id = ?", item.ID)
+	if warehouseID, ok, err := queryUint(c, "warehouse_id"); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
 		})
+	} else if ok {
+		query = query.Where("warehouse_id = ?", warehouseID)
 	}
 
-	item.Name = req.Name
-	item.Stock = req.Stock
-	item.Price = req.Price
+	preload := func(db *gorm.DB) *gorm.DB {
+		return db.Preload("User").Preload("Warehouse")
+	}
 
-	if result := database.DB.Save(&item); result.Error != nil {
+	movements, meta, err := paginate(query, params, func(movement models.StockMovement) uint { return movement.ID }, preload)
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"success": false,
-			"message": "Failed to update item",
+			"message": "Failed to fetch stock movements",
 		})
 	}
 
 	return c.JSON(fiber.Map{
 		"success": true,
-		"message": "Item updated successfully",
-		"data":    item,
+		"data":    movements,
+		"meta":    meta,
 	})
 }
 
-// DeleteItem soft deletes an item
-func DeleteItem(c *fiber.Ctx) error {
+// CreateItemMovement books a manual issue or adjustment for an item in
+// warehouse_id or the default warehouse. Issues take a positive qty;
+// adjustments take a signed qty.
+func CreateItemMovement(c *fiber.Ctx) error {
 	id := c.Params("id")
 
 	var item models.Item
@@ -177,15 +411,130 @@ func DeleteItem(c *fiber.Ctx) error {
 		})
 	}
 
-	if result := database.DB.Delete(&item); result.Error != nil {
+	var req CreateStockMovementRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request body",
+		})
+	}
+
+	// Validation
+	qty := req.Qty
+	switch req.Type {
+	case models.MovementIssue:
+		if qty <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"message": "Issue qty must be positive",
+			})
+		}
+		qty = -qty
+	case models.MovementAdjustment:
+		if qty == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"message": "Adjustment qty cannot be zero",
+			})
+		}
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Type must be 'issue' or 'adjustment'",
+		})
+	}
+
+	userID := c.Locals("userID").(uint)
+
+	var movement *models.StockMovement
+	err := database.Transaction(func(tx *gorm.DB) error {
+		var err error
+		movement, err = services.MoveStock(tx, services.StockChange{
+			ItemID:        item.ID,
+			WarehouseID:   req.WarehouseID,
+			Type:          req.Type,
+			Qty:           qty,
+			ReferenceType: models.MovementRefItem,
+			ReferenceID:   item.ID,
+			UserID:        userID,
+			Note:          req.Note,
+		})
+		return err
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to record stock movement")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"message": "Stock movement recorded successfully",
+		"data":    movement,
+	})
+}
+
+// GetLowStockItems returns the items that are low on stock, with what is on
+// order and the quantity to order
+func GetLowStockItems(c *fiber.Ctx) error {
+	lines, err := services.LowStockItems(database.DB)
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"success": false,
-			"message": "Failed to delete item",
+			"message": "Failed to fetch low stock items",
 		})
 	}
 
 	return c.JSON(fiber.Map{
 		"success": true,
-		"message": "Item deleted successfully",
+		"data":    lines,
 	})
 }
+
+// SuggestReorderPurchases runs the reorder check now and returns the draft
+// purchases it suggested
+func SuggestReorderPurchases(c *fiber.Ctx) error {
+	var purchases []models.Purchasing
+	err := database.Transaction(func(tx *gorm.DB) error {
+		var err error
+		purchases, err = services.SuggestPurchases(tx)
+		return err
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to suggest purchases")
+	}
+
+	if purchases == nil {
+		purchases = []models.Purchasing{}
+	}
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": fmt.Sprintf("%d purchases suggested", len(purchases)),
+		"data":    purchases,
+	})
+}
+
+// ReconcileStock checks that every item's stock equals the sum of its ledger
+func ReconcileStock(c *fiber.Ctx) error {
+	discrepancies, err := services.ReconcileStock(database.DB)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to reconcile stock",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data": fiber.Map{
+			"balanced":      len(discrepancies) == 0,
+			"discrepancies": discrepancies,
+		},
+	})
+}
+
+// preloadItem loads the stock of an item in every warehouse and its
+// preferred supplier
+func preloadItem(db *gorm.DB) *gorm.DB {
+	return db.Preload("Stocks", func(db *gorm.DB) *gorm.DB { return db.Order("warehouse_id") }).
+		Preload("Stocks.Warehouse").
+		Preload("PreferredSupplier")
+}
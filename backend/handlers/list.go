@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"procurement-system/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// listParams holds the pagination and sorting options shared by all list
+// endpoints. Clients either page with page/per_page or follow next_cursor;
+// cursors are only available when sorting by id.
+type listParams struct {
+	Page      int
+	PerPage   int
+	Cursor    uint
+	UseCursor bool
+	SortBy    string
+	Desc      bool
+}
+
+// parseListParams reads page, per_page, cursor and sort from the query
+// string. sortable maps the names accepted by ?sort= to column names; a
+// leading "-" sorts descending. defaultSort uses the same syntax.
+func parseListParams(c *fiber.Ctx, sortable map[string]string, defaultSort string) (listParams, error) {
+	params := listParams{Page: 1, PerPage: defaultPerPage}
+
+	if v := c.Query("page"); v != "" {
+		page, err := strconv.Atoi(v)
+		if err != nil || page < 1 {
+			return params, errors.New("page must be a positive integer")
+		}
+		params.Page = page
+	}
+
+	if v := c.Query("per_page"); v != "" {
+		perPage, err := strconv.Atoi(v)
+		if err != nil || perPage < 1 || perPage > maxPerPage {
+			return params, errors.New("per_page must be between 1 and " + strconv.Itoa(maxPerPage))
+		}
+		params.PerPage = perPage
+	}
+
+	sort := c.Query("sort", defaultSort)
+	if strings.HasPrefix(sort, "-") {
+		params.Desc = true
+		sort = sort[1:]
+	}
+	column, ok := sortable[sort]
+	if !ok {
+		return params, errors.New("cannot sort by '" + sort + "'")
+	}
+	params.SortBy = column
+
+	if v := c.Query("cursor"); v != "" {
+		if column != "id" {
+			return params, errors.New("cursor pagination requires sorting by id")
+		}
+		decoded, err := base64.RawURLEncoding.DecodeString(v)
+		if err != nil {
+			return params, errors.New("invalid cursor")
+		}
+		cursor, err := strconv.ParseUint(string(decoded), 10, 64)
+		if err != nil {
+			return params, errors.New("invalid cursor")
+		}
+		params.Cursor = uint(cursor)
+		params.UseCursor = true
+	}
+
+	return params, nil
+}
+
+// order returns the ORDER BY clause for the requested sort
+func (params listParams) order() string {
+	order := params.SortBy
+	if params.Desc {
+		order += " DESC"
+	}
+	if params.SortBy != "id" {
+		// Keep the order stable for rows with equal sort values
+		order += ", id"
+	}
+	return order
+}
+
+// paginate counts the rows matched by query, loads the requested page and
+// returns it together with the response metadata. idOf returns the primary
+// key of a row and is used to build the next cursor. scopes (e.g. preloads)
+// are only applied when loading the page, not when counting.
+func paginate[T any](query *gorm.DB, params listParams, idOf func(T) uint, scopes ...func(*gorm.DB) *gorm.DB) ([]T, fiber.Map, error) {
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, nil, err
+	}
+
+	page := query.Session(&gorm.Session{}).Scopes(scopes...).Order(params.order())
+	if params.UseCursor {
+		if params.Desc {
+			page = page.Where("id < ?", params.Cursor)
+		} else {
+			page = page.Where("id > ?", params.Cursor)
+		}
+	} else {
+		page = page.Offset((params.Page - 1) * params.PerPage)
+	}
+
+	// Fetch one extra row to find out whether there is a next page
+	var rows []T
+	if err := page.Limit(params.PerPage + 1).Find(&rows).Error; err != nil {
+		return nil, nil, err
+	}
+
+	hasMore := len(rows) > params.PerPage
+	if hasMore {
+		rows = rows[:params.PerPage]
+	}
+
+	meta := fiber.Map{
+		"per_page":    params.PerPage,
+		"total":       total,
+		"next_cursor": nil,
+	}
+	if !params.UseCursor {
+		meta["page"] = params.Page
+		meta["total_pages"] = (total + int64(params.PerPage) - 1) / int64(params.PerPage)
+	}
+	if hasMore && params.SortBy == "id" {
+		lastID := idOf(rows[len(rows)-1])
+		meta["next_cursor"] = encodeCursor(lastID)
+	}
+
+	return rows, meta, nil
+}
+
+// encodeCursor returns the cursor that continues after the row with the given
+// id
+func encodeCursor(id uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(uint64(id), 10)))
+}
+
+// queryDate parses a YYYY-MM-DD query parameter
+func queryDate(c *fiber.Ctx, key string) (time.Time, bool, error) {
+	v := c.Query(key)
+	if v == "" {
+		return time.Time{}, false, nil
+	}
+
+	date, err := time.ParseInLocation("2006-01-02", v, time.Local)
+	if err != nil {
+		return time.Time{}, false, errors.New(key + " must be a date in YYYY-MM-DD format")
+	}
+	return date, true, nil
+}
+
+// queryMoney parses an optional amount query parameter
+func queryMoney(c *fiber.Ctx, key string) (models.Money, bool, error) {
+	v := c.Query(key)
+	if v == "" {
+		return 0, false, nil
+	}
+
+	m, err := models.ParseMoney(v)
+	if err != nil {
+		return 0, false, errors.New(key + " must be a number")
+	}
+	return m, true, nil
+}
+
+// queryUint parses an optional non-negative integer query parameter
+func queryUint(c *fiber.Ctx, key string) (uint, bool, error) {
+	v := c.Query(key)
+	if v == "" {
+		return 0, false, nil
+	}
+
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, false, errors.New(key + " must be a non-negative integer")
+	}
+	return uint(n), true, nil
+}
+
+// likePattern builds a case-insensitive substring pattern for ILIKE
+func likePattern(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + replacer.Replace(s) + "%"
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var testSortColumns = map[string]string{
+	"id":         "id",
+	"name":       "name",
+	"created_at": "created_at",
+}
+
+// listParamsFor runs parseListParams against a request with the given query
+// string
+func listParamsFor(t *testing.T, query string) (listParams, error) {
+	t.Helper()
+	var params listParams
+	var parseErr error
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		params, parseErr = parseListParams(c, testSortColumns, "-id")
+		return nil
+	})
+	if _, err := app.Test(httptest.NewRequest("GET", "/?"+query, nil)); err != nil {
+		t.Fatalf("request %q: %v", query, err)
+	}
+	return params, parseErr
+}
+
+func TestParseListParams(t *testing.T) {
+	tests := []struct {
+		query string
+		want  listParams
+	}{
+		{"", listParams{Page: 1, PerPage: defaultPerPage, SortBy: "id", Desc: true}},
+		{"page=3&per_page=50", listParams{Page: 3, PerPage: 50, SortBy: "id", Desc: true}},
+		{"per_page=100&sort=name", listParams{Page: 1, PerPage: 100, SortBy: "name"}},
+		{"sort=-created_at", listParams{Page: 1, PerPage: defaultPerPage, SortBy: "created_at", Desc: true}},
+		{"sort=id&cursor=" + encodeCursor(40), listParams{Page: 1, PerPage: defaultPerPage, SortBy: "id", Cursor: 40, UseCursor: true}},
+	}
+	for _, tt := range tests {
+		got, err := listParamsFor(t, tt.query)
+		if err != nil || got != tt.want {
+			t.Errorf("parseListParams(%q) = %+v, %v; want %+v", tt.query, got, err, tt.want)
+		}
+	}
+
+	invalid := []string{
+		"page=0",
+		"page=x",
+		"per_page=0",
+		"per_page=101",
+		"sort=password",
+		"sort=name&cursor=" + encodeCursor(40),
+		"cursor=not*base64",
+		"cursor=" + encodeCursor(40)[1:] + "x",
+	}
+	for _, query := range invalid {
+		if _, err := listParamsFor(t, query); err == nil {
+			t.Errorf("parseListParams(%q) accepted", query)
+		}
+	}
+}
+
+// TestCursorRoundTrip checks that a next_cursor is read back as the id it
+// continues after
+func TestCursorRoundTrip(t *testing.T) {
+	for _, id := range []uint{1, 20, 1 << 40} {
+		params, err := listParamsFor(t, "cursor="+encodeCursor(id))
+		if err != nil || !params.UseCursor || params.Cursor != id {
+			t.Errorf("cursor of %d read back as %+v, %v", id, params, err)
+		}
+	}
+}
+
+func TestListOrder(t *testing.T) {
+	tests := map[listParams]string{
+		{SortBy: "id"}:               "id",
+		{SortBy: "id", Desc: true}:   "id DESC",
+		{SortBy: "name"}:             "name, id",
+		{SortBy: "name", Desc: true}: "name DESC, id",
+	}
+	for params, want := range tests {
+		if got := params.order(); got != want {
+			t.Errorf("order of %+v = %q, want %q", params, got, want)
+		}
+	}
+}
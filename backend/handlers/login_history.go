@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"procurement-system/database"
+	"procurement-system/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// loginAttemptSortColumns are the columns the login history can be sorted by
+var loginAttemptSortColumns = map[string]string{
+	"id":         "id",
+	"created_at": "created_at",
+}
+
+// GetUserLogins returns a page of the login history of a user
+func GetUserLogins(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var user models.User
+	if result := database.DB.First(&user, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "User not found",
+		})
+	}
+
+	return listLoginAttempts(c, user.ID)
+}
+
+// GetProfileLogins returns a page of the current user's login history
+func GetProfileLogins(c *fiber.Ctx) error {
+	return listLoginAttempts(c, c.Locals("userID").(uint))
+}
+
+// listLoginAttempts responds with a page of the login attempts of a user.
+// Filters: success, outcome, ip, date_from, date_to.
+func listLoginAttempts(c *fiber.Ctx, userID uint) error {
+	params, err := parseListParams(c, loginAttemptSortColumns, "-id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	query, err := filterLoginAttempts(c, database.DB.Model(&models.LoginAttempt{}).Where("user_id = ?", userID))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	attempts, meta, err := paginate(query, params, func(attempt models.LoginAttempt) uint { return attempt.ID })
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to fetch login history",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    attempts,
+		"meta":    meta,
+	})
+}
+
+// filterLoginAttempts applies the login history filters from the query string
+func filterLoginAttempts(c *fiber.Ctx, query *gorm.DB) (*gorm.DB, error) {
+	if success := c.Query("success"); success != "" {
+		query = query.Where("success = ?", c.QueryBool("success"))
+	}
+
+	if outcome := c.Query("outcome"); outcome != "" {
+		query = query.Where("outcome = ?", outcome)
+	}
+
+	if ip := c.Query("ip"); ip != "" {
+		query = query.Where("ip = ?", ip)
+	}
+
+	if from, ok, err := queryDate(c, "date_from"); err != nil {
+		return nil, err
+	} else if ok {
+		query = query.Where("created_at >= ?", from)
+	}
+
+	if to, ok, err := queryDate(c, "date_to"); err != nil {
+		return nil, err
+	} else if ok {
+		// date_to is inclusive
+		query = query.Where("created_at < ?", to.AddDate(0, 0, 1))
+	}
+
+	return query, nil
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"fmt"
+	"procurement-system/config"
+	"procurement-system/database"
+	"procurement-system/mailer"
+	"procurement-system/models"
+	"procurement-system/services"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// ChangePassword sets a new password for the current user, who must give
+// the current one. Other sessions of the user are logged out.
+func ChangePassword(c *fiber.Ctx) error {
+	var req ChangePasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request body",
+		})
+	}
+
+	err := database.Transaction(func(tx *gorm.DB) error {
+		return services.ChangePassword(tx, c.Locals("userID").(uint), c.Locals("sessionID").(string), req.CurrentPassword, req.NewPassword)
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to change password")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Password changed successfully",
+	})
+}
+
+// ForgotPassword mails a password reset link to the user with the given
+// email address. The response is the same whether or not the address is
+// registered.
+func ForgotPassword(c *fiber.Ctx) error {
+	var req ForgotPasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request body",
+		})
+	}
+
+	var user *models.User
+	var token string
+	err := database.Transaction(func(tx *gorm.DB) error {
+		var err error
+		user, token, err = services.RequestPasswordReset(tx, req.Email)
+		return err
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to request password reset")
+	}
+
+	// Mail after committing, so that the link works once it arrives. The
+	// mail is sent in the background: waiting for it would tell registered
+	// addresses apart by the response time, and failures are only logged.
+	if user != nil {
+		msg := mailer.Message{
+			To:      *user.Email,
+			Subject: "Reset your password",
+			Body: fmt.Sprintf("Hello %s,\n\nOpen this link to choose a new password:\n\n%s\n\nThe link expires in %s and works once. If you did not ask to reset your password, ignore this message.",
+				user.Username, services.PasswordResetLink(token), config.AppConfig.PasswordResetTTL),
+		}
+		mailer.SendAsync(msg, fmt.Sprintf("password reset mail to user %d", user.ID))
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "If the email address is registered, a password reset link has been sent to it",
+	})
+}
+
+// ResetPassword sets a new password with a token from a reset link and logs
+// the user out of every session
+func ResetPassword(c *fiber.Ctx) error {
+	var req ResetPasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request body",
+		})
+	}
+
+	err := database.Transaction(func(tx *gorm.DB) error {
+		return services.ResetPassword(tx, req.Token, req.NewPassword)
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to reset password")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Password reset successfully, please log in",
+	})
+}
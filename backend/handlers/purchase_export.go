@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"procurement-system/database"
+	"procurement-system/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// purchaseExportHeader are the columns of the purchase CSV export
+var purchaseExportHeader = []string{
+	"number", "id", "date", "supplier", "user", "status", "currency",
+	"subtotal", "discount_total", "tax_total", "grand_total",
+	"exchange_rate", "base_grand_total",
+}
+
+// maxExportRows is the largest number of purchases exported at once, so
+// that an export never has to hold the whole history in memory
+const maxExportRows = 10000
+
+// csvCell keeps spreadsheets from running text as a formula: values
+// starting with a character that begins a formula are prefixed with a quote
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// ExportPurchases returns every purchase matching the list filters as CSV,
+// in the order given by ?sort= (default newest first). Exports of more than
+// maxExportRows purchases are refused; narrow them down with the filters.
+func ExportPurchases(c *fiber.Ctx) error {
+	params, err := parseListParams(c, purchaseSortColumns, "-id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	query, err := filterPurchases(c, database.DB.Model(&models.Purchasing{}))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to fetch purchases",
+		})
+	}
+	if total > maxExportRows {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": fmt.Sprintf("%d purchases match, at most %d can be exported at once: narrow down the filters", total, maxExportRows),
+		})
+	}
+
+	var purchases []models.Purchasing
+	if result := query.Preload("Supplier").Preload("User").Order(params.order()).Find(&purchases); result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to fetch purchases",
+		})
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(purchaseExportHeader)
+	for _, p := range purchases {
+		w.Write([]string{
+			csvCell(p.Number),
+			strconv.FormatUint(uint64(p.ID), 10),
+			p.Date.Format("2006-01-02"),
+			csvCell(p.Supplier.Name),
+			csvCell(p.User.Username),
+			p.Status,
+			p.Currency,
+			p.Subtotal.String(),
+			p.DiscountTotal.String(),
+			p.TaxTotal.String(),
+			p.GrandTotal.String(),
+			p.ExchangeRate.String(),
+			p.BaseGrandTotal.String(),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to write export",
+		})
+	}
+
+	c.Attachment("purchases-" + time.Now().Format("20060102") + ".csv")
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	return c.Send(buf.Bytes())
+}
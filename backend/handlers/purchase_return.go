@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"procurement-system/database"
+	"procurement-system/models"
+	"procurement-system/services"
+	"procurement-system/webhooks"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type ReturnItemRequest struct {
+	PurchasingDetailID uint `json:"purchasing_detail_id"`
+	Qty                int  `json:"qty"`
+}
+
+type CreateReturnRequest struct {
+	Reason string              `json:"reason"`
+	Items  []ReturnItemRequest `json:"items"`
+}
+
+// purchaseReturnSortColumns are the columns purchase returns can be sorted by
+var purchaseReturnSortColumns = map[string]string{
+	"id":            "id",
+	"number":        "number",
+	"date":          "date",
+	"credit_amount": "credit_amount",
+}
+
+// GetAllPurchaseReturns returns a page of purchase returns with supplier and
+// purchase. Filters: supplier_id, purchasing_id, currency, date_from, date_to.
+func GetAllPurchaseReturns(c *fiber.Ctx) error {
+	params, err := parseListParams(c, purchaseReturnSortColumns, "-id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	query, err := filterPurchaseReturns(c, database.DB.Model(&models.PurchaseReturn{}))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	preload := func(db *gorm.DB) *gorm.DB {
+		return db.Preload("Supplier").Preload("Purchasing").Preload("User")
+	}
+
+	returns, meta, err := paginate(query, params, func(ret models.PurchaseReturn) uint { return ret.ID }, preload)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to fetch purchase returns",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    returns,
+		"meta":    meta,
+	})
+}
+
+// filterPurchaseReturns applies the purchase return list filters from the query string
+func filterPurchaseReturns(c *fiber.Ctx, query *gorm.DB) (*gorm.DB, error) {
+	if id, ok, err := queryUint(c, "supplier_id"); err != nil {
+		return nil, err
+	} else if ok {
+		query = query.Where("supplier_id = ?", id)
+	}
+
+	if id, ok, err := queryUint(c, "purchasing_id"); err != nil {
+		return nil, err
+	} else if ok {
+		query = query.Where("purchasing_id = ?", id)
+	}
+
+	if currency := c.Query("currency"); currency != "" {
+		query = query.Where("currency = ?", strings.ToUpper(currency))
+	}
+
+	if from, ok, err := queryDate(c, "date_from"); err != nil {
+		return nil, err
+	} else if ok {
+		query = query.Where("date >= ?", from)
+	}
+
+	if to, ok, err := queryDate(c, "date_to"); err != nil {
+		return nil, err
+	} else if ok {
+		// date_to is inclusive
+		query = query.Where("date < ?", to.AddDate(0, 0, 1))
+	}
+
+	return query, nil
+}
+
+// GetPurchaseReturn returns a single purchase return by ID
+func GetPurchaseReturn(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var ret models.PurchaseReturn
+	if result := preloadPurchaseReturn(database.DB).First(&ret, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Purchase return not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    ret,
+	})
+}
+
+// GetPurchaseReturns returns all returns of a purchase
+func GetPurchaseReturns(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var purchase models.Purchasing
+	if result := database.DB.First(&purchase, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Purchase not found",
+		})
+	}
+
+	var returns []models.PurchaseReturn
+	if result := database.DB.Preload("User").Preload("Details.Item").Where("purchasing_id = ?", purchase.ID).Order("id").Find(&returns); result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to fetch returns",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    returns,
+	})
+}
+
+// CreatePurchaseReturn sends received goods of a purchase back to the supplier
+func CreatePurchaseReturn(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Purchase not found",
+		})
+	}
+
+	var req CreateReturnRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request body",
+		})
+	}
+
+	lines := make([]services.ReturnLine, 0, len(req.Items))
+	for _, item := range req.Items {
+		lines = append(lines, services.ReturnLine{
+			PurchasingDetailID: item.PurchasingDetailID,
+			Qty:                item.Qty,
+		})
+	}
+
+	userID := c.Locals("userID").(uint)
+
+	var ret models.PurchaseReturn
+	err = database.Transaction(func(tx *gorm.DB) error {
+		created, err := services.ReturnPurchaseGoods(tx, uint(id), lines, time.Now(), userID, strings.TrimSpace(req.Reason))
+		if err != nil {
+			return err
+		}
+
+		if err := preloadPurchaseReturn(tx).First(&ret, created.ID).Error; err != nil {
+			return err
+		}
+		return webhooks.Publish(tx, webhooks.EventPurchaseReturned, webhooks.PurchaseReturnPayload(ret))
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to record purchase return")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"message": "Goods returned successfully",
+		"data":    ret,
+	})
+}
+
+// preloadPurchaseReturn loads every relation shown on a single purchase return
+func preloadPurchaseReturn(db *gorm.DB) *gorm.DB {
+	return db.Preload("Purchasing").
+		Preload("Supplier").
+		Preload("User").
+		Preload("Details.Item")
+}
@@ -1,33 +1,93 @@
 package handlers
 
 import (
-	"bytes"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"net/http"
 	"procurement-system/config"
 	"procurement-system/database"
 	"procurement-system/models"
+	"procurement-system/services"
+	"procurement-system/webhooks"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type PurchaseItemRequest struct {
-	ItemID uint `json:"item_id"`
-	Qty    int  `json:"qty"`
+	ItemID          uint         `json:"item_id"`
+	Qty             int          `json:"qty"`
+	DiscountPercent models.Rate  `json:"discount_percent"`
+	DiscountAmount  models.Money `json:"discount_amount"`
 }
 
 type CreatePurchaseRequest struct {
-	SupplierID uint                  `json:"supplier_id"`
-	Items      []PurchaseItemRequest `json:"items"`
+	SupplierID      uint                  `json:"supplier_id"`
+	WarehouseID     uint                  `json:"warehouse_id"`
+	Currency        string                `json:"currency"`
+	DiscountPercent models.Rate           `json:"discount_percent"`
+	DiscountAmount  models.Money          `json:"discount_amount"`
+	Items           []PurchaseItemRequest `json:"items"`
 }
 
-// GetAllPurchases returns all purchases with details
+type UpdatePurchaseRequest struct {
+	SupplierID      uint                  `json:"supplier_id"`
+	WarehouseID     uint                  `json:"warehouse_id"`
+	Currency        string                `json:"currency"`
+	DiscountPercent models.Rate           `json:"discount_percent"`
+	DiscountAmount  models.Money          `json:"discount_amount"`
+	Items           []PurchaseItemRequest `json:"items"`
+}
+
+type CancelPurchaseRequest struct {
+	Reason string `json:"reason"`
+}
+
+// purchaseSortColumns are the columns purchases can be sorted by
+var purchaseSortColumns = map[string]string{
+	"id":               "id",
+	"number":           "number",
+	"date":             "date",
+	"grand_total":      "grand_total",
+	"base_grand_total": "base_grand_total",
+	"status":           "status",
+	"created_at":       "created_at",
+}
+
+// GetAllPurchases returns a page of purchases with supplier and user.
+// Filters: number (substring), supplier_id, warehouse_id, user_id, status,
+// currency, suggested, date_from, date_to, min_total, max_total. Detail
+// lines are only included with include=details.
 func GetAllPurchases(c *fiber.Ctx) error {
-	var purchases []models.Purchasing
-	if result := database.DB.Preload("Supplier").Preload("User").Preload("PurchasingDetails.Item").Find(&purchases); result.Error != nil {
+	params, err := parseListParams(c, purchaseSortColumns, "-id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	query, err := filterPurchases(c, database.DB.Model(&models.Purchasing{}))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	includeDetails := c.Query("include") == "details"
+	preload := func(db *gorm.DB) *gorm.DB {
+		db = db.Preload("Supplier").Preload("Warehouse").Preload("User")
+		if includeDetails {
+			db = db.Preload("PurchasingDetails.Item").Preload("PurchasingDetails.TaxCode")
+		}
+		return db
+	}
+
+	purchases, meta, err := paginate(query, params, func(purchase models.Purchasing) uint { return purchase.ID }, preload)
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"success": false,
 			"message": "Failed to fetch purchases",
@@ -37,15 +97,83 @@ func GetAllPurchases(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{
 		"success": true,
 		"data":    purchases,
+		"meta":    meta,
 	})
 }
 
+// filterPurchases applies the purchase list filters from the query string
+func filterPurchases(c *fiber.Ctx, query *gorm.DB) (*gorm.DB, error) {
+	if id, ok, err := queryUint(c, "supplier_id"); err != nil {
+		return nil, err
+	} else if ok {
+		query = query.Where("supplier_id = ?", id)
+	}
+
+	if id, ok, err := queryUint(c, "warehouse_id"); err != nil {
+		return nil, err
+	} else if ok {
+		query = query.Where("warehouse_id = ?", id)
+	}
+
+	if id, ok, err := queryUint(c, "user_id"); err != nil {
+		return nil, err
+	} else if ok {
+		query = query.Where("user_id = ?", id)
+	}
+
+	if number := c.Query("number"); number != "" {
+		query = query.Where("number ILIKE ?", likePattern(number))
+	}
+
+	if status := c.Query("status"); status != "" {
+		if !models.IsValidStatus(status) {
+			return nil, errors.New("status must be one of: " + strings.Join(models.PurchaseStatuses, ", "))
+		}
+		query = query.Where("status = ?", status)
+	}
+
+	if currency := c.Query("currency"); currency != "" {
+		query = query.Where("currency = ?", strings.ToUpper(currency))
+	}
+
+	if suggested := c.Query("suggested"); suggested != "" {
+		query = query.Where("suggested = ?", c.QueryBool("suggested"))
+	}
+
+	if from, ok, err := queryDate(c, "date_from"); err != nil {
+		return nil, err
+	} else if ok {
+		query = query.Where("date >= ?", from)
+	}
+
+	if to, ok, err := queryDate(c, "date_to"); err != nil {
+		return nil, err
+	} else if ok {
+		// date_to is inclusive
+		query = query.Where("date < ?", to.AddDate(0, 0, 1))
+	}
+
+	if total, ok, err := queryMoney(c, "min_total"); err != nil {
+		return nil, err
+	} else if ok {
+		query = query.Where("grand_total >= ?", total)
+	}
+
+	if total, ok, err := queryMoney(c, "max_total"); err != nil {
+		return nil, err
+	} else if ok {
+		query = query.Where("grand_total <= ?", total)
+	}
+
+	return query, nil
+}
+
 // GetPurchase returns a single purchase by ID
 func GetPurchase(c *fiber.Ctx) error {
 	id := c.Params("id")
 
 	var purchase models.Purchasing
-	if result := database.DB.Preload("Supplier").Preload("User").Preload("PurchasingDetails.Item").First(&purchase, id); result.Error != nil {
+	if result := preloadPurchase(database.DB).First(&purchase, id); result.Error != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"success": false,
 			"message": "Purchase not found",
@@ -58,7 +186,7 @@ func GetPurchase(c *fiber.Ctx) error {
 	})
 }
 
-// CreatePurchase creates a new purchase transaction with ACID compliance
+// CreatePurchase creates a new draft purchase transaction with ACID compliance
 func CreatePurchase(c *fiber.Ctx) error {
 	var req CreatePurchaseRequest
 	if err := c.BodyParser(&req); err != nil {
@@ -86,188 +214,341 @@ func CreatePurchase(c *fiber.Ctx) error {
 	// Get user ID from JWT context
 	userID := c.Locals("userID").(uint)
 
-	// Check if supplier exists
-	var supplier models.Supplier
-	if result := database.DB.First(&supplier, req.SupplierID); result.Error != nil {
+	var completePurchase models.Purchasing
+	err := database.Transaction(func(tx *gorm.DB) error {
+		var supplier models.Supplier
+		if err := tx.First(&supplier, req.SupplierID).Error; err != nil {
+			return &services.ValidationError{Message: "Supplier not found"}
+		}
+
+		// Create purchase header. Stock is not touched until the goods are received.
+		purchase := models.Purchasing{
+			Date:       time.Now(),
+			SupplierID: req.SupplierID,
+			UserID:     userID,
+			GrandTotal: 0,
+			Status:     models.PurchaseStatusDraft,
+		}
+
+		// Goods are received into the default warehouse unless told otherwise
+		warehouseID, err := services.ResolveWarehouse(tx, req.WarehouseID)
+		if err != nil {
+			return err
+		}
+		purchase.WarehouseID = warehouseID
+
+		if err := setPurchaseCurrency(tx, &purchase, supplier, req.Currency); err != nil {
+			return err
+		}
+
+		// Price the lines from the supplier's catalog (NOT from request!)
+		details, err := services.PricePurchase(tx, &purchase, purchaseLines(req.Items), services.Discount{
+			Percent: req.DiscountPercent,
+			Amount:  req.DiscountAmount,
+		})
+		if err != nil {
+			return err
+		}
+
+		// Numbers are issued last so the sequence stays locked as briefly as possible
+		purchase.Number, err = services.NextDocumentNumber(tx, models.DocumentPurchaseOrder, config.AppConfig.PurchaseNumberFormat, purchase.Date)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Create(&purchase).Error; err != nil {
+			return err
+		}
+
+		if err := services.RecordPurchaseStatus(tx, purchase.ID, "", models.PurchaseStatusDraft, userID, ""); err != nil {
+			return err
+		}
+
+		// Create purchase details with a snapshot of the price paid
+		for i := range details {
+			details[i].PurchasingID = purchase.ID
+		}
+		if err := tx.Create(&details).Error; err != nil {
+			return err
+		}
+
+		// Queue webhook notification; it is only sent once the purchase is committed
+		if err := preloadPurchase(tx).First(&completePurchase, purchase.ID).Error; err != nil {
+			return err
+		}
+		return webhooks.Publish(tx, webhooks.EventPurchaseCreated, webhooks.PurchasePayload(completePurchase))
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to create purchase")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"message": "Purchase created successfully",
+		"data":    completePurchase,
+	})
+}
+
+// UpdatePurchase replaces the supplier, currency, discounts and lines of a
+// draft purchase. The lines are repriced from the catalog valid on the
+// purchase date; the number and date are kept.
+func UpdatePurchase(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var req UpdatePurchaseRequest
+	if err := c.BodyParser(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"success": false,
-			"message": "Supplier not found",
+			"message": "Invalid request body",
 		})
 	}
 
-	// Start transaction for ACID compliance
-	tx := database.DB.Begin()
-	if tx.Error != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+	// Validation
+	if req.SupplierID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"success": false,
-			"message": "Failed to start transaction",
+			"message": "Supplier ID is required",
 		})
 	}
 
-	// Defer rollback in case of error
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
+	if len(req.Items) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "At least one item is required",
+		})
+	}
+
+	var purchase models.Purchasing
+	err := database.Transaction(func(tx *gorm.DB) error {
+		// Lock the purchase so it cannot be submitted while being edited
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&purchase, id).Error; err != nil {
+			return &services.NotFoundError{Message: "Purchase not found"}
+		}
+		if purchase.Status != models.PurchaseStatusDraft {
+			return &services.ConflictError{Message: fmt.Sprintf("Only draft purchases can be edited (current status: '%s')", purchase.Status)}
+		}
+
+		var supplier models.Supplier
+		if err := tx.First(&supplier, req.SupplierID).Error; err != nil {
+			return &services.ValidationError{Message: "Supplier not found"}
+		}
+		purchase.SupplierID = supplier.ID
+
+		warehouseID, err := services.ResolveWarehouse(tx, req.WarehouseID)
+		if err != nil {
+			return err
+		}
+		purchase.WarehouseID = warehouseID
+
+		if err := setPurchaseCurrency(tx, &purchase, supplier, req.Currency); err != nil {
+			return err
+		}
+
+		details, err := services.PricePurchase(tx, &purchase, purchaseLines(req.Items), services.Discount{
+			Percent: req.DiscountPercent,
+			Amount:  req.DiscountAmount,
+		})
+		if err != nil {
+			return err
 		}
-	}()
 
-	// Create purchase header
-	purchase := models.Purchasing{
-		Date:       time.Now(),
-		SupplierID: req.SupplierID,
-		UserID:     userID,
-		GrandTotal: 0,
+		if err := tx.Where("purchasing_id = ?", purchase.ID).Delete(&models.PurchasingDetail{}).Error; err != nil {
+			return err
+		}
+		for i := range details {
+			details[i].PurchasingID = purchase.ID
+		}
+		if err := tx.Create(&details).Error; err != nil {
+			return err
+		}
+		if err := tx.Omit(clause.Associations).Save(&purchase).Error; err != nil {
+			return err
+		}
+
+		var updated models.Purchasing
+		if err := preloadPurchase(tx).First(&updated, purchase.ID).Error; err != nil {
+			return err
+		}
+		purchase = updated
+		return webhooks.Publish(tx, webhooks.EventPurchaseUpdated, webhooks.PurchasePayload(purchase))
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to update purchase")
 	}
 
-	if result := tx.Create(&purchase); result.Error != nil {
-		tx.Rollback()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Purchase updated successfully",
+		"data":    purchase,
+	})
+}
+
+// CancelPurchase cancels a purchase that is not closed yet. Received goods
+// are taken out of stock again.
+func CancelPurchase(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var req CancelPurchaseRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"success": false,
-			"message": "Failed to create purchase header",
+			"message": "Invalid request body",
 		})
 	}
 
-	var grandTotal float64 = 0
+	userID := c.Locals("userID").(uint)
 
-	// Process each item
-	for _, itemReq := range req.Items {
-		// Validate item request
-		if itemReq.ItemID == 0 || itemReq.Qty <= 0 {
-			tx.Rollback()
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"success": false,
-				"message": "Invalid item data: item_id and qty must be positive",
-			})
+	var purchase models.Purchasing
+	err := database.Transaction(func(tx *gorm.DB) error {
+		// Lock the purchase so concurrent receipts and transitions are serialized
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("PurchasingDetails").First(&purchase, id).Error; err != nil {
+			return &services.NotFoundError{Message: "Purchase not found"}
 		}
 
-		// Get item from database (for price and stock validation)
-		var item models.Item
-		if result := tx.First(&item, itemReq.ItemID); result.Error != nil {
-			tx.Rollback()
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"success": false,
-				"message": fmt.Sprintf("Item with ID %d not found", itemReq.ItemID),
-			})
+		if err := services.CancelPurchase(tx, &purchase, userID, strings.TrimSpace(req.Reason)); err != nil {
+			return err
 		}
 
-		// Check stock availability
-		if item.Stock < itemReq.Qty {
-			tx.Rollback()
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"success": false,
-				"message": fmt.Sprintf("Insufficient stock for item '%s'. Available: %d, Requested: %d", item.Name, item.Stock, itemReq.Qty),
-			})
+		var updated models.Purchasing
+		if err := preloadPurchase(tx).First(&updated, purchase.ID).Error; err != nil {
+			return err
 		}
+		purchase = updated
+		return webhooks.Publish(tx, webhooks.EventPurchaseCancelled, webhooks.PurchasePayload(purchase))
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to cancel purchase")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Purchase cancelled successfully",
+		"data":    purchase,
+	})
+}
 
-		// Calculate subtotal using price from database (NOT from request!)
-		subTotal := item.Price * float64(itemReq.Qty)
-		grandTotal += subTotal
+type PurchaseTransitionRequest struct {
+	Note string `json:"note"`
+}
 
-		// Create purchase detail
-		detail := models.PurchasingDetail{
-			PurchasingID: purchase.ID,
-			ItemID:       itemReq.ItemID,
-			Qty:          itemReq.Qty,
-			SubTotal:     subTotal,
-		}
+// SubmitPurchase sends a draft purchase for approval
+func SubmitPurchase(c *fiber.Ctx) error {
+	return transitionPurchase(c, models.PurchaseStatusSubmitted, "Purchase submitted successfully")
+}
 
-		if result := tx.Create(&detail); result.Error != nil {
-			tx.Rollback()
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"success": false,
-				"message": "Failed to create purchase detail",
-			})
-		}
+// ApprovePurchase approves a submitted purchase
+func ApprovePurchase(c *fiber.Ctx) error {
+	return transitionPurchase(c, models.PurchaseStatusApproved, "Purchase approved successfully")
+}
 
-		// Update item stock (deduct)
-		if result := tx.Model(&item).Update("stock", item.Stock-itemReq.Qty); result.Error != nil {
-			tx.Rollback()
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+// RejectPurchase rejects a submitted purchase
+func RejectPurchase(c *fiber.Ctx) error {
+	return transitionPurchase(c, models.PurchaseStatusRejected, "Purchase rejected successfully")
+}
+
+// OrderPurchase marks an approved purchase as ordered from the supplier
+func OrderPurchase(c *fiber.Ctx) error {
+	return transitionPurchase(c, models.PurchaseStatusOrdered, "Purchase ordered successfully")
+}
+
+// ClosePurchase closes a received purchase
+func ClosePurchase(c *fiber.Ctx) error {
+	return transitionPurchase(c, models.PurchaseStatusClosed, "Purchase closed successfully")
+}
+
+// transitionPurchase moves the purchase identified by :id to status inside a transaction
+func transitionPurchase(c *fiber.Ctx, status string, message string) error {
+	id := c.Params("id")
+
+	var req PurchaseTransitionRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"success": false,
-				"message": "Failed to update item stock",
+				"message": "Invalid request body",
 			})
 		}
 	}
 
-	// Update grand total
-	if result := tx.Model(&purchase).Update("grand_total", grandTotal); result.Error != nil {
-		tx.Rollback()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+	// Validation
+	if status == models.PurchaseStatusRejected && req.Note == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"success": false,
-			"message": "Failed to update grand total",
+			"message": "A note explaining the rejection is required",
 		})
 	}
 
-	// Commit transaction
-	if result := tx.Commit(); result.Error != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to commit transaction",
-		})
-	}
+	userID := c.Locals("userID").(uint)
 
-	// Reload purchase with all relations
-	var completePurchase models.Purchasing
-	database.DB.Preload("Supplier").Preload("User").Preload("PurchasingDetails.Item").First(&completePurchase, purchase.ID)
+	var updatedPurchase models.Purchasing
+	err := database.Transaction(func(tx *gorm.DB) error {
+		// Lock the purchase so concurrent transitions are serialized
+		var purchase models.Purchasing
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("PurchasingDetails").First(&purchase, id).Error; err != nil {
+			return &services.NotFoundError{Message: "Purchase not found"}
+		}
 
-	// Send webhook notification (async, don't block response)
-	go sendWebhookNotification(completePurchase)
+		if err := services.TransitionPurchase(tx, &purchase, status, userID, req.Note); err != nil {
+			return err
+		}
 
-	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		return preloadPurchase(tx).First(&updatedPurchase, purchase.ID).Error
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to update purchase status")
+	}
+
+	return c.JSON(fiber.Map{
 		"success": true,
-		"message": "Purchase created successfully",
-		"data":    completePurchase,
+		"message": message,
+		"data":    updatedPurchase,
 	})
 }
 
-// sendWebhookNotification sends purchase data to configured webhook URL
-func sendWebhookNotification(purchase models.Purchasing) {
-	webhookURL := config.AppConfig.WebhookURL
-	if webhookURL == "" {
-		log.Println("Webhook URL not configured, skipping notification")
-		return
-	}
-
-	// Prepare webhook payload
-	payload := map[string]interface{}{
-		"event":      "purchase_created",
-		"timestamp":  time.Now().Format(time.RFC3339),
-		"order_id":   purchase.ID,
-		"date":       purchase.Date.Format("2006-01-02"),
-		"supplier":   purchase.Supplier.Name,
-		"user":       purchase.User.Username,
-		"grand_total": purchase.GrandTotal,
-		"items":      make([]map[string]interface{}, 0),
-	}
-
-	for _, detail := range purchase.PurchasingDetails {
-		item := map[string]interface{}{
-			"item_id":   detail.ItemID,
-			"item_name": detail.Item.Name,
-			"qty":       detail.Qty,
-			"price":     detail.Item.Price,
-			"sub_total": detail.SubTotal,
-		}
-		payload["items"] = append(payload["items"].([]map[string]interface{}), item)
-	}
+// preloadPurchase loads every relation shown on a single purchase
+func preloadPurchase(db *gorm.DB) *gorm.DB {
+	return db.Preload("Supplier").
+		Preload("Warehouse").
+		Preload("User").
+		Preload("PurchasingDetails.Item").
+		Preload("PurchasingDetails.TaxCode").
+		Preload("StatusHistory.User").
+		Preload("Receipts.Details").
+		Preload("Returns.Details")
+}
 
-	jsonPayload, err := json.Marshal(payload)
-	if err != nil {
-		log.Printf("Failed to marshal webhook payload: %v", err)
-		return
+// setPurchaseCurrency sets the currency of purchase and the exchange rate
+// valid on its date. Purchases are in the supplier's currency unless another
+// one is requested.
+func setPurchaseCurrency(tx *gorm.DB, purchase *models.Purchasing, supplier models.Supplier, requested string) error {
+	purchase.Currency = strings.ToUpper(strings.TrimSpace(requested))
+	if purchase.Currency == "" {
+		purchase.Currency = supplier.Currency
+	}
+	if !services.IsValidCurrency(purchase.Currency) {
+		return &services.ValidationError{Message: "Currency must be a three-letter ISO 4217 code"}
 	}
 
-	// Send HTTP POST request
-	resp, err := http.Post(webhookURL, "application/json", bytes.NewBuffer(jsonPayload))
+	rate, err := services.ExchangeRateOn(tx, purchase.Currency, purchase.Date)
 	if err != nil {
-		log.Printf("Failed to send webhook notification: %v", err)
-		return
+		return err
 	}
-	defer resp.Body.Close()
+	purchase.ExchangeRate = rate
+	return nil
+}
 
-	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		log.Printf("Webhook notification sent successfully for order #%d", purchase.ID)
-	} else {
-		log.Printf("Webhook notification failed with status: %d", resp.StatusCode)
+// purchaseLines converts requested items to the input of services.PricePurchase
+func purchaseLines(items []PurchaseItemRequest) []services.PurchaseLineInput {
+	lines := make([]services.PurchaseLineInput, len(items))
+	for i, item := range items {
+		lines[i] = services.PurchaseLineInput{
+			ItemID: item.ItemID,
+			Qty:    item.Qty,
+			Discount: services.Discount{
+				Percent: item.DiscountPercent,
+				Amount:  item.DiscountAmount,
+			},
+		}
 	}
+	return lines
 }
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"procurement-system/database"
+	"procurement-system/models"
+	"procurement-system/services"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetAPAgingReport returns the accounts payable aging: outstanding supplier
+// invoices per supplier and currency, split into current, 1-30, 31-60,
+// 61-90 and over 90 days past due.
+// Filters: as_of (default today), supplier_id, currency.
+func GetAPAgingReport(c *fiber.Ctx) error {
+	asOf, ok, err := queryDate(c, "as_of")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+	if !ok {
+		asOf = time.Now()
+	}
+
+	query := database.DB.Model(&models.SupplierInvoice{})
+
+	if id, ok, err := queryUint(c, "supplier_id"); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	} else if ok {
+		query = query.Where("supplier_id = ?", id)
+	}
+
+	if currency := c.Query("currency"); currency != "" {
+		query = query.Where("currency = ?", strings.ToUpper(currency))
+	}
+
+	report, err := services.APAging(query, asOf)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to compute aging report",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    report,
+	})
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"procurement-system/database"
+	"procurement-system/models"
+	"procurement-system/services"
+	"procurement-system/webhooks"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type TransferItemRequest struct {
+	ItemID uint `json:"item_id"`
+	Qty    int  `json:"qty"`
+}
+
+type CreateStockTransferRequest struct {
+	FromWarehouseID uint                  `json:"from_warehouse_id"`
+	ToWarehouseID   uint                  `json:"to_warehouse_id"`
+	Note            string                `json:"note"`
+	Items           []TransferItemRequest `json:"items"`
+}
+
+// stockTransferSortColumns are the columns stock transfers can be sorted by
+var stockTransferSortColumns = map[string]string{
+	"id":     "id",
+	"number": "number",
+	"date":   "date",
+}
+
+// GetAllStockTransfers returns a page of stock transfers with warehouses and
+// user. Filters: from_warehouse_id, to_warehouse_id, warehouse_id (either
+// side), item_id, date_from, date_to.
+func GetAllStockTransfers(c *fiber.Ctx) error {
+	params, err := parseListParams(c, stockTransferSortColumns, "-id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	query, err := filterStockTransfers(c, database.DB.Model(&models.StockTransfer{}))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	preload := func(db *gorm.DB) *gorm.DB {
+		return db.Preload("FromWarehouse").Preload("ToWarehouse").Preload("User")
+	}
+
+	transfers, meta, err := paginate(query, params, func(transfer models.StockTransfer) uint { return transfer.ID }, preload)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to fetch stock transfers",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    transfers,
+		"meta":    meta,
+	})
+}
+
+// filterStockTransfers applies the stock transfer list filters from the query string
+func filterStockTransfers(c *fiber.Ctx, query *gorm.DB) (*gorm.DB, error) {
+	if id, ok, err := queryUint(c, "from_warehouse_id"); err != nil {
+		return nil, err
+	} else if ok {
+		query = query.Where("from_warehouse_id = ?", id)
+	}
+
+	if id, ok, err := queryUint(c, "to_warehouse_id"); err != nil {
+		return nil, err
+	} else if ok {
+		query = query.Where("to_warehouse_id = ?", id)
+	}
+
+	if id, ok, err := queryUint(c, "warehouse_id"); err != nil {
+		return nil, err
+	} else if ok {
+		query = query.Where("from_warehouse_id = ? OR to_warehouse_id = ?", id, id)
+	}
+
+	if id, ok, err := queryUint(c, "item_id"); err != nil {
+		return nil, err
+	} else if ok {
+		query = query.Where("id IN (?)", database.DB.Model(&models.StockTransferDetail{}).Select("stock_transfer_id").Where("item_id = ?", id))
+	}
+
+	if from, ok, err := queryDate(c, "date_from"); err != nil {
+		return nil, err
+	} else if ok {
+		query = query.Where("date >= ?", from)
+	}
+
+	if to, ok, err := queryDate(c, "date_to"); err != nil {
+		return nil, err
+	} else if ok {
+		// date_to is inclusive
+		query = query.Where("date < ?", to.AddDate(0, 0, 1))
+	}
+
+	return query, nil
+}
+
+// GetStockTransfer returns a single stock transfer by ID
+func GetStockTransfer(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var transfer models.StockTransfer
+	if result := preloadStockTransfer(database.DB).First(&transfer, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Stock transfer not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    transfer,
+	})
+}
+
+// CreateStockTransfer moves stock from one warehouse to another
+func CreateStockTransfer(c *fiber.Ctx) error {
+	var req CreateStockTransferRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request body",
+		})
+	}
+
+	lines := make([]services.TransferLine, 0, len(req.Items))
+	for _, item := range req.Items {
+		lines = append(lines, services.TransferLine{
+			ItemID: item.ItemID,
+			Qty:    item.Qty,
+		})
+	}
+
+	transfer := models.StockTransfer{
+		Date:            time.Now(),
+		FromWarehouseID: req.FromWarehouseID,
+		ToWarehouseID:   req.ToWarehouseID,
+		UserID:          c.Locals("userID").(uint),
+		Note:            strings.TrimSpace(req.Note),
+	}
+
+	var created models.StockTransfer
+	err := database.Transaction(func(tx *gorm.DB) error {
+		if err := services.TransferStock(tx, &transfer, lines); err != nil {
+			return err
+		}
+
+		if err := preloadStockTransfer(tx).First(&created, transfer.ID).Error; err != nil {
+			return err
+		}
+		return webhooks.Publish(tx, webhooks.EventStockTransferred, webhooks.StockTransferPayload(created))
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to transfer stock")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"message": "Stock transferred successfully",
+		"data":    created,
+	})
+}
+
+// preloadStockTransfer loads every relation shown on a single stock transfer
+func preloadStockTransfer(db *gorm.DB) *gorm.DB {
+	return db.Preload("FromWarehouse").
+		Preload("ToWarehouse").
+		Preload("User").
+		Preload("Details.Item")
+}
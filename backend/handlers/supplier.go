@@ -1,28 +1,70 @@
 package handlers
 
 import (
+	"procurement-system/config"
 	"procurement-system/database"
 	"procurement-system/models"
+	"procurement-system/services"
+	"procurement-system/webhooks"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
 type CreateSupplierRequest struct {
-	Name    string `json:"name"`
-	Email   string `json:"email"`
-	Address string `json:"address"`
+	Name            string `json:"name"`
+	Email           string `json:"email"`
+	Address         string `json:"address"`
+	Currency        string `json:"currency"`
+	TaxCodeID       *uint  `json:"tax_code_id"`
+	PaymentTermDays *int   `json:"payment_term_days"`
 }
 
 type UpdateSupplierRequest struct {
-	Name    string `json:"name"`
-	Email   string `json:"email"`
-	Address string `json:"address"`
+	Name            string `json:"name"`
+	Email           string `json:"email"`
+	Address         string `json:"address"`
+	Currency        string `json:"currency"`
+	TaxCodeID       *uint  `json:"tax_code_id"`
+	PaymentTermDays *int   `json:"payment_term_days"`
 }
 
-// GetAllSuppliers returns all suppliers
+// supplierSortColumns are the columns suppliers can be sorted by
+var supplierSortColumns = map[string]string{
+	"id":         "id",
+	"name":       "name",
+	"email":      "email",
+	"created_at": "created_at",
+}
+
+// GetAllSuppliers returns a page of suppliers.
+// Filters: name, email (substring), q (substring of name or email).
 func GetAllSuppliers(c *fiber.Ctx) error {
-	var suppliers []models.Supplier
-	if result := database.DB.Find(&suppliers); result.Error != nil {
+	params, err := parseListParams(c, supplierSortColumns, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	query := database.DB.Model(&models.Supplier{})
+
+	if name := c.Query("name"); name != "" {
+		query = query.Where("name ILIKE ?", likePattern(name))
+	}
+
+	if email := c.Query("email"); email != "" {
+		query = query.Where("email ILIKE ?", likePattern(email))
+	}
+
+	if q := c.Query("q"); q != "" {
+		query = query.Where("(name ILIKE ? OR email ILIKE ?)", likePattern(q), likePattern(q))
+	}
+
+	suppliers, meta, err := paginate(query, params, func(supplier models.Supplier) uint { return supplier.ID })
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"success": false,
 			"message": "Failed to fetch suppliers",
@@ -32,6 +74,7 @@ func GetAllSuppliers(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{
 		"success": true,
 		"data":    suppliers,
+		"meta":    meta,
 	})
 }
 
@@ -71,19 +114,46 @@ func CreateSupplier(c *fiber.Ctx) error {
 		})
 	}
 
-	supplier := models.Supplier{
-		Name:    req.Name,
-		Email:   req.Email,
-		Address: req.Address,
+	currency, ok := supplierCurrency(req.Currency)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Currency must be a three-letter ISO 4217 code",
+		})
 	}
 
-	if result := database.DB.Create(&supplier); result.Error != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+	if !validPaymentTerms(req.PaymentTermDays) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"success": false,
-			"message": "Failed to create supplier",
+			"message": "Payment terms must be between 0 and 365 days",
 		})
 	}
 
+	supplier := models.Supplier{
+		Name:      req.Name,
+		Email:     req.Email,
+		Address:   req.Address,
+		Currency:  currency,
+		TaxCodeID: req.TaxCodeID,
+	}
+	supplier.PaymentTermDays = models.DefaultPaymentTermDays
+	if req.PaymentTermDays != nil {
+		supplier.PaymentTermDays = *req.PaymentTermDays
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := services.CheckTaxCode(tx, supplier.TaxCodeID); err != nil {
+			return err
+		}
+		if err := tx.Create(&supplier).Error; err != nil {
+			return err
+		}
+		return webhooks.Publish(tx, webhooks.EventSupplierCreated, webhooks.SupplierPayload(supplier))
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to create supplier")
+	}
+
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
 		"success": true,
 		"message": "Supplier created successfully",
@@ -119,15 +189,41 @@ func UpdateSupplier(c *fiber.Ctx) error {
 		})
 	}
 
+	currency, ok := supplierCurrency(req.Currency)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Currency must be a three-letter ISO 4217 code",
+		})
+	}
+
+	if !validPaymentTerms(req.PaymentTermDays) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Payment terms must be between 0 and 365 days",
+		})
+	}
+
 	supplier.Name = req.Name
 	supplier.Email = req.Email
 	supplier.Address = req.Address
+	supplier.Currency = currency
+	supplier.TaxCodeID = req.TaxCodeID
+	if req.PaymentTermDays != nil {
+		supplier.PaymentTermDays = *req.PaymentTermDays
+	}
 
-	if result := database.DB.Save(&supplier); result.Error != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to update supplier",
-		})
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := services.CheckTaxCode(tx, supplier.TaxCodeID); err != nil {
+			return err
+		}
+		if err := tx.Save(&supplier).Error; err != nil {
+			return err
+		}
+		return webhooks.Publish(tx, webhooks.EventSupplierUpdated, webhooks.SupplierPayload(supplier))
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to update supplier")
 	}
 
 	return c.JSON(fiber.Map{
@@ -149,7 +245,16 @@ func DeleteSupplier(c *fiber.Ctx) error {
 		})
 	}
 
-	if result := database.DB.Delete(&supplier); result.Error != nil {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Item{}).Where("preferred_supplier_id = ?", supplier.ID).Update("preferred_supplier_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&supplier).Error; err != nil {
+			return err
+		}
+		return webhooks.Publish(tx, webhooks.EventSupplierDeleted, webhooks.SupplierPayload(supplier))
+	})
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"success": false,
 			"message": "Failed to delete supplier",
@@ -161,3 +266,19 @@ func DeleteSupplier(c *fiber.Ctx) error {
 		"message": "Supplier deleted successfully",
 	})
 }
+
+// supplierCurrency normalizes the currency of a supplier request, which
+// defaults to the base currency
+func supplierCurrency(currency string) (string, bool) {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency == "" {
+		return config.AppConfig.BaseCurrency, true
+	}
+	return currency, services.IsValidCurrency(currency)
+}
+
+// validPaymentTerms reports whether the payment terms of a supplier request,
+// if given, are between zero and a year
+func validPaymentTerms(days *int) bool {
+	return days == nil || (*days >= 0 && *days <= 365)
+}
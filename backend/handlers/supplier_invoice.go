@@ -0,0 +1,518 @@
+package handlers
+
+import (
+	"errors"
+	"procurement-system/database"
+	"procurement-system/models"
+	"procurement-system/services"
+	"procurement-system/webhooks"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type InvoiceLineRequest struct {
+	PurchasingDetailID uint         `json:"purchasing_detail_id"`
+	Qty                int          `json:"qty"`
+	UnitPrice          models.Money `json:"unit_price"`
+}
+
+type CreateSupplierInvoiceRequest struct {
+	SupplierID  uint                 `json:"supplier_id"`
+	Number      string               `json:"number"`
+	InvoiceDate string               `json:"invoice_date"`
+	DueDate     string               `json:"due_date"`
+	Amount      models.Money         `json:"amount"`
+	PurchaseIDs []uint               `json:"purchase_ids"`
+	Lines       []InvoiceLineRequest `json:"lines"`
+	Note        string               `json:"note"`
+}
+
+type ResolveVarianceRequest struct {
+	Note string `json:"note"`
+}
+
+type CreateSupplierPaymentRequest struct {
+	Date      string       `json:"date"`
+	Amount    models.Money `json:"amount"`
+	Method    string       `json:"method"`
+	Reference string       `json:"reference"`
+	Note      string       `json:"note"`
+}
+
+// supplierInvoiceSortColumns are the columns supplier invoices can be sorted by
+var supplierInvoiceSortColumns = map[string]string{
+	"id":           "id",
+	"number":       "number",
+	"invoice_date": "invoice_date",
+	"due_date":     "due_date",
+	"amount":       "amount",
+	"status":       "status",
+}
+
+// GetAllSupplierInvoices returns a page of supplier invoices with supplier.
+// Filters: supplier_id, purchasing_id, status, match_status, approved,
+// currency, number (substring), overdue, date_from, date_to (invoice date),
+// due_from, due_to.
+func GetAllSupplierInvoices(c *fiber.Ctx) error {
+	params, err := parseListParams(c, supplierInvoiceSortColumns, "-id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	query, err := filterSupplierInvoices(c, database.DB.Model(&models.SupplierInvoice{}))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	preload := func(db *gorm.DB) *gorm.DB {
+		return db.Preload("Supplier")
+	}
+
+	invoices, meta, err := paginate(query, params, func(invoice models.SupplierInvoice) uint { return invoice.ID }, preload)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to fetch invoices",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    invoices,
+		"meta":    meta,
+	})
+}
+
+// filterSupplierInvoices applies the supplier invoice list filters from the
+// query string
+func filterSupplierInvoices(c *fiber.Ctx, query *gorm.DB) (*gorm.DB, error) {
+	if id, ok, err := queryUint(c, "supplier_id"); err != nil {
+		return nil, err
+	} else if ok {
+		query = query.Where("supplier_id = ?", id)
+	}
+
+	if id, ok, err := queryUint(c, "purchasing_id"); err != nil {
+		return nil, err
+	} else if ok {
+		query = query.Where("id IN (?)", database.DB.Table("supplier_invoice_purchases").Select("supplier_invoice_id").Where("purchasing_id = ?", id))
+	}
+
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status IN ?", strings.Split(status, ","))
+	}
+
+	if matchStatus := c.Query("match_status"); matchStatus != "" {
+		query = query.Where("match_status IN ?", strings.Split(matchStatus, ","))
+	}
+
+	if approved := c.Query("approved"); approved != "" {
+		if c.QueryBool("approved") {
+			query = query.Where("approved_at IS NOT NULL")
+		} else {
+			query = query.Where("approved_at IS NULL")
+		}
+	}
+
+	if currency := c.Query("currency"); currency != "" {
+		query = query.Where("currency = ?", strings.ToUpper(currency))
+	}
+
+	if number := c.Query("number"); number != "" {
+		query = query.Where("number ILIKE ?", likePattern(number))
+	}
+
+	if c.QueryBool("overdue") {
+		query = query.Where("due_date < ? AND status <> ?", time.Now().Format("2006-01-02"), models.InvoiceStatusPaid)
+	}
+
+	dateRanges := []struct{ from, to, column string }{
+		{"date_from", "date_to", "invoice_date"},
+		{"due_from", "due_to", "due_date"},
+	}
+	for _, r := range dateRanges {
+		if from, ok, err := queryDate(c, r.from); err != nil {
+			return nil, err
+		} else if ok {
+			query = query.Where(r.column+" >= ?", from.Format("2006-01-02"))
+		}
+
+		if to, ok, err := queryDate(c, r.to); err != nil {
+			return nil, err
+		} else if ok {
+			query = query.Where(r.column+" <= ?", to.Format("2006-01-02"))
+		}
+	}
+
+	return query, nil
+}
+
+// GetSupplierInvoice returns a single supplier invoice by ID
+func GetSupplierInvoice(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var invoice models.SupplierInvoice
+	if result := preloadSupplierInvoice(database.DB).First(&invoice, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Invoice not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    invoice,
+	})
+}
+
+// CreateSupplierInvoice records an invoice received from a supplier
+func CreateSupplierInvoice(c *fiber.Ctx) error {
+	var req CreateSupplierInvoiceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request body",
+		})
+	}
+
+	invoiceDate, err := bodyDate(req.InvoiceDate, "invoice_date")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	dueDate, err := bodyDate(req.DueDate, "due_date")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	invoice := models.SupplierInvoice{
+		SupplierID:  req.SupplierID,
+		Number:      req.Number,
+		InvoiceDate: invoiceDate,
+		DueDate:     dueDate,
+		Amount:      req.Amount,
+		Note:        strings.TrimSpace(req.Note),
+		UserID:      c.Locals("userID").(uint),
+	}
+
+	lines := make([]services.InvoiceLine, 0, len(req.Lines))
+	for _, line := range req.Lines {
+		lines = append(lines, services.InvoiceLine{
+			PurchasingDetailID: line.PurchasingDetailID,
+			Qty:                line.Qty,
+			UnitPrice:          line.UnitPrice,
+		})
+	}
+
+	var created models.SupplierInvoice
+	err = database.Transaction(func(tx *gorm.DB) error {
+		if err := services.CreateSupplierInvoice(tx, &invoice, req.PurchaseIDs, lines); err != nil {
+			return err
+		}
+
+		if err := preloadSupplierInvoice(tx).First(&created, invoice.ID).Error; err != nil {
+			return err
+		}
+		return webhooks.Publish(tx, webhooks.EventInvoiceCreated, webhooks.SupplierInvoicePayload(created))
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to record invoice")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"message": "Invoice recorded successfully",
+		"data":    created,
+	})
+}
+
+// DeleteSupplierInvoice deletes an invoice without payments
+func DeleteSupplierInvoice(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Invoice not found",
+		})
+	}
+
+	err = database.Transaction(func(tx *gorm.DB) error {
+		invoice, err := services.DeleteSupplierInvoice(tx, uint(id))
+		if err != nil {
+			return err
+		}
+		return webhooks.Publish(tx, webhooks.EventInvoiceDeleted, webhooks.SupplierInvoicePayload(*invoice))
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to delete invoice")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Invoice deleted successfully",
+	})
+}
+
+// MatchSupplierInvoice matches an unapproved invoice against its purchases
+// and receipts again
+func MatchSupplierInvoice(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Invoice not found",
+		})
+	}
+
+	var invoice models.SupplierInvoice
+	err = database.Transaction(func(tx *gorm.DB) error {
+		if _, err := services.MatchSupplierInvoice(tx, uint(id)); err != nil {
+			return err
+		}
+		return preloadSupplierInvoice(tx).First(&invoice, id).Error
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to match invoice")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Invoice matched",
+		"data":    invoice,
+	})
+}
+
+// ResolveMatchVariance accepts a variance of an invoice with a note
+func ResolveMatchVariance(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Invoice not found",
+		})
+	}
+
+	varianceID, err := c.ParamsInt("varianceId")
+	if err != nil || varianceID <= 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Variance not found",
+		})
+	}
+
+	var req ResolveVarianceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request body",
+		})
+	}
+
+	userID := c.Locals("userID").(uint)
+
+	var invoice models.SupplierInvoice
+	err = database.Transaction(func(tx *gorm.DB) error {
+		if _, err := services.ResolveMatchVariance(tx, uint(id), uint(varianceID), userID, strings.TrimSpace(req.Note)); err != nil {
+			return err
+		}
+		return preloadSupplierInvoice(tx).First(&invoice, id).Error
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to resolve variance")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Variance resolved",
+		"data":    invoice,
+	})
+}
+
+// ApproveSupplierInvoice approves a matched invoice for payment
+func ApproveSupplierInvoice(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Invoice not found",
+		})
+	}
+
+	userID := c.Locals("userID").(uint)
+
+	var invoice models.SupplierInvoice
+	err = database.Transaction(func(tx *gorm.DB) error {
+		if _, err := services.ApproveSupplierInvoice(tx, uint(id), userID); err != nil {
+			return err
+		}
+
+		if err := preloadSupplierInvoice(tx).First(&invoice, id).Error; err != nil {
+			return err
+		}
+		return webhooks.Publish(tx, webhooks.EventInvoiceApproved, webhooks.SupplierInvoicePayload(invoice))
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to approve invoice")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Invoice approved for payment",
+		"data":    invoice,
+	})
+}
+
+// GetSupplierPayments returns all payments made against an invoice
+func GetSupplierPayments(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var invoice models.SupplierInvoice
+	if result := database.DB.First(&invoice, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Invoice not found",
+		})
+	}
+
+	var payments []models.SupplierPayment
+	if result := database.DB.Preload("User").Where("supplier_invoice_id = ?", invoice.ID).Order("date, id").Find(&payments); result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to fetch payments",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    payments,
+	})
+}
+
+// CreateSupplierPayment records a full or partial payment of an invoice
+func CreateSupplierPayment(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Invoice not found",
+		})
+	}
+
+	var req CreateSupplierPaymentRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request body",
+		})
+	}
+
+	date, err := bodyDate(req.Date, "date")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	payment := models.SupplierPayment{
+		Date:      date,
+		Amount:    req.Amount,
+		Method:    req.Method,
+		Reference: req.Reference,
+		Note:      strings.TrimSpace(req.Note),
+		UserID:    c.Locals("userID").(uint),
+	}
+
+	var invoice models.SupplierInvoice
+	err = database.Transaction(func(tx *gorm.DB) error {
+		if _, err := services.RecordSupplierPayment(tx, uint(id), &payment); err != nil {
+			return err
+		}
+
+		if err := preloadSupplierInvoice(tx).First(&invoice, id).Error; err != nil {
+			return err
+		}
+		return webhooks.Publish(tx, webhooks.EventPaymentRecorded, webhooks.SupplierPaymentPayload(invoice, payment))
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to record payment")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"message": "Payment recorded successfully",
+		"data":    invoice,
+	})
+}
+
+// GetSupplierBalance returns what is owed to a supplier, per currency
+func GetSupplierBalance(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var supplier models.Supplier
+	if result := database.DB.First(&supplier, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Supplier not found",
+		})
+	}
+
+	balances, err := services.SupplierBalances(database.DB, supplier.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to compute balance",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data": fiber.Map{
+			"supplier_id": supplier.ID,
+			"supplier":    supplier.Name,
+			"balances":    balances,
+		},
+	})
+}
+
+// preloadSupplierInvoice loads every relation shown on a single invoice
+func preloadSupplierInvoice(db *gorm.DB) *gorm.DB {
+	return db.Preload("Supplier").
+		Preload("User").
+		Preload("ApprovedBy").
+		Preload("Purchases").
+		Preload("Lines.Item").
+		Preload("Variances.Item").
+		Preload("Variances.ResolvedBy").
+		Preload("Payments", func(db *gorm.DB) *gorm.DB { return db.Order("date, id") }).
+		Preload("Payments.User")
+}
+
+// bodyDate parses an optional YYYY-MM-DD date of a request body. An empty
+// value gives the zero time.
+func bodyDate(value, key string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, errors.New(key + " must be a date in YYYY-MM-DD format")
+	}
+	return date, nil
+}
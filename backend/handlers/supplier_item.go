@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"errors"
+	"procurement-system/database"
+	"procurement-system/models"
+	"procurement-system/services"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type CreateSupplierItemRequest struct {
+	ItemID       uint         `json:"item_id"`
+	SKU          string       `json:"sku"`
+	UnitPrice    models.Money `json:"unit_price"`
+	Currency     string       `json:"currency"`
+	MinOrderQty  int          `json:"min_order_qty"`
+	LeadTimeDays int          `json:"lead_time_days"`
+	ValidFrom    string       `json:"valid_from"`
+	ValidTo      string       `json:"valid_to"`
+}
+
+type UpdateSupplierItemRequest struct {
+	SKU          string       `json:"sku"`
+	UnitPrice    models.Money `json:"unit_price"`
+	Currency     string       `json:"currency"`
+	MinOrderQty  int          `json:"min_order_qty"`
+	LeadTimeDays int          `json:"lead_time_days"`
+	ValidFrom    string       `json:"valid_from"`
+	ValidTo      string       `json:"valid_to"`
+}
+
+// supplierItemSortColumns are the columns catalog entries can be sorted by
+var supplierItemSortColumns = map[string]string{
+	"id":         "id",
+	"sku":        "sku",
+	"unit_price": "unit_price",
+	"valid_from": "valid_from",
+	"created_at": "created_at",
+}
+
+// GetSupplierItems returns a page of a supplier's catalog with items.
+// Filters: item_id, sku (substring), valid_on (YYYY-MM-DD).
+func GetSupplierItems(c *fiber.Ctx) error {
+	var supplier models.Supplier
+	if result := database.DB.First(&supplier, c.Params("id")); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Supplier not found",
+		})
+	}
+
+	params, err := parseListParams(c, supplierItemSortColumns, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	query, err := filterSupplierItems(c, database.DB.Model(&models.SupplierItem{}).Where("supplier_id = ?", supplier.ID))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	preload := func(db *gorm.DB) *gorm.DB { return db.Preload("Item") }
+	entries, meta, err := paginate(query, params, func(entry models.SupplierItem) uint { return entry.ID }, preload)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to fetch supplier catalog",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    entries,
+		"meta":    meta,
+	})
+}
+
+// GetItemSuppliers returns every supplier offering an item, cheapest first.
+// Filters: valid_on (YYYY-MM-DD).
+func GetItemSuppliers(c *fiber.Ctx) error {
+	var item models.Item
+	if result := database.DB.First(&item, c.Params("id")); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Item not found",
+		})
+	}
+
+	query, err := filterSupplierItems(c, database.DB.Where("item_id = ?", item.ID))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	var entries []models.SupplierItem
+	if result := query.InnerJoins("Supplier").Order("unit_price, supplier_items.id").Find(&entries); result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to fetch item suppliers",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    entries,
+	})
+}
+
+// filterSupplierItems applies the catalog filters from the query string
+func filterSupplierItems(c *fiber.Ctx, query *gorm.DB) (*gorm.DB, error) {
+	if id, ok, err := queryUint(c, "item_id"); err != nil {
+		return nil, err
+	} else if ok {
+		query = query.Where("item_id = ?", id)
+	}
+
+	if sku := c.Query("sku"); sku != "" {
+		query = query.Where("sku ILIKE ?", likePattern(sku))
+	}
+
+	if day, ok, err := queryDate(c, "valid_on"); err != nil {
+		return nil, err
+	} else if ok {
+		d := day.Format("2006-01-02")
+		query = query.Where("(valid_from IS NULL OR valid_from <= ?) AND (valid_to IS NULL OR valid_to >= ?)", d, d)
+	}
+
+	return query, nil
+}
+
+// GetSupplierItem returns a single catalog entry of a supplier
+func GetSupplierItem(c *fiber.Ctx) error {
+	var entry models.SupplierItem
+	if result := database.DB.Preload("Item").Where("supplier_id = ?", c.Params("id")).First(&entry, c.Params("entryId")); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Catalog entry not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    entry,
+	})
+}
+
+// CreateSupplierItem adds an item to a supplier's catalog
+func CreateSupplierItem(c *fiber.Ctx) error {
+	var req CreateSupplierItemRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request body",
+		})
+	}
+
+	// Validation
+	if req.ItemID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Item ID is required",
+		})
+	}
+
+	supplierID, err := c.ParamsInt("id")
+	if err != nil || supplierID <= 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Supplier not found",
+		})
+	}
+
+	entry := models.SupplierItem{
+		SupplierID: uint(supplierID),
+		ItemID:     req.ItemID,
+	}
+	if err := applySupplierItemRequest(&entry, UpdateSupplierItemRequest{
+		SKU:          req.SKU,
+		UnitPrice:    req.UnitPrice,
+		Currency:     req.Currency,
+		MinOrderQty:  req.MinOrderQty,
+		LeadTimeDays: req.LeadTimeDays,
+		ValidFrom:    req.ValidFrom,
+		ValidTo:      req.ValidTo,
+	}); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	err = database.Transaction(func(tx *gorm.DB) error {
+		return services.SaveSupplierItem(tx, &entry)
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to create catalog entry")
+	}
+
+	database.DB.Preload("Item").First(&entry, entry.ID)
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"message": "Catalog entry created successfully",
+		"data":    entry,
+	})
+}
+
+// UpdateSupplierItem changes the price and terms of a catalog entry
+func UpdateSupplierItem(c *fiber.Ctx) error {
+	var entry models.SupplierItem
+	if result := database.DB.Where("supplier_id = ?", c.Params("id")).First(&entry, c.Params("entryId")); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Catalog entry not found",
+		})
+	}
+
+	var req UpdateSupplierItemRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request body",
+		})
+	}
+
+	if err := applySupplierItemRequest(&entry, req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	err := database.Transaction(func(tx *gorm.DB) error {
+		return services.SaveSupplierItem(tx, &entry)
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to update catalog entry")
+	}
+
+	database.DB.Preload("Item").First(&entry, entry.ID)
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Catalog entry updated successfully",
+		"data":    entry,
+	})
+}
+
+// DeleteSupplierItem removes an item from a supplier's catalog. Purchases
+// already priced from the entry are not affected.
+func DeleteSupplierItem(c *fiber.Ctx) error {
+	var entry models.SupplierItem
+	if result := database.DB.Where("supplier_id = ?", c.Params("id")).First(&entry, c.Params("entryId")); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Catalog entry not found",
+		})
+	}
+
+	if result := database.DB.Delete(&entry); result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to delete catalog entry",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Catalog entry deleted successfully",
+	})
+}
+
+// applySupplierItemRequest copies the editable fields of a request onto a
+// catalog entry, filling in defaults and parsing the validity dates
+func applySupplierItemRequest(entry *models.SupplierItem, req UpdateSupplierItemRequest) error {
+	validFrom, err := parseOptionalDate("valid_from", req.ValidFrom)
+	if err != nil {
+		return err
+	}
+	validTo, err := parseOptionalDate("valid_to", req.ValidTo)
+	if err != nil {
+		return err
+	}
+
+	entry.SKU = strings.TrimSpace(req.SKU)
+	entry.UnitPrice = req.UnitPrice
+	entry.Currency = strings.ToUpper(strings.TrimSpace(req.Currency))
+	entry.MinOrderQty = req.MinOrderQty
+	if entry.MinOrderQty == 0 {
+		entry.MinOrderQty = 1
+	}
+	entry.LeadTimeDays = req.LeadTimeDays
+	entry.ValidFrom = validFrom
+	entry.ValidTo = validTo
+
+	return nil
+}
+
+// parseOptionalDate parses a YYYY-MM-DD request field; an empty value is nil
+func parseOptionalDate(key, v string) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+
+	date, err := time.ParseInLocation("2006-01-02", v, time.Local)
+	if err != nil {
+		return nil, errors.New(key + " must be a date in YYYY-MM-DD format")
+	}
+	return &date, nil
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"procurement-system/database"
+	"procurement-system/models"
+	"procurement-system/services"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type TaxCodeRequest struct {
+	Code        string      `json:"code"`
+	Name        string      `json:"name"`
+	Rate        models.Rate `json:"rate"`
+	Inclusive   bool        `json:"inclusive"`
+	Withholding bool        `json:"withholding"`
+}
+
+// taxCodeSortColumns are the columns tax codes can be sorted by
+var taxCodeSortColumns = map[string]string{
+	"id":   "id",
+	"code": "code",
+	"name": "name",
+	"rate": "rate",
+}
+
+// GetAllTaxCodes returns a page of tax codes, ordered by code unless
+// sorted otherwise
+func GetAllTaxCodes(c *fiber.Ctx) error {
+	params, err := parseListParams(c, taxCodeSortColumns, "code")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	codes, meta, err := paginate(database.DB.Model(&models.TaxCode{}), params, func(code models.TaxCode) uint { return code.ID })
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to fetch tax codes",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    codes,
+		"meta":    meta,
+	})
+}
+
+// GetTaxCode returns a single tax code by ID
+func GetTaxCode(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var code models.TaxCode
+	if result := database.DB.First(&code, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Tax code not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    code,
+	})
+}
+
+// CreateTaxCode adds a tax code
+func CreateTaxCode(c *fiber.Ctx) error {
+	var req TaxCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request body",
+		})
+	}
+
+	var code models.TaxCode
+	applyTaxCodeRequest(&code, req)
+
+	err := database.Transaction(func(tx *gorm.DB) error {
+		return services.SaveTaxCode(tx, &code)
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to create tax code")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"message": "Tax code created successfully",
+		"data":    code,
+	})
+}
+
+// UpdateTaxCode changes a tax code. Existing purchase lines keep the rate
+// they were priced with.
+func UpdateTaxCode(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var code models.TaxCode
+	if result := database.DB.First(&code, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Tax code not found",
+		})
+	}
+
+	var req TaxCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request body",
+		})
+	}
+
+	applyTaxCodeRequest(&code, req)
+
+	err := database.Transaction(func(tx *gorm.DB) error {
+		return services.SaveTaxCode(tx, &code)
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to update tax code")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Tax code updated successfully",
+		"data":    code,
+	})
+}
+
+// DeleteTaxCode removes a tax code. Items and suppliers that use it fall
+// back to no tax.
+func DeleteTaxCode(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var code models.TaxCode
+	if result := database.DB.First(&code, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Tax code not found",
+		})
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Item{}).Where("tax_code_id = ?", code.ID).Update("tax_code_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Supplier{}).Where("tax_code_id = ?", code.ID).Update("tax_code_id", nil).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&code).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to delete tax code",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Tax code deleted successfully",
+	})
+}
+
+// applyTaxCodeRequest copies a request onto code
+func applyTaxCodeRequest(code *models.TaxCode, req TaxCodeRequest) {
+	code.Code = req.Code
+	code.Name = req.Name
+	code.Rate = req.Rate
+	code.Inclusive = req.Inclusive
+	code.Withholding = req.Withholding
+}
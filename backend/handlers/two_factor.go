@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"procurement-system/database"
+	"procurement-system/models"
+	"procurement-system/services"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type DisableTwoFactorRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type TwoFactorPolicyRequest struct {
+	Roles []string `json:"roles"`
+}
+
+// GetTwoFactorStatus tells the current user whether two-factor
+// authentication is enabled or required, and how many recovery codes are
+// left
+func GetTwoFactorStatus(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uint)
+
+	var user models.User
+	if result := database.DB.First(&user, userID); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "User not found",
+		})
+	}
+
+	required, err := services.TwoFactorRequired(database.DB, user.Role)
+	if err != nil {
+		return serviceError(c, err, "Failed to fetch two-factor status")
+	}
+	codesLeft, err := services.RecoveryCodesLeft(database.DB, user.ID)
+	if err != nil {
+		return serviceError(c, err, "Failed to fetch two-factor status")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data": fiber.Map{
+			"enabled":             user.TOTPEnabled,
+			"required":            required,
+			"recovery_codes_left": codesLeft,
+		},
+	})
+}
+
+// SetupTwoFactor generates a TOTP secret for the current user, returning it
+// with an otpauth URI to show as a QR code. Two-factor authentication is
+// enabled once VerifyTwoFactor accepts a code.
+func SetupTwoFactor(c *fiber.Ctx) error {
+	var enrollment *services.TOTPEnrollment
+	err := database.Transaction(func(tx *gorm.DB) error {
+		var err error
+		enrollment, err = services.BeginTOTPEnrollment(tx, c.Locals("userID").(uint))
+		return err
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to set up two-factor authentication")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Add the secret to your authenticator app and verify a code to enable two-factor authentication",
+		"data":    enrollment,
+	})
+}
+
+// VerifyTwoFactor enables two-factor authentication for the current user
+// with a code from their authenticator app and returns their recovery
+// codes, which are shown only this once. Sessions that had to set up
+// two-factor authentication are unrestricted once their token is refreshed.
+func VerifyTwoFactor(c *fiber.Ctx) error {
+	var req TwoFactorCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request body",
+		})
+	}
+
+	var codes []string
+	err := database.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = services.ConfirmTOTPEnrollment(tx, c.Locals("userID").(uint), req.Code)
+		return err
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to enable two-factor authentication")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Two-factor authentication enabled. Store the recovery codes somewhere safe.",
+		"data": fiber.Map{
+			"recovery_codes": codes,
+		},
+	})
+}
+
+// DisableTwoFactor turns two-factor authentication off for the current
+// user, who must give their password and a code
+func DisableTwoFactor(c *fiber.Ctx) error {
+	var req DisableTwoFactorRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request body",
+		})
+	}
+
+	err := database.Transaction(func(tx *gorm.DB) error {
+		return services.DisableTOTP(tx, c.Locals("userID").(uint), req.Password, req.Code)
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to disable two-factor authentication")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Two-factor authentication disabled",
+	})
+}
+
+// RegenerateRecoveryCodes replaces the current user's recovery codes,
+// given a code
+func RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	var req TwoFactorCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request body",
+		})
+	}
+
+	var codes []string
+	err := database.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = services.RegenerateRecoveryCodes(tx, c.Locals("userID").(uint), req.Code)
+		return err
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to regenerate recovery codes")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Recovery codes regenerated. The previous ones no longer work.",
+		"data": fiber.Map{
+			"recovery_codes": codes,
+		},
+	})
+}
+
+// ResetUserTwoFactor turns two-factor authentication off for a user who has
+// lost their authenticator and recovery codes, and logs them out everywhere
+func ResetUserTwoFactor(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var user models.User
+	if result := database.DB.First(&user, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "User not found",
+		})
+	}
+
+	err := database.Transaction(func(tx *gorm.DB) error {
+		return services.ResetTwoFactor(tx, &user)
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to reset two-factor authentication")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Two-factor authentication reset successfully",
+		"data":    adminUser(user),
+	})
+}
+
+// GetTwoFactorPolicy returns the roles that must use two-factor
+// authentication
+func GetTwoFactorPolicy(c *fiber.Ctx) error {
+	roles, err := services.TwoFactorRoles(database.DB)
+	if err != nil {
+		return serviceError(c, err, "Failed to fetch two-factor policy")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data": fiber.Map{
+			"roles": roles,
+		},
+	})
+}
+
+// UpdateTwoFactorPolicy replaces the roles that must use two-factor
+// authentication
+func UpdateTwoFactorPolicy(c *fiber.Ctx) error {
+	var req TwoFactorPolicyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request body",
+		})
+	}
+
+	var roles []string
+	err := database.Transaction(func(tx *gorm.DB) error {
+		var err error
+		roles, err = services.SetTwoFactorRoles(tx, req.Roles)
+		return err
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to update two-factor policy")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Two-factor policy updated successfully",
+		"data": fiber.Map{
+			"roles": roles,
+		},
+	})
+}
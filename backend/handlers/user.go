@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"procurement-system/database"
+	"procurement-system/models"
+	"procurement-system/services"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type UpdateUserRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// userSortColumns are the columns users can be sorted by
+var userSortColumns = map[string]string{
+	"id":         "id",
+	"username":   "username",
+	"role":       "role",
+	"created_at": "created_at",
+}
+
+// GetAllUsers returns a page of users
+func GetAllUsers(c *fiber.Ctx) error {
+	params, err := parseListParams(c, userSortColumns, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	users, meta, err := paginate(database.DB.Model(&models.User{}), params, func(user models.User) uint { return user.ID })
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to fetch users",
+		})
+	}
+
+	data := make([]fiber.Map, len(users))
+	for i, user := range users {
+		data[i] = adminUser(user)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    data,
+		"meta":    meta,
+	})
+}
+
+// UpdateUserRole changes the role of an existing user
+func UpdateUserRole(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var user models.User
+	if result := database.DB.First(&user, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "User not found",
+		})
+	}
+
+	var req UpdateUserRoleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request body",
+		})
+	}
+
+	// Validation
+	if !models.IsValidRole(req.Role) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid role",
+		})
+	}
+
+	// Prevent admins from locking themselves out
+	if user.ID == c.Locals("userID").(uint) && req.Role != models.RoleAdmin {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "You cannot change your own role",
+		})
+	}
+
+	// Tokens carry the role, so the user has to log in again for the new
+	// one to take effect
+	err := database.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("role", req.Role).Error; err != nil {
+			return err
+		}
+		return services.RevokeUserSessions(tx, user.ID)
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to update user role",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "User role updated successfully",
+		"data":    adminUser(user),
+	})
+}
+
+// LogoutUser ends every session of a user
+func LogoutUser(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var user models.User
+	if result := database.DB.First(&user, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "User not found",
+		})
+	}
+
+	err := database.Transaction(func(tx *gorm.DB) error {
+		return services.RevokeUserSessions(tx, user.ID)
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to log out user")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "User logged out of all sessions",
+	})
+}
+
+// UnlockUser lifts the lockout of a user locked out after failed logins
+func UnlockUser(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var user models.User
+	if result := database.DB.First(&user, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "User not found",
+		})
+	}
+
+	err := database.Transaction(func(tx *gorm.DB) error {
+		return services.UnlockUser(tx, &user)
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to unlock user")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "User unlocked successfully",
+		"data":    adminUser(user),
+	})
+}
+
+// adminUser is a user as shown to admins, including the email address and
+// lockout state that are hidden wherever else users appear
+func adminUser(user models.User) fiber.Map {
+	return fiber.Map{
+		"id":            user.ID,
+		"username":      user.Username,
+		"email":         user.Email,
+		"role":          user.Role,
+		"failed_logins": user.FailedLogins,
+		"locked_until":  user.LockedUntil,
+		"totp_enabled":  user.TOTPEnabled,
+		"created_at":    user.CreatedAt,
+		"updated_at":    user.UpdatedAt,
+	}
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"procurement-system/database"
+	"procurement-system/models"
+	"procurement-system/services"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type WarehouseRequest struct {
+	Code      string `json:"code"`
+	Name      string `json:"name"`
+	Address   string `json:"address"`
+	IsDefault bool   `json:"is_default"`
+}
+
+// warehouseSortColumns are the columns warehouses can be sorted by
+var warehouseSortColumns = map[string]string{
+	"id":   "id",
+	"code": "code",
+	"name": "name",
+}
+
+// GetAllWarehouses returns a page of warehouses, ordered by code unless
+// sorted otherwise
+func GetAllWarehouses(c *fiber.Ctx) error {
+	params, err := parseListParams(c, warehouseSortColumns, "code")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	warehouses, meta, err := paginate(database.DB.Model(&models.Warehouse{}), params, func(warehouse models.Warehouse) uint { return warehouse.ID })
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to fetch warehouses",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    warehouses,
+		"meta":    meta,
+	})
+}
+
+// GetWarehouse returns a single warehouse by ID
+func GetWarehouse(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var warehouse models.Warehouse
+	if result := database.DB.First(&warehouse, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Warehouse not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    warehouse,
+	})
+}
+
+// GetWarehouseStock returns the items in stock at a warehouse
+func GetWarehouseStock(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var warehouse models.Warehouse
+	if result := database.DB.First(&warehouse, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Warehouse not found",
+		})
+	}
+
+	var stocks []struct {
+		ItemID   uint   `json:"item_id"`
+		ItemName string `json:"item_name"`
+		Stock    int    `json:"stock"`
+	}
+	err := database.DB.Model(&models.ItemStock{}).
+		Select("item_stocks.item_id, items.name AS item_name, item_stocks.stock").
+		Joins("JOIN items ON items.id = item_stocks.item_id AND items.deleted_at IS NULL").
+		Where("item_stocks.warehouse_id = ? AND item_stocks.stock <> 0", warehouse.ID).
+		Order("items.name, item_stocks.item_id").
+		Scan(&stocks).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to fetch warehouse stock",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data": fiber.Map{
+			"warehouse": warehouse,
+			"items":     stocks,
+		},
+	})
+}
+
+// CreateWarehouse adds a stock location
+func CreateWarehouse(c *fiber.Ctx) error {
+	var req WarehouseRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request body",
+		})
+	}
+
+	var warehouse models.Warehouse
+	applyWarehouseRequest(&warehouse, req)
+
+	err := database.Transaction(func(tx *gorm.DB) error {
+		return services.SaveWarehouse(tx, &warehouse)
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to create warehouse")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"message": "Warehouse created successfully",
+		"data":    warehouse,
+	})
+}
+
+// UpdateWarehouse changes the code, name, address or default flag of a
+// warehouse
+func UpdateWarehouse(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var warehouse models.Warehouse
+	if result := database.DB.First(&warehouse, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Warehouse not found",
+		})
+	}
+
+	var req WarehouseRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request body",
+		})
+	}
+
+	applyWarehouseRequest(&warehouse, req)
+
+	err := database.Transaction(func(tx *gorm.DB) error {
+		return services.SaveWarehouse(tx, &warehouse)
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to update warehouse")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Warehouse updated successfully",
+		"data":    warehouse,
+	})
+}
+
+// DeleteWarehouse soft deletes an empty warehouse
+func DeleteWarehouse(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var warehouse models.Warehouse
+	if result := database.DB.First(&warehouse, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Warehouse not found",
+		})
+	}
+
+	err := database.Transaction(func(tx *gorm.DB) error {
+		return services.DeleteWarehouse(tx, &warehouse)
+	})
+	if err != nil {
+		return serviceError(c, err, "Failed to delete warehouse")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Warehouse deleted successfully",
+	})
+}
+
+// applyWarehouseRequest copies a request onto warehouse
+func applyWarehouseRequest(warehouse *models.Warehouse, req WarehouseRequest) {
+	warehouse.Code = req.Code
+	warehouse.Name = req.Name
+	warehouse.Address = req.Address
+	warehouse.IsDefault = req.IsDefault
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"procurement-system/database"
+	"procurement-system/models"
+	"procurement-system/webhooks"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// webhookDeliverySortColumns are the columns deliveries can be sorted by
+var webhookDeliverySortColumns = map[string]string{
+	"id":              "id",
+	"event":           "event",
+	"status":          "status",
+	"attempts":        "attempts",
+	"next_attempt_at": "next_attempt_at",
+	"created_at":      "created_at",
+}
+
+// GetAllWebhookDeliveries returns a page of outbox entries.
+// Filters: status, event.
+func GetAllWebhookDeliveries(c *fiber.Ctx) error {
+	params, err := parseListParams(c, webhookDeliverySortColumns, "-id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	query := database.DB.Model(&models.WebhookDelivery{})
+
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	if event := c.Query("event"); event != "" {
+		query = query.Where("event = ?", event)
+	}
+
+	deliveries, meta, err := paginate(query, params, func(delivery models.WebhookDelivery) uint { return delivery.ID })
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to fetch webhook deliveries",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    deliveries,
+		"meta":    meta,
+	})
+}
+
+// GetWebhookDelivery returns a single outbox entry by ID
+func GetWebhookDelivery(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var delivery models.WebhookDelivery
+	if result := database.DB.First(&delivery, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Webhook delivery not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    delivery,
+	})
+}
+
+// ReplayWebhookDelivery queues a delivery to be sent again
+func ReplayWebhookDelivery(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var delivery models.WebhookDelivery
+	if result := database.DB.First(&delivery, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Webhook delivery not found",
+		})
+	}
+
+	if err := webhooks.Replay(database.DB, &delivery); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to replay webhook delivery",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Webhook delivery queued for replay",
+		"data":    delivery,
+	})
+}
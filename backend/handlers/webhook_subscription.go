@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"procurement-system/database"
+	"procurement-system/models"
+	"procurement-system/webhooks"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type CreateWebhookSubscriptionRequest struct {
+	Name    string   `json:"name"`
+	URL     string   `json:"url"`
+	Secret  string   `json:"secret"`
+	Events  []string `json:"events"`
+	Enabled *bool    `json:"enabled"`
+}
+
+type UpdateWebhookSubscriptionRequest struct {
+	Name         string   `json:"name"`
+	URL          string   `json:"url"`
+	Events       []string `json:"events"`
+	Enabled      *bool    `json:"enabled"`
+	RotateSecret bool     `json:"rotate_secret"`
+}
+
+// webhookSubscriptionWithSecret exposes the signing secret, which is only
+// returned when a subscription is created or its secret is rotated
+type webhookSubscriptionWithSecret struct {
+	models.WebhookSubscription
+	Secret string `json:"secret"`
+}
+
+// validateSubscription checks the URL and event list of a subscription
+func validateSubscription(rawURL string, events []string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("URL must be an absolute http or https URL")
+	}
+
+	if len(events) == 0 {
+		return errors.New("At least one event is required")
+	}
+	for _, event := range events {
+		if !webhooks.IsValidEvent(event) {
+			return errors.New("Unknown event '" + event + "'. Valid events: " + strings.Join(webhooks.Events, ", ") + " or *")
+		}
+	}
+
+	return nil
+}
+
+// newWebhookSecret generates a random signing secret
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// webhookSubscriptionSortColumns are the columns webhook subscriptions can be
+// sorted by
+var webhookSubscriptionSortColumns = map[string]string{
+	"id":         "id",
+	"url":        "url",
+	"created_at": "created_at",
+}
+
+// GetAllWebhookSubscriptions returns a page of webhook subscriptions
+func GetAllWebhookSubscriptions(c *fiber.Ctx) error {
+	params, err := parseListParams(c, webhookSubscriptionSortColumns, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	subscriptions, meta, err := paginate(database.DB.Model(&models.WebhookSubscription{}), params, func(subscription models.WebhookSubscription) uint { return subscription.ID })
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to fetch webhook subscriptions",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    subscriptions,
+		"meta":    meta,
+	})
+}
+
+// GetWebhookSubscription returns a single webhook subscription by ID
+func GetWebhookSubscription(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var subscription models.WebhookSubscription
+	if result := database.DB.First(&subscription, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Webhook subscription not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    subscription,
+	})
+}
+
+// CreateWebhookSubscription registers a new endpoint. A secret is generated
+// when none is supplied; it is only returned in this response.
+func CreateWebhookSubscription(c *fiber.Ctx) error {
+	var req CreateWebhookSubscriptionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request body",
+		})
+	}
+
+	if err := validateSubscription(req.URL, req.Events); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		var err error
+		if secret, err = newWebhookSecret(); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"message": "Failed to generate webhook secret",
+			})
+		}
+	}
+
+	subscription := models.WebhookSubscription{
+		Name:    req.Name,
+		URL:     req.URL,
+		Secret:  secret,
+		Events:  req.Events,
+		Enabled: req.Enabled == nil || *req.Enabled,
+	}
+
+	if result := database.DB.Create(&subscription); result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to create webhook subscription",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"message": "Webhook subscription created successfully",
+		"data":    webhookSubscriptionWithSecret{subscription, subscription.Secret},
+	})
+}
+
+// UpdateWebhookSubscription changes a subscription's URL, events or enabled
+// flag, and rotates its secret when rotate_secret is set
+func UpdateWebhookSubscription(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var subscription models.WebhookSubscription
+	if result := database.DB.First(&subscription, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Webhook subscription not found",
+		})
+	}
+
+	var req UpdateWebhookSubscriptionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request body",
+		})
+	}
+
+	if err := validateSubscription(req.URL, req.Events); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	subscription.Name = req.Name
+	subscription.URL = req.URL
+	subscription.Events = req.Events
+	if req.Enabled != nil {
+		subscription.Enabled = *req.Enabled
+	}
+	if req.RotateSecret {
+		secret, err := newWebhookSecret()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"message": "Failed to generate webhook secret",
+			})
+		}
+		subscription.Secret = secret
+	}
+
+	if result := database.DB.Save(&subscription); result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to update webhook subscription",
+		})
+	}
+
+	var data interface{} = subscription
+	if req.RotateSecret {
+		data = webhookSubscriptionWithSecret{subscription, subscription.Secret}
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Webhook subscription updated successfully",
+		"data":    data,
+	})
+}
+
+// DeleteWebhookSubscription removes a subscription. Its pending deliveries
+// are dead-lettered by the dispatcher.
+func DeleteWebhookSubscription(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var subscription models.WebhookSubscription
+	if result := database.DB.First(&subscription, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"message": "Webhook subscription not found",
+		})
+	}
+
+	if result := database.DB.Delete(&subscription); result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to delete webhook subscription",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Webhook subscription deleted successfully",
+	})
+}
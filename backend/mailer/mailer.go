@@ -0,0 +1,183 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"procurement-system/config"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers email
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Default is the mailer selected by MAILER, set up by Setup
+var Default Mailer = LogMailer{}
+
+// Setup selects the mailer configured by MAILER: log (the default) writes
+// messages to the application log, file appends them to MAIL_FILE, and smtp
+// sends them through SMTP_HOST
+func Setup() error {
+	cfg := config.AppConfig
+	switch cfg.Mailer {
+	case "", "log":
+		Default = LogMailer{}
+	case "file":
+		if cfg.MailFile == "" {
+			return fmt.Errorf("MAIL_FILE is required for the file mailer")
+		}
+		Default = &FileMailer{Path: cfg.MailFile}
+	case "smtp":
+		if cfg.SMTPHost == "" || cfg.MailFrom == "" {
+			return fmt.Errorf("SMTP_HOST and MAIL_FROM are required for the smtp mailer")
+		}
+		if cfg.SMTPTimeout <= 0 {
+			return fmt.Errorf("SMTP_TIMEOUT must be positive")
+		}
+		Default = &SMTPMailer{
+			Addr:     net.JoinHostPort(cfg.SMTPHost, cfg.SMTPPort),
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.MailFrom,
+			Timeout:  cfg.SMTPTimeout,
+		}
+	default:
+		return fmt.Errorf("unknown MAILER %q, use log, file or smtp", cfg.Mailer)
+	}
+	return nil
+}
+
+// Send delivers msg with the default mailer
+func Send(msg Message) error {
+	return Default.Send(msg)
+}
+
+// SendAsync delivers msg with the default mailer in the background, so that
+// the caller neither waits for the mail server nor reveals through its
+// response time whether a message was sent. Failures are logged as failures
+// to send what.
+func SendAsync(msg Message, what string) {
+	go func() {
+		if err := Send(msg); err != nil {
+			log.Printf("Failed to send %s: %v", what, err)
+		}
+	}()
+}
+
+// LogMailer writes messages to the application log, for local use
+type LogMailer struct{}
+
+func (LogMailer) Send(msg Message) error {
+	log.Printf("Mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// FileMailer appends messages to a file, for local use and tests
+type FileMailer struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (m *FileMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC1123Z), msg.To, msg.Subject, msg.Body)
+	return err
+}
+
+// SMTPMailer sends messages through an SMTP server, using STARTTLS when the
+// server offers it and authenticating with PLAIN auth when a username is
+// set. A message that cannot be delivered within Timeout fails.
+type SMTPMailer struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+	Timeout  time.Duration
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	// Header values must not smuggle in further headers
+	for _, value := range []string{msg.To, msg.Subject} {
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("invalid header value %q", value)
+		}
+	}
+
+	body := strings.ReplaceAll(msg.Body, "\n", "\r\n")
+	data := "From: " + m.From + "\r\n" +
+		"To: " + msg.To + "\r\n" +
+		"Subject: " + msg.Subject + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + body + "\r\n"
+
+	conn, err := net.DialTimeout("tcp", m.Addr, m.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// The deadline covers the whole conversation, so a slow server cannot
+	// hold on to the sender
+	if err := conn.SetDeadline(time.Now().Add(m.Timeout)); err != nil {
+		return err
+	}
+
+	host, _, _ := net.SplitHostPort(m.Addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if m.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.Username, m.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(m.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(msg.To); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(data)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -4,7 +4,10 @@ import (
 	"log"
 	"procurement-system/config"
 	"procurement-system/database"
+	"procurement-system/mailer"
 	"procurement-system/routes"
+	"procurement-system/services"
+	"procurement-system/webhooks"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -15,15 +18,63 @@ import (
 func main() {
 	// Load configuration
 	config.LoadConfig()
+	if err := services.ValidateNumberFormat(config.AppConfig.PurchaseNumberFormat); err != nil {
+		log.Fatal("Invalid PURCHASE_NUMBER_FORMAT: ", err)
+	}
+	if err := services.ValidateNumberFormat(config.AppConfig.PurchaseReturnNumberFormat); err != nil {
+		log.Fatal("Invalid PURCHASE_RETURN_NUMBER_FORMAT: ", err)
+	}
+	if err := services.ValidateNumberFormat(config.AppConfig.StockTransferNumberFormat); err != nil {
+		log.Fatal("Invalid STOCK_TRANSFER_NUMBER_FORMAT: ", err)
+	}
+	if err := services.LoadTokenKeys(); err != nil {
+		log.Fatal("Invalid JWT configuration: ", err)
+	}
+	if config.AppConfig.LoginMaxFailures < 1 || config.AppConfig.LoginIPMaxFailures < 1 || config.AppConfig.LoginLockoutDuration <= 0 || config.AppConfig.LoginDelay < 0 {
+		log.Fatal("LOGIN_MAX_FAILURES, LOGIN_IP_MAX_FAILURES and LOGIN_LOCKOUT_DURATION must be positive and LOGIN_DELAY not negative")
+	}
+	if len(config.AppConfig.TrustedProxies) > 0 && config.AppConfig.ProxyHeader == "" {
+		log.Fatal("PROXY_HEADER must be set when TRUSTED_PROXIES is")
+	}
+	if config.AppConfig.PasswordResetTTL <= 0 {
+		log.Fatal("PASSWORD_RESET_TTL must be positive")
+	}
+	if err := mailer.Setup(); err != nil {
+		log.Fatal("Invalid mail configuration: ", err)
+	}
+	if config.AppConfig.AccessTokenTTL <= 0 || config.AppConfig.RefreshTokenTTL < config.AppConfig.AccessTokenTTL {
+		log.Fatal("ACCESS_TOKEN_TTL must be positive and no longer than REFRESH_TOKEN_TTL")
+	}
+	if config.AppConfig.WebhookMaxAttempts < 1 || config.AppConfig.WebhookTimeout <= 0 || config.AppConfig.WebhookPollInterval <= 0 {
+		log.Fatal("WEBHOOK_MAX_ATTEMPTS, WEBHOOK_TIMEOUT and WEBHOOK_POLL_INTERVAL must be positive")
+	}
+	if _, err := services.ParseTolerance(config.AppConfig.MatchQtyTolerance); err != nil {
+		log.Fatal("Invalid MATCH_QTY_TOLERANCE: ", err)
+	}
+	if _, err := services.ParseTolerance(config.AppConfig.MatchPriceTolerance); err != nil {
+		log.Fatal("Invalid MATCH_PRICE_TOLERANCE: ", err)
+	}
 
 	// Connect to database
 	database.Connect()
 
 	// Run migrations
 	database.Migrate()
+	if err := services.NumberLegacyPurchases(database.DB, config.AppConfig.PurchaseNumberFormat); err != nil {
+		log.Fatal("Failed to number existing purchases: ", err)
+	}
+
+	// Seed initial data
+	database.Seed()
+
+	// Deliver queued webhook notifications in the background
+	webhooks.StartDispatcher()
+
+	// Suggest purchases for items that need reordering in the background
+	services.StartReorderCheck(database.DB)
 
 	// Create Fiber app
-	app := fiber.New(fiber.Config{
+	appConfig := fiber.Config{
 		ErrorHandler: func(c *fiber.Ctx, err error) error {
 			code := fiber.StatusInternalServerError
 			if e, ok := err.(*fiber.Error); ok {
@@ -34,7 +85,17 @@ func main() {
 				"message": err.Error(),
 			})
 		},
-	})
+	}
+
+	// Client addresses are only taken from the proxy header of requests
+	// sent by a trusted proxy, so that clients cannot pick their own
+	if len(config.AppConfig.TrustedProxies) > 0 {
+		appConfig.EnableTrustedProxyCheck = true
+		appConfig.TrustedProxies = config.AppConfig.TrustedProxies
+		appConfig.ProxyHeader = config.AppConfig.ProxyHeader
+		appConfig.EnableIPValidation = true
+	}
+	app := fiber.New(appConfig)
 
 	// Middleware
 	app.Use(logger.New())
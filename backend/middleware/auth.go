@@ -1,13 +1,16 @@
 package middleware
 
 import (
-	"procurement-system/config"
+	"errors"
+	"procurement-system/database"
+	"procurement-system/services"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v5"
 )
 
+// AuthMiddleware admits requests bearing a valid access token that has not
+// been revoked
 func AuthMiddleware() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		authHeader := c.Get("Authorization")
@@ -27,37 +30,43 @@ func AuthMiddleware() fiber.Handler {
 			})
 		}
 
-		tokenString := parts[1]
-
-		// Parse and validate token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fiber.NewError(fiber.StatusUnauthorized, "Invalid signing method")
+		claims, err := services.ParseAccessToken(database.DB, parts[1])
+		if err != nil {
+			var unauthorized *services.UnauthorizedError
+			if errors.As(err, &unauthorized) {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"success": false,
+					"message": unauthorized.Message,
+				})
 			}
-			return []byte(config.AppConfig.JWTSecret), nil
-		})
-
-		if err != nil || !token.Valid {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success": false,
-				"message": "Invalid or expired token",
+				"message": "Failed to verify token",
 			})
 		}
 
-		// Extract claims
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+		// Set user info in context
+		c.Locals("userID", claims.UserID)
+		c.Locals("username", claims.Username)
+		c.Locals("role", claims.Role)
+		c.Locals("tokenID", claims.TokenID)
+		c.Locals("sessionID", claims.SessionID)
+		c.Locals("twoFactorSetupRequired", claims.TwoFactorSetupRequired)
+
+		return c.Next()
+	}
+}
+
+// RequireTwoFactorSetup rejects requests of sessions that must set up
+// two-factor authentication first. Must be used after AuthMiddleware.
+func RequireTwoFactorSetup() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if required, _ := c.Locals("twoFactorSetupRequired").(bool); required {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 				"success": false,
-				"message": "Invalid token claims",
+				"message": "Your role requires two-factor authentication, please set it up first",
 			})
 		}
-
-		// Set user info in context
-		c.Locals("userID", uint(claims["user_id"].(float64)))
-		c.Locals("username", claims["username"].(string))
-		c.Locals("role", claims["role"].(string))
-
 		return c.Next()
 	}
 }
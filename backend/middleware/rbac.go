@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"procurement-system/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Permission is a single action a role may perform
+type Permission string
+
+// Permissions
+const (
+	PermItemsRead        Permission = "items:read"
+	PermItemsWrite       Permission = "items:write"
+	PermItemsDelete      Permission = "items:delete"
+	PermSuppliersRead    Permission = "suppliers:read"
+	PermSuppliersWrite   Permission = "suppliers:write"
+	PermSuppliersDelete  Permission = "suppliers:delete"
+	PermPurchasesRead    Permission = "purchases:read"
+	PermPurchasesCreate  Permission = "purchases:create"
+	PermPurchasesApprove Permission = "purchases:approve"
+	PermPurchasesReceive Permission = "purchases:receive"
+	PermUsersManage      Permission = "users:manage"
+	PermWebhooksManage   Permission = "webhooks:manage"
+	PermRatesManage      Permission = "exchange_rates:manage"
+	PermTaxCodesManage   Permission = "tax_codes:manage"
+	PermPayablesRead     Permission = "payables:read"
+	PermPayablesWrite    Permission = "payables:write"
+	PermPayablesApprove  Permission = "payables:approve"
+	PermWarehousesManage Permission = "warehouses:manage"
+)
+
+// rolePermissions is the permission matrix. Admin is granted everything
+// and therefore not listed here.
+var rolePermissions = map[string][]Permission{
+	models.RolePurchaser: {
+		PermItemsRead,
+		PermSuppliersRead, PermSuppliersWrite,
+		PermPurchasesRead, PermPurchasesCreate,
+		PermPayablesRead,
+	},
+	models.RoleWarehouse: {
+		PermItemsRead, PermItemsWrite,
+		PermSuppliersRead,
+		PermPurchasesRead, PermPurchasesReceive,
+	},
+	models.RoleViewer: {
+		PermItemsRead,
+		PermSuppliersRead,
+		PermPurchasesRead,
+	},
+}
+
+// HasPermission reports whether role is granted perm
+func HasPermission(role string, perm Permission) bool {
+	if role == models.RoleAdmin {
+		return true
+	}
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// RequirePermission rejects requests whose role lacks perm.
+// Must be used after AuthMiddleware.
+func RequirePermission(perm Permission) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		role, _ := c.Locals("role").(string)
+		if !HasPermission(role, perm) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"success": false,
+				"message": "You do not have permission to perform this action",
+			})
+		}
+
+		return c.Next()
+	}
+}
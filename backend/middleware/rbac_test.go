@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"procurement-system/models"
+	"testing"
+)
+
+func TestHasPermission(t *testing.T) {
+	tests := []struct {
+		role string
+		perm Permission
+		want bool
+	}{
+		// Viewers only read
+		{models.RoleViewer, PermItemsRead, true},
+		{models.RoleViewer, PermSuppliersRead, true},
+		{models.RoleViewer, PermPurchasesRead, true},
+		{models.RoleViewer, PermItemsWrite, false},
+		{models.RoleViewer, PermItemsDelete, false},
+		{models.RoleViewer, PermSuppliersWrite, false},
+		{models.RoleViewer, PermPurchasesCreate, false},
+		{models.RoleViewer, PermPurchasesReceive, false},
+		{models.RoleViewer, PermPayablesRead, false},
+
+		{models.RolePurchaser, PermPurchasesCreate, true},
+		{models.RolePurchaser, PermSuppliersWrite, true},
+		{models.RolePurchaser, PermPayablesRead, true},
+		{models.RolePurchaser, PermPurchasesApprove, false},
+		{models.RolePurchaser, PermPurchasesReceive, false},
+		{models.RolePurchaser, PermItemsWrite, false},
+		{models.RolePurchaser, PermPayablesWrite, false},
+
+		{models.RoleWarehouse, PermPurchasesReceive, true},
+		{models.RoleWarehouse, PermItemsWrite, true},
+		{models.RoleWarehouse, PermPurchasesCreate, false},
+		{models.RoleWarehouse, PermItemsDelete, false},
+		{models.RoleWarehouse, PermWarehousesManage, false},
+
+		// Admins are granted everything, including permissions no other
+		// role has
+		{models.RoleAdmin, PermItemsDelete, true},
+		{models.RoleAdmin, PermPurchasesApprove, true},
+		{models.RoleAdmin, PermPayablesApprove, true},
+		{models.RoleAdmin, Permission("anything"), true},
+
+		// Unknown roles are granted nothing
+		{"", PermItemsRead, false},
+		{"superuser", PermItemsRead, false},
+		{"Admin", PermUsersManage, false},
+	}
+	for _, tt := range tests {
+		if got := HasPermission(tt.role, tt.perm); got != tt.want {
+			t.Errorf("HasPermission(%q, %s) = %v, want %v", tt.role, tt.perm, got, tt.want)
+		}
+	}
+}
+
+// TestAdminOnlyPermissions checks that managing users and the other
+// administrative settings is left to admins
+func TestAdminOnlyPermissions(t *testing.T) {
+	adminOnly := []Permission{
+		PermUsersManage,
+		PermWebhooksManage,
+		PermRatesManage,
+		PermTaxCodesManage,
+		PermWarehousesManage,
+		PermPurchasesApprove,
+		PermPayablesApprove,
+	}
+	for _, perm := range adminOnly {
+		for _, role := range models.Roles {
+			if got, want := HasPermission(role, perm), role == models.RoleAdmin; got != want {
+				t.Errorf("HasPermission(%q, %s) = %v, want %v", role, perm, got, want)
+			}
+		}
+	}
+}
+
+// TestRolePermissionsKnown checks that the matrix only lists known roles,
+// none of them admin, and no permission twice
+func TestRolePermissionsKnown(t *testing.T) {
+	for role, perms := range rolePermissions {
+		if !models.IsValidRole(role) || role == models.RoleAdmin {
+			t.Errorf("matrix lists role %q", role)
+		}
+		seen := make(map[Permission]bool)
+		for _, perm := range perms {
+			if seen[perm] {
+				t.Errorf("role %q lists %s twice", role, perm)
+			}
+			seen[perm] = true
+		}
+	}
+}
@@ -1,68 +1,723 @@
 package models
 
 import (
+	"errors"
 	"time"
 
 	"gorm.io/gorm"
 )
 
-// User model
+// User roles
+const (
+	RoleAdmin     = "admin"
+	RolePurchaser = "purchaser"
+	RoleWarehouse = "warehouse"
+	RoleViewer    = "viewer"
+)
+
+// DefaultRole is assigned to self-registered users
+const DefaultRole = RoleViewer
+
+// SystemUsername is the user that changes made by background jobs, such as
+// reorder suggestions, are recorded under. It cannot log in.
+const SystemUsername = "system"
+
+// Roles lists every known role
+var Roles = []string{RoleAdmin, RolePurchaser, RoleWarehouse, RoleViewer}
+
+// IsValidRole reports whether role is one of the known roles
+func IsValidRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// User model. Email, stored lower-cased, receives password reset links; it
+// is only shown to the user themselves and to admins.
 type User struct {
-	ID        uint           `gorm:"primaryKey" json:"id"`
-	Username  string         `gorm:"uniqueIndex;not null;size:100" json:"username"`
-	Password  string         `gorm:"not null" json:"-"`
-	Role      string         `gorm:"default:user;size:50" json:"role"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+	ID       uint    `gorm:"primaryKey" json:"id"`
+	Username string  `gorm:"uniqueIndex;not null;size:100" json:"username"`
+	Email    *string `gorm:"uniqueIndex;size:255" json:"-"`
+	Password string  `gorm:"not null" json:"-"`
+	Role     string  `gorm:"default:viewer;size:50" json:"role"`
+	// FailedLogins counts consecutive failed logins; reaching the lockout
+	// threshold locks the account until LockedUntil. Both are only shown to
+	// admins.
+	FailedLogins int        `gorm:"not null;default:0" json:"-"`
+	LockedUntil  *time.Time `json:"-"`
+	// TOTPSecret is the base32 two-factor secret, set on enrollment and in
+	// use once TOTPEnabled; TOTPLastStep is the time step of the last code
+	// accepted, which cannot be used again
+	TOTPSecret   string         `gorm:"size:64" json:"-"`
+	TOTPEnabled  bool           `gorm:"not null;default:false" json:"totp_enabled"`
+	TOTPLastStep int64          `gorm:"not null;default:0" json:"-"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// RecoveryCode is a single-use code that stands in for a TOTP code when a
+// user has lost their authenticator. Only a hash of the code is stored.
+type RecoveryCode struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	CodeHash  string     `gorm:"size:64;not null" json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
 }
 
-// Supplier model
+// LoginChallenge is the pending second step of a login by a user with
+// two-factor authentication. Only a hash of the challenge token is stored.
+type LoginChallenge struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	TokenHash string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	Attempts  int        `gorm:"not null;default:0" json:"attempts"`
+	ExpiresAt time.Time  `gorm:"not null;index" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// TwoFactorRole is a role whose users must use two-factor authentication
+type TwoFactorRole struct {
+	Role      string    `gorm:"primaryKey;size:50" json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Outcomes of a login attempt
+const (
+	LoginSucceeded       = "succeeded"
+	LoginInvalidPassword = "invalid_password"
+	LoginUnknownUser     = "unknown_user"
+	LoginAccountLocked   = "account_locked"
+	LoginThrottled       = "throttled"
+	LoginIPBlocked       = "ip_blocked"
+	LoginOTPRequired     = "otp_required"
+	LoginInvalidOTP      = "invalid_otp"
+)
+
+// LoginAttempt is one entry of the login history. Attempts for unknown
+// usernames have no UserID.
+type LoginAttempt struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    *uint     `gorm:"index" json:"user_id"`
+	Username  string    `gorm:"size:100;not null" json:"username"`
+	IP        string    `gorm:"size:64;not null;index:idx_login_attempts_ip_created" json:"ip"`
+	UserAgent string    `gorm:"size:255" json:"user_agent"`
+	Success   bool      `gorm:"not null" json:"success"`
+	Outcome   string    `gorm:"size:20;not null" json:"outcome"`
+	CreatedAt time.Time `gorm:"index;index:idx_login_attempts_ip_created" json:"created_at"`
+}
+
+// RefreshToken is one refresh token of a login session. Refresh tokens are
+// rotated on every use; the tokens of one session share its SessionID, and
+// each remembers the access token issued with it so that revoking the
+// session can revoke that access token too. Only a hash of the token is
+// stored.
+type RefreshToken struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	UserID          uint       `gorm:"not null;index" json:"user_id"`
+	SessionID       string     `gorm:"size:32;not null;index" json:"session_id"`
+	TokenHash       string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	AccessJTI       string     `gorm:"size:32;not null" json:"-"`
+	AccessExpiresAt time.Time  `gorm:"not null" json:"-"`
+	ExpiresAt       time.Time  `gorm:"not null;index" json:"expires_at"`
+	RevokedAt       *time.Time `json:"revoked_at,omitempty"`
+	IP              string     `gorm:"size:64" json:"ip"`
+	UserAgent       string     `gorm:"size:255" json:"user_agent"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// PasswordResetToken is a single-use token that lets a user who forgot
+// their password set a new one. Only a hash of the token is stored.
+type PasswordResetToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	TokenHash string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null;index" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// RevokedToken is an access token that may no longer be used although it
+// has not expired yet. Entries are purged once the token has expired.
+type RevokedToken struct {
+	JTI       string    `gorm:"primaryKey;size:32" json:"jti"`
+	UserID    uint      `gorm:"not null;index" json:"user_id"`
+	ExpiresAt time.Time `gorm:"not null;index" json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DefaultPaymentTermDays is the payment term of suppliers created without one
+const DefaultPaymentTermDays = 30
+
+// Supplier model. PaymentTermDays is the number of days after the invoice
+// date its invoices are due.
 type Supplier struct {
-	ID        uint           `gorm:"primaryKey" json:"id"`
-	Name      string         `gorm:"not null;size:200" json:"name"`
-	Email     string         `gorm:"size:100" json:"email"`
-	Address   string         `gorm:"type:text" json:"address"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+	ID              uint           `gorm:"primaryKey" json:"id"`
+	Name            string         `gorm:"not null;size:200" json:"name"`
+	Email           string         `gorm:"size:100" json:"email"`
+	Address         string         `gorm:"type:text" json:"address"`
+	Currency        string         `gorm:"not null;default:IDR;size:3" json:"currency"`
+	TaxCodeID       *uint          `json:"tax_code_id"`
+	TaxCode         *TaxCode       `gorm:"foreignKey:TaxCodeID" json:"tax_code,omitempty"`
+	PaymentTermDays int            `gorm:"not null;default:30" json:"payment_term_days"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+	DeletedAt       gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
-// Item model
+// Item model. Stock is the total over all warehouses; Stocks are the
+// balances per warehouse. Once Stock plus what is on order is at or below
+// ReorderPoint, the item is reordered from PreferredSupplier.
 type Item struct {
+	ID                  uint           `gorm:"primaryKey" json:"id"`
+	Name                string         `gorm:"not null;size:200" json:"name"`
+	Stock               int            `gorm:"not null;default:0;check:chk_items_stock_non_negative,stock >= 0" json:"stock"`
+	Stocks              []ItemStock    `gorm:"foreignKey:ItemID" json:"stocks,omitempty"`
+	Price               Money          `gorm:"type:numeric(18,2);not null;default:0" json:"price"`
+	TaxCodeID           *uint          `json:"tax_code_id"`
+	TaxCode             *TaxCode       `gorm:"foreignKey:TaxCodeID" json:"tax_code,omitempty"`
+	ReorderPoint        int            `gorm:"not null;default:0" json:"reorder_point"`
+	SafetyStock         int            `gorm:"not null;default:0" json:"safety_stock"`
+	ReorderQty          int            `gorm:"not null;default:0" json:"reorder_qty"`
+	PreferredSupplierID *uint          `gorm:"index" json:"preferred_supplier_id"`
+	PreferredSupplier   *Supplier      `gorm:"foreignKey:PreferredSupplierID" json:"preferred_supplier,omitempty"`
+	CreatedAt           time.Time      `json:"created_at"`
+	UpdatedAt           time.Time      `json:"updated_at"`
+	DeletedAt           gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// DefaultWarehouseCode is the code of the warehouse created for stock that
+// existed before warehouses
+const DefaultWarehouseCode = "MAIN"
+
+// Warehouse is a location stock is kept in. Stock changes that do not name
+// a warehouse are booked to the default warehouse.
+type Warehouse struct {
 	ID        uint           `gorm:"primaryKey" json:"id"`
+	Code      string         `gorm:"not null;size:20;uniqueIndex" json:"code"`
 	Name      string         `gorm:"not null;size:200" json:"name"`
-	Stock     int            `gorm:"not null;default:0" json:"stock"`
-	Price     float64        `gorm:"not null;default:0" json:"price"`
+	Address   string         `gorm:"type:text" json:"address"`
+	IsDefault bool           `gorm:"not null;default:false;uniqueIndex:idx_warehouses_default,where:is_default AND deleted_at IS NULL" json:"is_default"`
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
-// Purchasing (Header) model
+// ItemStock is the stock of one item in one warehouse
+type ItemStock struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	ItemID      uint       `gorm:"not null;uniqueIndex:idx_item_stocks_item_warehouse,priority:1" json:"item_id"`
+	WarehouseID uint       `gorm:"not null;uniqueIndex:idx_item_stocks_item_warehouse,priority:2;index" json:"warehouse_id"`
+	Warehouse   *Warehouse `gorm:"foreignKey:WarehouseID" json:"warehouse,omitempty"`
+	Stock       int        `gorm:"not null;default:0;check:chk_item_stocks_stock_non_negative,stock >= 0" json:"stock"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// TaxCode is a tax applied to purchase lines, such as VAT (PPN) or a
+// withholding tax (PPh). Rate is a percentage. Inclusive rates are already
+// contained in catalog prices; withholding taxes are deducted from the line
+// total instead of added to it.
+type TaxCode struct {
+	ID          uint           `gorm:"primaryKey" json:"id"`
+	Code        string         `gorm:"uniqueIndex;not null;size:20" json:"code"`
+	Name        string         `gorm:"not null;size:100" json:"name"`
+	Rate        Rate           `gorm:"type:numeric(18,8);not null" json:"rate"`
+	Inclusive   bool           `gorm:"not null;default:false" json:"inclusive"`
+	Withholding bool           `gorm:"not null;default:false" json:"withholding"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// SupplierItem is a supplier's catalog entry for an item: the price and terms
+// under which the supplier sells it. A supplier may list an item several
+// times with non-overlapping validity periods; ValidFrom and ValidTo are
+// inclusive and open-ended when nil.
+type SupplierItem struct {
+	ID           uint           `gorm:"primaryKey" json:"id"`
+	SupplierID   uint           `gorm:"not null;index:idx_supplier_items_supplier_item,priority:1" json:"supplier_id"`
+	Supplier     Supplier       `gorm:"foreignKey:SupplierID" json:"supplier,omitempty"`
+	ItemID       uint           `gorm:"not null;index:idx_supplier_items_supplier_item,priority:2" json:"item_id"`
+	Item         Item           `gorm:"foreignKey:ItemID" json:"item,omitempty"`
+	SKU          string         `gorm:"size:100" json:"sku"`
+	UnitPrice    Money          `gorm:"type:numeric(18,2);not null;default:0" json:"unit_price"`
+	Currency     string         `gorm:"not null;default:IDR;size:3" json:"currency"`
+	MinOrderQty  int            `gorm:"not null;default:1" json:"min_order_qty"`
+	LeadTimeDays int            `gorm:"not null;default:0" json:"lead_time_days"`
+	ValidFrom    *time.Time     `gorm:"type:date" json:"valid_from"`
+	ValidTo      *time.Time     `gorm:"type:date" json:"valid_to"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// ExchangeRate is the value of one unit of Currency in the base currency,
+// valid from EffectiveDate until the next rate of the same currency
+type ExchangeRate struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	Currency      string    `gorm:"not null;size:3;uniqueIndex:idx_exchange_rates_currency_date,priority:1" json:"currency"`
+	EffectiveDate time.Time `gorm:"type:date;not null;uniqueIndex:idx_exchange_rates_currency_date,priority:2" json:"effective_date"`
+	Rate          Rate      `gorm:"type:numeric(18,8);not null" json:"rate"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Purchase statuses
+const (
+	PurchaseStatusDraft     = "draft"
+	PurchaseStatusSubmitted = "submitted"
+	PurchaseStatusApproved  = "approved"
+	PurchaseStatusRejected  = "rejected"
+	PurchaseStatusOrdered   = "ordered"
+	PurchaseStatusReceived  = "received"
+	PurchaseStatusClosed    = "closed"
+	PurchaseStatusCancelled = "cancelled"
+)
+
+// PurchaseStatuses lists every purchase status in workflow order
+var PurchaseStatuses = []string{
+	PurchaseStatusDraft,
+	PurchaseStatusSubmitted,
+	PurchaseStatusApproved,
+	PurchaseStatusRejected,
+	PurchaseStatusOrdered,
+	PurchaseStatusReceived,
+	PurchaseStatusClosed,
+	PurchaseStatusCancelled,
+}
+
+// IsValidStatus reports whether status is one of the purchase statuses
+func IsValidStatus(status string) bool {
+	for _, s := range PurchaseStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Document types numbered by DocumentSequence
+const (
+	DocumentPurchaseOrder  = "purchase_order"
+	DocumentPurchaseReturn = "purchase_return"
+	DocumentStockTransfer  = "stock_transfer"
+)
+
+// DocumentSequence is the last number issued for a document type within a
+// scope. Scope is the document number with its sequence left out (e.g.
+// "PO/2026/10/{SEQ}"), so every period of a number format is counted
+// separately.
+type DocumentSequence struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	DocumentType string    `gorm:"not null;size:50;uniqueIndex:idx_document_sequences_type_scope,priority:1" json:"document_type"`
+	Scope        string    `gorm:"not null;size:100;uniqueIndex:idx_document_sequences_type_scope,priority:2" json:"scope"`
+	LastNumber   int       `gorm:"not null;default:0" json:"last_number"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Purchasing (Header) model. Number is the human-readable order number
+// issued from a DocumentSequence. Goods are received into WarehouseID.
+// Amounts are in Currency; BaseGrandTotal is GrandTotal converted to the base
+// currency at ExchangeRate, the rate valid on Date. HeaderDiscount is the
+// order-level discount, which is spread over the lines and included in
+// DiscountTotal. CancelReason and CancelledAt are set when the purchase is
+// cancelled.
 type Purchasing struct {
-	ID                 uint                `gorm:"primaryKey" json:"id"`
-	Date               time.Time           `gorm:"not null" json:"date"`
-	SupplierID         uint                `gorm:"not null" json:"supplier_id"`
-	Supplier           Supplier            `gorm:"foreignKey:SupplierID" json:"supplier,omitempty"`
-	UserID             uint                `gorm:"not null" json:"user_id"`
-	User               User                `gorm:"foreignKey:UserID" json:"user,omitempty"`
-	GrandTotal         float64             `gorm:"not null;default:0" json:"grand_total"`
-	PurchasingDetails  []PurchasingDetail  `gorm:"foreignKey:PurchasingID" json:"details,omitempty"`
-	CreatedAt          time.Time           `json:"created_at"`
-	UpdatedAt          time.Time           `json:"updated_at"`
-	DeletedAt          gorm.DeletedAt      `gorm:"index" json:"-"`
-}
-
-// PurchasingDetail model
+	ID                uint               `gorm:"primaryKey" json:"id"`
+	Number            string             `gorm:"size:100;uniqueIndex" json:"number"`
+	Date              time.Time          `gorm:"not null" json:"date"`
+	SupplierID        uint               `gorm:"not null" json:"supplier_id"`
+	Supplier          Supplier           `gorm:"foreignKey:SupplierID" json:"supplier,omitempty"`
+	WarehouseID       uint               `gorm:"index" json:"warehouse_id"`
+	Warehouse         *Warehouse         `gorm:"foreignKey:WarehouseID" json:"warehouse,omitempty"`
+	UserID            uint               `gorm:"not null" json:"user_id"`
+	User              User               `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Currency          string             `gorm:"not null;default:IDR;size:3" json:"currency"`
+	ExchangeRate      Rate               `gorm:"type:numeric(18,8);not null;default:1" json:"exchange_rate"`
+	Subtotal          Money              `gorm:"type:numeric(18,2);not null;default:0" json:"subtotal"`
+	HeaderDiscount    Money              `gorm:"type:numeric(18,2);not null;default:0" json:"header_discount"`
+	DiscountTotal     Money              `gorm:"type:numeric(18,2);not null;default:0" json:"discount_total"`
+	TaxTotal          Money              `gorm:"type:numeric(18,2);not null;default:0" json:"tax_total"`
+	GrandTotal        Money              `gorm:"type:numeric(18,2);not null;default:0" json:"grand_total"`
+	BaseGrandTotal    Money              `gorm:"type:numeric(18,2);not null;default:0" json:"base_grand_total"`
+	Status            string             `gorm:"not null;default:draft;size:20;index" json:"status"`
+	CancelReason      string             `gorm:"type:text" json:"cancel_reason,omitempty"`
+	CancelledAt       *time.Time         `json:"cancelled_at,omitempty"`
+	Suggested         bool               `gorm:"not null;default:false" json:"suggested"`
+	PurchasingDetails []PurchasingDetail `gorm:"foreignKey:PurchasingID" json:"details,omitempty"`
+	StatusHistory     []PurchasingStatus `gorm:"foreignKey:PurchasingID" json:"status_history,omitempty"`
+	Receipts          []GoodsReceipt     `gorm:"foreignKey:PurchasingID" json:"receipts,omitempty"`
+	Returns           []PurchaseReturn   `gorm:"foreignKey:PurchasingID" json:"returns,omitempty"`
+	CreatedAt         time.Time          `json:"created_at"`
+	UpdatedAt         time.Time          `json:"updated_at"`
+	DeletedAt         gorm.DeletedAt     `gorm:"index" json:"-"`
+}
+
+// PurchasingDetail model. UnitPrice, Discount, Tax and LineTotal are a
+// snapshot taken when the purchase is created, so repricing an item or a
+// supplier catalog never changes historical orders. SubTotal is UnitPrice x
+// Qty; Discount includes the line's share of the header discount; TaxBase is
+// the discounted amount excluding tax; Tax is negative for withholding taxes.
 type PurchasingDetail struct {
 	ID           uint           `gorm:"primaryKey" json:"id"`
 	PurchasingID uint           `gorm:"not null" json:"purchasing_id"`
 	ItemID       uint           `gorm:"not null" json:"item_id"`
 	Item         Item           `gorm:"foreignKey:ItemID" json:"item,omitempty"`
 	Qty          int            `gorm:"not null" json:"qty"`
-	SubTotal     float64        `gorm:"not null;default:0" json:"sub_total"`
+	ReceivedQty  int            `gorm:"not null;default:0" json:"received_qty"`
+	ReturnedQty  int            `gorm:"not null;default:0" json:"returned_qty"`
+	Outstanding  int            `gorm:"-" json:"outstanding_qty"`
+	UnitPrice    Money          `gorm:"type:numeric(18,2);not null;default:0" json:"unit_price"`
+	SubTotal     Money          `gorm:"type:numeric(18,2);not null;default:0" json:"sub_total"`
+	Discount     Money          `gorm:"type:numeric(18,2);not null;default:0" json:"discount"`
+	TaxBase      Money          `gorm:"type:numeric(18,2);not null;default:0" json:"tax_base"`
+	TaxCodeID    *uint          `json:"tax_code_id"`
+	TaxCode      *TaxCode       `gorm:"foreignKey:TaxCodeID" json:"tax_code,omitempty"`
+	TaxRate      Rate           `gorm:"type:numeric(18,8);not null;default:0" json:"tax_rate"`
+	TaxInclusive bool           `gorm:"not null;default:false" json:"tax_inclusive"`
+	Tax          Money          `gorm:"type:numeric(18,2);not null;default:0" json:"tax"`
+	LineTotal    Money          `gorm:"type:numeric(18,2);not null;default:0" json:"line_total"`
 	CreatedAt    time.Time      `json:"created_at"`
 	UpdatedAt    time.Time      `json:"updated_at"`
 	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
 }
+
+// PurchasingStatus records a single status transition of a purchase
+type PurchasingStatus struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	PurchasingID uint      `gorm:"not null;index" json:"purchasing_id"`
+	FromStatus   string    `gorm:"size:20" json:"from_status"`
+	ToStatus     string    `gorm:"not null;size:20" json:"to_status"`
+	UserID       uint      `gorm:"not null" json:"user_id"`
+	User         User      `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Note         string    `gorm:"type:text" json:"note"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// AfterFind computes the quantity still to be delivered
+func (d *PurchasingDetail) AfterFind(tx *gorm.DB) error {
+	d.Outstanding = d.Qty - d.ReceivedQty
+	return nil
+}
+
+// GoodsReceipt records one delivery received against a purchase
+type GoodsReceipt struct {
+	ID           uint                 `gorm:"primaryKey" json:"id"`
+	PurchasingID uint                 `gorm:"not null;index" json:"purchasing_id"`
+	Date         time.Time            `gorm:"not null" json:"date"`
+	UserID       uint                 `gorm:"not null" json:"user_id"`
+	User         User                 `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Note         string               `gorm:"type:text" json:"note"`
+	Details      []GoodsReceiptDetail `gorm:"foreignKey:GoodsReceiptID" json:"details,omitempty"`
+	CreatedAt    time.Time            `json:"created_at"`
+	UpdatedAt    time.Time            `json:"updated_at"`
+}
+
+// GoodsReceiptDetail is the quantity received for one purchase detail line
+type GoodsReceiptDetail struct {
+	ID                 uint      `gorm:"primaryKey" json:"id"`
+	GoodsReceiptID     uint      `gorm:"not null;index" json:"goods_receipt_id"`
+	PurchasingDetailID uint      `gorm:"not null;index" json:"purchasing_detail_id"`
+	ItemID             uint      `gorm:"not null" json:"item_id"`
+	Item               Item      `gorm:"foreignKey:ItemID" json:"item,omitempty"`
+	Qty                int       `gorm:"not null" json:"qty"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+// PurchaseReturn sends goods received for a purchase back to its supplier.
+// CreditAmount is the amount the supplier owes back, in Currency (the
+// purchase currency).
+type PurchaseReturn struct {
+	ID           uint                   `gorm:"primaryKey" json:"id"`
+	Number       string                 `gorm:"size:100;uniqueIndex" json:"number"`
+	PurchasingID uint                   `gorm:"not null;index" json:"purchasing_id"`
+	Purchasing   *Purchasing            `gorm:"foreignKey:PurchasingID" json:"purchase,omitempty"`
+	SupplierID   uint                   `gorm:"not null;index" json:"supplier_id"`
+	Supplier     *Supplier              `gorm:"foreignKey:SupplierID" json:"supplier,omitempty"`
+	Date         time.Time              `gorm:"not null" json:"date"`
+	UserID       uint                   `gorm:"not null" json:"user_id"`
+	User         User                   `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Reason       string                 `gorm:"type:text;not null" json:"reason"`
+	Currency     string                 `gorm:"not null;size:3" json:"currency"`
+	CreditAmount Money                  `gorm:"type:numeric(18,2);not null;default:0" json:"credit_amount"`
+	Details      []PurchaseReturnDetail `gorm:"foreignKey:PurchaseReturnID" json:"details,omitempty"`
+	CreatedAt    time.Time              `json:"created_at"`
+	UpdatedAt    time.Time              `json:"updated_at"`
+}
+
+// PurchaseReturnDetail is the quantity returned for one purchase detail
+// line. CreditAmount is the returned share of the line total, including
+// discounts and taxes.
+type PurchaseReturnDetail struct {
+	ID                 uint      `gorm:"primaryKey" json:"id"`
+	PurchaseReturnID   uint      `gorm:"not null;index" json:"purchase_return_id"`
+	PurchasingDetailID uint      `gorm:"not null;index" json:"purchasing_detail_id"`
+	ItemID             uint      `gorm:"not null" json:"item_id"`
+	Item               Item      `gorm:"foreignKey:ItemID" json:"item,omitempty"`
+	Qty                int       `gorm:"not null" json:"qty"`
+	CreditAmount       Money     `gorm:"type:numeric(18,2);not null;default:0" json:"credit_amount"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+// Supplier invoice statuses
+const (
+	InvoiceStatusOpen    = "open"
+	InvoiceStatusPartial = "partial"
+	InvoiceStatusPaid    = "paid"
+)
+
+// Match statuses of a supplier invoice
+const (
+	MatchStatusPending  = "pending"
+	MatchStatusMatched  = "matched"
+	MatchStatusVariance = "variance"
+)
+
+// SupplierInvoice is a bill received from a supplier for one or more of its
+// purchases. Number is the supplier's own invoice number. Amount and
+// PaidAmount are in Currency, the currency of the invoiced purchases;
+// DueDate defaults to InvoiceDate plus the supplier's payment terms.
+// MatchStatus is the result of the last three-way match of the invoice
+// lines against the purchases and their receipts; an invoice can only be
+// approved for payment once it is matched.
+type SupplierInvoice struct {
+	ID           uint                  `gorm:"primaryKey" json:"id"`
+	Number       string                `gorm:"not null;size:100;uniqueIndex:idx_supplier_invoices_supplier_number,priority:2" json:"number"`
+	SupplierID   uint                  `gorm:"not null;uniqueIndex:idx_supplier_invoices_supplier_number,priority:1" json:"supplier_id"`
+	Supplier     *Supplier             `gorm:"foreignKey:SupplierID" json:"supplier,omitempty"`
+	InvoiceDate  time.Time             `gorm:"type:date;not null" json:"invoice_date"`
+	DueDate      time.Time             `gorm:"type:date;not null;index" json:"due_date"`
+	Currency     string                `gorm:"not null;size:3" json:"currency"`
+	Amount       Money                 `gorm:"type:numeric(18,2);not null;default:0" json:"amount"`
+	PaidAmount   Money                 `gorm:"type:numeric(18,2);not null;default:0" json:"paid_amount"`
+	Outstanding  Money                 `gorm:"-" json:"outstanding"`
+	Status       string                `gorm:"not null;default:open;size:20;index" json:"status"`
+	MatchStatus  string                `gorm:"not null;default:pending;size:20;index" json:"match_status"`
+	MatchedAt    *time.Time            `json:"matched_at"`
+	ApprovedByID *uint                 `json:"approved_by_id"`
+	ApprovedBy   *User                 `gorm:"foreignKey:ApprovedByID" json:"approved_by,omitempty"`
+	ApprovedAt   *time.Time            `json:"approved_at"`
+	Note         string                `gorm:"type:text" json:"note"`
+	UserID       uint                  `gorm:"not null" json:"user_id"`
+	User         User                  `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Purchases    []Purchasing          `gorm:"many2many:supplier_invoice_purchases" json:"purchases,omitempty"`
+	Lines        []SupplierInvoiceLine `gorm:"foreignKey:SupplierInvoiceID" json:"lines,omitempty"`
+	Variances    []MatchVariance       `gorm:"foreignKey:SupplierInvoiceID" json:"variances,omitempty"`
+	Payments     []SupplierPayment     `gorm:"foreignKey:SupplierInvoiceID" json:"payments,omitempty"`
+	CreatedAt    time.Time             `json:"created_at"`
+	UpdatedAt    time.Time             `json:"updated_at"`
+}
+
+// AfterFind computes the amount still to be paid
+func (i *SupplierInvoice) AfterFind(tx *gorm.DB) error {
+	var err error
+	i.Outstanding, err = i.Amount.Sub(i.PaidAmount)
+	return err
+}
+
+// SupplierInvoiceLine is the quantity and unit price a supplier bills for
+// one purchase detail line. Amount is Qty x UnitPrice.
+type SupplierInvoiceLine struct {
+	ID                 uint      `gorm:"primaryKey" json:"id"`
+	SupplierInvoiceID  uint      `gorm:"not null;index" json:"supplier_invoice_id"`
+	PurchasingDetailID uint      `gorm:"not null;index" json:"purchasing_detail_id"`
+	ItemID             uint      `gorm:"not null" json:"item_id"`
+	Item               Item      `gorm:"foreignKey:ItemID" json:"item,omitempty"`
+	Qty                int       `gorm:"not null" json:"qty"`
+	UnitPrice          Money     `gorm:"type:numeric(18,2);not null" json:"unit_price"`
+	Amount             Money     `gorm:"type:numeric(18,2);not null" json:"amount"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+// Match variance types
+const (
+	VarianceQuantity = "quantity"
+	VariancePrice    = "price"
+	VarianceAmount   = "amount"
+)
+
+// MatchVariance is a difference between a supplier invoice and its
+// purchases beyond the matching tolerance. Quantity variances compare the
+// invoiced quantity of a purchase detail line with its received (less
+// returned) quantity; price variances compare the invoiced unit price with
+// Expected, the order's unit price; amount variances compare the invoice
+// amount with Expected, the order value of the received goods. A variance
+// blocks approval of the invoice until it is resolved with a note.
+type MatchVariance struct {
+	ID                 uint       `gorm:"primaryKey" json:"id"`
+	SupplierInvoiceID  uint       `gorm:"not null;index" json:"supplier_invoice_id"`
+	Type               string     `gorm:"not null;size:20" json:"type"`
+	PurchasingDetailID *uint      `json:"purchasing_detail_id"`
+	ItemID             *uint      `json:"item_id"`
+	Item               *Item      `gorm:"foreignKey:ItemID" json:"item,omitempty"`
+	OrderedQty         int        `gorm:"not null;default:0" json:"ordered_qty"`
+	ReceivedQty        int        `gorm:"not null;default:0" json:"received_qty"`
+	InvoicedQty        int        `gorm:"not null;default:0" json:"invoiced_qty"`
+	Expected           Money      `gorm:"type:numeric(18,2);not null;default:0" json:"expected"`
+	Invoiced           Money      `gorm:"type:numeric(18,2);not null;default:0" json:"invoiced"`
+	ResolvedByID       *uint      `json:"resolved_by_id"`
+	ResolvedBy         *User      `gorm:"foreignKey:ResolvedByID" json:"resolved_by,omitempty"`
+	ResolvedAt         *time.Time `json:"resolved_at"`
+	ResolutionNote     string     `gorm:"type:text" json:"resolution_note"`
+	CreatedAt          time.Time  `json:"created_at"`
+}
+
+// SupplierPayment is one payment made against a supplier invoice, in the
+// invoice currency
+type SupplierPayment struct {
+	ID                uint      `gorm:"primaryKey" json:"id"`
+	SupplierInvoiceID uint      `gorm:"not null;index" json:"supplier_invoice_id"`
+	Date              time.Time `gorm:"type:date;not null" json:"date"`
+	Amount            Money     `gorm:"type:numeric(18,2);not null" json:"amount"`
+	Method            string    `gorm:"size:50" json:"method"`
+	Reference         string    `gorm:"size:100" json:"reference"`
+	Note              string    `gorm:"type:text" json:"note"`
+	UserID            uint      `gorm:"not null" json:"user_id"`
+	User              User      `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// StockTransfer moves stock from one warehouse to another. Every line is
+// booked as a pair of transfer movements, out of the source warehouse and
+// into the destination, so the item's total stock does not change.
+type StockTransfer struct {
+	ID              uint                  `gorm:"primaryKey" json:"id"`
+	Number          string                `gorm:"size:100;uniqueIndex" json:"number"`
+	Date            time.Time             `gorm:"not null" json:"date"`
+	FromWarehouseID uint                  `gorm:"not null;index" json:"from_warehouse_id"`
+	FromWarehouse   *Warehouse            `gorm:"foreignKey:FromWarehouseID" json:"from_warehouse,omitempty"`
+	ToWarehouseID   uint                  `gorm:"not null;index" json:"to_warehouse_id"`
+	ToWarehouse     *Warehouse            `gorm:"foreignKey:ToWarehouseID" json:"to_warehouse,omitempty"`
+	UserID          uint                  `gorm:"not null" json:"user_id"`
+	User            User                  `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Note            string                `gorm:"type:text" json:"note"`
+	Details         []StockTransferDetail `gorm:"foreignKey:StockTransferID" json:"details,omitempty"`
+	CreatedAt       time.Time             `json:"created_at"`
+	UpdatedAt       time.Time             `json:"updated_at"`
+}
+
+// StockTransferDetail is the quantity of one item moved by a transfer
+type StockTransferDetail struct {
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	StockTransferID uint      `gorm:"not null;index" json:"stock_transfer_id"`
+	ItemID          uint      `gorm:"not null" json:"item_id"`
+	Item            Item      `gorm:"foreignKey:ItemID" json:"item,omitempty"`
+	Qty             int       `gorm:"not null" json:"qty"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// Stock movement types
+const (
+	MovementReceipt    = "receipt"
+	MovementIssue      = "issue"
+	MovementAdjustment = "adjustment"
+	MovementReturn     = "return"
+	MovementTransfer   = "transfer"
+)
+
+// Stock movement reference types
+const (
+	MovementRefGoodsReceipt   = "goods_receipt"
+	MovementRefPurchase       = "purchase"
+	MovementRefPurchaseReturn = "purchase_return"
+	MovementRefStockTransfer  = "stock_transfer"
+	MovementRefItem           = "item"
+	MovementRefOpeningBalance = "opening_balance"
+)
+
+// ErrImmutableMovement is returned when trying to change a ledger entry
+var ErrImmutableMovement = errors.New("stock movements are immutable")
+
+// StockMovement is an immutable ledger entry for one change of Item.Stock.
+// Qty is signed: positive quantities add stock, negative quantities remove it.
+// BalanceAfter is the item's total stock after the movement and
+// WarehouseBalanceAfter its stock in WarehouseID.
+type StockMovement struct {
+	ID                    uint       `gorm:"primaryKey" json:"id"`
+	ItemID                uint       `gorm:"not null;index" json:"item_id"`
+	WarehouseID           uint       `gorm:"index" json:"warehouse_id"`
+	Warehouse             *Warehouse `gorm:"foreignKey:WarehouseID" json:"warehouse,omitempty"`
+	Type                  string     `gorm:"not null;size:20" json:"type"`
+	Qty                   int        `gorm:"not null" json:"qty"`
+	BalanceAfter          int        `gorm:"not null" json:"balance_after"`
+	WarehouseBalanceAfter int        `gorm:"not null;default:0" json:"warehouse_balance_after"`
+	ReferenceType         string     `gorm:"size:50;index:idx_stock_movements_reference" json:"reference_type"`
+	ReferenceID           uint       `gorm:"index:idx_stock_movements_reference" json:"reference_id"`
+	UserID                *uint      `json:"user_id"`
+	User                  *User      `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Note                  string     `gorm:"type:text" json:"note"`
+	CreatedAt             time.Time  `json:"created_at"`
+}
+
+// BeforeUpdate prevents ledger entries from being modified
+func (m *StockMovement) BeforeUpdate(tx *gorm.DB) error {
+	return ErrImmutableMovement
+}
+
+// BeforeDelete prevents ledger entries from being deleted
+func (m *StockMovement) BeforeDelete(tx *gorm.DB) error {
+	return ErrImmutableMovement
+}
+
+// Webhook delivery statuses
+const (
+	DeliveryStatusPending   = "pending"
+	DeliveryStatusDelivered = "delivered"
+	DeliveryStatusDead      = "dead"
+)
+
+// WebhookDelivery is an outbox entry for one webhook event sent to one
+// endpoint. It is written in the same transaction as the change it reports
+// and sent by the background dispatcher, which retries failed deliveries
+// until they are dead-lettered. SubscriptionID is nil for deliveries to the
+// endpoint configured through WEBHOOK_URL.
+type WebhookDelivery struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	Event          string     `gorm:"not null;size:50;index" json:"event"`
+	Payload        JSON       `gorm:"type:jsonb;not null" json:"payload"`
+	SubscriptionID *uint      `gorm:"index" json:"subscription_id"`
+	URL            string     `gorm:"not null;size:500" json:"url"`
+	Status         string     `gorm:"not null;default:pending;size:20;index:idx_webhook_deliveries_due,priority:1" json:"status"`
+	Attempts       int        `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt  time.Time  `gorm:"not null;index:idx_webhook_deliveries_due,priority:2" json:"next_attempt_at"`
+	LastStatusCode int        `json:"last_status_code"`
+	LastError      string     `gorm:"type:text" json:"last_error"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// WebhookSubscription is an endpoint that receives the webhook events it
+// subscribes to. Events may contain "*" to receive every event.
+type WebhookSubscription struct {
+	ID        uint           `gorm:"primaryKey" json:"id"`
+	Name      string         `gorm:"size:100" json:"name"`
+	URL       string         `gorm:"not null;size:500" json:"url"`
+	Secret    string         `gorm:"not null;size:200" json:"-"`
+	Events    []string       `gorm:"serializer:json;type:jsonb;not null" json:"events"`
+	Enabled   bool           `gorm:"not null" json:"enabled"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// Subscribes reports whether the subscription receives event
+func (s *WebhookSubscription) Subscribes(event string) bool {
+	for _, e := range s.Events {
+		if e == event || e == "*" {
+			return true
+		}
+	}
+	return false
+}
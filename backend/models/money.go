@@ -0,0 +1,236 @@
+package models
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+)
+
+// Money is a monetary amount in fixed-point decimal with two fractional
+// digits, stored as a whole number of hundredths. It is kept in numeric(18,2)
+// columns and encoded in JSON as a plain number such as 1234.50.
+//
+// Rounding rules: amounts are exact under Add, Sub and Mul, which report
+// results that do not fit numeric(18,2) as ErrOutOfRange. Whenever a value with more
+// precision is converted to Money (parsing input, scanning a float,
+// applying a rate) it is rounded to the nearest hundredth, with halves
+// rounded away from zero.
+type Money int64
+
+// MoneyColumn is the column type used for every Money field
+const MoneyColumn = "numeric(18,2)"
+
+// maxFixed is the largest whole number of units a numeric(18,2) or
+// numeric(18,8) column can hold: eighteen nines
+const maxFixed = 999999999999999999
+
+// MaxMoney is the largest amount that can be stored
+const MaxMoney Money = maxFixed
+
+var (
+	// ErrInvalidNumber is returned for input that is not a plain decimal
+	ErrInvalidNumber = errors.New("invalid decimal number")
+	// ErrOutOfRange is returned for amounts and rates too large to store
+	ErrOutOfRange = errors.New("number is out of range")
+)
+
+// decimalPattern matches the only accepted number format: an optional
+// minus sign, digits and an optional fraction. Fractions such as "1/3",
+// hexadecimal and exponents are rejected.
+var decimalPattern = regexp.MustCompile(`^-?\d+(\.\d+)?$`)
+
+// ParseMoney parses a decimal string such as "1234.5" or "-0.125"
+func ParseMoney(s string) (Money, error) {
+	n, err := parseFixed(s, 100)
+	return Money(n), err
+}
+
+// MoneyFromRat rounds an exact rational amount to Money
+func MoneyFromRat(r *big.Rat) (Money, error) {
+	n, err := fixedFromRat(r, 100)
+	return Money(n), err
+}
+
+// parseFixed parses a decimal string into a whole number of 1/scale units
+func parseFixed(s string, scale int64) (int64, error) {
+	if !decimalPattern.MatchString(s) {
+		return 0, ErrInvalidNumber
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, ErrInvalidNumber
+	}
+	return fixedFromRat(r, scale)
+}
+
+// fixedFromRat rounds r to a whole number of 1/scale units
+func fixedFromRat(r *big.Rat, scale int64) (int64, error) {
+	n := roundHalfAwayFromZero(new(big.Rat).Mul(r, big.NewRat(scale, 1)))
+	if !n.IsInt64() {
+		return 0, ErrOutOfRange
+	}
+	return checkFixed(n.Int64())
+}
+
+// checkFixed returns n, or ErrOutOfRange when it has more than eighteen
+// digits
+func checkFixed(n int64) (int64, error) {
+	if n > maxFixed || n < -maxFixed {
+		return 0, ErrOutOfRange
+	}
+	return n, nil
+}
+
+// scanInt converts a whole number read from the database to 1/scale units
+func scanInt(v, scale int64) (int64, error) {
+	if v > maxFixed/scale || v < -maxFixed/scale {
+		return 0, ErrOutOfRange
+	}
+	return v * scale, nil
+}
+
+// formatFixed formats a whole number of 1/10^digits units as a decimal
+func formatFixed(n int64, digits int) string {
+	// Negate as unsigned so that math.MinInt64 keeps its magnitude
+	sign := ""
+	u := uint64(n)
+	if n < 0 {
+		sign = "-"
+		u = -u
+	}
+	scale := uint64(1)
+	for i := 0; i < digits; i++ {
+		scale *= 10
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, u/scale, digits, u%scale)
+}
+
+// unquote strips the quotes of a JSON string so that numbers may be sent
+// either as JSON numbers or as strings
+func unquote(data []byte) []byte {
+	data = bytes.TrimSpace(data)
+	if len(data) >= 2 && data[0] == '"' && data[len(data)-1] == '"' {
+		return data[1 : len(data)-1]
+	}
+	return data
+}
+
+// roundHalfAwayFromZero rounds r to the nearest integer
+func roundHalfAwayFromZero(r *big.Rat) *big.Int {
+	q, rem := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	// |rem| * 2 >= denom means the fraction is at least one half
+	if rem.Sign() != 0 && new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(r.Denom()) >= 0 {
+		q.Add(q, big.NewInt(int64(r.Sign())))
+	}
+	return q
+}
+
+// Add returns m + o
+func (m Money) Add(o Money) (Money, error) {
+	// Operands that can be stored cannot overflow int64 when added
+	if _, err := checkFixed(int64(m)); err != nil {
+		return 0, err
+	}
+	if _, err := checkFixed(int64(o)); err != nil {
+		return 0, err
+	}
+	n, err := checkFixed(int64(m) + int64(o))
+	return Money(n), err
+}
+
+// Sub returns m - o
+func (m Money) Sub(o Money) (Money, error) {
+	if _, err := checkFixed(int64(o)); err != nil {
+		return 0, err
+	}
+	return m.Add(-o)
+}
+
+// Mul returns m multiplied by a whole quantity
+func (m Money) Mul(qty int) (Money, error) {
+	product := new(big.Int).Mul(big.NewInt(int64(m)), big.NewInt(int64(qty)))
+	if !product.IsInt64() {
+		return 0, ErrOutOfRange
+	}
+	n, err := checkFixed(product.Int64())
+	return Money(n), err
+}
+
+// Neg returns -m
+func (m Money) Neg() Money {
+	return -m
+}
+
+// IsNegative reports whether m is below zero
+func (m Money) IsNegative() bool {
+	return m < 0
+}
+
+// IsZero reports whether m is zero
+func (m Money) IsZero() bool {
+	return m == 0
+}
+
+// Rat returns m as an exact rational number
+func (m Money) Rat() *big.Rat {
+	return big.NewRat(int64(m), 100)
+}
+
+// String formats m with exactly two fractional digits, e.g. "-12.05"
+func (m Money) String() string {
+	return formatFixed(int64(m), 2)
+}
+
+// Value implements driver.Valuer
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+// Scan implements sql.Scanner. Besides numeric text it accepts floats and
+// integers so that columns can be read before they are converted.
+func (m *Money) Scan(value interface{}) error {
+	var err error
+	switch v := value.(type) {
+	case nil:
+		*m = 0
+	case []byte:
+		*m, err = ParseMoney(string(v))
+	case string:
+		*m, err = ParseMoney(v)
+	case float64:
+		*m, err = ParseMoney(strconv.FormatFloat(v, 'f', -1, 64))
+	case int64:
+		var n int64
+		n, err = scanInt(v, 100)
+		*m = Money(n)
+	default:
+		err = fmt.Errorf("cannot scan %T into Money", value)
+	}
+	return err
+}
+
+// MarshalJSON implements json.Marshaler
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Amounts may be sent as JSON
+// numbers or as strings; they are parsed as decimals, never as floats.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = unquote(data)
+	if bytes.Equal(data, []byte("null")) {
+		*m = 0
+		return nil
+	}
+
+	parsed, err := ParseMoney(string(data))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
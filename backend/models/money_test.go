@@ -0,0 +1,210 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money
+	}{
+		{"0", 0},
+		{"1234.5", 123450},
+		{"-12.05", -1205},
+		{"0.125", 13},
+		{"-0.125", -13},
+		{"0.124", 12},
+		{"99999999.999", 10000000000},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in)
+		if err != nil {
+			t.Errorf("ParseMoney(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseMoney(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseMoneyRejectsNonDecimals(t *testing.T) {
+	for _, in := range []string{"", "1/3", "0x10", "1e3", "+1", "1.", ".5", " 1", "1,5", "NaN", "Inf"} {
+		if _, err := ParseMoney(in); !errors.Is(err, ErrInvalidNumber) {
+			t.Errorf("ParseMoney(%q) err = %v, want %v", in, err, ErrInvalidNumber)
+		}
+	}
+}
+
+func TestParseMoneyOutOfRange(t *testing.T) {
+	huge := strings.Repeat("9", 100000)
+	_, err := ParseMoney(huge)
+	if !errors.Is(err, ErrOutOfRange) {
+		t.Fatalf("ParseMoney(huge) err = %v, want %v", err, ErrOutOfRange)
+	}
+	if len(err.Error()) > 100 {
+		t.Errorf("error message is %d bytes long, want it not to echo the input", len(err.Error()))
+	}
+}
+
+// TestMoneyColumnRange checks that only amounts numeric(18,2) can hold are
+// parsed
+func TestMoneyColumnRange(t *testing.T) {
+	valid := map[string]Money{
+		"9999999999999999.99":   MaxMoney,
+		"-9999999999999999.99":  -MaxMoney,
+		"9999999999999999.994":  MaxMoney,
+		"-9999999999999999.994": -MaxMoney,
+	}
+	for in, want := range valid {
+		if got, err := ParseMoney(in); err != nil || got != want {
+			t.Errorf("ParseMoney(%q) = %s, %v; want %s", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"10000000000000000", "9999999999999999.995", "-9999999999999999.995", "9223372036854775807"} {
+		if _, err := ParseMoney(in); !errors.Is(err, ErrOutOfRange) {
+			t.Errorf("ParseMoney(%q) err = %v, want %v", in, err, ErrOutOfRange)
+		}
+	}
+}
+
+func TestMoneyMul(t *testing.T) {
+	got, err := Money(1205).Mul(3)
+	if err != nil || got != 3615 {
+		t.Errorf("12.05 * 3 = %s, %v; want 36.15", got, err)
+	}
+
+	if _, err := Money(math.MaxInt64 / 2).Mul(3); !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("overflowing Mul err = %v, want %v", err, ErrOutOfRange)
+	}
+	if _, err := Money(math.MinInt64).Mul(-1); !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("overflowing negation err = %v, want %v", err, ErrOutOfRange)
+	}
+	if _, err := (MaxMoney/2 + 1).Mul(2); !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("Mul beyond MaxMoney err = %v, want %v", err, ErrOutOfRange)
+	}
+}
+
+func TestMoneyAddSub(t *testing.T) {
+	if got, err := Money(1205).Add(-5); err != nil || got != 1200 {
+		t.Errorf("12.05 + -0.05 = %s, %v; want 12.00", got, err)
+	}
+	if got, err := Money(1205).Sub(2000); err != nil || got != -795 {
+		t.Errorf("12.05 - 20.00 = %s, %v; want -7.95", got, err)
+	}
+	if got, err := MaxMoney.Sub(MaxMoney); err != nil || got != 0 {
+		t.Errorf("MaxMoney - MaxMoney = %s, %v; want 0", got, err)
+	}
+
+	overflows := [][2]Money{
+		{MaxMoney, 1},
+		{-MaxMoney, -1},
+		{math.MaxInt64, 1},
+		{1, math.MaxInt64},
+		{math.MinInt64, -1},
+		{math.MaxInt64, math.MaxInt64},
+	}
+	for _, o := range overflows {
+		if _, err := o[0].Add(o[1]); !errors.Is(err, ErrOutOfRange) {
+			t.Errorf("%d + %d err = %v, want %v", o[0], o[1], err, ErrOutOfRange)
+		}
+		if _, err := o[0].Sub(-o[1]); !errors.Is(err, ErrOutOfRange) {
+			t.Errorf("%d - %d err = %v, want %v", o[0], -o[1], err, ErrOutOfRange)
+		}
+	}
+	if _, err := Money(0).Sub(math.MinInt64); !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("0 - MinInt64 err = %v, want %v", err, ErrOutOfRange)
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := map[Money]string{
+		0:             "0.00",
+		5:             "0.05",
+		-5:            "-0.05",
+		-1205:         "-12.05",
+		MaxMoney:      "9999999999999999.99",
+		math.MaxInt64: "92233720368547758.07",
+		math.MinInt64: "-92233720368547758.08",
+	}
+	for m, want := range tests {
+		if got := m.String(); got != want {
+			t.Errorf("String of %d = %s, want %s", int64(m), got, want)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	var v struct {
+		Price Money `json:"price"`
+		Total Money `json:"total"`
+	}
+	if err := json.Unmarshal([]byte(`{"price": 10.5, "total": "20.255"}`), &v); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if v.Price != 1050 || v.Total != 2026 {
+		t.Errorf("unmarshal = %s, %s; want 10.50, 20.26", v.Price, v.Total)
+	}
+
+	out, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if string(out) != `{"price":10.50,"total":20.26}` {
+		t.Errorf("marshal = %s", out)
+	}
+
+	if err := json.Unmarshal([]byte(`{"price": 1e3}`), &v); err == nil {
+		t.Error("unmarshal of an exponent succeeded, want an error")
+	}
+}
+
+func TestMoneyScan(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want Money
+	}{
+		{nil, 0},
+		{[]byte("12.34"), 1234},
+		{"-0.50", -50},
+		{0.1 + 0.2, 30},
+		{int64(7), 700},
+	}
+	for _, tt := range tests {
+		var m Money
+		if err := m.Scan(tt.in); err != nil {
+			t.Errorf("Scan(%v): %v", tt.in, err)
+			continue
+		}
+		if m != tt.want {
+			t.Errorf("Scan(%v) = %s, want %s", tt.in, m, tt.want)
+		}
+	}
+}
+
+func TestScanOutOfRange(t *testing.T) {
+	for _, in := range []interface{}{int64(math.MaxInt64 / 10), int64(math.MinInt64 / 10), "10000000000000000", 1e17} {
+		var m Money
+		if err := m.Scan(in); !errors.Is(err, ErrOutOfRange) {
+			t.Errorf("Scan(%v) into Money err = %v, want %v", in, err, ErrOutOfRange)
+		}
+	}
+
+	var m Money
+	if err := m.Scan(int64(9999999999999999)); err != nil || m != MaxMoney-99 {
+		t.Errorf("Scan of the largest whole amount = %s, %v", m, err)
+	}
+
+	var r Rate
+	if err := r.Scan(int64(1e11)); !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("Scan(1e11) into Rate err = %v, want %v", err, ErrOutOfRange)
+	}
+	if err := r.Scan(int64(15750)); err != nil || r != 15750*OneRate {
+		t.Errorf("Scan(15750) into Rate = %s, %v", r, err)
+	}
+}
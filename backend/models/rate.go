@@ -0,0 +1,88 @@
+package models
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// rateScale is the number of units in 1 for Rate (eight decimals)
+const rateScale = 100000000
+
+// Rate is an exchange rate in fixed-point decimal with eight fractional
+// digits, stored in numeric(18,8) columns and encoded in JSON as a number.
+// Values with more precision are rounded half away from zero, like Money.
+type Rate int64
+
+// OneRate is the rate between a currency and itself
+const OneRate Rate = rateScale
+
+// ParseRate parses a decimal string such as "15750.25"
+func ParseRate(s string) (Rate, error) {
+	n, err := parseFixed(s, rateScale)
+	return Rate(n), err
+}
+
+// IsPositive reports whether r is above zero
+func (r Rate) IsPositive() bool {
+	return r > 0
+}
+
+// Rat returns r as an exact rational number
+func (r Rate) Rat() *big.Rat {
+	return big.NewRat(int64(r), rateScale)
+}
+
+// String formats r with eight fractional digits
+func (r Rate) String() string {
+	return formatFixed(int64(r), 8)
+}
+
+// Value implements driver.Valuer
+func (r Rate) Value() (driver.Value, error) {
+	return r.String(), nil
+}
+
+// Scan implements sql.Scanner
+func (r *Rate) Scan(value interface{}) error {
+	var n int64
+	var err error
+	switch v := value.(type) {
+	case nil:
+	case []byte:
+		n, err = parseFixed(string(v), rateScale)
+	case string:
+		n, err = parseFixed(v, rateScale)
+	case float64:
+		n, err = parseFixed(strconv.FormatFloat(v, 'f', -1, 64), rateScale)
+	case int64:
+		n, err = scanInt(v, rateScale)
+	default:
+		err = fmt.Errorf("cannot scan %T into Rate", value)
+	}
+	*r = Rate(n)
+	return err
+}
+
+// MarshalJSON implements json.Marshaler
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	data = unquote(data)
+	if bytes.Equal(data, []byte("null")) {
+		*r = 0
+		return nil
+	}
+
+	parsed, err := ParseRate(string(data))
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
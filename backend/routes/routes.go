@@ -8,6 +8,9 @@ import (
 )
 
 func SetupRoutes(app *fiber.App) {
+	// Token verification keys (public)
+	app.Get("/.well-known/jwks.json", handlers.GetJWKS)
+
 	// API group
 	api := app.Group("/api")
 
@@ -15,32 +18,162 @@ func SetupRoutes(app *fiber.App) {
 	auth := api.Group("/auth")
 	auth.Post("/register", handlers.Register)
 	auth.Post("/login", handlers.Login)
+	auth.Post("/login/2fa", handlers.LoginTwoFactor)
+	auth.Post("/refresh", handlers.Refresh)
+	auth.Post("/forgot-password", handlers.ForgotPassword)
+	auth.Post("/reset-password", handlers.ResetPassword)
 
 	// Protected routes
 	protected := api.Group("/", middleware.AuthMiddleware())
-	
+
 	// Profile
 	protected.Get("/profile", handlers.GetProfile)
+	protected.Put("/profile", handlers.UpdateProfile)
+	protected.Get("/profile/logins", handlers.GetProfileLogins)
+
+	// Sessions
+	protected.Post("/auth/logout", handlers.Logout)
+	protected.Post("/auth/logout-all", handlers.LogoutAll)
+	protected.Post("/auth/change-password", handlers.ChangePassword)
+
+	// Two-factor authentication
+	protected.Get("/auth/2fa", handlers.GetTwoFactorStatus)
+	protected.Post("/auth/2fa/setup", handlers.SetupTwoFactor)
+	protected.Post("/auth/2fa/verify", handlers.VerifyTwoFactor)
+	protected.Post("/auth/2fa/disable", handlers.DisableTwoFactor)
+	protected.Post("/auth/2fa/recovery-codes", handlers.RegenerateRecoveryCodes)
+
+	// Users whose role requires two-factor authentication can only use the
+	// routes above until they have set it up
+	protected.Use(middleware.RequireTwoFactorSetup())
+
+	// Users (admin)
+	users := protected.Group("/users")
+	users.Get("/", middleware.RequirePermission(middleware.PermUsersManage), handlers.GetAllUsers)
+	users.Get("/2fa-policy", middleware.RequirePermission(middleware.PermUsersManage), handlers.GetTwoFactorPolicy)
+	users.Put("/2fa-policy", middleware.RequirePermission(middleware.PermUsersManage), handlers.UpdateTwoFactorPolicy)
+	users.Put("/:id/role", middleware.RequirePermission(middleware.PermUsersManage), handlers.UpdateUserRole)
+	users.Post("/:id/logout", middleware.RequirePermission(middleware.PermUsersManage), handlers.LogoutUser)
+	users.Post("/:id/unlock", middleware.RequirePermission(middleware.PermUsersManage), handlers.UnlockUser)
+	users.Get("/:id/logins", middleware.RequirePermission(middleware.PermUsersManage), handlers.GetUserLogins)
+	users.Post("/:id/2fa/reset", middleware.RequirePermission(middleware.PermUsersManage), handlers.ResetUserTwoFactor)
 
 	// Items CRUD
 	items := protected.Group("/items")
-	items.Get("/", handlers.GetAllItems)
-	items.Get("/:id", handlers.GetItem)
-	items.Post("/", handlers.CreateItem)
-	items.Put("/:id", handlers.UpdateItem)
-	items.Delete("/:id", handlers.DeleteItem)
+	items.Get("/", middleware.RequirePermission(middleware.PermItemsRead), handlers.GetAllItems)
+	items.Get("/reconciliation", middleware.RequirePermission(middleware.PermItemsRead), handlers.ReconcileStock)
+	items.Get("/low-stock", middleware.RequirePermission(middleware.PermItemsRead), handlers.GetLowStockItems)
+	items.Post("/reorder-suggestions", middleware.RequirePermission(middleware.PermPurchasesCreate), handlers.SuggestReorderPurchases)
+	items.Get("/:id", middleware.RequirePermission(middleware.PermItemsRead), handlers.GetItem)
+	items.Post("/", middleware.RequirePermission(middleware.PermItemsWrite), handlers.CreateItem)
+	items.Put("/:id", middleware.RequirePermission(middleware.PermItemsWrite), handlers.UpdateItem)
+	items.Delete("/:id", middleware.RequirePermission(middleware.PermItemsDelete), handlers.DeleteItem)
+
+	// Stock ledger
+	items.Get("/:id/movements", middleware.RequirePermission(middleware.PermItemsRead), handlers.GetItemMovements)
+	items.Post("/:id/movements", middleware.RequirePermission(middleware.PermItemsWrite), handlers.CreateItemMovement)
+	items.Get("/:id/suppliers", middleware.RequirePermission(middleware.PermSuppliersRead), handlers.GetItemSuppliers)
+
+	// Warehouses and transfers between them
+	warehouses := protected.Group("/warehouses")
+	warehouses.Get("/", middleware.RequirePermission(middleware.PermItemsRead), handlers.GetAllWarehouses)
+	warehouses.Get("/:id", middleware.RequirePermission(middleware.PermItemsRead), handlers.GetWarehouse)
+	warehouses.Get("/:id/stock", middleware.RequirePermission(middleware.PermItemsRead), handlers.GetWarehouseStock)
+	warehouses.Post("/", middleware.RequirePermission(middleware.PermWarehousesManage), handlers.CreateWarehouse)
+	warehouses.Put("/:id", middleware.RequirePermission(middleware.PermWarehousesManage), handlers.UpdateWarehouse)
+	warehouses.Delete("/:id", middleware.RequirePermission(middleware.PermWarehousesManage), handlers.DeleteWarehouse)
+	transfers := protected.Group("/stock-transfers")
+	transfers.Get("/", middleware.RequirePermission(middleware.PermItemsRead), handlers.GetAllStockTransfers)
+	transfers.Get("/:id", middleware.RequirePermission(middleware.PermItemsRead), handlers.GetStockTransfer)
+	transfers.Post("/", middleware.RequirePermission(middleware.PermItemsWrite), handlers.CreateStockTransfer)
 
 	// Suppliers CRUD
 	suppliers := protected.Group("/suppliers")
-	suppliers.Get("/", handlers.GetAllSuppliers)
-	suppliers.Get("/:id", handlers.GetSupplier)
-	suppliers.Post("/", handlers.CreateSupplier)
-	suppliers.Put("/:id", handlers.UpdateSupplier)
-	suppliers.Delete("/:id", handlers.DeleteSupplier)
+	suppliers.Get("/", middleware.RequirePermission(middleware.PermSuppliersRead), handlers.GetAllSuppliers)
+	suppliers.Get("/:id", middleware.RequirePermission(middleware.PermSuppliersRead), handlers.GetSupplier)
+	suppliers.Post("/", middleware.RequirePermission(middleware.PermSuppliersWrite), handlers.CreateSupplier)
+	suppliers.Put("/:id", middleware.RequirePermission(middleware.PermSuppliersWrite), handlers.UpdateSupplier)
+	suppliers.Delete("/:id", middleware.RequirePermission(middleware.PermSuppliersDelete), handlers.DeleteSupplier)
+
+	// Supplier catalog
+	suppliers.Get("/:id/items", middleware.RequirePermission(middleware.PermSuppliersRead), handlers.GetSupplierItems)
+	suppliers.Get("/:id/items/:entryId", middleware.RequirePermission(middleware.PermSuppliersRead), handlers.GetSupplierItem)
+	suppliers.Post("/:id/items", middleware.RequirePermission(middleware.PermSuppliersWrite), handlers.CreateSupplierItem)
+	suppliers.Put("/:id/items/:entryId", middleware.RequirePermission(middleware.PermSuppliersWrite), handlers.UpdateSupplierItem)
+	suppliers.Delete("/:id/items/:entryId", middleware.RequirePermission(middleware.PermSuppliersWrite), handlers.DeleteSupplierItem)
+
+	// Supplier balance
+	suppliers.Get("/:id/balance", middleware.RequirePermission(middleware.PermPayablesRead), handlers.GetSupplierBalance)
 
 	// Purchasing
 	purchases := protected.Group("/purchases")
-	purchases.Get("/", handlers.GetAllPurchases)
-	purchases.Get("/:id", handlers.GetPurchase)
-	purchases.Post("/", handlers.CreatePurchase)
+	purchases.Get("/", middleware.RequirePermission(middleware.PermPurchasesRead), handlers.GetAllPurchases)
+	purchases.Get("/export", middleware.RequirePermission(middleware.PermPurchasesRead), handlers.ExportPurchases)
+	purchases.Get("/:id", middleware.RequirePermission(middleware.PermPurchasesRead), handlers.GetPurchase)
+	purchases.Post("/", middleware.RequirePermission(middleware.PermPurchasesCreate), handlers.CreatePurchase)
+	purchases.Put("/:id", middleware.RequirePermission(middleware.PermPurchasesCreate), handlers.UpdatePurchase)
+
+	// Purchase approval workflow
+	purchases.Post("/:id/submit", middleware.RequirePermission(middleware.PermPurchasesCreate), handlers.SubmitPurchase)
+	purchases.Post("/:id/approve", middleware.RequirePermission(middleware.PermPurchasesApprove), handlers.ApprovePurchase)
+	purchases.Post("/:id/reject", middleware.RequirePermission(middleware.PermPurchasesApprove), handlers.RejectPurchase)
+	purchases.Post("/:id/order", middleware.RequirePermission(middleware.PermPurchasesCreate), handlers.OrderPurchase)
+	purchases.Post("/:id/receive", middleware.RequirePermission(middleware.PermPurchasesReceive), handlers.ReceivePurchase)
+	purchases.Post("/:id/close", middleware.RequirePermission(middleware.PermPurchasesCreate), handlers.ClosePurchase)
+	purchases.Post("/:id/cancel", middleware.RequirePermission(middleware.PermPurchasesCreate), handlers.CancelPurchase)
+
+	// Goods receipts
+	purchases.Get("/:id/receipts", middleware.RequirePermission(middleware.PermPurchasesRead), handlers.GetPurchaseReceipts)
+	purchases.Post("/:id/receipts", middleware.RequirePermission(middleware.PermPurchasesReceive), handlers.CreatePurchaseReceipt)
+
+	// Purchase returns
+	purchases.Get("/:id/returns", middleware.RequirePermission(middleware.PermPurchasesRead), handlers.GetPurchaseReturns)
+	purchases.Post("/:id/returns", middleware.RequirePermission(middleware.PermPurchasesReceive), handlers.CreatePurchaseReturn)
+	returns := protected.Group("/purchase-returns")
+	returns.Get("/", middleware.RequirePermission(middleware.PermPurchasesRead), handlers.GetAllPurchaseReturns)
+	returns.Get("/:id", middleware.RequirePermission(middleware.PermPurchasesRead), handlers.GetPurchaseReturn)
+
+	// Accounts payable
+	invoices := protected.Group("/supplier-invoices")
+	invoices.Get("/", middleware.RequirePermission(middleware.PermPayablesRead), handlers.GetAllSupplierInvoices)
+	invoices.Get("/:id", middleware.RequirePermission(middleware.PermPayablesRead), handlers.GetSupplierInvoice)
+	invoices.Post("/", middleware.RequirePermission(middleware.PermPayablesWrite), handlers.CreateSupplierInvoice)
+	invoices.Delete("/:id", middleware.RequirePermission(middleware.PermPayablesWrite), handlers.DeleteSupplierInvoice)
+	invoices.Post("/:id/match", middleware.RequirePermission(middleware.PermPayablesWrite), handlers.MatchSupplierInvoice)
+	invoices.Post("/:id/variances/:varianceId/resolve", middleware.RequirePermission(middleware.PermPayablesApprove), handlers.ResolveMatchVariance)
+	invoices.Post("/:id/approve", middleware.RequirePermission(middleware.PermPayablesApprove), handlers.ApproveSupplierInvoice)
+	invoices.Get("/:id/payments", middleware.RequirePermission(middleware.PermPayablesRead), handlers.GetSupplierPayments)
+	invoices.Post("/:id/payments", middleware.RequirePermission(middleware.PermPayablesWrite), handlers.CreateSupplierPayment)
+
+	// Reports
+	reports := protected.Group("/reports")
+	reports.Get("/ap-aging", middleware.RequirePermission(middleware.PermPayablesRead), handlers.GetAPAgingReport)
+
+	// Exchange rates
+	rates := protected.Group("/exchange-rates")
+	rates.Get("/", middleware.RequirePermission(middleware.PermPurchasesRead), handlers.GetAllExchangeRates)
+	rates.Post("/import", middleware.RequirePermission(middleware.PermRatesManage), handlers.ImportExchangeRates)
+	rates.Get("/:id", middleware.RequirePermission(middleware.PermPurchasesRead), handlers.GetExchangeRate)
+	rates.Post("/", middleware.RequirePermission(middleware.PermRatesManage), handlers.CreateExchangeRate)
+	rates.Put("/:id", middleware.RequirePermission(middleware.PermRatesManage), handlers.UpdateExchangeRate)
+	rates.Delete("/:id", middleware.RequirePermission(middleware.PermRatesManage), handlers.DeleteExchangeRate)
+
+	// Tax codes
+	taxCodes := protected.Group("/tax-codes")
+	taxCodes.Get("/", middleware.RequirePermission(middleware.PermPurchasesRead), handlers.GetAllTaxCodes)
+	taxCodes.Get("/:id", middleware.RequirePermission(middleware.PermPurchasesRead), handlers.GetTaxCode)
+	taxCodes.Post("/", middleware.RequirePermission(middleware.PermTaxCodesManage), handlers.CreateTaxCode)
+	taxCodes.Put("/:id", middleware.RequirePermission(middleware.PermTaxCodesManage), handlers.UpdateTaxCode)
+	taxCodes.Delete("/:id", middleware.RequirePermission(middleware.PermTaxCodesManage), handlers.DeleteTaxCode)
+
+	// Webhooks (admin)
+	webhooks := protected.Group("/webhooks", middleware.RequirePermission(middleware.PermWebhooksManage))
+	webhooks.Get("/deliveries", handlers.GetAllWebhookDeliveries)
+	webhooks.Get("/deliveries/:id", handlers.GetWebhookDelivery)
+	webhooks.Post("/deliveries/:id/replay", handlers.ReplayWebhookDelivery)
+	webhooks.Get("/", handlers.GetAllWebhookSubscriptions)
+	webhooks.Get("/:id", handlers.GetWebhookSubscription)
+	webhooks.Post("/", handlers.CreateWebhookSubscription)
+	webhooks.Put("/:id", handlers.UpdateWebhookSubscription)
+	webhooks.Delete("/:id", handlers.DeleteWebhookSubscription)
 }
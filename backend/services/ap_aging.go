@@ -0,0 +1,140 @@
+package services
+
+import (
+	"procurement-system/models"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AgingBuckets splits outstanding invoice amounts by how many days past
+// their due date they are
+type AgingBuckets struct {
+	Current    models.Money `json:"current"`
+	Days1To30  models.Money `json:"days_1_30"`
+	Days31To60 models.Money `json:"days_31_60"`
+	Days61To90 models.Money `json:"days_61_90"`
+	Over90     models.Money `json:"days_over_90"`
+	Total      models.Money `json:"total"`
+}
+
+// add puts amount into the bucket of an invoice daysOverdue days past due
+func (b *AgingBuckets) add(daysOverdue int, amount models.Money) error {
+	bucket := &b.Over90
+	switch {
+	case daysOverdue <= 0:
+		bucket = &b.Current
+	case daysOverdue <= 30:
+		bucket = &b.Days1To30
+	case daysOverdue <= 60:
+		bucket = &b.Days31To60
+	case daysOverdue <= 90:
+		bucket = &b.Days61To90
+	}
+	return addTo(map[*models.Money]models.Money{bucket: amount, &b.Total: amount})
+}
+
+// AgingLine is the aging of what is owed to one supplier in one currency
+type AgingLine struct {
+	SupplierID uint   `json:"supplier_id"`
+	Supplier   string `json:"supplier"`
+	Currency   string `json:"currency"`
+	AgingBuckets
+}
+
+// AgingTotal is the aging of what is owed to all suppliers in one currency
+type AgingTotal struct {
+	Currency string `json:"currency"`
+	AgingBuckets
+}
+
+// AgingReport is the accounts payable aging on a date
+type AgingReport struct {
+	AsOf      string       `json:"as_of"`
+	Suppliers []AgingLine  `json:"suppliers"`
+	Totals    []AgingTotal `json:"totals"`
+}
+
+// APAging ages the invoices of query as they stood at the end of asOf: only
+// invoices dated on or before asOf and payments made on or before asOf are
+// counted, so past reports can be reproduced. Lines are ordered by supplier
+// name and currency; amounts in different currencies are never added up.
+func APAging(query *gorm.DB, asOf time.Time) (*AgingReport, error) {
+	asOf = civilDate(asOf)
+	day := asOf.Format("2006-01-02")
+
+	var invoices []models.SupplierInvoice
+	err := query.
+		Preload("Supplier", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).
+		Preload("Payments", "date <= ?", day).
+		Where("invoice_date <= ?", day).
+		Find(&invoices).Error
+	if err != nil {
+		return nil, err
+	}
+
+	type lineKey struct {
+		supplierID uint
+		currency   string
+	}
+	lines := make(map[lineKey]*AgingLine)
+	totals := make(map[string]*AgingTotal)
+
+	for _, invoice := range invoices {
+		outstanding := invoice.Amount
+		for _, payment := range invoice.Payments {
+			if outstanding, err = outstanding.Sub(payment.Amount); err != nil {
+				return nil, err
+			}
+		}
+		if outstanding.IsZero() || outstanding.IsNegative() {
+			continue
+		}
+
+		key := lineKey{invoice.SupplierID, invoice.Currency}
+		if lines[key] == nil {
+			lines[key] = &AgingLine{SupplierID: invoice.SupplierID, Currency: invoice.Currency}
+			if invoice.Supplier != nil {
+				lines[key].Supplier = invoice.Supplier.Name
+			}
+		}
+		if totals[invoice.Currency] == nil {
+			totals[invoice.Currency] = &AgingTotal{Currency: invoice.Currency}
+		}
+
+		daysOverdue := int(asOf.Sub(civilDate(invoice.DueDate)).Hours() / 24)
+		if err := lines[key].add(daysOverdue, outstanding); err != nil {
+			return nil, err
+		}
+		if err := totals[invoice.Currency].add(daysOverdue, outstanding); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &AgingReport{
+		AsOf:      day,
+		Suppliers: make([]AgingLine, 0, len(lines)),
+		Totals:    make([]AgingTotal, 0, len(totals)),
+	}
+	for _, line := range lines {
+		report.Suppliers = append(report.Suppliers, *line)
+	}
+	for _, total := range totals {
+		report.Totals = append(report.Totals, *total)
+	}
+
+	sort.Slice(report.Suppliers, func(i, j int) bool {
+		a, b := report.Suppliers[i], report.Suppliers[j]
+		if a.Supplier != b.Supplier {
+			return a.Supplier < b.Supplier
+		}
+		if a.SupplierID != b.SupplierID {
+			return a.SupplierID < b.SupplierID
+		}
+		return a.Currency < b.Currency
+	})
+	sort.Slice(report.Totals, func(i, j int) bool { return report.Totals[i].Currency < report.Totals[j].Currency })
+
+	return report, nil
+}
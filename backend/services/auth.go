@@ -0,0 +1,308 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"procurement-system/config"
+	"procurement-system/models"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TokenPair is what a client receives when it logs in or refreshes its
+// session
+type TokenPair struct {
+	AccessToken      string    `json:"token"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+
+	// TwoFactorSetupRequired is set when the user's role requires
+	// two-factor authentication and they have not set it up. The access
+	// token then only allows setting it up.
+	TwoFactorSetupRequired bool `json:"two_factor_setup_required"`
+}
+
+// ClientInfo identifies where a session is used from
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+// AccessClaims are the claims of a valid, unrevoked access token
+type AccessClaims struct {
+	UserID    uint
+	Username  string
+	Role      string
+	TokenID   string
+	SessionID string
+	ExpiresAt time.Time
+
+	TwoFactorSetupRequired bool
+}
+
+// TokenReuseError is returned when a refresh token that was already rotated
+// or revoked is presented again, which suggests it has been stolen. The
+// transaction the error is returned from is rolled back, so the caller must
+// revoke the session in a transaction of its own.
+type TokenReuseError struct {
+	SessionID string
+}
+
+func (e *TokenReuseError) Error() string {
+	return "Refresh token has already been used"
+}
+
+// IssueTokens signs an access token for user and stores a refresh token
+// that renews it. An empty sessionID starts a new session.
+func IssueTokens(tx *gorm.DB, user models.User, sessionID string, client ClientInfo) (TokenPair, error) {
+	now := time.Now()
+
+	if sessionID == "" {
+		// Logins are rare enough to clean up after without a background job
+		if err := purgeExpiredTokens(tx, now); err != nil {
+			return TokenPair{}, err
+		}
+		sessionID = randomID()
+	}
+
+	pair := TokenPair{
+		ExpiresAt:        now.Add(config.AppConfig.AccessTokenTTL),
+		RefreshToken:     randomToken(),
+		RefreshExpiresAt: now.Add(config.AppConfig.RefreshTokenTTL),
+	}
+
+	if !user.TOTPEnabled {
+		required, err := TwoFactorRequired(tx, user.Role)
+		if err != nil {
+			return TokenPair{}, err
+		}
+		pair.TwoFactorSetupRequired = required
+	}
+
+	jti := randomID()
+	claims := jwt.MapClaims{
+		"user_id":  user.ID,
+		"username": user.Username,
+		"role":     user.Role,
+		"jti":      jti,
+		"sid":      sessionID,
+		"exp":      pair.ExpiresAt.Unix(),
+	}
+	if pair.TwoFactorSetupRequired {
+		claims["setup_2fa"] = true
+	}
+	token, err := signToken(claims)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	pair.AccessToken = token
+
+	refresh := models.RefreshToken{
+		UserID:          user.ID,
+		SessionID:       sessionID,
+		TokenHash:       hashToken(pair.RefreshToken),
+		AccessJTI:       jti,
+		AccessExpiresAt: pair.ExpiresAt,
+		ExpiresAt:       pair.RefreshExpiresAt,
+		IP:              truncate(client.IP, 64),
+		UserAgent:       truncate(client.UserAgent, 255),
+	}
+	if err := tx.Create(&refresh).Error; err != nil {
+		return TokenPair{}, err
+	}
+
+	return pair, nil
+}
+
+// RefreshSession exchanges a refresh token for a new token pair of the same
+// session. The presented refresh token is spent; presenting it again yields
+// a TokenReuseError.
+func RefreshSession(tx *gorm.DB, raw string, client ClientInfo) (TokenPair, models.User, error) {
+	if raw == "" {
+		return TokenPair{}, models.User{}, &ValidationError{Message: "Refresh token is required"}
+	}
+
+	var refresh models.RefreshToken
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token_hash = ?", hashToken(raw)).
+		First(&refresh).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return TokenPair{}, models.User{}, &UnauthorizedError{Message: "Invalid refresh token"}
+	}
+	if err != nil {
+		return TokenPair{}, models.User{}, err
+	}
+
+	now := time.Now()
+	if refresh.RevokedAt != nil {
+		return TokenPair{}, models.User{}, &TokenReuseError{SessionID: refresh.SessionID}
+	}
+	if !refresh.ExpiresAt.After(now) {
+		return TokenPair{}, models.User{}, &UnauthorizedError{Message: "Refresh token has expired"}
+	}
+
+	var user models.User
+	if err := tx.First(&user, refresh.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return TokenPair{}, models.User{}, &UnauthorizedError{Message: "Invalid refresh token"}
+		}
+		return TokenPair{}, models.User{}, err
+	}
+
+	if err := tx.Model(&refresh).Update("revoked_at", now).Error; err != nil {
+		return TokenPair{}, models.User{}, err
+	}
+
+	pair, err := IssueTokens(tx, user, refresh.SessionID, client)
+	if err != nil {
+		return TokenPair{}, models.User{}, err
+	}
+	return pair, user, nil
+}
+
+// RevokeSession revokes every refresh token of a session and the access
+// tokens issued with them
+func RevokeSession(tx *gorm.DB, sessionID string) error {
+	return revokeTokens(tx, "session_id = ?", sessionID)
+}
+
+// RevokeUserSessions logs a user out everywhere by revoking the refresh
+// tokens of all their sessions and the access tokens issued with them
+func RevokeUserSessions(tx *gorm.DB, userID uint) error {
+	return revokeTokens(tx, "user_id = ?", userID)
+}
+
+// RevokeOtherSessions logs a user out of every session but one
+func RevokeOtherSessions(tx *gorm.DB, userID uint, keepSessionID string) error {
+	return revokeTokens(tx, "user_id = ? AND session_id <> ?", userID, keepSessionID)
+}
+
+// revokeTokens revokes the refresh tokens matching a condition and adds the
+// access tokens issued with them that have not expired yet to the
+// revocation list
+func revokeTokens(tx *gorm.DB, condition string, args ...interface{}) error {
+	now := time.Now()
+
+	var tokens []models.RefreshToken
+	if err := tx.Where(condition, args...).Where("access_expires_at > ?", now).Find(&tokens).Error; err != nil {
+		return err
+	}
+
+	err := tx.Model(&models.RefreshToken{}).
+		Where(condition, args...).
+		Where("revoked_at IS NULL").
+		Update("revoked_at", now).Error
+	if err != nil {
+		return err
+	}
+
+	if len(tokens) == 0 {
+		return nil
+	}
+	revoked := make([]models.RevokedToken, 0, len(tokens))
+	for _, token := range tokens {
+		revoked = append(revoked, models.RevokedToken{
+			JTI:       token.AccessJTI,
+			UserID:    token.UserID,
+			ExpiresAt: token.AccessExpiresAt,
+		})
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&revoked).Error
+}
+
+// ParseAccessToken verifies an access token and returns its claims. Tokens
+// that are expired, malformed or on the revocation list are rejected with
+// an UnauthorizedError.
+func ParseAccessToken(db *gorm.DB, raw string) (*AccessClaims, error) {
+	token, err := jwt.Parse(raw, verificationKey)
+	if err != nil || !token.Valid {
+		return nil, &UnauthorizedError{Message: "Invalid or expired token"}
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, &UnauthorizedError{Message: "Invalid token claims"}
+	}
+
+	userID, _ := claims["user_id"].(float64)
+	username, _ := claims["username"].(string)
+	role, _ := claims["role"].(string)
+	jti, _ := claims["jti"].(string)
+	sid, _ := claims["sid"].(string)
+	setup2FA, _ := claims["setup_2fa"].(bool)
+	exp, err := claims.GetExpirationTime()
+	if userID <= 0 || username == "" || role == "" || jti == "" || sid == "" || err != nil || exp == nil {
+		return nil, &UnauthorizedError{Message: "Invalid token claims"}
+	}
+
+	var revoked int64
+	if err := db.Model(&models.RevokedToken{}).Where("jti = ?", jti).Count(&revoked).Error; err != nil {
+		return nil, err
+	}
+	if revoked > 0 {
+		return nil, &UnauthorizedError{Message: "Token has been revoked"}
+	}
+
+	return &AccessClaims{
+		UserID:    uint(userID),
+		Username:  username,
+		Role:      role,
+		TokenID:   jti,
+		SessionID: sid,
+		ExpiresAt: exp.Time,
+
+		TwoFactorSetupRequired: setup2FA,
+	}, nil
+}
+
+// purgeExpiredTokens deletes refresh tokens and revocation list entries
+// that can no longer be used anyway
+func purgeExpiredTokens(tx *gorm.DB, now time.Time) error {
+	if err := tx.Where("expires_at <= ?", now).Delete(&models.RevokedToken{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("expires_at <= ? AND access_expires_at <= ?", now, now).Delete(&models.RefreshToken{}).Error
+}
+
+// randomID returns a random 128-bit identifier in hex
+func randomID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// randomToken returns a random 256-bit secret, URL-safe encoded
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// hashToken returns the hex SHA-256 of a token, which is what is stored of
+// secrets handed to clients
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// truncate shortens s to at most n bytes without splitting a UTF-8 sequence
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && s[n]&0xC0 == 0x80 {
+		n--
+	}
+	return s[:n]
+}
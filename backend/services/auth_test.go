@@ -0,0 +1,110 @@
+package services
+
+import (
+	"errors"
+	"procurement-system/database"
+	"testing"
+)
+
+// isUnauthorized reports whether err refuses the caller's credentials
+func isUnauthorized(err error) bool {
+	var unauthorizedErr *UnauthorizedError
+	return errors.As(err, &unauthorizedErr)
+}
+
+// TestRefreshSession checks that refresh tokens rotate, that a spent one
+// cannot be used again and that revoked sessions end at once
+func TestRefreshSession(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, testRun(t))
+	client := ClientInfo{IP: "192.0.2.1", UserAgent: "test"}
+
+	login, err := IssueTokens(database.DB, user, "", client)
+	if err != nil {
+		t.Fatalf("issue tokens: %v", err)
+	}
+	claims, err := ParseAccessToken(database.DB, login.AccessToken)
+	if err != nil {
+		t.Fatalf("parse access token: %v", err)
+	}
+	if claims.UserID != user.ID || claims.Role != user.Role || claims.SessionID == "" {
+		t.Errorf("claims = %+v, want user %d with role %q", claims, user.ID, user.Role)
+	}
+
+	refreshed, refreshedUser, err := RefreshSession(database.DB, login.RefreshToken, client)
+	if err != nil {
+		t.Fatalf("refresh session: %v", err)
+	}
+	if refreshedUser.ID != user.ID {
+		t.Errorf("refreshed user = %d, want %d", refreshedUser.ID, user.ID)
+	}
+	if refreshed.RefreshToken == login.RefreshToken {
+		t.Error("refresh token was not rotated")
+	}
+	refreshedClaims, err := ParseAccessToken(database.DB, refreshed.AccessToken)
+	if err != nil {
+		t.Fatalf("parse refreshed access token: %v", err)
+	}
+	if refreshedClaims.SessionID != claims.SessionID {
+		t.Errorf("refreshed session = %q, want %q", refreshedClaims.SessionID, claims.SessionID)
+	}
+
+	// A spent refresh token is reported as reused along with its session
+	var reuseErr *TokenReuseError
+	if _, _, err := RefreshSession(database.DB, login.RefreshToken, client); !errors.As(err, &reuseErr) || reuseErr.SessionID != claims.SessionID {
+		t.Errorf("refresh with a spent token: err = %v, want reuse of session %q", err, claims.SessionID)
+	}
+
+	for _, raw := range []string{"", "not-a-token"} {
+		if _, _, err := RefreshSession(database.DB, raw, client); !isRejection(err) && !isUnauthorized(err) {
+			t.Errorf("refresh with %q: err = %v, want a refusal", raw, err)
+		}
+	}
+
+	if err := RevokeSession(database.DB, claims.SessionID); err != nil {
+		t.Fatalf("revoke session: %v", err)
+	}
+	if _, err := ParseAccessToken(database.DB, refreshed.AccessToken); !isUnauthorized(err) {
+		t.Errorf("access token of a revoked session: err = %v, want a refusal", err)
+	}
+	if _, _, err := RefreshSession(database.DB, refreshed.RefreshToken, client); !errors.As(err, &reuseErr) {
+		t.Errorf("refresh a revoked session: err = %v, want a reuse error", err)
+	}
+}
+
+// TestRevokeOtherSessions checks that logging out elsewhere keeps the
+// current session alive
+func TestRevokeOtherSessions(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, testRun(t))
+
+	var sessions []TokenPair
+	for i := 0; i < 2; i++ {
+		pair, err := IssueTokens(database.DB, user, "", ClientInfo{})
+		if err != nil {
+			t.Fatalf("issue tokens: %v", err)
+		}
+		sessions = append(sessions, pair)
+	}
+	current, err := ParseAccessToken(database.DB, sessions[0].AccessToken)
+	if err != nil {
+		t.Fatalf("parse access token: %v", err)
+	}
+
+	if err := RevokeOtherSessions(database.DB, user.ID, current.SessionID); err != nil {
+		t.Fatalf("revoke other sessions: %v", err)
+	}
+	if _, err := ParseAccessToken(database.DB, sessions[0].AccessToken); err != nil {
+		t.Errorf("current session: %v", err)
+	}
+	if _, err := ParseAccessToken(database.DB, sessions[1].AccessToken); !isUnauthorized(err) {
+		t.Errorf("other session: err = %v, want a refusal", err)
+	}
+
+	if err := RevokeUserSessions(database.DB, user.ID); err != nil {
+		t.Fatalf("revoke user sessions: %v", err)
+	}
+	if _, err := ParseAccessToken(database.DB, sessions[0].AccessToken); !isUnauthorized(err) {
+		t.Errorf("current session after logging out everywhere: err = %v, want a refusal", err)
+	}
+}
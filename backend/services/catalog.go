@@ -0,0 +1,93 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"procurement-system/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SaveSupplierItem validates a catalog entry and creates or updates it.
+// Entries of the same supplier and item may not have overlapping validity
+// periods, so that a purchase date always resolves to a single price.
+func SaveSupplierItem(tx *gorm.DB, entry *models.SupplierItem) error {
+	if entry.UnitPrice.IsNegative() {
+		return &ValidationError{Message: "Unit price cannot be negative"}
+	}
+	if entry.MinOrderQty < 1 {
+		return &ValidationError{Message: "Minimum order quantity must be at least 1"}
+	}
+	if entry.LeadTimeDays < 0 {
+		return &ValidationError{Message: "Lead time cannot be negative"}
+	}
+	if entry.ValidFrom != nil && entry.ValidTo != nil && entry.ValidTo.Before(*entry.ValidFrom) {
+		return &ValidationError{Message: "valid_to cannot be before valid_from"}
+	}
+
+	// Lock the supplier so concurrent edits of its catalog cannot both pass
+	// the overlap check
+	var supplier models.Supplier
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&supplier, entry.SupplierID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &NotFoundError{Message: "Supplier not found"}
+		}
+		return err
+	}
+
+	// Prices default to the supplier's currency
+	if entry.Currency == "" {
+		entry.Currency = supplier.Currency
+	}
+	if !IsValidCurrency(entry.Currency) {
+		return &ValidationError{Message: "Currency must be a three-letter ISO 4217 code"}
+	}
+
+	var item models.Item
+	if err := tx.First(&item, entry.ItemID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &ValidationError{Message: fmt.Sprintf("Item with ID %d not found", entry.ItemID)}
+		}
+		return err
+	}
+
+	overlap := tx.Model(&models.SupplierItem{}).
+		Where("supplier_id = ? AND item_id = ? AND id <> ?", entry.SupplierID, entry.ItemID, entry.ID)
+	if entry.ValidTo != nil {
+		overlap = overlap.Where("(valid_from IS NULL OR valid_from <= ?)", entry.ValidTo.Format("2006-01-02"))
+	}
+	if entry.ValidFrom != nil {
+		overlap = overlap.Where("(valid_to IS NULL OR valid_to >= ?)", entry.ValidFrom.Format("2006-01-02"))
+	}
+	var count int64
+	if err := overlap.Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return &ConflictError{Message: fmt.Sprintf("Supplier '%s' already lists '%s' for an overlapping validity period", supplier.Name, item.Name)}
+	}
+
+	return tx.Save(entry).Error
+}
+
+// SupplierPrice returns the catalog entry under which a supplier sells an
+// item on date. It returns a ValidationError if the supplier does not offer
+// the item on that date.
+func SupplierPrice(tx *gorm.DB, supplierID, itemID uint, date time.Time) (*models.SupplierItem, error) {
+	day := date.Format("2006-01-02")
+
+	var entry models.SupplierItem
+	err := tx.Where("supplier_id = ? AND item_id = ?", supplierID, itemID).
+		Where("(valid_from IS NULL OR valid_from <= ?) AND (valid_to IS NULL OR valid_to >= ?)", day, day).
+		First(&entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, &ValidationError{Message: fmt.Sprintf("Item with ID %d is not offered by this supplier", itemID)}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
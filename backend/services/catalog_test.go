@@ -0,0 +1,83 @@
+package services
+
+import (
+	"procurement-system/database"
+	"procurement-system/models"
+	"testing"
+	"time"
+)
+
+// TestSupplierPrice checks that a supplier may list an item for several
+// non-overlapping periods and that a date resolves to the right one
+func TestSupplierPrice(t *testing.T) {
+	setupTestDB(t)
+	run := testRun(t)
+	supplier := createTestSupplier(t, run)
+	item := createTestItem(t, run)
+
+	day := func(month time.Month, d int) *time.Time {
+		date := time.Date(2024, month, d, 0, 0, 0, 0, time.UTC)
+		return &date
+	}
+
+	periods := []models.SupplierItem{
+		{SupplierID: supplier.ID, ItemID: item.ID, UnitPrice: 1000, MinOrderQty: 1, ValidTo: day(time.March, 31)},
+		{SupplierID: supplier.ID, ItemID: item.ID, UnitPrice: 1200, MinOrderQty: 1, ValidFrom: day(time.April, 1), ValidTo: day(time.June, 30)},
+		{SupplierID: supplier.ID, ItemID: item.ID, UnitPrice: 1500, MinOrderQty: 1, ValidFrom: day(time.September, 1)},
+	}
+	for i := range periods {
+		if err := SaveSupplierItem(database.DB, &periods[i]); err != nil {
+			t.Fatalf("save period %d: %v", i, err)
+		}
+	}
+
+	prices := []struct {
+		date *time.Time
+		want models.Money
+	}{
+		{day(time.January, 15), 1000},
+		{day(time.March, 31), 1000},
+		{day(time.April, 1), 1200},
+		{day(time.June, 30), 1200},
+		{day(time.December, 1), 1500},
+	}
+	for _, p := range prices {
+		entry, err := SupplierPrice(database.DB, supplier.ID, item.ID, *p.date)
+		if err != nil {
+			t.Errorf("price on %s: %v", p.date.Format("2006-01-02"), err)
+			continue
+		}
+		if entry.UnitPrice != p.want {
+			t.Errorf("price on %s = %s, want %s", p.date.Format("2006-01-02"), entry.UnitPrice, p.want)
+		}
+	}
+
+	// There is no price between July and August
+	if _, err := SupplierPrice(database.DB, supplier.ID, item.ID, *day(time.August, 1)); !isRejection(err) {
+		t.Errorf("price in a gap: err = %v, want a rejection", err)
+	}
+
+	overlapping := models.SupplierItem{SupplierID: supplier.ID, ItemID: item.ID, UnitPrice: 900, MinOrderQty: 1, ValidFrom: day(time.June, 1), ValidTo: day(time.July, 31)}
+	if err := SaveSupplierItem(database.DB, &overlapping); err == nil {
+		t.Error("saving an overlapping period succeeded, want a conflict")
+	} else if _, ok := err.(*ConflictError); !ok {
+		t.Errorf("saving an overlapping period: err = %v, want a conflict", err)
+	}
+
+	// A period saved again does not overlap with itself
+	periods[1].UnitPrice = 1250
+	if err := SaveSupplierItem(database.DB, &periods[1]); err != nil {
+		t.Errorf("update period: %v", err)
+	}
+
+	invalid := []models.SupplierItem{
+		{SupplierID: supplier.ID, ItemID: item.ID, UnitPrice: -1, MinOrderQty: 1},
+		{SupplierID: supplier.ID, ItemID: item.ID, UnitPrice: 100, MinOrderQty: 0},
+		{SupplierID: supplier.ID, ItemID: item.ID, UnitPrice: 100, MinOrderQty: 1, ValidFrom: day(time.August, 2), ValidTo: day(time.August, 1)},
+	}
+	for i := range invalid {
+		if err := SaveSupplierItem(database.DB, &invalid[i]); !isRejection(err) {
+			t.Errorf("invalid entry %d: err = %v, want a rejection", i, err)
+		}
+	}
+}
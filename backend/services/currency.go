@@ -0,0 +1,149 @@
+package services
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"procurement-system/config"
+	"procurement-system/models"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// IsValidCurrency reports whether code looks like an ISO 4217 currency code
+func IsValidCurrency(code string) bool {
+	return currencyCode.MatchString(code)
+}
+
+// ExchangeRateOn returns the value of one unit of currency in the base
+// currency on date, i.e. the latest rate effective on or before date
+func ExchangeRateOn(tx *gorm.DB, currency string, date time.Time) (models.Rate, error) {
+	if currency == config.AppConfig.BaseCurrency {
+		return models.OneRate, nil
+	}
+
+	var rate models.ExchangeRate
+	err := tx.Where("currency = ? AND effective_date <= ?", currency, date.Format("2006-01-02")).
+		Order("effective_date DESC").
+		First(&rate).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, &ValidationError{Message: fmt.Sprintf("No exchange rate for %s on %s", currency, date.Format("2006-01-02"))}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return rate.Rate, nil
+}
+
+// ConvertMoney converts amount between two currencies at the rates valid
+// on date. The result is rounded once, after the full conversion.
+func ConvertMoney(tx *gorm.DB, amount models.Money, from, to string, date time.Time) (models.Money, error) {
+	if from == to {
+		return amount, nil
+	}
+
+	fromRate, err := ExchangeRateOn(tx, from, date)
+	if err != nil {
+		return 0, err
+	}
+	toRate, err := ExchangeRateOn(tx, to, date)
+	if err != nil {
+		return 0, err
+	}
+
+	r := amount.Rat()
+	r.Mul(r, fromRate.Rat())
+	r.Quo(r, toRate.Rat())
+	return models.MoneyFromRat(r)
+}
+
+// ToBaseCurrency converts amount at rate to the base currency
+func ToBaseCurrency(amount models.Money, rate models.Rate) (models.Money, error) {
+	r := amount.Rat()
+	return models.MoneyFromRat(r.Mul(r, rate.Rat()))
+}
+
+// ValidateExchangeRate checks a rate before it is saved
+func ValidateExchangeRate(rate *models.ExchangeRate) error {
+	if !IsValidCurrency(rate.Currency) {
+		return &ValidationError{Message: "Currency must be a three-letter ISO 4217 code"}
+	}
+	if rate.Currency == config.AppConfig.BaseCurrency {
+		return &ValidationError{Message: fmt.Sprintf("%s is the base currency and always has rate 1", rate.Currency)}
+	}
+	if rate.EffectiveDate.IsZero() {
+		return &ValidationError{Message: "Effective date is required"}
+	}
+	if !rate.Rate.IsPositive() {
+		return &ValidationError{Message: "Rate must be greater than zero"}
+	}
+	return nil
+}
+
+// ImportExchangeRates reads rates from CSV with the columns currency,
+// effective_date (YYYY-MM-DD) and rate; a header row is optional. Rates
+// that already exist for the same currency and date are replaced. Nothing
+// is saved if any row is invalid.
+func ImportExchangeRates(tx *gorm.DB, r io.Reader) (int, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+
+	var rates []models.ExchangeRate
+	// A later row for the same currency and date replaces an earlier one
+	seen := make(map[string]int)
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, &ValidationError{Message: fmt.Sprintf("Line %d: %v", line, err)}
+		}
+
+		if line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "currency") {
+			continue
+		}
+
+		rate := models.ExchangeRate{Currency: strings.ToUpper(strings.TrimSpace(record[0]))}
+		if rate.EffectiveDate, err = time.Parse("2006-01-02", strings.TrimSpace(record[1])); err != nil {
+			return 0, &ValidationError{Message: fmt.Sprintf("Line %d: effective_date must be a date in YYYY-MM-DD format", line)}
+		}
+		if rate.Rate, err = models.ParseRate(strings.TrimSpace(record[2])); err != nil {
+			return 0, &ValidationError{Message: fmt.Sprintf("Line %d: rate must be a number", line)}
+		}
+		if err := ValidateExchangeRate(&rate); err != nil {
+			return 0, &ValidationError{Message: fmt.Sprintf("Line %d: %v", line, err)}
+		}
+
+		key := rate.Currency + " " + rate.EffectiveDate.Format("2006-01-02")
+		if i, ok := seen[key]; ok {
+			rates[i] = rate
+			continue
+		}
+		seen[key] = len(rates)
+		rates = append(rates, rate)
+	}
+
+	if len(rates) == 0 {
+		return 0, &ValidationError{Message: "The file does not contain any rates"}
+	}
+
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "currency"}, {Name: "effective_date"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rate", "updated_at"}),
+	}).CreateInBatches(&rates, 500).Error
+	if err != nil {
+		return 0, err
+	}
+
+	return len(rates), nil
+}
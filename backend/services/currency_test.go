@@ -0,0 +1,98 @@
+package services
+
+import (
+	"procurement-system/config"
+	"procurement-system/database"
+	"procurement-system/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestExchangeRates imports dated rates and converts amounts with them
+func TestExchangeRates(t *testing.T) {
+	setupTestDB(t)
+
+	// XTD and XTE are test currencies; the second XTD row of January
+	// replaces the first
+	csv := `currency,effective_date,rate
+XTD,2024-01-01,90
+xtd,2024-01-01,100
+XTD,2024-02-01,110
+XTE,2024-01-01,55
+`
+	n, err := ImportExchangeRates(database.DB, strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("import rates: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("imported %d rates, want 3", n)
+	}
+
+	date := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+
+	rates := []struct {
+		currency string
+		on       string
+		want     models.Rate
+	}{
+		{"XTD", "2024-01-01", 100 * models.OneRate},
+		{"XTD", "2024-01-31", 100 * models.OneRate},
+		{"XTD", "2024-02-01", 110 * models.OneRate},
+		{config.AppConfig.BaseCurrency, "1990-01-01", models.OneRate},
+	}
+	for _, r := range rates {
+		got, err := ExchangeRateOn(database.DB, r.currency, date(r.on))
+		if err != nil {
+			t.Errorf("rate of %s on %s: %v", r.currency, r.on, err)
+			continue
+		}
+		if got != r.want {
+			t.Errorf("rate of %s on %s = %s, want %s", r.currency, r.on, got, r.want)
+		}
+	}
+	if _, err := ExchangeRateOn(database.DB, "XTD", date("2023-12-31")); !isRejection(err) {
+		t.Errorf("rate before the first one: err = %v, want a rejection", err)
+	}
+
+	conversions := []struct {
+		amount   models.Money
+		from, to string
+		on       string
+		want     models.Money
+	}{
+		// 10.00 XTD is 1,100.00 in the base currency, which is 20.00 XTE
+		{1000, "XTD", "XTE", "2024-02-15", 2000},
+		{1000, "XTD", config.AppConfig.BaseCurrency, "2024-01-15", 100000},
+		{100, config.AppConfig.BaseCurrency, "XTE", "2024-01-15", 2},
+		{1234, "XTD", "XTD", "1990-01-01", 1234},
+	}
+	for _, c := range conversions {
+		got, err := ConvertMoney(database.DB, c.amount, c.from, c.to, date(c.on))
+		if err != nil {
+			t.Errorf("convert %s %s to %s: %v", c.amount, c.from, c.to, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("convert %s %s to %s = %s, want %s", c.amount, c.from, c.to, got, c.want)
+		}
+	}
+
+	invalid := map[string]string{
+		"empty":         "currency,effective_date,rate\n",
+		"bad currency":  "XT1,2024-01-01,1\n",
+		"base currency": config.AppConfig.BaseCurrency + ",2024-01-01,1\n",
+		"bad date":      "XTD,01/01/2024,1\n",
+		"bad rate":      "XTD,2024-01-01,1e3\n",
+		"zero rate":     "XTD,2024-01-01,0\n",
+		"extra column":  "XTD,2024-01-01,1,2\n",
+	}
+	for name, csv := range invalid {
+		if _, err := ImportExchangeRates(database.DB, strings.NewReader(csv)); !isRejection(err) {
+			t.Errorf("%s: err = %v, want a rejection", name, err)
+		}
+	}
+}
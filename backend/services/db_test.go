@@ -0,0 +1,155 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"procurement-system/config"
+	"procurement-system/database"
+	"procurement-system/models"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDSNVariable names the environment variable holding the DSN of the
+// scratch Postgres database the database tests run against. Tests that need
+// a database are skipped when it is not set. Never point it at production:
+// the tests create their own users, suppliers, items and purchases.
+const testDSNVariable = "TEST_DATABASE_DSN"
+
+var (
+	testDBOnce sync.Once
+	testDBErr  error
+)
+
+// setupTestDB connects database.DB to the test database and migrates it
+// once per test binary, or skips t if no test database is configured
+func setupTestDB(t *testing.T) {
+	t.Helper()
+
+	dsn := os.Getenv(testDSNVariable)
+	if dsn == "" {
+		t.Skipf("%s is not set; skipping database test", testDSNVariable)
+	}
+
+	testDBOnce.Do(func() {
+		config.AppConfig = &config.Config{
+			BaseCurrency:               "IDR",
+			LowStockThreshold:          10,
+			PurchaseNumberFormat:       "PO/{YYYY}/{MM}/{SEQ:4}",
+			PurchaseReturnNumberFormat: "RTN/{YYYY}/{MM}/{SEQ:4}",
+			StockTransferNumberFormat:  "TRF/{YYYY}/{MM}/{SEQ:4}",
+			MatchQtyTolerance:          "0",
+			MatchPriceTolerance:        "0",
+			JWTSecret:                  randomToken(),
+			AccessTokenTTL:             15 * time.Minute,
+			RefreshTokenTTL:            24 * time.Hour,
+			PasswordResetTTL:           time.Hour,
+			LoginMaxFailures:           5,
+			LoginIPMaxFailures:         20,
+			LoginLockoutDuration:       15 * time.Minute,
+			TOTPIssuer:                 "Procurement System",
+		}
+		if testDBErr = LoadTokenKeys(); testDBErr != nil {
+			return
+		}
+
+		database.DB, testDBErr = gorm.Open(postgres.Open(dsn), &gorm.Config{
+			Logger: logger.Default.LogMode(logger.Silent),
+		})
+		if testDBErr != nil {
+			return
+		}
+		database.Migrate()
+	})
+	if testDBErr != nil {
+		t.Fatalf("set up test database: %v", testDBErr)
+	}
+}
+
+// testRun returns a name unique to this run of t, used to keep the records
+// of different tests and runs apart
+func testRun(t *testing.T) string {
+	return fmt.Sprintf("%s-%d", t.Name(), time.Now().UnixNano())
+}
+
+// createTestUser creates a user that owns the records of a test. The
+// password is not a bcrypt hash, so it can never log in.
+func createTestUser(t *testing.T, run string) models.User {
+	t.Helper()
+	user := models.User{Username: run, Password: "!", Role: models.RoleViewer}
+	if err := database.DB.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return user
+}
+
+func createTestSupplier(t *testing.T, run string) models.Supplier {
+	t.Helper()
+	supplier := models.Supplier{Name: run, Currency: config.AppConfig.BaseCurrency}
+	if err := database.DB.Create(&supplier).Error; err != nil {
+		t.Fatalf("create supplier: %v", err)
+	}
+	return supplier
+}
+
+func createTestItem(t *testing.T, name string) models.Item {
+	t.Helper()
+	item := models.Item{Name: name}
+	if err := database.DB.Create(&item).Error; err != nil {
+		t.Fatalf("create item: %v", err)
+	}
+	return item
+}
+
+// createOrderedPurchase creates a purchase of lines (item ID to qty) that is
+// ready to be received
+func createOrderedPurchase(t *testing.T, user models.User, supplier models.Supplier, lines map[uint]int) models.Purchasing {
+	t.Helper()
+	purchase := models.Purchasing{
+		Date:         time.Now(),
+		SupplierID:   supplier.ID,
+		UserID:       user.ID,
+		Currency:     supplier.Currency,
+		ExchangeRate: models.OneRate,
+		Status:       models.PurchaseStatusOrdered,
+	}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		purchase.WarehouseID, err = ResolveWarehouse(tx, 0)
+		if err != nil {
+			return err
+		}
+		purchase.Number, err = NextDocumentNumber(tx, models.DocumentPurchaseOrder, user.Username+"/{SEQ}", purchase.Date)
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(&purchase).Error; err != nil {
+			return err
+		}
+
+		for itemID, qty := range lines {
+			detail := models.PurchasingDetail{PurchasingID: purchase.ID, ItemID: itemID, Qty: qty}
+			if err := tx.Create(&detail).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("create purchase: %v", err)
+	}
+	return purchase
+}
+
+// useConfig makes cfg the application configuration until t ends
+func useConfig(t *testing.T, cfg *config.Config) {
+	t.Helper()
+	previous := config.AppConfig
+	t.Cleanup(func() { config.AppConfig = previous })
+	config.AppConfig = cfg
+}
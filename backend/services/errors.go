@@ -0,0 +1,54 @@
+package services
+
+import "time"
+
+// ValidationError is returned when the caller supplied invalid input.
+// Message is safe to show to API clients.
+type ValidationError struct {
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// ConflictError is returned when an operation is not allowed in the
+// current state of a document. Message is safe to show to API clients.
+type ConflictError struct {
+	Message string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Message
+}
+
+// NotFoundError is returned when a referenced record does not exist.
+// Message is safe to show to API clients.
+type NotFoundError struct {
+	Message string
+}
+
+func (e *NotFoundError) Error() string {
+	return e.Message
+}
+
+// UnauthorizedError is returned when credentials or tokens are missing,
+// invalid or revoked. Message is safe to show to API clients.
+type UnauthorizedError struct {
+	Message string
+}
+
+func (e *UnauthorizedError) Error() string {
+	return e.Message
+}
+
+// TooManyRequestsError is returned when an operation is refused for being
+// attempted too often. RetryAfter is how long the client should wait.
+type TooManyRequestsError struct {
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *TooManyRequestsError) Error() string {
+	return e.Message
+}
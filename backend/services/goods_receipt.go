@@ -0,0 +1,141 @@
+package services
+
+import (
+	"fmt"
+	"procurement-system/models"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ReceiptLine is the quantity delivered for one purchase detail line
+type ReceiptLine struct {
+	PurchasingDetailID uint
+	Qty                int
+}
+
+// OutstandingLines returns a receipt line for every detail of purchase that
+// still has quantity to be delivered
+func OutstandingLines(purchase *models.Purchasing) []ReceiptLine {
+	var lines []ReceiptLine
+	for _, detail := range purchase.PurchasingDetails {
+		if outstanding := detail.Qty - detail.ReceivedQty; outstanding > 0 {
+			lines = append(lines, ReceiptLine{PurchasingDetailID: detail.ID, Qty: outstanding})
+		}
+	}
+	return lines
+}
+
+// ReceivePurchaseGoods locks the purchase identified by purchaseID and
+// records a goods receipt for it. A nil lines slice receives everything that
+// is still outstanding.
+func ReceivePurchaseGoods(tx *gorm.DB, purchaseID uint, lines []ReceiptLine, date time.Time, userID uint, note string) (*models.GoodsReceipt, error) {
+	// Lock the purchase so concurrent deliveries cannot over-receive
+	var purchase models.Purchasing
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("PurchasingDetails").First(&purchase, purchaseID).Error; err != nil {
+		return nil, &NotFoundError{Message: "Purchase not found"}
+	}
+
+	if lines == nil {
+		lines = OutstandingLines(&purchase)
+	}
+
+	return ReceiveGoods(tx, &purchase, lines, date, userID, note)
+}
+
+// ReceiveGoods records a (possibly partial) delivery against an ordered
+// purchase and adds the received quantities to stock. When every line has
+// been delivered in full the purchase moves to received. The purchase must
+// have been loaded with its details and locked inside tx.
+func ReceiveGoods(tx *gorm.DB, purchase *models.Purchasing, lines []ReceiptLine, date time.Time, userID uint, note string) (*models.GoodsReceipt, error) {
+	if purchase.Status != models.PurchaseStatusOrdered {
+		return nil, &ConflictError{Message: fmt.Sprintf("Goods can only be received for ordered purchases (current status: '%s')", purchase.Status)}
+	}
+
+	if len(lines) == 0 {
+		return nil, &ValidationError{Message: "At least one item is required"}
+	}
+
+	details := make(map[uint]*models.PurchasingDetail, len(purchase.PurchasingDetails))
+	for i := range purchase.PurchasingDetails {
+		details[purchase.PurchasingDetails[i].ID] = &purchase.PurchasingDetails[i]
+	}
+
+	// Touch items in a consistent order so that concurrent receipts lock
+	// item rows in the same sequence and cannot deadlock each other
+	lines = append([]ReceiptLine(nil), lines...)
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lineItemID(details, lines[i]) < lineItemID(details, lines[j])
+	})
+
+	receipt := models.GoodsReceipt{
+		PurchasingID: purchase.ID,
+		Date:         date,
+		UserID:       userID,
+		Note:         note,
+	}
+	if err := tx.Create(&receipt).Error; err != nil {
+		return nil, err
+	}
+
+	for _, line := range lines {
+		detail, ok := details[line.PurchasingDetailID]
+		if !ok {
+			return nil, &ValidationError{Message: fmt.Sprintf("Purchase detail %d does not belong to this purchase", line.PurchasingDetailID)}
+		}
+
+		if line.Qty <= 0 {
+			return nil, &ValidationError{Message: "Received qty must be positive"}
+		}
+
+		if outstanding := detail.Qty - detail.ReceivedQty; line.Qty > outstanding {
+			return nil, &ValidationError{Message: fmt.Sprintf("Received qty for purchase detail %d exceeds outstanding qty. Outstanding: %d, Received: %d", detail.ID, outstanding, line.Qty)}
+		}
+
+		receiptDetail := models.GoodsReceiptDetail{
+			GoodsReceiptID:     receipt.ID,
+			PurchasingDetailID: detail.ID,
+			ItemID:             detail.ItemID,
+			Qty:                line.Qty,
+		}
+		if err := tx.Create(&receiptDetail).Error; err != nil {
+			return nil, err
+		}
+
+		detail.ReceivedQty += line.Qty
+		if err := tx.Model(detail).Update("received_qty", detail.ReceivedQty).Error; err != nil {
+			return nil, err
+		}
+
+		_, err := MoveStock(tx, StockChange{
+			ItemID:        detail.ItemID,
+			WarehouseID:   purchase.WarehouseID,
+			Type:          models.MovementReceipt,
+			Qty:           line.Qty,
+			ReferenceType: models.MovementRefGoodsReceipt,
+			ReferenceID:   receipt.ID,
+			UserID:        userID,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(OutstandingLines(purchase)) == 0 {
+		if err := TransitionPurchase(tx, purchase, models.PurchaseStatusReceived, userID, "All items received"); err != nil {
+			return nil, err
+		}
+	}
+
+	return &receipt, nil
+}
+
+// lineItemID returns the item received by line, or zero for unknown lines
+func lineItemID(details map[uint]*models.PurchasingDetail, line ReceiptLine) uint {
+	if detail, ok := details[line.PurchasingDetailID]; ok {
+		return detail.ItemID
+	}
+	return 0
+}
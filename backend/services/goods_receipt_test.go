@@ -0,0 +1,73 @@
+package services
+
+import (
+	"procurement-system/database"
+	"procurement-system/models"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// receiveTestGoods records a receipt of lines for the purchase identified by
+// purchaseID the way the receive endpoint does
+func receiveTestGoods(purchaseID, userID uint, lines []ReceiptLine) error {
+	return database.Transaction(func(tx *gorm.DB) error {
+		_, err := ReceivePurchaseGoods(tx, purchaseID, lines, time.Now(), userID, "")
+		return err
+	})
+}
+
+// TestReceiveMoreThanOutstanding checks that a line can be received in
+// parts but never beyond its ordered qty, and that refused receipts leave
+// stock and received quantities alone
+func TestReceiveMoreThanOutstanding(t *testing.T) {
+	setupTestDB(t)
+	run := testRun(t)
+	user := createTestUser(t, run)
+	supplier := createTestSupplier(t, run)
+	item := createTestItem(t, run)
+	purchase := createOrderedPurchase(t, user, supplier, map[uint]int{item.ID: 5})
+
+	var detail models.PurchasingDetail
+	database.DB.Where("purchasing_id = ?", purchase.ID).First(&detail)
+
+	if err := receiveTestGoods(purchase.ID, user.ID, []ReceiptLine{{PurchasingDetailID: detail.ID, Qty: 3}}); err != nil {
+		t.Fatalf("receive part of the line: %v", err)
+	}
+
+	rejected := map[string][]ReceiptLine{
+		"more than outstanding":    {{PurchasingDetailID: detail.ID, Qty: 3}},
+		"more than ordered":        {{PurchasingDetailID: detail.ID, Qty: 6}},
+		"outstanding split in two": {{PurchasingDetailID: detail.ID, Qty: 2}, {PurchasingDetailID: detail.ID, Qty: 1}},
+		"zero qty":                 {{PurchasingDetailID: detail.ID, Qty: 0}},
+		"line of another purchase": {{PurchasingDetailID: detail.ID + 1000000, Qty: 1}},
+		"no lines":                 {},
+	}
+	for name, lines := range rejected {
+		if err := receiveTestGoods(purchase.ID, user.ID, lines); !isRejection(err) {
+			t.Errorf("%s: err = %v, want a rejection", name, err)
+		}
+	}
+
+	database.DB.First(&detail, detail.ID)
+	if detail.ReceivedQty != 3 {
+		t.Errorf("received_qty after refused receipts = %d, want 3", detail.ReceivedQty)
+	}
+	checkStock(t, item.ID, 3)
+
+	if err := receiveTestGoods(purchase.ID, user.ID, []ReceiptLine{{PurchasingDetailID: detail.ID, Qty: 2}}); err != nil {
+		t.Fatalf("receive the rest: %v", err)
+	}
+	database.DB.First(&purchase, purchase.ID)
+	if purchase.Status != models.PurchaseStatusReceived {
+		t.Errorf("purchase status = %s, want %s", purchase.Status, models.PurchaseStatusReceived)
+	}
+	checkStock(t, item.ID, 5)
+
+	// A received purchase takes no further deliveries
+	if err := receiveTestGoods(purchase.ID, user.ID, []ReceiptLine{{PurchasingDetailID: detail.ID, Qty: 1}}); !isRejection(err) {
+		t.Errorf("receive against a received purchase: err = %v, want a rejection", err)
+	}
+	checkStock(t, item.ID, 5)
+}
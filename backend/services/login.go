@@ -0,0 +1,218 @@
+package services
+
+import (
+	"errors"
+	"procurement-system/config"
+	"procurement-system/models"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxLoginDelay caps the wait between failed logins of an account
+const maxLoginDelay = time.Minute
+
+// failedLoginOutcomes are the outcomes of attempts with wrong credentials
+var failedLoginOutcomes = []string{models.LoginInvalidPassword, models.LoginUnknownUser, models.LoginInvalidOTP}
+
+// dummyPasswordHash is compared against when the username is unknown, so
+// that unknown and known usernames take as long to reject
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// Authenticate checks a username and password and records the attempt in
+// the login history. Logins are refused with a TooManyRequestsError while
+// the account is locked, while it has to wait after a failed login, or once
+// the client's IP address has failed too often; wrong credentials yield an
+// UnauthorizedError. The attempt is recorded in tx on those errors too, so
+// callers must commit tx rather than roll it back. For users with
+// two-factor authentication the login is only complete once a code is
+// given to CompleteLoginChallenge.
+func Authenticate(tx *gorm.DB, username, password string, client ClientInfo) (*models.User, error) {
+	now := time.Now()
+	attempt := models.LoginAttempt{
+		Username:  truncate(username, 100),
+		IP:        truncate(client.IP, 64),
+		UserAgent: truncate(client.UserAgent, 255),
+	}
+
+	if err := checkLoginIP(tx, &attempt, now); err != nil {
+		return nil, err
+	}
+
+	// Lock the account so that concurrent attempts are counted one by one
+	var user models.User
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("username = ?", username).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		attempt.Outcome = models.LoginUnknownUser
+		return nil, refuseLogin(tx, &attempt, &UnauthorizedError{Message: "Invalid username or password"})
+	}
+	if err != nil {
+		return nil, err
+	}
+	attempt.UserID = &user.ID
+
+	if err := checkLoginAccount(tx, &user, &attempt, now); err != nil {
+		return nil, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return nil, failLogin(tx, &user, &attempt, models.LoginInvalidPassword, now, &UnauthorizedError{Message: "Invalid username or password"})
+	}
+
+	if user.TOTPEnabled {
+		// Failed logins are only forgotten once the code is right too, so
+		// that knowing the password does not allow guessing codes forever
+		attempt.Outcome = models.LoginOTPRequired
+		if err := tx.Create(&attempt).Error; err != nil {
+			return nil, err
+		}
+		return &user, nil
+	}
+
+	if err := succeedLogin(tx, &user, &attempt); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// checkLoginIP refuses the attempt if its IP address has failed too often
+func checkLoginIP(tx *gorm.DB, attempt *models.LoginAttempt, now time.Time) error {
+	retryAfter, err := ipRetryAfter(tx, attempt.IP, now)
+	if err != nil {
+		return err
+	}
+	if retryAfter > 0 {
+		attempt.Outcome = models.LoginIPBlocked
+		return refuseLogin(tx, attempt, &TooManyRequestsError{
+			Message:    "Too many failed logins from this address, try again later",
+			RetryAfter: retryAfter,
+		})
+	}
+	return nil
+}
+
+// checkLoginAccount refuses the attempt while the account is locked or has
+// to wait after a failed login
+func checkLoginAccount(tx *gorm.DB, user *models.User, attempt *models.LoginAttempt, now time.Time) error {
+	if user.LockedUntil != nil && user.LockedUntil.After(now) {
+		attempt.Outcome = models.LoginAccountLocked
+		return refuseLogin(tx, attempt, &TooManyRequestsError{
+			Message:    "Account is temporarily locked after too many failed logins",
+			RetryAfter: user.LockedUntil.Sub(now),
+		})
+	}
+
+	if user.FailedLogins == 0 {
+		return nil
+	}
+	var last models.LoginAttempt
+	err := tx.Where("user_id = ? AND outcome IN ?", user.ID, failedLoginOutcomes).
+		Order("created_at DESC").
+		Limit(1).
+		Find(&last).Error
+	if err != nil {
+		return err
+	}
+	if wait := last.CreatedAt.Add(loginDelay(user.FailedLogins)).Sub(now); last.ID != 0 && wait > 0 {
+		attempt.Outcome = models.LoginThrottled
+		return refuseLogin(tx, attempt, &TooManyRequestsError{
+			Message:    "Too many failed logins, try again shortly",
+			RetryAfter: wait,
+		})
+	}
+	return nil
+}
+
+// failLogin counts a failed login against the account, locking it once the
+// failures reach LOGIN_MAX_FAILURES, and records the attempt
+func failLogin(tx *gorm.DB, user *models.User, attempt *models.LoginAttempt, outcome string, now time.Time, refusal error) error {
+	updates := map[string]interface{}{"failed_logins": user.FailedLogins + 1}
+	if user.FailedLogins+1 >= config.AppConfig.LoginMaxFailures {
+		// Start counting afresh once the lockout is over
+		updates["failed_logins"] = 0
+		updates["locked_until"] = now.Add(config.AppConfig.LoginLockoutDuration)
+	}
+	if err := tx.Model(user).Updates(updates).Error; err != nil {
+		return err
+	}
+
+	attempt.Outcome = outcome
+	return refuseLogin(tx, attempt, refusal)
+}
+
+// succeedLogin forgets the failed logins of the account and records the
+// attempt
+func succeedLogin(tx *gorm.DB, user *models.User, attempt *models.LoginAttempt) error {
+	if user.FailedLogins > 0 || user.LockedUntil != nil {
+		err := tx.Model(user).Updates(map[string]interface{}{"failed_logins": 0, "locked_until": nil}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	attempt.Success = true
+	attempt.Outcome = models.LoginSucceeded
+	return tx.Create(attempt).Error
+}
+
+// UnlockUser lifts the lockout of an account and forgets its failed logins
+func UnlockUser(tx *gorm.DB, user *models.User) error {
+	err := tx.Model(user).Updates(map[string]interface{}{"failed_logins": 0, "locked_until": nil}).Error
+	if err != nil {
+		return err
+	}
+	user.FailedLogins = 0
+	user.LockedUntil = nil
+	return nil
+}
+
+// ipRetryAfter returns how long an IP address must wait before it may try
+// to log in again; zero unless it has failed too often within the lockout
+// duration
+func ipRetryAfter(tx *gorm.DB, ip string, now time.Time) (time.Duration, error) {
+	window := config.AppConfig.LoginLockoutDuration
+
+	// Only wrong credentials count, so that refused attempts do not extend
+	// the block indefinitely
+	var failures []time.Time
+	err := tx.Model(&models.LoginAttempt{}).
+		Where("ip = ? AND created_at > ?", ip, now.Add(-window)).
+		Where("outcome IN ?", failedLoginOutcomes).
+		Order("created_at DESC").
+		Limit(config.AppConfig.LoginIPMaxFailures).
+		Pluck("created_at", &failures).Error
+	if err != nil {
+		return 0, err
+	}
+	if len(failures) < config.AppConfig.LoginIPMaxFailures {
+		return 0, nil
+	}
+
+	// Blocked until the oldest of the latest failures leaves the window
+	return failures[len(failures)-1].Add(window).Sub(now), nil
+}
+
+// loginDelay is how long an account waits after its failures-th consecutive
+// failed login: LOGIN_DELAY, doubled with every further failure
+func loginDelay(failures int) time.Duration {
+	delay := config.AppConfig.LoginDelay
+	for i := 1; i < failures && delay < maxLoginDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxLoginDelay {
+		delay = maxLoginDelay
+	}
+	return delay
+}
+
+// refuseLogin records a refused attempt and returns the error to refuse it
+// with
+func refuseLogin(tx *gorm.DB, attempt *models.LoginAttempt, refusal error) error {
+	if err := tx.Create(attempt).Error; err != nil {
+		return err
+	}
+	return refusal
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"procurement-system/config"
+	"procurement-system/database"
+	"procurement-system/models"
+	"testing"
+	"time"
+)
+
+// loginTestPassword is the password of users created by createLoginUser
+const loginTestPassword = "correct horse battery staple"
+
+// createLoginUser creates a user that can log in with loginTestPassword
+func createLoginUser(t *testing.T, run string) models.User {
+	t.Helper()
+	user := createTestUser(t, run)
+	hash, err := HashPassword(loginTestPassword)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	if err := database.DB.Model(&user).Update("password", hash).Error; err != nil {
+		t.Fatalf("set password: %v", err)
+	}
+	return user
+}
+
+// useLoginLimits sets the login limits for the rest of t
+func useLoginLimits(t *testing.T, maxFailures, ipMaxFailures int, delay time.Duration) {
+	t.Helper()
+	cfg := *config.AppConfig
+	cfg.LoginMaxFailures = maxFailures
+	cfg.LoginIPMaxFailures = ipMaxFailures
+	cfg.LoginLockoutDuration = time.Hour
+	cfg.LoginDelay = delay
+	useConfig(t, &cfg)
+}
+
+// testClient returns a client at an address no other test run uses
+func testClient() ClientInfo {
+	n := time.Now().UnixNano()
+	return ClientInfo{IP: fmt.Sprintf("2001:db8::%x:%x", n>>16&0xffff, n&0xffff), UserAgent: "test"}
+}
+
+// isTooManyRequests reports whether err refuses an attempt for now
+func isTooManyRequests(err error) bool {
+	var tooManyErr *TooManyRequestsError
+	return errors.As(err, &tooManyErr) && tooManyErr.RetryAfter > 0
+}
+
+func TestLoginDelay(t *testing.T) {
+	useConfig(t, &config.Config{LoginDelay: time.Second})
+
+	delays := map[int]time.Duration{
+		1:   time.Second,
+		2:   2 * time.Second,
+		4:   8 * time.Second,
+		7:   maxLoginDelay,
+		100: maxLoginDelay,
+	}
+	for failures, want := range delays {
+		if got := loginDelay(failures); got != want {
+			t.Errorf("loginDelay(%d) = %s, want %s", failures, got, want)
+		}
+	}
+}
+
+// TestAuthenticateLockout checks that an account is locked after too many
+// wrong passwords, even against the right one, until it is unlocked
+func TestAuthenticateLockout(t *testing.T) {
+	setupTestDB(t)
+	useLoginLimits(t, 3, 100, 0)
+	user := createLoginUser(t, testRun(t))
+	client := testClient()
+
+	for i := 0; i < 3; i++ {
+		if _, err := Authenticate(database.DB, user.Username, "wrong", client); !isUnauthorized(err) {
+			t.Fatalf("wrong password %d: err = %v, want a refusal", i+1, err)
+		}
+	}
+	if _, err := Authenticate(database.DB, user.Username, loginTestPassword, client); !isTooManyRequests(err) {
+		t.Errorf("right password on a locked account: err = %v, want too many requests", err)
+	}
+
+	var outcomes []string
+	database.DB.Model(&models.LoginAttempt{}).Where("user_id = ?", user.ID).Order("id").Pluck("outcome", &outcomes)
+	want := []string{models.LoginInvalidPassword, models.LoginInvalidPassword, models.LoginInvalidPassword, models.LoginAccountLocked}
+	if fmt.Sprint(outcomes) != fmt.Sprint(want) {
+		t.Errorf("login history = %v, want %v", outcomes, want)
+	}
+
+	database.DB.First(&user, user.ID)
+	if err := UnlockUser(database.DB, &user); err != nil {
+		t.Fatalf("unlock user: %v", err)
+	}
+	if _, err := Authenticate(database.DB, user.Username, loginTestPassword, client); err != nil {
+		t.Errorf("login after unlocking: %v", err)
+	}
+}
+
+// TestAuthenticateThrottle checks that an account waits after a failed
+// login before it may try again
+func TestAuthenticateThrottle(t *testing.T) {
+	setupTestDB(t)
+	useLoginLimits(t, 100, 100, time.Hour)
+	user := createLoginUser(t, testRun(t))
+	client := testClient()
+
+	if _, err := Authenticate(database.DB, user.Username, loginTestPassword, client); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if _, err := Authenticate(database.DB, user.Username, "wrong", client); !isUnauthorized(err) {
+		t.Fatalf("wrong password: err = %v, want a refusal", err)
+	}
+	if _, err := Authenticate(database.DB, user.Username, loginTestPassword, client); !isTooManyRequests(err) {
+		t.Errorf("login right after a failure: err = %v, want too many requests", err)
+	}
+}
+
+// TestAuthenticateIPBlock checks that an address guessing usernames is
+// refused while other addresses may still log in
+func TestAuthenticateIPBlock(t *testing.T) {
+	setupTestDB(t)
+	useLoginLimits(t, 100, 3, 0)
+	run := testRun(t)
+	user := createLoginUser(t, run)
+	attacker := testClient()
+
+	for i := 0; i < 3; i++ {
+		if _, err := Authenticate(database.DB, fmt.Sprintf("%s-unknown-%d", run, i), "guess", attacker); !isUnauthorized(err) {
+			t.Fatalf("unknown user %d: err = %v, want a refusal", i+1, err)
+		}
+	}
+	if _, err := Authenticate(database.DB, user.Username, loginTestPassword, attacker); !isTooManyRequests(err) {
+		t.Errorf("login from a blocked address: err = %v, want too many requests", err)
+	}
+
+	if _, err := Authenticate(database.DB, user.Username, loginTestPassword, testClient()); err != nil {
+		t.Errorf("login from another address: %v", err)
+	}
+}
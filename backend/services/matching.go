@@ -0,0 +1,271 @@
+package services
+
+import (
+	"fmt"
+	"math/big"
+	"procurement-system/config"
+	"procurement-system/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ParseTolerance parses a matching tolerance, a percentage between 0 and 100
+func ParseTolerance(s string) (models.Rate, error) {
+	tolerance, err := models.ParseRate(s)
+	if err != nil {
+		return 0, err
+	}
+	if tolerance < 0 || tolerance.Rat().Cmp(hundred) > 0 {
+		return 0, fmt.Errorf("tolerance %q must be a percentage between 0 and 100", s)
+	}
+	return tolerance, nil
+}
+
+// MatchSupplierInvoice locks the invoice identified by invoiceID and
+// matches it three ways: the invoiced quantity of every line of its
+// purchases against what was received and not returned, the invoiced unit
+// prices against the order's, and the invoice amount against the order
+// value of the received goods. Differences beyond MATCH_QTY_TOLERANCE and
+// MATCH_PRICE_TOLERANCE replace the invoice's variances. A variance that
+// was resolved before stays resolved as long as it is unchanged.
+func MatchSupplierInvoice(tx *gorm.DB, invoiceID uint) (*models.SupplierInvoice, error) {
+	qtyTolerance, err := ParseTolerance(config.AppConfig.MatchQtyTolerance)
+	if err != nil {
+		return nil, err
+	}
+	priceTolerance, err := ParseTolerance(config.AppConfig.MatchPriceTolerance)
+	if err != nil {
+		return nil, err
+	}
+
+	var invoice models.SupplierInvoice
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Preload("Lines").
+		Preload("Variances").
+		Preload("Purchases", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Preload("Purchases.PurchasingDetails", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		First(&invoice, invoiceID).Error
+	if err != nil {
+		return nil, &NotFoundError{Message: "Invoice not found"}
+	}
+
+	if invoice.ApprovedAt != nil {
+		return nil, &ConflictError{Message: fmt.Sprintf("Invoice %s is already approved", invoice.Number)}
+	}
+
+	invoicedQty := make(map[uint]int, len(invoice.Lines))
+	for _, line := range invoice.Lines {
+		invoicedQty[line.PurchasingDetailID] += line.Qty
+	}
+
+	var variances []models.MatchVariance
+	details := make(map[uint]*models.PurchasingDetail)
+	var expected models.Money
+
+	for i := range invoice.Purchases {
+		for j := range invoice.Purchases[i].PurchasingDetails {
+			detail := &invoice.Purchases[i].PurchasingDetails[j]
+			details[detail.ID] = detail
+
+			received := detail.ReceivedQty - detail.ReturnedQty
+			value, err := lineShare(detail, received)
+			if err != nil {
+				return nil, err
+			}
+			if expected, err = expected.Add(value); err != nil {
+				return nil, err
+			}
+
+			invoiced := invoicedQty[detail.ID]
+			if !withinTolerance(big.NewRat(int64(received), 1), big.NewRat(int64(invoiced), 1), qtyTolerance) {
+				variances = append(variances, models.MatchVariance{
+					Type:               models.VarianceQuantity,
+					PurchasingDetailID: &detail.ID,
+					ItemID:             &detail.ItemID,
+					OrderedQty:         detail.Qty,
+					ReceivedQty:        received,
+					InvoicedQty:        invoiced,
+				})
+			}
+		}
+	}
+
+	for _, line := range invoice.Lines {
+		detail, ok := details[line.PurchasingDetailID]
+		if !ok {
+			continue
+		}
+		if !withinTolerance(detail.UnitPrice.Rat(), line.UnitPrice.Rat(), priceTolerance) {
+			variances = append(variances, models.MatchVariance{
+				Type:               models.VariancePrice,
+				PurchasingDetailID: &detail.ID,
+				ItemID:             &detail.ItemID,
+				OrderedQty:         detail.Qty,
+				ReceivedQty:        detail.ReceivedQty - detail.ReturnedQty,
+				InvoicedQty:        line.Qty,
+				Expected:           detail.UnitPrice,
+				Invoiced:           line.UnitPrice,
+			})
+		}
+	}
+
+	if !withinTolerance(expected.Rat(), invoice.Amount.Rat(), priceTolerance) {
+		variances = append(variances, models.MatchVariance{
+			Type:     models.VarianceAmount,
+			Expected: expected,
+			Invoiced: invoice.Amount,
+		})
+	}
+
+	// Keep earlier resolutions of variances that have not changed
+	unresolved := 0
+	for i := range variances {
+		variances[i].SupplierInvoiceID = invoice.ID
+		for _, old := range invoice.Variances {
+			if old.ResolvedAt != nil && sameVariance(old, variances[i]) {
+				variances[i].ResolvedByID = old.ResolvedByID
+				variances[i].ResolvedAt = old.ResolvedAt
+				variances[i].ResolutionNote = old.ResolutionNote
+				break
+			}
+		}
+		if variances[i].ResolvedAt == nil {
+			unresolved++
+		}
+	}
+
+	if err := tx.Where("supplier_invoice_id = ?", invoice.ID).Delete(&models.MatchVariance{}).Error; err != nil {
+		return nil, err
+	}
+	if len(variances) > 0 {
+		if err := tx.Create(&variances).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	invoice.MatchStatus = models.MatchStatusMatched
+	if unresolved > 0 {
+		invoice.MatchStatus = models.MatchStatusVariance
+	}
+	invoice.MatchedAt = &now
+	invoice.Variances = variances
+
+	err = tx.Model(&invoice).Updates(map[string]interface{}{
+		"match_status": invoice.MatchStatus,
+		"matched_at":   now,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &invoice, nil
+}
+
+// ResolveMatchVariance accepts a variance of an unapproved invoice with an
+// explanatory note. The invoice is matched once no variance is left open.
+func ResolveMatchVariance(tx *gorm.DB, invoiceID, varianceID, userID uint, note string) (*models.SupplierInvoice, error) {
+	if note == "" {
+		return nil, &ValidationError{Message: "A note explaining the resolution is required"}
+	}
+
+	var invoice models.SupplierInvoice
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&invoice, invoiceID).Error; err != nil {
+		return nil, &NotFoundError{Message: "Invoice not found"}
+	}
+	if invoice.ApprovedAt != nil {
+		return nil, &ConflictError{Message: fmt.Sprintf("Invoice %s is already approved", invoice.Number)}
+	}
+
+	var variance models.MatchVariance
+	if err := tx.Where("supplier_invoice_id = ?", invoice.ID).First(&variance, varianceID).Error; err != nil {
+		return nil, &NotFoundError{Message: "Variance not found"}
+	}
+	if variance.ResolvedAt != nil {
+		return nil, &ConflictError{Message: "Variance is already resolved"}
+	}
+
+	now := time.Now()
+	err := tx.Model(&variance).Updates(map[string]interface{}{
+		"resolved_by_id":  userID,
+		"resolved_at":     now,
+		"resolution_note": note,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var open int64
+	if err := tx.Model(&models.MatchVariance{}).Where("supplier_invoice_id = ? AND resolved_at IS NULL", invoice.ID).Count(&open).Error; err != nil {
+		return nil, err
+	}
+	if open == 0 && invoice.MatchStatus == models.MatchStatusVariance {
+		invoice.MatchStatus = models.MatchStatusMatched
+		if err := tx.Model(&invoice).Update("match_status", invoice.MatchStatus).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return &invoice, nil
+}
+
+// ApproveSupplierInvoice matches the invoice identified by invoiceID again,
+// so that receipts and returns since the last match are taken into account,
+// and approves it for payment if no variance is left open
+func ApproveSupplierInvoice(tx *gorm.DB, invoiceID, userID uint) (*models.SupplierInvoice, error) {
+	invoice, err := MatchSupplierInvoice(tx, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if invoice.MatchStatus != models.MatchStatusMatched {
+		open := 0
+		for _, variance := range invoice.Variances {
+			if variance.ResolvedAt == nil {
+				open++
+			}
+		}
+		return nil, &ConflictError{Message: fmt.Sprintf("Invoice %s has %d unresolved variances and cannot be approved", invoice.Number, open)}
+	}
+
+	now := time.Now()
+	err = tx.Model(invoice).Updates(map[string]interface{}{
+		"approved_by_id": userID,
+		"approved_at":    now,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	invoice.ApprovedByID = &userID
+	invoice.ApprovedAt = &now
+
+	return invoice, nil
+}
+
+// withinTolerance reports whether actual differs from expected by at most
+// tolerance percent of expected
+func withinTolerance(expected, actual *big.Rat, tolerance models.Rate) bool {
+	diff := new(big.Rat).Sub(actual, expected)
+	diff.Abs(diff)
+
+	limit := new(big.Rat).Abs(expected)
+	limit.Mul(limit, tolerance.Rat())
+	limit.Quo(limit, hundred)
+
+	return diff.Cmp(limit) <= 0
+}
+
+// sameVariance reports whether a and b report the same difference
+func sameVariance(a, b models.MatchVariance) bool {
+	sameDetail := (a.PurchasingDetailID == nil && b.PurchasingDetailID == nil) ||
+		(a.PurchasingDetailID != nil && b.PurchasingDetailID != nil && *a.PurchasingDetailID == *b.PurchasingDetailID)
+
+	return sameDetail &&
+		a.Type == b.Type &&
+		a.ReceivedQty == b.ReceivedQty &&
+		a.InvoicedQty == b.InvoicedQty &&
+		a.Expected == b.Expected &&
+		a.Invoiced == b.Invoiced
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"math/big"
+	"procurement-system/database"
+	"procurement-system/models"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestParseTolerance(t *testing.T) {
+	valid := map[string]models.Rate{
+		"0":   0,
+		"2.5": 2*models.OneRate + models.OneRate/2,
+		"100": 100 * models.OneRate,
+	}
+	for s, want := range valid {
+		got, err := ParseTolerance(s)
+		if err != nil || got != want {
+			t.Errorf("ParseTolerance(%q) = %s, %v; want %s", s, got, err, want)
+		}
+	}
+
+	for _, s := range []string{"", "-1", "100.01", "5%", "1e1"} {
+		if _, err := ParseTolerance(s); err == nil {
+			t.Errorf("ParseTolerance(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestWithinTolerance(t *testing.T) {
+	tests := []struct {
+		expected, actual int64
+		tolerance        models.Rate
+		want             bool
+	}{
+		{100, 100, 0, true},
+		{100, 101, 0, false},
+		{100, 105, 5 * models.OneRate, true},
+		{100, 95, 5 * models.OneRate, true},
+		{100, 106, 5 * models.OneRate, false},
+		{0, 1, 100 * models.OneRate, false},
+	}
+	for _, tt := range tests {
+		got := withinTolerance(big.NewRat(tt.expected, 1), big.NewRat(tt.actual, 1), tt.tolerance)
+		if got != tt.want {
+			t.Errorf("withinTolerance(%d, %d, %s) = %v, want %v", tt.expected, tt.actual, tt.tolerance, got, tt.want)
+		}
+	}
+}
+
+// TestMatchSupplierInvoice checks that an invoice billing more than was
+// ordered cannot be approved until its variances are resolved
+func TestMatchSupplierInvoice(t *testing.T) {
+	setupTestDB(t)
+	run := testRun(t)
+	user := createTestUser(t, run)
+	supplier := createTestSupplier(t, run)
+	item := createTestItem(t, run)
+	purchase, detail := createReceivedPurchase(t, user, supplier, item, 2, 500)
+
+	// 2 units at 5.50 instead of 5.00
+	invoice := models.SupplierInvoice{Number: run, SupplierID: supplier.ID, UserID: user.ID}
+	lines := []InvoiceLine{{PurchasingDetailID: detail.ID, Qty: 2, UnitPrice: 550}}
+	if err := createTestInvoice(&invoice, []uint{purchase.ID}, lines); err != nil {
+		t.Fatalf("create invoice: %v", err)
+	}
+
+	approve := func() error {
+		return database.DB.Transaction(func(tx *gorm.DB) error {
+			_, err := ApproveSupplierInvoice(tx, invoice.ID, user.ID)
+			return err
+		})
+	}
+
+	var variances []models.MatchVariance
+	database.DB.Where("supplier_invoice_id = ?", invoice.ID).Order("id").Find(&variances)
+	if invoice.MatchStatus != models.MatchStatusVariance || len(variances) != 2 {
+		t.Fatalf("match status %q with %d variances, want %q with a price and an amount variance", invoice.MatchStatus, len(variances), models.MatchStatusVariance)
+	}
+	for _, v := range variances {
+		switch v.Type {
+		case models.VariancePrice:
+			if v.Expected != 500 || v.Invoiced != 550 {
+				t.Errorf("price variance expected %s invoiced %s, want 5.00 and 5.50", v.Expected, v.Invoiced)
+			}
+		case models.VarianceAmount:
+			if v.Expected != 1000 || v.Invoiced != 1100 {
+				t.Errorf("amount variance expected %s invoiced %s, want 10.00 and 11.00", v.Expected, v.Invoiced)
+			}
+		default:
+			t.Errorf("unexpected %s variance", v.Type)
+		}
+	}
+
+	if err := approve(); !isRejection(err) {
+		t.Errorf("approve with open variances: err = %v, want a rejection", err)
+	}
+
+	resolve := func(varianceID uint, note string) (*models.SupplierInvoice, error) {
+		var resolved *models.SupplierInvoice
+		err := database.DB.Transaction(func(tx *gorm.DB) error {
+			var err error
+			resolved, err = ResolveMatchVariance(tx, invoice.ID, varianceID, user.ID, note)
+			return err
+		})
+		return resolved, err
+	}
+
+	if _, err := resolve(variances[0].ID, ""); !isRejection(err) {
+		t.Errorf("resolve without a note: err = %v, want a rejection", err)
+	}
+	for _, v := range variances {
+		resolved, err := resolve(v.ID, "Price increase agreed by phone")
+		if err != nil {
+			t.Fatalf("resolve %s variance: %v", v.Type, err)
+		}
+		invoice.MatchStatus = resolved.MatchStatus
+	}
+	if invoice.MatchStatus != models.MatchStatusMatched {
+		t.Errorf("match status after resolving = %q, want %q", invoice.MatchStatus, models.MatchStatusMatched)
+	}
+	if _, err := resolve(variances[0].ID, "Again"); !isRejection(err) {
+		t.Errorf("resolve twice: err = %v, want a rejection", err)
+	}
+
+	// Approval matches again; the unchanged variances stay resolved
+	if err := approve(); err != nil {
+		t.Fatalf("approve invoice: %v", err)
+	}
+	if err := approve(); !isRejection(err) {
+		t.Errorf("approve twice: err = %v, want a rejection", err)
+	}
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"procurement-system/models"
+	"regexp"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// numberToken matches the placeholders of a document number format
+var numberToken = regexp.MustCompile(`\{(YYYY|YY|MM|DD|SEQ(?::(\d+))?)\}`)
+
+// maxSequenceWidth is the widest zero padding {SEQ:n} accepts
+const maxSequenceWidth = 12
+
+// ValidateNumberFormat checks a document number format. A format is literal
+// text with the placeholders {YYYY}, {YY}, {MM} and {DD}, taken from the
+// document date, and exactly one {SEQ} or {SEQ:n}, the sequence number
+// zero-padded to n digits.
+func ValidateNumberFormat(format string) error {
+	sequences := 0
+	for _, m := range numberToken.FindAllStringSubmatch(format, -1) {
+		if m[1] == "YYYY" || m[1] == "YY" || m[1] == "MM" || m[1] == "DD" {
+			continue
+		}
+		sequences++
+		if m[2] != "" {
+			if width, _ := strconv.Atoi(m[2]); width < 1 || width > maxSequenceWidth {
+				return fmt.Errorf("sequence width in %q must be between 1 and %d", format, maxSequenceWidth)
+			}
+		}
+	}
+	if sequences != 1 {
+		return fmt.Errorf("number format %q must contain exactly one {SEQ} or {SEQ:n}", format)
+	}
+	return nil
+}
+
+// NextDocumentNumber issues the next number of docType for a document dated
+// date. Sequences restart whenever the date placeholders of format change,
+// e.g. every month for PO/{YYYY}/{MM}/{SEQ:4}.
+//
+// The sequence row stays locked until tx ends and the increment is rolled
+// back with it, so concurrent documents wait for each other instead of
+// skipping or sharing numbers.
+func NextDocumentNumber(tx *gorm.DB, docType, format string, date time.Time) (string, error) {
+	if err := ValidateNumberFormat(format); err != nil {
+		return "", err
+	}
+
+	scope := renderNumber(format, date, -1)
+
+	var last int
+	err := tx.Raw(`INSERT INTO document_sequences (document_type, scope, last_number, created_at, updated_at)
+		VALUES (?, ?, 1, NOW(), NOW())
+		ON CONFLICT (document_type, scope) DO UPDATE
+		SET last_number = document_sequences.last_number + 1, updated_at = NOW()
+		RETURNING last_number`, docType, scope).Scan(&last).Error
+	if err != nil {
+		return "", err
+	}
+
+	return renderNumber(format, date, last), nil
+}
+
+// renderNumber fills in the placeholders of format. A negative seq leaves
+// the sequence placeholder as {SEQ}.
+func renderNumber(format string, date time.Time, seq int) string {
+	return numberToken.ReplaceAllStringFunc(format, func(token string) string {
+		m := numberToken.FindStringSubmatch(token)
+		switch m[1] {
+		case "YYYY":
+			return date.Format("2006")
+		case "YY":
+			return date.Format("06")
+		case "MM":
+			return date.Format("01")
+		case "DD":
+			return date.Format("02")
+		}
+
+		if seq < 0 {
+			return "{SEQ}"
+		}
+		width := 1
+		if m[2] != "" {
+			width, _ = strconv.Atoi(m[2])
+		}
+		return fmt.Sprintf("%0*d", width, seq)
+	})
+}
+
+// NumberLegacyPurchases issues numbers to purchases created before order
+// numbers existed, in the order they were created
+func NumberLegacyPurchases(db *gorm.DB, format string) error {
+	var purchases []models.Purchasing
+	if err := db.Where("number IS NULL OR number = ''").Order("id").Find(&purchases).Error; err != nil {
+		return err
+	}
+
+	for _, purchase := range purchases {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			number, err := NextDocumentNumber(tx, models.DocumentPurchaseOrder, format, purchase.Date)
+			if err != nil {
+				return err
+			}
+			return tx.Model(&models.Purchasing{}).Where("id = ?", purchase.ID).Update("number", number).Error
+		})
+		if err != nil {
+			return fmt.Errorf("purchase %d: %w", purchase.ID, err)
+		}
+	}
+
+	if len(purchases) > 0 {
+		log.Printf("Numbered %d existing purchases", len(purchases))
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"fmt"
+	"procurement-system/database"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestValidateNumberFormat(t *testing.T) {
+	valid := []string{"{SEQ}", "PO/{YYYY}/{MM}/{SEQ:4}", "{YY}{MM}{DD}-{SEQ:12}"}
+	for _, format := range valid {
+		if err := ValidateNumberFormat(format); err != nil {
+			t.Errorf("ValidateNumberFormat(%q): %v", format, err)
+		}
+	}
+
+	invalid := []string{"", "PO/{YYYY}", "{SEQ}-{SEQ:2}", "{SEQ:0}", "{SEQ:13}"}
+	for _, format := range invalid {
+		if err := ValidateNumberFormat(format); err == nil {
+			t.Errorf("ValidateNumberFormat(%q) succeeded, want an error", format)
+		}
+	}
+}
+
+func TestRenderNumber(t *testing.T) {
+	date := time.Date(2024, time.March, 7, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		format string
+		seq    int
+		want   string
+	}{
+		{"PO/{YYYY}/{MM}/{SEQ:4}", 42, "PO/2024/03/0042"},
+		{"{YY}{MM}{DD}-{SEQ}", 7, "240307-7"},
+		// sequences wider than the padding are not truncated
+		{"{SEQ:2}", 123, "123"},
+		// the scope of a sequence leaves the sequence out
+		{"PO/{YYYY}/{MM}/{SEQ:4}", -1, "PO/2024/03/{SEQ}"},
+	}
+	for _, tt := range tests {
+		if got := renderNumber(tt.format, date, tt.seq); got != tt.want {
+			t.Errorf("renderNumber(%q, %d) = %q, want %q", tt.format, tt.seq, got, tt.want)
+		}
+	}
+}
+
+// TestNextDocumentNumber issues numbers concurrently; every number must be
+// issued exactly once and sequences must restart for each month
+func TestNextDocumentNumber(t *testing.T) {
+	setupTestDB(t)
+	docType := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	format := "T/{YYYY}/{MM}/{SEQ:3}"
+	march := time.Date(2024, time.March, 7, 0, 0, 0, 0, time.UTC)
+
+	const n = 50
+	var mu sync.Mutex
+	issued := make(map[string]bool)
+	parallel(concurrentWorkers, n, func(int) {
+		var number string
+		err := database.Transaction(func(tx *gorm.DB) error {
+			var err error
+			number, err = NextDocumentNumber(tx, docType, format, march)
+			return err
+		})
+		if err != nil {
+			t.Errorf("next number: %v", err)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if issued[number] {
+			t.Errorf("number %s issued twice", number)
+		}
+		issued[number] = true
+	})
+
+	for seq := 1; seq <= n; seq++ {
+		if number := fmt.Sprintf("T/2024/03/%03d", seq); !issued[number] {
+			t.Errorf("number %s was skipped", number)
+		}
+	}
+
+	april, err := NextDocumentNumber(database.DB, docType, format, march.AddDate(0, 1, 0))
+	if err != nil {
+		t.Fatalf("next number: %v", err)
+	}
+	if april != "T/2024/04/001" {
+		t.Errorf("first number of April = %s, want T/2024/04/001", april)
+	}
+}
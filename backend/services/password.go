@@ -0,0 +1,224 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"procurement-system/config"
+	"procurement-system/models"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MinPasswordLength is the shortest password accepted
+const MinPasswordLength = 6
+
+// resetRequestInterval is how long after a reset link was sent another one
+// is not sent, so the forgot-password endpoint cannot flood a mailbox
+const resetRequestInterval = time.Minute
+
+// CheckPassword returns a ValidationError unless password is acceptable
+func CheckPassword(password string) error {
+	if len(password) < MinPasswordLength {
+		return &ValidationError{Message: fmt.Sprintf("Password must be at least %d characters", MinPasswordLength)}
+	}
+	return nil
+}
+
+// HashPassword checks and hashes a new password
+func HashPassword(password string) (string, error) {
+	if err := CheckPassword(password); err != nil {
+		return "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// NormalizeEmail validates an email address and returns it lower-cased, or
+// nil for an empty one
+func NormalizeEmail(email string) (*string, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return nil, nil
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || len(email) > 255 {
+		return nil, &ValidationError{Message: "Invalid email address"}
+	}
+	email = strings.ToLower(email)
+	return &email, nil
+}
+
+// SetUserEmail changes the email address of a user, which must not belong to
+// another user
+func SetUserEmail(tx *gorm.DB, user *models.User, email string) error {
+	normalized, err := NormalizeEmail(email)
+	if err != nil {
+		return err
+	}
+	if normalized != nil {
+		var count int64
+		err := tx.Model(&models.User{}).Unscoped().
+			Where("email = ? AND id <> ?", *normalized, user.ID).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return &ConflictError{Message: "Email is already in use"}
+		}
+	}
+
+	if err := tx.Model(user).Update("email", normalized).Error; err != nil {
+		return err
+	}
+	user.Email = normalized
+	return nil
+}
+
+// ChangePassword sets a new password for a user who knows the current one
+// and logs them out of every other session
+func ChangePassword(tx *gorm.DB, userID uint, sessionID, current, next string) error {
+	var user models.User
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &NotFoundError{Message: "User not found"}
+		}
+		return err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(current)); err != nil {
+		return &ValidationError{Message: "Current password is incorrect"}
+	}
+	if current == next {
+		return &ValidationError{Message: "New password must differ from the current one"}
+	}
+
+	hash, err := HashPassword(next)
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(&user).Update("password", hash).Error; err != nil {
+		return err
+	}
+	return RevokeOtherSessions(tx, user.ID, sessionID)
+}
+
+// RequestPasswordReset issues a password reset token for the user with an
+// email address. It returns a nil user, and no error, when there is no such
+// user or a token was issued for them moments ago, so that callers respond
+// alike either way and do not reveal which addresses are registered.
+func RequestPasswordReset(tx *gorm.DB, email string) (*models.User, string, error) {
+	normalized, err := NormalizeEmail(email)
+	if err != nil {
+		return nil, "", err
+	}
+	if normalized == nil {
+		return nil, "", &ValidationError{Message: "Email is required"}
+	}
+
+	now := time.Now()
+	if err := tx.Where("expires_at <= ?", now).Delete(&models.PasswordResetToken{}).Error; err != nil {
+		return nil, "", err
+	}
+
+	var user models.User
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("email = ?", *normalized).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	var recent int64
+	err = tx.Model(&models.PasswordResetToken{}).
+		Where("user_id = ? AND created_at > ?", user.ID, now.Add(-resetRequestInterval)).
+		Count(&recent).Error
+	if err != nil {
+		return nil, "", err
+	}
+	if recent > 0 {
+		return nil, "", nil
+	}
+
+	// Only the latest link works
+	if err := tx.Where("user_id = ? AND used_at IS NULL", user.ID).Delete(&models.PasswordResetToken{}).Error; err != nil {
+		return nil, "", err
+	}
+
+	raw := randomToken()
+	reset := models.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(raw),
+		ExpiresAt: now.Add(config.AppConfig.PasswordResetTTL),
+	}
+	if err := tx.Create(&reset).Error; err != nil {
+		return nil, "", err
+	}
+	return &user, raw, nil
+}
+
+// ResetPassword spends a password reset token to set a new password. The
+// account is unlocked and logged out of every session.
+func ResetPassword(tx *gorm.DB, raw, password string) error {
+	if err := CheckPassword(password); err != nil {
+		return err
+	}
+
+	var reset models.PasswordResetToken
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token_hash = ?", hashToken(raw)).
+		First(&reset).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &ValidationError{Message: "Invalid or expired reset token"}
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if reset.UsedAt != nil || !reset.ExpiresAt.After(now) {
+		return &ValidationError{Message: "Invalid or expired reset token"}
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	var user models.User
+	if err := tx.First(&user, reset.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &ValidationError{Message: "Invalid or expired reset token"}
+		}
+		return err
+	}
+
+	err = tx.Model(&user).Updates(map[string]interface{}{
+		"password":      hash,
+		"failed_logins": 0,
+		"locked_until":  nil,
+	}).Error
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(&reset).Update("used_at", now).Error; err != nil {
+		return err
+	}
+	return RevokeUserSessions(tx, user.ID)
+}
+
+// PasswordResetLink returns the link a reset token is mailed in. The token
+// goes in the fragment, which browsers do not send to servers or in
+// Referer headers.
+func PasswordResetLink(token string) string {
+	return config.AppConfig.PasswordResetURL + "#token=" + token
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"procurement-system/database"
+	"procurement-system/models"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	valid := map[string]string{
+		"user@example.com":     "user@example.com",
+		" User@Example.COM ":   "user@example.com",
+		"first.last@sub.co.id": "first.last@sub.co.id",
+	}
+	for email, want := range valid {
+		got, err := NormalizeEmail(email)
+		if err != nil || got == nil || *got != want {
+			t.Errorf("NormalizeEmail(%q) = %v, %v; want %q", email, got, err, want)
+		}
+	}
+
+	if got, err := NormalizeEmail("  "); got != nil || err != nil {
+		t.Errorf("NormalizeEmail of blanks = %v, %v; want nil", got, err)
+	}
+
+	invalid := []string{
+		"user",
+		"user@",
+		"User <user@example.com>",
+		"user@example.com, other@example.com",
+		strings.Repeat("a", 250) + "@example.com",
+	}
+	for _, email := range invalid {
+		if _, err := NormalizeEmail(email); !isRejection(err) {
+			t.Errorf("NormalizeEmail(%q): err = %v, want a rejection", email, err)
+		}
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	if err := CheckPassword(strings.Repeat("x", MinPasswordLength)); err != nil {
+		t.Errorf("password of minimum length: %v", err)
+	}
+	if err := CheckPassword(strings.Repeat("x", MinPasswordLength-1)); !isRejection(err) {
+		t.Errorf("short password: err = %v, want a rejection", err)
+	}
+}
+
+// TestPasswordReset checks that a reset link sets a new password once and
+// ends every session of the user
+func TestPasswordReset(t *testing.T) {
+	setupTestDB(t)
+	run := testRun(t)
+	user := createLoginUser(t, run)
+	email := strings.ToLower(run) + "@example.com"
+
+	if err := SetUserEmail(database.DB, &user, strings.ToUpper(email)); err != nil {
+		t.Fatalf("set email: %v", err)
+	}
+	if user.Email == nil || *user.Email != email {
+		t.Errorf("email = %v, want %q", user.Email, email)
+	}
+	other := createTestUser(t, run+"-other")
+	if err := SetUserEmail(database.DB, &other, email); !isRejection(err) {
+		t.Errorf("take another user's email: err = %v, want a rejection", err)
+	}
+
+	session, err := IssueTokens(database.DB, user, "", ClientInfo{})
+	if err != nil {
+		t.Fatalf("issue tokens: %v", err)
+	}
+
+	// Unknown addresses are answered like known ones
+	if found, _, err := RequestPasswordReset(database.DB, "unknown-"+email); found != nil || err != nil {
+		t.Errorf("reset for an unknown address = %v, %v; want nothing", found, err)
+	}
+
+	found, raw, err := RequestPasswordReset(database.DB, email)
+	if err != nil || found == nil || found.ID != user.ID || raw == "" {
+		t.Fatalf("request reset = %v, %q, %v; want a token for user %d", found, raw, err, user.ID)
+	}
+	if again, _, err := RequestPasswordReset(database.DB, email); again != nil || err != nil {
+		t.Errorf("second request right away = %v, %v; want nothing", again, err)
+	}
+
+	if err := ResetPassword(database.DB, raw, "short"); !isRejection(err) {
+		t.Errorf("reset to a short password: err = %v, want a rejection", err)
+	}
+	if err := ResetPassword(database.DB, "not-a-token", "a new password"); !isRejection(err) {
+		t.Errorf("reset with an unknown token: err = %v, want a rejection", err)
+	}
+	if err := ResetPassword(database.DB, raw, "a new password"); err != nil {
+		t.Fatalf("reset password: %v", err)
+	}
+	if err := ResetPassword(database.DB, raw, "another password"); !isRejection(err) {
+		t.Errorf("reuse a reset token: err = %v, want a rejection", err)
+	}
+
+	var reloaded models.User
+	database.DB.First(&reloaded, user.ID)
+	if err := bcrypt.CompareHashAndPassword([]byte(reloaded.Password), []byte("a new password")); err != nil {
+		t.Errorf("new password does not match: %v", err)
+	}
+	if _, err := ParseAccessToken(database.DB, session.AccessToken); !isUnauthorized(err) {
+		t.Errorf("session after a reset: err = %v, want a refusal", err)
+	}
+}
+
+// TestChangePassword checks that changing a password needs the current one
+// and keeps only the session it was changed from
+func TestChangePassword(t *testing.T) {
+	setupTestDB(t)
+	user := createLoginUser(t, testRun(t))
+
+	var sessions []TokenPair
+	var ids []string
+	for i := 0; i < 2; i++ {
+		pair, err := IssueTokens(database.DB, user, "", ClientInfo{})
+		if err != nil {
+			t.Fatalf("issue tokens: %v", err)
+		}
+		claims, err := ParseAccessToken(database.DB, pair.AccessToken)
+		if err != nil {
+			t.Fatalf("parse access token: %v", err)
+		}
+		sessions = append(sessions, pair)
+		ids = append(ids, claims.SessionID)
+	}
+
+	invalid := map[string][2]string{
+		"wrong current password": {"wrong", "a new password"},
+		"unchanged password":     {loginTestPassword, loginTestPassword},
+		"short new password":     {loginTestPassword, "short"},
+	}
+	for name, change := range invalid {
+		if err := ChangePassword(database.DB, user.ID, ids[0], change[0], change[1]); !isRejection(err) {
+			t.Errorf("%s: err = %v, want a rejection", name, err)
+		}
+	}
+
+	if err := ChangePassword(database.DB, user.ID, ids[0], loginTestPassword, "a new password"); err != nil {
+		t.Fatalf("change password: %v", err)
+	}
+	if _, err := ParseAccessToken(database.DB, sessions[0].AccessToken); err != nil {
+		t.Errorf("current session: %v", err)
+	}
+	if _, err := ParseAccessToken(database.DB, sessions[1].AccessToken); !isUnauthorized(err) {
+		t.Errorf("other session: err = %v, want a refusal", err)
+	}
+}
@@ -0,0 +1,403 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"procurement-system/models"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// invoiceableStatuses are the purchase statuses a supplier may invoice
+var invoiceableStatuses = []string{
+	models.PurchaseStatusApproved,
+	models.PurchaseStatusOrdered,
+	models.PurchaseStatusReceived,
+	models.PurchaseStatusClosed,
+}
+
+// InvoiceLine is the quantity and unit price billed for one purchase detail
+type InvoiceLine struct {
+	PurchasingDetailID uint
+	Qty                int
+	UnitPrice          models.Money
+}
+
+// CreateSupplierInvoice validates invoice and records it against the
+// purchases identified by purchaseIDs, which must belong to the invoice's
+// supplier, share one currency and not be invoiced yet. Without lines every
+// detail line of the purchases is billed at its ordered quantity and price.
+// A zero Amount defaults to the order value of the billed quantities and a
+// zero DueDate to the invoice date plus the supplier's payment terms. The
+// new invoice is matched against its purchases right away.
+func CreateSupplierInvoice(tx *gorm.DB, invoice *models.SupplierInvoice, purchaseIDs []uint, lines []InvoiceLine) error {
+	invoice.Number = strings.TrimSpace(invoice.Number)
+	if invoice.Number == "" {
+		return &ValidationError{Message: "Invoice number is required"}
+	}
+	if len(purchaseIDs) == 0 {
+		return &ValidationError{Message: "At least one purchase is required"}
+	}
+	if invoice.Amount.IsNegative() {
+		return &ValidationError{Message: "Invoice amount cannot be negative"}
+	}
+
+	var supplier models.Supplier
+	if err := tx.First(&supplier, invoice.SupplierID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &ValidationError{Message: fmt.Sprintf("Supplier with ID %d not found", invoice.SupplierID)}
+		}
+		return err
+	}
+
+	var count int64
+	if err := tx.Model(&models.SupplierInvoice{}).Where("supplier_id = ? AND number = ?", supplier.ID, invoice.Number).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return &ConflictError{Message: fmt.Sprintf("Invoice %s of %s is already recorded", invoice.Number, supplier.Name)}
+	}
+
+	// Lock the purchases so that concurrent invoices cannot both bill them
+	var purchases []models.Purchasing
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("PurchasingDetails").Where("id IN ?", purchaseIDs).Order("id").Find(&purchases).Error; err != nil {
+		return err
+	}
+	found := make(map[uint]bool, len(purchases))
+	for _, purchase := range purchases {
+		found[purchase.ID] = true
+	}
+	for _, id := range purchaseIDs {
+		if !found[id] {
+			return &ValidationError{Message: fmt.Sprintf("Purchase with ID %d not found", id)}
+		}
+	}
+
+	for _, purchase := range purchases {
+		if purchase.SupplierID != supplier.ID {
+			return &ValidationError{Message: fmt.Sprintf("Purchase %s is not from %s", purchase.Number, supplier.Name)}
+		}
+		if purchase.Currency != purchases[0].Currency {
+			return &ValidationError{Message: "All purchases of an invoice must be in the same currency"}
+		}
+		if !invoiceable(purchase.Status) {
+			return &ConflictError{Message: fmt.Sprintf("Purchase %s in status '%s' cannot be invoiced", purchase.Number, purchase.Status)}
+		}
+	}
+
+	if number, err := invoiceNumberOf(tx, purchaseIDs); err != nil {
+		return err
+	} else if number != "" {
+		return &ConflictError{Message: fmt.Sprintf("A purchase of this invoice is already billed on invoice %s", number)}
+	}
+
+	if invoice.InvoiceDate.IsZero() {
+		invoice.InvoiceDate = civilDate(time.Now())
+	}
+	if invoice.DueDate.IsZero() {
+		invoice.DueDate = invoice.InvoiceDate.AddDate(0, 0, supplier.PaymentTermDays)
+	}
+	if invoice.DueDate.Before(invoice.InvoiceDate) {
+		return &ValidationError{Message: "Due date cannot be before the invoice date"}
+	}
+
+	invoiceLines, value, err := billedLines(purchases, lines)
+	if err != nil {
+		return err
+	}
+
+	if invoice.Amount.IsZero() {
+		invoice.Amount = value
+	}
+	if invoice.Amount.IsZero() {
+		return &ValidationError{Message: "Invoice amount must be positive"}
+	}
+
+	invoice.Currency = purchases[0].Currency
+	invoice.PaidAmount = 0
+	invoice.Status = models.InvoiceStatusOpen
+	invoice.MatchStatus = models.MatchStatusPending
+	invoice.Purchases = purchases
+	invoice.Lines = invoiceLines
+
+	// Only link the purchases, never write them back
+	if err := tx.Omit("Purchases.*").Create(invoice).Error; err != nil {
+		return err
+	}
+
+	matched, err := MatchSupplierInvoice(tx, invoice.ID)
+	if err != nil {
+		return err
+	}
+	invoice.MatchStatus = matched.MatchStatus
+	invoice.MatchedAt = matched.MatchedAt
+	return nil
+}
+
+// billedLines builds the invoice lines for lines billed against the detail
+// lines of purchases, or for every detail line at its ordered quantity and
+// price if lines is empty. It also returns the order value of the billed
+// quantities.
+func billedLines(purchases []models.Purchasing, lines []InvoiceLine) ([]models.SupplierInvoiceLine, models.Money, error) {
+	details := make(map[uint]*models.PurchasingDetail)
+	for i := range purchases {
+		for j := range purchases[i].PurchasingDetails {
+			detail := &purchases[i].PurchasingDetails[j]
+			details[detail.ID] = detail
+		}
+	}
+
+	if len(lines) == 0 {
+		for _, purchase := range purchases {
+			for _, detail := range purchase.PurchasingDetails {
+				lines = append(lines, InvoiceLine{
+					PurchasingDetailID: detail.ID,
+					Qty:                detail.Qty,
+					UnitPrice:          detail.UnitPrice,
+				})
+			}
+		}
+	}
+
+	var value models.Money
+	billed := make(map[uint]bool, len(lines))
+	result := make([]models.SupplierInvoiceLine, 0, len(lines))
+	for _, line := range lines {
+		detail, ok := details[line.PurchasingDetailID]
+		if !ok {
+			return nil, 0, &ValidationError{Message: fmt.Sprintf("Purchase detail %d does not belong to the invoiced purchases", line.PurchasingDetailID)}
+		}
+		if billed[detail.ID] {
+			return nil, 0, &ValidationError{Message: fmt.Sprintf("Purchase detail %d is billed twice", detail.ID)}
+		}
+		billed[detail.ID] = true
+
+		if line.Qty <= 0 {
+			return nil, 0, &ValidationError{Message: "Invoiced qty must be positive"}
+		}
+		if line.UnitPrice.IsNegative() {
+			return nil, 0, &ValidationError{Message: "Invoiced unit price cannot be negative"}
+		}
+
+		share, err := lineShare(detail, line.Qty)
+		if err != nil {
+			return nil, 0, err
+		}
+		if value, err = value.Add(share); err != nil {
+			return nil, 0, &ValidationError{Message: "Invoiced amount is too large"}
+		}
+
+		amount, err := line.UnitPrice.Mul(line.Qty)
+		if err != nil {
+			return nil, 0, &ValidationError{Message: fmt.Sprintf("Invoiced amount of purchase detail %d is too large", detail.ID)}
+		}
+
+		result = append(result, models.SupplierInvoiceLine{
+			PurchasingDetailID: detail.ID,
+			ItemID:             detail.ItemID,
+			Qty:                line.Qty,
+			UnitPrice:          line.UnitPrice,
+			Amount:             amount,
+		})
+	}
+
+	return result, value, nil
+}
+
+// RecordSupplierPayment locks the invoice identified by invoiceID and
+// records payment against it. A payment may not exceed what is still owed
+// on the invoice, nor be dated before it or in the future.
+func RecordSupplierPayment(tx *gorm.DB, invoiceID uint, payment *models.SupplierPayment) (*models.SupplierInvoice, error) {
+	var invoice models.SupplierInvoice
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&invoice, invoiceID).Error; err != nil {
+		return nil, &NotFoundError{Message: "Invoice not found"}
+	}
+
+	if invoice.ApprovedAt == nil {
+		return nil, &ConflictError{Message: fmt.Sprintf("Invoice %s must be approved before it can be paid", invoice.Number)}
+	}
+
+	if payment.Date.IsZero() {
+		payment.Date = civilDate(time.Now())
+	}
+	if payment.Date.Before(invoice.InvoiceDate) {
+		return nil, &ValidationError{Message: "Payment date cannot be before the invoice date"}
+	}
+	if payment.Date.After(civilDate(time.Now())) {
+		return nil, &ValidationError{Message: "Payment date cannot be in the future"}
+	}
+
+	if payment.Amount.IsNegative() || payment.Amount.IsZero() {
+		return nil, &ValidationError{Message: "Payment amount must be positive"}
+	}
+	if payment.Amount > invoice.Outstanding {
+		return nil, &ValidationError{Message: fmt.Sprintf("Payment exceeds the outstanding amount. Outstanding: %s, Paid: %s", invoice.Outstanding, payment.Amount)}
+	}
+
+	payment.SupplierInvoiceID = invoice.ID
+	payment.Method = strings.TrimSpace(payment.Method)
+	payment.Reference = strings.TrimSpace(payment.Reference)
+	if err := tx.Create(payment).Error; err != nil {
+		return nil, err
+	}
+
+	paid, err := invoice.PaidAmount.Add(payment.Amount)
+	if err != nil {
+		return nil, err
+	}
+	invoice.PaidAmount = paid
+	if invoice.Outstanding, err = invoice.Amount.Sub(paid); err != nil {
+		return nil, err
+	}
+	invoice.Status = models.InvoiceStatusPartial
+	if invoice.Outstanding.IsZero() {
+		invoice.Status = models.InvoiceStatusPaid
+	}
+
+	err = tx.Model(&invoice).Updates(map[string]interface{}{
+		"paid_amount": invoice.PaidAmount,
+		"status":      invoice.Status,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &invoice, nil
+}
+
+// DeleteSupplierInvoice deletes an invoice entered by mistake, together
+// with its links to purchases. Invoices with payments cannot be deleted.
+func DeleteSupplierInvoice(tx *gorm.DB, invoiceID uint) (*models.SupplierInvoice, error) {
+	var invoice models.SupplierInvoice
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Supplier").Preload("Purchases").First(&invoice, invoiceID).Error; err != nil {
+		return nil, &NotFoundError{Message: "Invoice not found"}
+	}
+
+	if !invoice.PaidAmount.IsZero() {
+		return nil, &ConflictError{Message: fmt.Sprintf("Invoice %s has payments and cannot be deleted", invoice.Number)}
+	}
+
+	if err := tx.Model(&invoice).Association("Purchases").Clear(); err != nil {
+		return nil, err
+	}
+	if err := tx.Where("supplier_invoice_id = ?", invoice.ID).Delete(&models.SupplierInvoiceLine{}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("supplier_invoice_id = ?", invoice.ID).Delete(&models.MatchVariance{}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Delete(&invoice).Error; err != nil {
+		return nil, err
+	}
+
+	return &invoice, nil
+}
+
+// invoiceNumberOf returns the number of an invoice billing any of the
+// purchases identified by purchaseIDs, or "" if none of them is invoiced
+func invoiceNumberOf(tx *gorm.DB, purchaseIDs []uint) (string, error) {
+	var numbers []string
+	err := tx.Model(&models.SupplierInvoice{}).
+		Joins("JOIN supplier_invoice_purchases ON supplier_invoice_purchases.supplier_invoice_id = supplier_invoices.id").
+		Where("supplier_invoice_purchases.purchasing_id IN ?", purchaseIDs).
+		Limit(1).
+		Pluck("supplier_invoices.number", &numbers).Error
+	if err != nil || len(numbers) == 0 {
+		return "", err
+	}
+	return numbers[0], nil
+}
+
+// invoiceable reports whether a purchase in status may be invoiced
+func invoiceable(status string) bool {
+	for _, s := range invoiceableStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// SupplierBalance is what is owed to a supplier in one currency. Credits
+// are the amounts of goods returned to the supplier, which it owes back;
+// Net is Outstanding less Credits.
+type SupplierBalance struct {
+	Currency    string       `json:"currency"`
+	Invoiced    models.Money `json:"invoiced"`
+	Paid        models.Money `json:"paid"`
+	Outstanding models.Money `json:"outstanding"`
+	Overdue     models.Money `json:"overdue"`
+	Credits     models.Money `json:"return_credits"`
+	Net         models.Money `json:"net"`
+}
+
+// SupplierBalances returns the balance of a supplier in every currency it
+// has invoices or returns in, ordered by currency
+func SupplierBalances(db *gorm.DB, supplierID uint) ([]SupplierBalance, error) {
+	var invoices []models.SupplierInvoice
+	if err := db.Where("supplier_id = ?", supplierID).Find(&invoices).Error; err != nil {
+		return nil, err
+	}
+
+	var returns []models.PurchaseReturn
+	if err := db.Select("currency", "credit_amount").Where("supplier_id = ?", supplierID).Find(&returns).Error; err != nil {
+		return nil, err
+	}
+
+	balances := make(map[string]*SupplierBalance)
+	balanceOf := func(currency string) *SupplierBalance {
+		if balances[currency] == nil {
+			balances[currency] = &SupplierBalance{Currency: currency}
+		}
+		return balances[currency]
+	}
+
+	today := civilDate(time.Now())
+	for _, invoice := range invoices {
+		balance := balanceOf(invoice.Currency)
+		err := addTo(map[*models.Money]models.Money{
+			&balance.Invoiced:    invoice.Amount,
+			&balance.Paid:        invoice.PaidAmount,
+			&balance.Outstanding: invoice.Outstanding,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if invoice.DueDate.Before(today) {
+			if balance.Overdue, err = balance.Overdue.Add(invoice.Outstanding); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, ret := range returns {
+		balance := balanceOf(ret.Currency)
+		credits, err := balance.Credits.Add(ret.CreditAmount)
+		if err != nil {
+			return nil, err
+		}
+		balance.Credits = credits
+	}
+
+	result := make([]SupplierBalance, 0, len(balances))
+	for _, balance := range balances {
+		net, err := balance.Outstanding.Sub(balance.Credits)
+		if err != nil {
+			return nil, err
+		}
+		balance.Net = net
+		result = append(result, *balance)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Currency < result[j].Currency })
+	return result, nil
+}
+
+// civilDate returns midnight UTC of the calendar date of t, the way date
+// columns are read back from the database
+func civilDate(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"procurement-system/database"
+	"procurement-system/models"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// createReceivedPurchase creates a purchase of qty units of item at
+// unitPrice and receives it in full
+func createReceivedPurchase(t *testing.T, user models.User, supplier models.Supplier, item models.Item, qty int, unitPrice models.Money) (models.Purchasing, models.PurchasingDetail) {
+	t.Helper()
+	purchase := createOrderedPurchase(t, user, supplier, map[uint]int{item.ID: qty})
+
+	var detail models.PurchasingDetail
+	database.DB.Where("purchasing_id = ?", purchase.ID).First(&detail)
+	lineTotal, err := unitPrice.Mul(qty)
+	if err != nil {
+		t.Fatalf("price purchase: %v", err)
+	}
+	detail.UnitPrice = unitPrice
+	detail.LineTotal = lineTotal
+	if err := database.DB.Model(&detail).Updates(map[string]interface{}{"unit_price": unitPrice, "line_total": lineTotal}).Error; err != nil {
+		t.Fatalf("price purchase: %v", err)
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		_, err := ReceivePurchaseGoods(tx, purchase.ID, nil, time.Now(), user.ID, "")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("receive goods: %v", err)
+	}
+	return purchase, detail
+}
+
+// createTestInvoice records invoice for the purchases identified by
+// purchaseIDs
+func createTestInvoice(invoice *models.SupplierInvoice, purchaseIDs []uint, lines []InvoiceLine) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		return CreateSupplierInvoice(tx, invoice, purchaseIDs, lines)
+	})
+}
+
+// payTestInvoice records a payment of amount on date against the invoice
+// identified by invoiceID
+func payTestInvoice(invoiceID, userID uint, amount models.Money, date time.Time) (*models.SupplierInvoice, error) {
+	var invoice *models.SupplierInvoice
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		invoice, err = RecordSupplierPayment(tx, invoiceID, &models.SupplierPayment{Date: date, Amount: amount, UserID: userID})
+		return err
+	})
+	return invoice, err
+}
+
+// TestSupplierInvoicePayments records an invoice, pays it in two parts and
+// checks the supplier's balance and aging along the way
+func TestSupplierInvoicePayments(t *testing.T) {
+	setupTestDB(t)
+	run := testRun(t)
+	user := createTestUser(t, run)
+	supplier := createTestSupplier(t, run)
+	item := createTestItem(t, run)
+	purchase, _ := createReceivedPurchase(t, user, supplier, item, 2, 500)
+
+	today := civilDate(time.Now())
+	daysAgo := func(n int) time.Time { return today.AddDate(0, 0, -n) }
+
+	// Without an amount and a due date the order value is billed, due after
+	// the default payment terms
+	invoice := models.SupplierInvoice{Number: run, SupplierID: supplier.ID, InvoiceDate: daysAgo(100), UserID: user.ID}
+	if err := createTestInvoice(&invoice, []uint{purchase.ID}, nil); err != nil {
+		t.Fatalf("create invoice: %v", err)
+	}
+	if invoice.Amount != 1000 || !invoice.DueDate.Equal(daysAgo(100-models.DefaultPaymentTermDays)) {
+		t.Errorf("invoice amount %s due %s, want 10.00 due %s", invoice.Amount, invoice.DueDate.Format("2006-01-02"), daysAgo(100-models.DefaultPaymentTermDays).Format("2006-01-02"))
+	}
+	if invoice.MatchStatus != models.MatchStatusMatched {
+		t.Errorf("match status = %q, want %q", invoice.MatchStatus, models.MatchStatusMatched)
+	}
+
+	duplicates := map[string]models.SupplierInvoice{
+		"same number":    {Number: run, SupplierID: supplier.ID, UserID: user.ID},
+		"same purchases": {Number: run + "-2", SupplierID: supplier.ID, UserID: user.ID},
+	}
+	for name, duplicate := range duplicates {
+		if err := createTestInvoice(&duplicate, []uint{purchase.ID}, nil); !isRejection(err) {
+			t.Errorf("%s: err = %v, want a rejection", name, err)
+		}
+	}
+
+	if _, err := payTestInvoice(invoice.ID, user.ID, 400, daysAgo(50)); !isRejection(err) {
+		t.Errorf("pay before approval: err = %v, want a rejection", err)
+	}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		_, err := ApproveSupplierInvoice(tx, invoice.ID, user.ID)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("approve invoice: %v", err)
+	}
+
+	invalid := map[string]struct {
+		amount models.Money
+		date   time.Time
+	}{
+		"zero amount":         {0, daysAgo(50)},
+		"more than owed":      {1001, daysAgo(50)},
+		"before the invoice":  {400, daysAgo(101)},
+		"dated in the future": {400, today.AddDate(0, 0, 1)},
+	}
+	for name, payment := range invalid {
+		if _, err := payTestInvoice(invoice.ID, user.ID, payment.amount, payment.date); !isRejection(err) {
+			t.Errorf("%s: err = %v, want a rejection", name, err)
+		}
+	}
+
+	paid, err := payTestInvoice(invoice.ID, user.ID, 400, daysAgo(50))
+	if err != nil {
+		t.Fatalf("pay invoice: %v", err)
+	}
+	if paid.Status != models.InvoiceStatusPartial || paid.Outstanding != 600 {
+		t.Errorf("after paying 4.00: status %q, outstanding %s; want %q, 6.00", paid.Status, paid.Outstanding, models.InvoiceStatusPartial)
+	}
+
+	balances, err := SupplierBalances(database.DB, supplier.ID)
+	if err != nil {
+		t.Fatalf("supplier balances: %v", err)
+	}
+	want := SupplierBalance{Currency: supplier.Currency, Invoiced: 1000, Paid: 400, Outstanding: 600, Overdue: 600, Net: 600}
+	if len(balances) != 1 || balances[0] != want {
+		t.Errorf("balances = %+v, want %+v", balances, want)
+	}
+
+	// The invoice fell due 70 days ago; aging reports as of earlier dates
+	// leave out later payments
+	agings := []struct {
+		asOf    time.Time
+		buckets AgingBuckets
+	}{
+		{daysAgo(101), AgingBuckets{}},
+		{daysAgo(60), AgingBuckets{Days1To30: 1000, Total: 1000}},
+		{daysAgo(20), AgingBuckets{Days31To60: 600, Total: 600}},
+		{today, AgingBuckets{Days61To90: 600, Total: 600}},
+	}
+	for _, aging := range agings {
+		report, err := APAging(database.DB.Where("supplier_id = ?", supplier.ID), aging.asOf)
+		if err != nil {
+			t.Fatalf("aging: %v", err)
+		}
+		var got AgingBuckets
+		if len(report.Suppliers) > 0 {
+			got = report.Suppliers[0].AgingBuckets
+		}
+		if len(report.Suppliers) > 1 || got != aging.buckets {
+			t.Errorf("aging as of %s = %+v, want %+v", report.AsOf, report.Suppliers, aging.buckets)
+		}
+	}
+
+	if err := database.DB.Transaction(func(tx *gorm.DB) error {
+		_, err := DeleteSupplierInvoice(tx, invoice.ID)
+		return err
+	}); !isRejection(err) {
+		t.Errorf("delete a paid invoice: err = %v, want a rejection", err)
+	}
+
+	paid, err = payTestInvoice(invoice.ID, user.ID, 600, today)
+	if err != nil {
+		t.Fatalf("pay invoice: %v", err)
+	}
+	if paid.Status != models.InvoiceStatusPaid || !paid.Outstanding.IsZero() {
+		t.Errorf("after paying in full: status %q, outstanding %s; want %q, 0.00", paid.Status, paid.Outstanding, models.InvoiceStatusPaid)
+	}
+}
+
+// TestAgingBuckets checks which bucket amounts overdue by a number of days
+// fall into
+func TestAgingBuckets(t *testing.T) {
+	var b AgingBuckets
+	for _, days := range []int{-5, 0, 1, 30, 31, 60, 61, 90, 91, 400} {
+		b.add(days, 100)
+	}
+	want := AgingBuckets{Current: 200, Days1To30: 200, Days31To60: 200, Days61To90: 200, Over90: 200, Total: 1000}
+	if b != want {
+		t.Errorf("buckets = %+v, want %+v", b, want)
+	}
+}
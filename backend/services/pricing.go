@@ -0,0 +1,288 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"procurement-system/models"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+var hundred = big.NewRat(100, 1)
+
+// Discount is a percentage and/or a fixed amount taken off a line or an
+// order. When both are given the percentage is applied first.
+type Discount struct {
+	Percent models.Rate
+	Amount  models.Money
+}
+
+// amountOf returns the discount on base, rounded once
+func (d Discount) amountOf(base models.Money) (models.Money, error) {
+	if d.Percent < 0 || d.Percent.Rat().Cmp(hundred) > 0 {
+		return 0, &ValidationError{Message: "Discount percentage must be between 0 and 100"}
+	}
+	if d.Amount.IsNegative() {
+		return 0, &ValidationError{Message: "Discount amount cannot be negative"}
+	}
+
+	r := base.Rat()
+	r.Mul(r, d.Percent.Rat())
+	r.Quo(r, hundred)
+	pct, err := models.MoneyFromRat(r)
+	if err != nil {
+		return 0, err
+	}
+
+	total, err := pct.Add(d.Amount)
+	if err != nil || total > base {
+		return 0, &ValidationError{Message: fmt.Sprintf("Discount of %s exceeds the amount of %s", total, base)}
+	}
+	return total, nil
+}
+
+// PurchaseLineInput is one requested line of a purchase
+type PurchaseLineInput struct {
+	ItemID   uint
+	Qty      int
+	Discount Discount
+}
+
+// PricePurchase builds the detail lines of purchase: each line is priced
+// from the supplier's catalog valid on purchase.Date and converted to
+// purchase.Currency, then line discounts, the header discount and taxes are
+// applied. The header totals of purchase are set; the returned lines are not
+// saved yet.
+//
+// Taxes are computed per line after all discounts. A line uses its item's
+// tax code, or else the supplier's. Every amount is rounded once, half away
+// from zero; the header discount is spread over the lines in proportion to
+// their discounted amounts, with rounding differences going to the lines with
+// the largest remainders, so that the lines always add up to the header.
+func PricePurchase(tx *gorm.DB, purchase *models.Purchasing, lines []PurchaseLineInput, headerDiscount Discount) ([]models.PurchasingDetail, error) {
+	if len(lines) == 0 {
+		return nil, &ValidationError{Message: "At least one item is required"}
+	}
+
+	var supplier models.Supplier
+	if err := tx.Preload("TaxCode").First(&supplier, purchase.SupplierID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &ValidationError{Message: "Supplier not found"}
+		}
+		return nil, err
+	}
+
+	details := make([]models.PurchasingDetail, len(lines))
+	taxCodes := make([]*models.TaxCode, len(lines))
+	for i, line := range lines {
+		if line.ItemID == 0 || line.Qty <= 0 {
+			return nil, &ValidationError{Message: "Invalid item data: item_id and qty must be positive"}
+		}
+
+		var item models.Item
+		if err := tx.Preload("TaxCode").First(&item, line.ItemID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, &ValidationError{Message: fmt.Sprintf("Item with ID %d not found", line.ItemID)}
+			}
+			return nil, err
+		}
+
+		// Price the line from the supplier's catalog (NOT from the request!)
+		entry, err := SupplierPrice(tx, purchase.SupplierID, item.ID, purchase.Date)
+		if err != nil {
+			return nil, err
+		}
+		if line.Qty < entry.MinOrderQty {
+			return nil, &ValidationError{Message: fmt.Sprintf("Minimum order quantity for %s is %d", item.Name, entry.MinOrderQty)}
+		}
+
+		// Catalog prices in another currency are converted at the purchase date
+		unitPrice, err := ConvertMoney(tx, entry.UnitPrice, entry.Currency, purchase.Currency, purchase.Date)
+		if err != nil {
+			return nil, err
+		}
+
+		subTotal, err := unitPrice.Mul(line.Qty)
+		if err != nil {
+			return nil, &ValidationError{Message: fmt.Sprintf("Amount for %s is too large", item.Name)}
+		}
+		discount, err := line.Discount.amountOf(subTotal)
+		if err != nil {
+			return nil, &ValidationError{Message: fmt.Sprintf("%s: %s", item.Name, err.Error())}
+		}
+
+		details[i] = models.PurchasingDetail{
+			ItemID:    item.ID,
+			Qty:       line.Qty,
+			UnitPrice: unitPrice,
+			SubTotal:  subTotal,
+			Discount:  discount,
+		}
+
+		taxCodes[i] = item.TaxCode
+		if taxCodes[i] == nil {
+			taxCodes[i] = supplier.TaxCode
+		}
+	}
+
+	// Spread the header discount over the lines
+	net := make([]models.Money, len(details))
+	var netTotal models.Money
+	for i := range details {
+		var err error
+		if net[i], err = details[i].SubTotal.Sub(details[i].Discount); err != nil {
+			return nil, err
+		}
+		if netTotal, err = netTotal.Add(net[i]); err != nil {
+			return nil, errTotalTooLarge()
+		}
+	}
+	headerAmount, err := headerDiscount.amountOf(netTotal)
+	if err != nil {
+		return nil, err
+	}
+	for i, share := range allocate(headerAmount, net) {
+		if details[i].Discount, err = details[i].Discount.Add(share); err != nil {
+			return nil, err
+		}
+	}
+
+	purchase.Subtotal = 0
+	purchase.HeaderDiscount = headerAmount
+	purchase.DiscountTotal = 0
+	purchase.TaxTotal = 0
+	purchase.GrandTotal = 0
+
+	for i := range details {
+		if err := applyTax(&details[i], taxCodes[i]); err != nil {
+			return nil, err
+		}
+
+		err := addTo(map[*models.Money]models.Money{
+			&purchase.Subtotal:      details[i].SubTotal,
+			&purchase.DiscountTotal: details[i].Discount,
+			&purchase.TaxTotal:      details[i].Tax,
+			&purchase.GrandTotal:    details[i].LineTotal,
+		})
+		if err != nil {
+			return nil, errTotalTooLarge()
+		}
+	}
+
+	purchase.BaseGrandTotal, err = ToBaseCurrency(purchase.GrandTotal, purchase.ExchangeRate)
+	if err != nil {
+		return nil, err
+	}
+
+	return details, nil
+}
+
+// addTo adds each amount to the total it is keyed by. It fails with
+// models.ErrOutOfRange when a total no longer fits.
+func addTo(amounts map[*models.Money]models.Money) error {
+	for total, amount := range amounts {
+		sum, err := total.Add(amount)
+		if err != nil {
+			return err
+		}
+		*total = sum
+	}
+	return nil
+}
+
+// errTotalTooLarge reports purchase totals that cannot be stored
+func errTotalTooLarge() error {
+	return &ValidationError{Message: "Purchase total is too large"}
+}
+
+// applyTax computes the tax of a line whose SubTotal and Discount are set
+func applyTax(detail *models.PurchasingDetail, code *models.TaxCode) error {
+	amount, err := detail.SubTotal.Sub(detail.Discount)
+	if err != nil {
+		return err
+	}
+	detail.TaxBase = amount
+	detail.Tax = 0
+	detail.LineTotal = amount
+	detail.TaxCodeID = nil
+	detail.TaxRate = 0
+	detail.TaxInclusive = false
+
+	if code == nil {
+		return nil
+	}
+	detail.TaxCodeID = &code.ID
+	detail.TaxRate = code.Rate
+	detail.TaxInclusive = code.Inclusive
+
+	rate := new(big.Rat).Quo(code.Rate.Rat(), hundred)
+	if code.Inclusive {
+		// amount = base * (1 + rate)
+		r := amount.Rat()
+		r.Quo(r, new(big.Rat).Add(big.NewRat(1, 1), rate))
+		base, err := models.MoneyFromRat(r)
+		if err != nil {
+			return err
+		}
+		detail.TaxBase = base
+		detail.Tax, err = amount.Sub(base)
+		return err
+	}
+
+	r := amount.Rat()
+	tax, err := models.MoneyFromRat(r.Mul(r, rate))
+	if err != nil {
+		return err
+	}
+	if code.Withholding {
+		tax = tax.Neg()
+	}
+	detail.Tax = tax
+	if detail.LineTotal, err = amount.Add(tax); err != nil {
+		return errTotalTooLarge()
+	}
+	return nil
+}
+
+// allocate splits total over weights in proportion to them using the
+// largest remainder method, so that the shares always add up to total
+func allocate(total models.Money, weights []models.Money) []models.Money {
+	shares := make([]models.Money, len(weights))
+
+	var sum int64
+	for _, w := range weights {
+		sum += int64(w)
+	}
+	if total == 0 || sum == 0 {
+		return shares
+	}
+
+	type remainder struct {
+		index int
+		rem   *big.Int
+	}
+	remainders := make([]remainder, len(weights))
+	allocated := models.Money(0)
+	for i, w := range weights {
+		q, rem := new(big.Int).QuoRem(
+			new(big.Int).Mul(big.NewInt(int64(total)), big.NewInt(int64(w))),
+			big.NewInt(sum),
+			new(big.Int),
+		)
+		shares[i] = models.Money(q.Int64())
+		allocated += shares[i]
+		remainders[i] = remainder{i, rem}
+	}
+
+	sort.SliceStable(remainders, func(a, b int) bool {
+		return remainders[a].rem.Cmp(remainders[b].rem) > 0
+	})
+	for i := 0; allocated < total; i++ {
+		shares[remainders[i].index]++
+		allocated++
+	}
+
+	return shares
+}
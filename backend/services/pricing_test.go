@@ -0,0 +1,231 @@
+package services
+
+import (
+	"procurement-system/database"
+	"procurement-system/models"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		total   models.Money
+		weights []models.Money
+		want    []models.Money
+	}{
+		{1000, []models.Money{1000, 4000}, []models.Money{200, 800}},
+		// 1.00 over three equal lines: the first line gets the extra cent
+		{100, []models.Money{500, 500, 500}, []models.Money{34, 33, 33}},
+		// the largest remainders get the rounding difference
+		{10, []models.Money{100, 200, 400}, []models.Money{1, 3, 6}},
+		{0, []models.Money{100, 200}, []models.Money{0, 0}},
+		{100, []models.Money{0, 0}, []models.Money{0, 0}},
+	}
+	for _, tt := range tests {
+		got := allocate(tt.total, tt.weights)
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("allocate(%s, %v) = %v, want %v", tt.total, tt.weights, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestDiscountAmountOf(t *testing.T) {
+	tests := []struct {
+		discount Discount
+		base     models.Money
+		want     models.Money
+		wantErr  bool
+	}{
+		{Discount{}, 10000, 0, false},
+		{Discount{Percent: 10 * models.OneRate}, 10000, 1000, false},
+		{Discount{Amount: 250}, 10000, 250, false},
+		// the percentage is applied first, then the amount
+		{Discount{Percent: 10 * models.OneRate, Amount: 250}, 10000, 1250, false},
+		// 12.5% of 0.99 is 0.12375, rounded once
+		{Discount{Percent: 12*models.OneRate + models.OneRate/2}, 99, 12, false},
+		{Discount{Percent: -models.OneRate}, 10000, 0, true},
+		{Discount{Percent: 101 * models.OneRate}, 10000, 0, true},
+		{Discount{Amount: -1}, 10000, 0, true},
+		{Discount{Amount: 10001}, 10000, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := tt.discount.amountOf(tt.base)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%+v.amountOf(%s) err = %v, wantErr %v", tt.discount, tt.base, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%+v.amountOf(%s) = %s, want %s", tt.discount, tt.base, got, tt.want)
+		}
+	}
+}
+
+func TestApplyTax(t *testing.T) {
+	vat := &models.TaxCode{ID: 1, Rate: 11 * models.OneRate}
+	inclusive := &models.TaxCode{ID: 2, Rate: 11 * models.OneRate, Inclusive: true}
+	withholding := &models.TaxCode{ID: 3, Rate: 2 * models.OneRate, Withholding: true}
+
+	tests := []struct {
+		name                    string
+		subTotal, discount      models.Money
+		code                    *models.TaxCode
+		taxBase, tax, lineTotal models.Money
+	}{
+		{"untaxed", 10000, 1000, nil, 9000, 0, 9000},
+		{"exclusive", 10000, 0, vat, 10000, 1100, 11100},
+		{"exclusive after discount", 10000, 1000, vat, 9000, 990, 9990},
+		{"inclusive", 11100, 0, inclusive, 10000, 1100, 11100},
+		{"withholding", 10000, 0, withholding, 10000, -200, 9800},
+	}
+	for _, tt := range tests {
+		detail := models.PurchasingDetail{SubTotal: tt.subTotal, Discount: tt.discount}
+		if err := applyTax(&detail, tt.code); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if detail.TaxBase != tt.taxBase || detail.Tax != tt.tax || detail.LineTotal != tt.lineTotal {
+			t.Errorf("%s: base/tax/total = %s/%s/%s, want %s/%s/%s", tt.name,
+				detail.TaxBase, detail.Tax, detail.LineTotal, tt.taxBase, tt.tax, tt.lineTotal)
+		}
+		if (detail.TaxCodeID == nil) != (tt.code == nil) {
+			t.Errorf("%s: tax code ID = %v", tt.name, detail.TaxCodeID)
+		}
+	}
+}
+
+// TestPricePurchase prices a purchase from the supplier's catalog and
+// spreads a header discount over its lines
+func TestPricePurchase(t *testing.T) {
+	setupTestDB(t)
+	run := testRun(t)
+	supplier := createTestSupplier(t, run)
+	first := createTestItem(t, run+"-a")
+	second := createTestItem(t, run+"-b")
+	unlisted := createTestItem(t, run+"-c")
+
+	for _, entry := range []models.SupplierItem{
+		{SupplierID: supplier.ID, ItemID: first.ID, UnitPrice: 1000, MinOrderQty: 1},
+		{SupplierID: supplier.ID, ItemID: second.ID, UnitPrice: 2000, MinOrderQty: 2},
+	} {
+		if err := SaveSupplierItem(database.DB, &entry); err != nil {
+			t.Fatalf("save catalog entry: %v", err)
+		}
+	}
+
+	purchase := models.Purchasing{
+		Date:         time.Now(),
+		SupplierID:   supplier.ID,
+		Currency:     supplier.Currency,
+		ExchangeRate: models.OneRate,
+	}
+	lines := []PurchaseLineInput{{ItemID: first.ID, Qty: 1}, {ItemID: second.ID, Qty: 2}}
+	details, err := PricePurchase(database.DB, &purchase, lines, Discount{Amount: 1000})
+	if err != nil {
+		t.Fatalf("price purchase: %v", err)
+	}
+
+	// The lines are worth 10.00 and 40.00, so the 10.00 header discount is
+	// split 2.00 / 8.00
+	wantTotals := []models.Money{800, 3200}
+	for i, detail := range details {
+		if detail.LineTotal != wantTotals[i] {
+			t.Errorf("line %d total = %s, want %s", i, detail.LineTotal, wantTotals[i])
+		}
+	}
+	if purchase.Subtotal != 5000 || purchase.HeaderDiscount != 1000 || purchase.DiscountTotal != 1000 || purchase.GrandTotal != 4000 {
+		t.Errorf("subtotal/header discount/discount total/grand total = %s/%s/%s/%s, want 50.00/10.00/10.00/40.00",
+			purchase.Subtotal, purchase.HeaderDiscount, purchase.DiscountTotal, purchase.GrandTotal)
+	}
+	if purchase.BaseGrandTotal != purchase.GrandTotal {
+		t.Errorf("base grand total = %s, want %s", purchase.BaseGrandTotal, purchase.GrandTotal)
+	}
+
+	rejected := map[string][]PurchaseLineInput{
+		"below minimum order qty": {{ItemID: second.ID, Qty: 1}},
+		"not in catalog":          {{ItemID: unlisted.ID, Qty: 1}},
+		"zero qty":                {{ItemID: first.ID, Qty: 0}},
+	}
+	for name, lines := range rejected {
+		if _, err := PricePurchase(database.DB, &purchase, lines, Discount{}); !isRejection(err) {
+			t.Errorf("%s: err = %v, want a rejection", name, err)
+		}
+	}
+}
+
+// TestPurchaseLinesKeepSnapshot checks that saved purchase lines keep the
+// prices they were created with when the catalog and item prices change
+func TestPurchaseLinesKeepSnapshot(t *testing.T) {
+	setupTestDB(t)
+	run := testRun(t)
+	user := createTestUser(t, run)
+	supplier := createTestSupplier(t, run)
+	item := createTestItem(t, run)
+
+	entry := models.SupplierItem{SupplierID: supplier.ID, ItemID: item.ID, UnitPrice: 1250, MinOrderQty: 1}
+	if err := SaveSupplierItem(database.DB, &entry); err != nil {
+		t.Fatalf("save catalog entry: %v", err)
+	}
+
+	purchase := models.Purchasing{
+		Date:         time.Now(),
+		SupplierID:   supplier.ID,
+		UserID:       user.ID,
+		Currency:     supplier.Currency,
+		ExchangeRate: models.OneRate,
+		Status:       models.PurchaseStatusDraft,
+	}
+	err := database.Transaction(func(tx *gorm.DB) error {
+		details, err := PricePurchase(tx, &purchase, []PurchaseLineInput{{ItemID: item.ID, Qty: 4}}, Discount{})
+		if err != nil {
+			return err
+		}
+		if purchase.WarehouseID, err = ResolveWarehouse(tx, 0); err != nil {
+			return err
+		}
+		if purchase.Number, err = NextDocumentNumber(tx, models.DocumentPurchaseOrder, user.Username+"/{SEQ}", purchase.Date); err != nil {
+			return err
+		}
+		if err := tx.Create(&purchase).Error; err != nil {
+			return err
+		}
+		for i := range details {
+			details[i].PurchasingID = purchase.ID
+		}
+		return tx.Create(&details).Error
+	})
+	if err != nil {
+		t.Fatalf("create purchase: %v", err)
+	}
+
+	// Reprice the catalog entry and the item
+	entry.UnitPrice = 9900
+	if err := SaveSupplierItem(database.DB, &entry); err != nil {
+		t.Fatalf("reprice catalog entry: %v", err)
+	}
+	if err := database.DB.Model(&item).Update("price", models.Money(8800)).Error; err != nil {
+		t.Fatalf("reprice item: %v", err)
+	}
+	if current, err := SupplierPrice(database.DB, supplier.ID, item.ID, time.Now()); err != nil || current.UnitPrice != 9900 {
+		t.Fatalf("catalog price after repricing = %v, %v; want 99.00", current, err)
+	}
+
+	var saved models.Purchasing
+	if err := database.DB.Preload("PurchasingDetails").First(&saved, purchase.ID).Error; err != nil {
+		t.Fatalf("load purchase: %v", err)
+	}
+	if len(saved.PurchasingDetails) != 1 {
+		t.Fatalf("%d purchase lines, want 1", len(saved.PurchasingDetails))
+	}
+	detail := saved.PurchasingDetails[0]
+	if detail.UnitPrice != 1250 || detail.SubTotal != 5000 || detail.LineTotal != 5000 {
+		t.Errorf("unit price/subtotal/line total = %s/%s/%s, want 12.50/50.00/50.00", detail.UnitPrice, detail.SubTotal, detail.LineTotal)
+	}
+	if saved.GrandTotal != 5000 {
+		t.Errorf("grand total = %s, want 50.00", saved.GrandTotal)
+	}
+}
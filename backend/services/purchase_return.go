@@ -0,0 +1,160 @@
+package services
+
+import (
+	"fmt"
+	"math/big"
+	"procurement-system/config"
+	"procurement-system/models"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ReturnLine is the quantity sent back for one purchase detail line
+type ReturnLine struct {
+	PurchasingDetailID uint
+	Qty                int
+}
+
+// ReturnPurchaseGoods locks the purchase identified by purchaseID and sends
+// goods received for it back to the supplier. Each line may return at most
+// what was received and not returned yet. The returned quantities are taken
+// out of stock and credited at their share of the line total, so that
+// returning a whole line always credits exactly its line total.
+func ReturnPurchaseGoods(tx *gorm.DB, purchaseID uint, lines []ReturnLine, date time.Time, userID uint, reason string) (*models.PurchaseReturn, error) {
+	if reason == "" {
+		return nil, &ValidationError{Message: "A reason for the return is required"}
+	}
+	if len(lines) == 0 {
+		return nil, &ValidationError{Message: "At least one item is required"}
+	}
+
+	// Lock the purchase so concurrent returns cannot return more than was received
+	var purchase models.Purchasing
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("PurchasingDetails").First(&purchase, purchaseID).Error; err != nil {
+		return nil, &NotFoundError{Message: "Purchase not found"}
+	}
+
+	switch purchase.Status {
+	case models.PurchaseStatusOrdered, models.PurchaseStatusReceived, models.PurchaseStatusClosed:
+	default:
+		return nil, &ConflictError{Message: fmt.Sprintf("Goods cannot be returned for a purchase in status '%s'", purchase.Status)}
+	}
+
+	details := make(map[uint]*models.PurchasingDetail, len(purchase.PurchasingDetails))
+	for i := range purchase.PurchasingDetails {
+		details[purchase.PurchasingDetails[i].ID] = &purchase.PurchasingDetails[i]
+	}
+
+	// Touch items in a consistent order so that concurrent stock changes
+	// lock item rows in the same sequence and cannot deadlock each other
+	lines = append([]ReturnLine(nil), lines...)
+	sort.SliceStable(lines, func(i, j int) bool {
+		return returnItemID(details, lines[i]) < returnItemID(details, lines[j])
+	})
+
+	number, err := NextDocumentNumber(tx, models.DocumentPurchaseReturn, config.AppConfig.PurchaseReturnNumberFormat, date)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := models.PurchaseReturn{
+		Number:       number,
+		PurchasingID: purchase.ID,
+		SupplierID:   purchase.SupplierID,
+		Date:         date,
+		UserID:       userID,
+		Reason:       reason,
+		Currency:     purchase.Currency,
+	}
+	if err := tx.Create(&ret).Error; err != nil {
+		return nil, err
+	}
+
+	for _, line := range lines {
+		detail, ok := details[line.PurchasingDetailID]
+		if !ok {
+			return nil, &ValidationError{Message: fmt.Sprintf("Purchase detail %d does not belong to this purchase", line.PurchasingDetailID)}
+		}
+
+		if line.Qty <= 0 {
+			return nil, &ValidationError{Message: "Returned qty must be positive"}
+		}
+
+		if available := detail.ReceivedQty - detail.ReturnedQty; line.Qty > available {
+			return nil, &ValidationError{Message: fmt.Sprintf("Returned qty for purchase detail %d exceeds received qty. Returnable: %d, Returned: %d", detail.ID, available, line.Qty)}
+		}
+
+		// Credit the difference between the shares of the line total returned
+		// after and before this line, so rounding never adds up to more than
+		// the line total
+		before, err := lineShare(detail, detail.ReturnedQty)
+		if err != nil {
+			return nil, err
+		}
+		after, err := lineShare(detail, detail.ReturnedQty+line.Qty)
+		if err != nil {
+			return nil, err
+		}
+		credit, err := after.Sub(before)
+		if err != nil {
+			return nil, err
+		}
+
+		returnDetail := models.PurchaseReturnDetail{
+			PurchaseReturnID:   ret.ID,
+			PurchasingDetailID: detail.ID,
+			ItemID:             detail.ItemID,
+			Qty:                line.Qty,
+			CreditAmount:       credit,
+		}
+		if err := tx.Create(&returnDetail).Error; err != nil {
+			return nil, err
+		}
+		if ret.CreditAmount, err = ret.CreditAmount.Add(credit); err != nil {
+			return nil, err
+		}
+
+		detail.ReturnedQty += line.Qty
+		if err := tx.Model(detail).Update("returned_qty", detail.ReturnedQty).Error; err != nil {
+			return nil, err
+		}
+
+		_, err = MoveStock(tx, StockChange{
+			ItemID:        detail.ItemID,
+			WarehouseID:   purchase.WarehouseID,
+			Type:          models.MovementReturn,
+			Qty:           -line.Qty,
+			ReferenceType: models.MovementRefPurchaseReturn,
+			ReferenceID:   ret.ID,
+			UserID:        userID,
+			Note:          reason,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Model(&ret).Update("credit_amount", ret.CreditAmount).Error; err != nil {
+		return nil, err
+	}
+
+	return &ret, nil
+}
+
+// lineShare returns the part of a line's total that qty units account for
+func lineShare(detail *models.PurchasingDetail, qty int) (models.Money, error) {
+	r := detail.LineTotal.Rat()
+	r.Mul(r, big.NewRat(int64(qty), int64(detail.Qty)))
+	return models.MoneyFromRat(r)
+}
+
+// returnItemID returns the item returned by line, or zero for unknown lines
+func returnItemID(details map[uint]*models.PurchasingDetail, line ReturnLine) uint {
+	if detail, ok := details[line.PurchasingDetailID]; ok {
+		return detail.ItemID
+	}
+	return 0
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"procurement-system/database"
+	"procurement-system/models"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// returnTestGoods returns lines of the purchase identified by purchaseID
+func returnTestGoods(purchaseID, userID uint, lines []ReturnLine, reason string) (*models.PurchaseReturn, error) {
+	var ret *models.PurchaseReturn
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		ret, err = ReturnPurchaseGoods(tx, purchaseID, lines, time.Now(), userID, reason)
+		return err
+	})
+	return ret, err
+}
+
+// TestReturnPurchaseGoods checks that returns take goods out of stock, never
+// exceed what was received and credit exactly the line total in the end
+func TestReturnPurchaseGoods(t *testing.T) {
+	setupTestDB(t)
+	run := testRun(t)
+	user := createTestUser(t, run)
+	supplier := createTestSupplier(t, run)
+	item := createTestItem(t, run)
+
+	purchase := createOrderedPurchase(t, user, supplier, map[uint]int{item.ID: 3})
+	var detail models.PurchasingDetail
+	database.DB.Where("purchasing_id = ?", purchase.ID).First(&detail)
+	// 3 units for 10.00 do not split into equal cents
+	if err := database.DB.Model(&detail).Update("line_total", models.Money(1000)).Error; err != nil {
+		t.Fatalf("price purchase: %v", err)
+	}
+
+	if _, err := returnTestGoods(purchase.ID, user.ID, []ReturnLine{{PurchasingDetailID: detail.ID, Qty: 1}}, "Damaged"); !isRejection(err) {
+		t.Errorf("return before receiving: err = %v, want a rejection", err)
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		_, err := ReceivePurchaseGoods(tx, purchase.ID, nil, time.Now(), user.ID, "")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("receive goods: %v", err)
+	}
+
+	invalid := map[string]struct {
+		lines  []ReturnLine
+		reason string
+	}{
+		"no reason":    {[]ReturnLine{{PurchasingDetailID: detail.ID, Qty: 1}}, ""},
+		"no lines":     {nil, "Damaged"},
+		"zero qty":     {[]ReturnLine{{PurchasingDetailID: detail.ID, Qty: 0}}, "Damaged"},
+		"too many":     {[]ReturnLine{{PurchasingDetailID: detail.ID, Qty: 4}}, "Damaged"},
+		"foreign line": {[]ReturnLine{{PurchasingDetailID: detail.ID + 1000000, Qty: 1}}, "Damaged"},
+	}
+	for name, c := range invalid {
+		if _, err := returnTestGoods(purchase.ID, user.ID, c.lines, c.reason); !isRejection(err) {
+			t.Errorf("%s: err = %v, want a rejection", name, err)
+		}
+	}
+	checkStock(t, item.ID, 3)
+
+	// Each unit is worth 3.33 1/3: the credits add up to the line total
+	// instead of 9.99
+	var credited models.Money
+	for _, want := range []models.Money{333, 334, 333} {
+		ret, err := returnTestGoods(purchase.ID, user.ID, []ReturnLine{{PurchasingDetailID: detail.ID, Qty: 1}}, "Damaged")
+		if err != nil {
+			t.Fatalf("return goods: %v", err)
+		}
+		if ret.CreditAmount != want {
+			t.Errorf("credit of return %s = %s, want %s", ret.Number, ret.CreditAmount, want)
+		}
+		credited += ret.CreditAmount
+	}
+	if credited != 1000 {
+		t.Errorf("total credit = %s, want 10.00", credited)
+	}
+	checkStock(t, item.ID, 0)
+
+	if _, err := returnTestGoods(purchase.ID, user.ID, []ReturnLine{{PurchasingDetailID: detail.ID, Qty: 1}}, "Damaged"); !isRejection(err) {
+		t.Errorf("return more than received: err = %v, want a rejection", err)
+	}
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"fmt"
+	"procurement-system/models"
+	"procurement-system/webhooks"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// purchaseTransitions lists the statuses a purchase may move to from each status
+var purchaseTransitions = map[string][]string{
+	models.PurchaseStatusDraft:     {models.PurchaseStatusSubmitted, models.PurchaseStatusCancelled},
+	models.PurchaseStatusSubmitted: {models.PurchaseStatusApproved, models.PurchaseStatusRejected, models.PurchaseStatusCancelled},
+	models.PurchaseStatusApproved:  {models.PurchaseStatusOrdered, models.PurchaseStatusCancelled},
+	models.PurchaseStatusOrdered:   {models.PurchaseStatusReceived, models.PurchaseStatusCancelled},
+	models.PurchaseStatusReceived:  {models.PurchaseStatusClosed, models.PurchaseStatusCancelled},
+}
+
+// CanTransition reports whether a purchase in status from may move to status to
+func CanTransition(from, to string) bool {
+	for _, s := range purchaseTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordPurchaseStatus appends an entry to the purchase's status history
+func RecordPurchaseStatus(tx *gorm.DB, purchaseID uint, from, to string, userID uint, note string) error {
+	entry := models.PurchasingStatus{
+		PurchasingID: purchaseID,
+		FromStatus:   from,
+		ToStatus:     to,
+		UserID:       userID,
+		Note:         note,
+	}
+	return tx.Create(&entry).Error
+}
+
+// TransitionPurchase moves purchase to status to and records who performed
+// the transition. Stock is never changed here: goods enter stock through
+// goods receipts (see ReceiveGoods), which move the purchase to received
+// once nothing is outstanding.
+func TransitionPurchase(tx *gorm.DB, purchase *models.Purchasing, to string, userID uint, note string) error {
+	from := purchase.Status
+	if !CanTransition(from, to) {
+		return &ConflictError{Message: fmt.Sprintf("Cannot change purchase status from '%s' to '%s'", from, to)}
+	}
+
+	if err := tx.Model(purchase).Update("status", to).Error; err != nil {
+		return err
+	}
+	purchase.Status = to
+
+	if err := RecordPurchaseStatus(tx, purchase.ID, from, to, userID, note); err != nil {
+		return err
+	}
+
+	return webhooks.Publish(tx, webhooks.EventPurchaseStatusChanged, map[string]interface{}{
+		"order_id":    purchase.ID,
+		"from_status": from,
+		"to_status":   to,
+		"user_id":     userID,
+		"note":        note,
+	})
+}
+
+// CancelPurchase cancels purchase for reason and reverses every stock effect
+// it had: quantities received and not yet returned to the supplier are taken
+// out of stock again as returns. It fails with a ConflictError if that stock
+// has since been issued. The purchase must have been loaded with its details
+// and locked inside tx.
+func CancelPurchase(tx *gorm.DB, purchase *models.Purchasing, userID uint, reason string) error {
+	if reason == "" {
+		return &ValidationError{Message: "A reason for the cancellation is required"}
+	}
+	if !CanTransition(purchase.Status, models.PurchaseStatusCancelled) {
+		return &ConflictError{Message: fmt.Sprintf("Cannot cancel a purchase in status '%s'", purchase.Status)}
+	}
+
+	// Invoiced purchases are owed to the supplier until the invoice is deleted
+	if number, err := invoiceNumberOf(tx, []uint{purchase.ID}); err != nil {
+		return err
+	} else if number != "" {
+		return &ConflictError{Message: fmt.Sprintf("Purchase %s is billed on invoice %s and cannot be cancelled", purchase.Number, number)}
+	}
+
+	// Touch items in a consistent order so that concurrent stock changes
+	// lock item rows in the same sequence and cannot deadlock each other
+	details := append([]models.PurchasingDetail(nil), purchase.PurchasingDetails...)
+	sort.SliceStable(details, func(i, j int) bool {
+		return details[i].ItemID < details[j].ItemID
+	})
+
+	for _, detail := range details {
+		inStock := detail.ReceivedQty - detail.ReturnedQty
+		if inStock == 0 {
+			continue
+		}
+		_, err := MoveStock(tx, StockChange{
+			ItemID:        detail.ItemID,
+			WarehouseID:   purchase.WarehouseID,
+			Type:          models.MovementReturn,
+			Qty:           -inStock,
+			ReferenceType: models.MovementRefPurchase,
+			ReferenceID:   purchase.ID,
+			UserID:        userID,
+			Note:          fmt.Sprintf("Purchase %s cancelled", purchase.Number),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	if err := tx.Model(purchase).Updates(map[string]interface{}{"cancel_reason": reason, "cancelled_at": now}).Error; err != nil {
+		return err
+	}
+	purchase.CancelReason = reason
+	purchase.CancelledAt = &now
+
+	return TransitionPurchase(tx, purchase, models.PurchaseStatusCancelled, userID, reason)
+}
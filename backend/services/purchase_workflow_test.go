@@ -0,0 +1,152 @@
+package services
+
+import (
+	"procurement-system/database"
+	"procurement-system/models"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// cancelTestPurchase locks and cancels the purchase identified by purchaseID
+// the way the cancel endpoint does
+func cancelTestPurchase(purchaseID, userID uint, reason string) (models.Purchasing, error) {
+	var purchase models.Purchasing
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("PurchasingDetails").First(&purchase, purchaseID).Error; err != nil {
+			return err
+		}
+		return CancelPurchase(tx, &purchase, userID, reason)
+	})
+	return purchase, err
+}
+
+func TestCanTransition(t *testing.T) {
+	allowed := map[string][]string{
+		models.PurchaseStatusDraft:     {models.PurchaseStatusSubmitted, models.PurchaseStatusCancelled},
+		models.PurchaseStatusSubmitted: {models.PurchaseStatusApproved, models.PurchaseStatusRejected, models.PurchaseStatusCancelled},
+		models.PurchaseStatusApproved:  {models.PurchaseStatusOrdered, models.PurchaseStatusCancelled},
+		models.PurchaseStatusOrdered:   {models.PurchaseStatusReceived, models.PurchaseStatusCancelled},
+		models.PurchaseStatusReceived:  {models.PurchaseStatusClosed, models.PurchaseStatusCancelled},
+	}
+
+	// Every pair of statuses is either one of the edges above or refused;
+	// closed, cancelled and rejected purchases never move again
+	for _, from := range models.PurchaseStatuses {
+		for _, to := range models.PurchaseStatuses {
+			want := false
+			for _, s := range allowed[from] {
+				want = want || s == to
+			}
+			if got := CanTransition(from, to); got != want {
+				t.Errorf("CanTransition(%s, %s) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+
+	for _, pair := range [][2]string{
+		{"", models.PurchaseStatusSubmitted},
+		{models.PurchaseStatusDraft, ""},
+		{"Draft", models.PurchaseStatusSubmitted},
+		{models.PurchaseStatusDraft, "shipped"},
+	} {
+		if CanTransition(pair[0], pair[1]) {
+			t.Errorf("CanTransition(%q, %q) = true, want false", pair[0], pair[1])
+		}
+	}
+}
+
+func TestIsValidStatus(t *testing.T) {
+	for _, status := range models.PurchaseStatuses {
+		if !models.IsValidStatus(status) {
+			t.Errorf("IsValidStatus(%q) = false, want true", status)
+		}
+	}
+	for _, status := range []string{"", "Draft", "shipped", "draft "} {
+		if models.IsValidStatus(status) {
+			t.Errorf("IsValidStatus(%q) = true, want false", status)
+		}
+	}
+}
+
+// TestCancelPurchase checks that cancelling a purchase takes the goods
+// received for it out of stock again
+func TestCancelPurchase(t *testing.T) {
+	setupTestDB(t)
+	run := testRun(t)
+	user := createTestUser(t, run)
+	supplier := createTestSupplier(t, run)
+	item := createTestItem(t, run)
+
+	purchase := createOrderedPurchase(t, user, supplier, map[uint]int{item.ID: 10})
+	var detail models.PurchasingDetail
+	database.DB.Where("purchasing_id = ?", purchase.ID).First(&detail)
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		_, err := ReceivePurchaseGoods(tx, purchase.ID, []ReceiptLine{{PurchasingDetailID: detail.ID, Qty: 4}}, time.Now(), user.ID, "")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("receive goods: %v", err)
+	}
+	checkStock(t, item.ID, 4)
+
+	if _, err := cancelTestPurchase(purchase.ID, user.ID, ""); !isRejection(err) {
+		t.Errorf("cancel without a reason: err = %v, want a rejection", err)
+	}
+	checkStock(t, item.ID, 4)
+
+	cancelled, err := cancelTestPurchase(purchase.ID, user.ID, "Supplier went out of business")
+	if err != nil {
+		t.Fatalf("cancel purchase: %v", err)
+	}
+	if cancelled.Status != models.PurchaseStatusCancelled || cancelled.CancelledAt == nil {
+		t.Errorf("cancelled purchase: status = %q, cancelled at %v", cancelled.Status, cancelled.CancelledAt)
+	}
+	checkStock(t, item.ID, 0)
+
+	var history models.PurchasingStatus
+	database.DB.Where("purchasing_id = ?", purchase.ID).Order("id DESC").First(&history)
+	if history.ToStatus != models.PurchaseStatusCancelled || history.Note != "Supplier went out of business" {
+		t.Errorf("last status change = %+v, want the cancellation", history)
+	}
+
+	if _, err := cancelTestPurchase(purchase.ID, user.ID, "Again"); !isRejection(err) {
+		t.Errorf("cancel twice: err = %v, want a rejection", err)
+	}
+	checkStock(t, item.ID, 0)
+}
+
+// TestCancelPurchaseIssuedStock checks that a purchase whose goods have been
+// issued since cannot be cancelled
+func TestCancelPurchaseIssuedStock(t *testing.T) {
+	setupTestDB(t)
+	run := testRun(t)
+	user := createTestUser(t, run)
+	supplier := createTestSupplier(t, run)
+	item := createTestItem(t, run)
+
+	purchase := createOrderedPurchase(t, user, supplier, map[uint]int{item.ID: 3})
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		_, err := ReceivePurchaseGoods(tx, purchase.ID, nil, time.Now(), user.ID, "")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("receive goods: %v", err)
+	}
+	if _, err := MoveStock(database.DB, StockChange{ItemID: item.ID, Type: models.MovementIssue, Qty: -2}); err != nil {
+		t.Fatalf("issue stock: %v", err)
+	}
+
+	if _, err := cancelTestPurchase(purchase.ID, user.ID, "Ordered by mistake"); !isRejection(err) {
+		t.Errorf("cancel after issuing stock: err = %v, want a rejection", err)
+	}
+	checkStock(t, item.ID, 1)
+
+	var reloaded models.Purchasing
+	database.DB.First(&reloaded, purchase.ID)
+	if reloaded.Status != models.PurchaseStatusReceived {
+		t.Errorf("status after a failed cancellation = %q, want %q", reloaded.Status, models.PurchaseStatusReceived)
+	}
+}
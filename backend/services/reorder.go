@@ -0,0 +1,371 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"procurement-system/config"
+	"procurement-system/models"
+	"procurement-system/webhooks"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// reorderLockKey is the advisory lock that keeps concurrent reorder checks,
+// e.g. of several app instances, from suggesting the same purchases twice
+const reorderLockKey = 7_305_001
+
+// openPurchaseStatuses are the statuses of purchases whose outstanding
+// quantities are still expected to arrive
+var openPurchaseStatuses = []string{
+	models.PurchaseStatusDraft,
+	models.PurchaseStatusSubmitted,
+	models.PurchaseStatusApproved,
+	models.PurchaseStatusOrdered,
+}
+
+// LowStockThreshold returns the stock level at or below which an item with
+// the given reorder point is low on stock: the reorder point itself, or
+// LOW_STOCK_THRESHOLD for items without one
+func LowStockThreshold(reorderPoint int) int {
+	if reorderPoint > 0 {
+		return reorderPoint
+	}
+	return config.AppConfig.LowStockThreshold
+}
+
+// LowStockCondition is the scope form of LowStockThreshold: it keeps the
+// items whose stock is at or below their threshold
+func LowStockCondition(db *gorm.DB) *gorm.DB {
+	return db.Where("items.stock <= CASE WHEN items.reorder_point > 0 THEN items.reorder_point ELSE ? END",
+		config.AppConfig.LowStockThreshold)
+}
+
+// ReorderLine is an item whose stock is low
+type ReorderLine struct {
+	ItemID       uint   `json:"item_id"`
+	Name         string `json:"name"`
+	Stock        int    `json:"stock"`
+	OnOrder      int    `json:"on_order"`
+	ReorderPoint int    `json:"reorder_point"`
+	// Threshold is the level at or below which the stock is low
+	Threshold   int `json:"threshold"`
+	SafetyStock int `json:"safety_stock"`
+	ReorderQty  int `json:"reorder_qty"`
+	// BelowSafetyStock reports stock that has fallen into the safety stock
+	BelowSafetyStock bool `json:"below_safety_stock"`
+	// SuggestedQty is what to order on top of OnOrder; zero when enough is
+	// already on order or the item has no reorder point
+	SuggestedQty        int   `json:"suggested_qty"`
+	PreferredSupplierID *uint `json:"preferred_supplier_id"`
+}
+
+// CheckReorderSettings returns a ValidationError unless the replenishment
+// settings of item are consistent and its preferred supplier, if any, exists
+func CheckReorderSettings(tx *gorm.DB, item *models.Item) error {
+	if item.ReorderPoint < 0 || item.SafetyStock < 0 || item.ReorderQty < 0 {
+		return &ValidationError{Message: "Reorder point, safety stock and reorder qty cannot be negative"}
+	}
+	if item.SafetyStock > item.ReorderPoint {
+		return &ValidationError{Message: "Safety stock cannot exceed the reorder point"}
+	}
+
+	if item.PreferredSupplierID == nil {
+		return nil
+	}
+	var supplier models.Supplier
+	if err := tx.First(&supplier, *item.PreferredSupplierID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &ValidationError{Message: fmt.Sprintf("Supplier with ID %d not found", *item.PreferredSupplierID)}
+		}
+		return err
+	}
+	return nil
+}
+
+// LowStockItems returns every item that is low on stock as defined by
+// LowStockCondition, with what is on order from open purchases and the
+// quantity to order. Only items with a reorder point are reordered: once
+// their stock plus what is on order is at or below the reorder point, they
+// are ordered up to the reorder point plus the safety stock, but at least
+// their reorder quantity.
+func LowStockItems(db *gorm.DB) ([]ReorderLine, error) {
+	onOrder := db.Model(&models.PurchasingDetail{}).
+		Select("purchasing_details.item_id, SUM(purchasing_details.qty - purchasing_details.received_qty) AS qty").
+		Joins("JOIN purchasings ON purchasings.id = purchasing_details.purchasing_id AND purchasings.deleted_at IS NULL").
+		Where("purchasings.status IN ?", openPurchaseStatuses).
+		Group("purchasing_details.item_id")
+
+	var lines []ReorderLine
+	err := db.Model(&models.Item{}).
+		Select("items.id AS item_id, items.name, items.stock, COALESCE(o.qty, 0) AS on_order, items.reorder_point, items.safety_stock, items.reorder_qty, items.preferred_supplier_id").
+		Joins("LEFT JOIN (?) o ON o.item_id = items.id", onOrder).
+		Scopes(LowStockCondition).
+		Order("items.id").
+		Scan(&lines).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range lines {
+		line := &lines[i]
+		line.Threshold = LowStockThreshold(line.ReorderPoint)
+		line.BelowSafetyStock = line.Stock < line.SafetyStock
+
+		position := line.Stock + line.OnOrder
+		if line.ReorderPoint == 0 || position > line.ReorderPoint {
+			continue
+		}
+		line.SuggestedQty = line.ReorderPoint + line.SafetyStock - position
+		if line.SuggestedQty < line.ReorderQty {
+			line.SuggestedQty = line.ReorderQty
+		}
+		if line.SuggestedQty < 1 {
+			line.SuggestedQty = 1
+		}
+	}
+
+	// Furthest below the threshold first
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].Stock-lines[i].Threshold < lines[j].Stock-lines[j].Threshold
+	})
+	return lines, nil
+}
+
+// SuggestPurchases creates a draft purchase for every supplier that items in
+// need of reordering are bought from, on behalf of the system user, and
+// returns them. Items are bought from their preferred supplier, or else from
+// the cheapest supplier offering them today; items no supplier offers are
+// skipped. Quantities are raised to the supplier's minimum order quantity.
+// Suggested purchases are on order from then on, so running the check again
+// does not suggest the same quantities twice.
+func SuggestPurchases(tx *gorm.DB) ([]models.Purchasing, error) {
+	var locked bool
+	if err := tx.Raw("SELECT pg_try_advisory_xact_lock(?)", reorderLockKey).Scan(&locked).Error; err != nil {
+		return nil, err
+	}
+	if !locked {
+		return nil, &ConflictError{Message: "A reorder check is already running"}
+	}
+
+	lines, err := LowStockItems(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var system models.User
+	if err := tx.Where("username = ?", models.SystemUsername).First(&system).Error; err != nil {
+		return nil, fmt.Errorf("system user: %w", err)
+	}
+
+	now := time.Now()
+	bySupplier := make(map[uint][]PurchaseLineInput)
+	for _, line := range lines {
+		if line.SuggestedQty == 0 {
+			continue
+		}
+
+		entry, err := reorderSource(tx, line, now)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			log.Printf("Reorder check: no supplier offers item '%s'", line.Name)
+			continue
+		}
+
+		qty := line.SuggestedQty
+		if qty < entry.MinOrderQty {
+			qty = entry.MinOrderQty
+		}
+		bySupplier[entry.SupplierID] = append(bySupplier[entry.SupplierID], PurchaseLineInput{ItemID: line.ItemID, Qty: qty})
+	}
+
+	supplierIDs := make([]uint, 0, len(bySupplier))
+	for id := range bySupplier {
+		supplierIDs = append(supplierIDs, id)
+	}
+	sort.Slice(supplierIDs, func(i, j int) bool { return supplierIDs[i] < supplierIDs[j] })
+
+	var purchases []models.Purchasing
+	for _, supplierID := range supplierIDs {
+		var purchase models.Purchasing
+
+		// A supplier whose purchase cannot be priced, e.g. for want of an
+		// exchange rate, must not hold up the others
+		err := tx.Transaction(func(tx *gorm.DB) error {
+			return suggestPurchase(tx, &purchase, supplierID, bySupplier[supplierID], system.ID, now)
+		})
+		var validation *ValidationError
+		if errors.As(err, &validation) {
+			log.Printf("Reorder check: cannot suggest a purchase from supplier %d: %v", supplierID, err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		purchases = append(purchases, purchase)
+	}
+
+	return purchases, nil
+}
+
+// reorderSource returns the catalog entry an item in need of reordering is
+// bought under, or nil if no supplier offers it on date. Without a usable
+// preferred supplier the cheapest offer wins; prices are compared in the
+// base currency, and offers that cannot be converted on date are skipped.
+func reorderSource(tx *gorm.DB, line ReorderLine, date time.Time) (*models.SupplierItem, error) {
+	var validation *ValidationError
+	if line.PreferredSupplierID != nil {
+		entry, err := SupplierPrice(tx, *line.PreferredSupplierID, line.ItemID, date)
+		if err == nil {
+			return entry, nil
+		}
+		if !errors.As(err, &validation) {
+			return nil, err
+		}
+	}
+
+	day := date.Format("2006-01-02")
+
+	var entries []models.SupplierItem
+	err := tx.InnerJoins("Supplier").
+		Where("supplier_items.item_id = ?", line.ItemID).
+		Where("(valid_from IS NULL OR valid_from <= ?) AND (valid_to IS NULL OR valid_to >= ?)", day, day).
+		Order("supplier_items.id").
+		Find(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var cheapest *models.SupplierItem
+	var cheapestPrice models.Money
+	for i := range entries {
+		entry := &entries[i]
+
+		// The purchase is placed in the supplier's currency, so that needs
+		// a rate as well as the currency of the price
+		if _, err := ExchangeRateOn(tx, entry.Supplier.Currency, date); err != nil {
+			if errors.As(err, &validation) {
+				continue
+			}
+			return nil, err
+		}
+		price, err := ConvertMoney(tx, entry.UnitPrice, entry.Currency, config.AppConfig.BaseCurrency, date)
+		if err != nil {
+			if errors.As(err, &validation) {
+				continue
+			}
+			return nil, err
+		}
+
+		if cheapest == nil || price < cheapestPrice {
+			cheapest, cheapestPrice = entry, price
+		}
+	}
+	return cheapest, nil
+}
+
+// suggestPurchase creates a draft purchase of lines from a supplier in the
+// supplier's currency, for delivery to the default warehouse
+func suggestPurchase(tx *gorm.DB, purchase *models.Purchasing, supplierID uint, lines []PurchaseLineInput, userID uint, date time.Time) error {
+	var supplier models.Supplier
+	if err := tx.First(&supplier, supplierID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &ValidationError{Message: "Supplier not found"}
+		}
+		return err
+	}
+
+	warehouseID, err := ResolveWarehouse(tx, 0)
+	if err != nil {
+		return err
+	}
+
+	rate, err := ExchangeRateOn(tx, supplier.Currency, date)
+	if err != nil {
+		return err
+	}
+
+	*purchase = models.Purchasing{
+		Date:         date,
+		SupplierID:   supplier.ID,
+		WarehouseID:  warehouseID,
+		UserID:       userID,
+		Currency:     supplier.Currency,
+		ExchangeRate: rate,
+		Status:       models.PurchaseStatusDraft,
+		Suggested:    true,
+	}
+
+	details, err := PricePurchase(tx, purchase, lines, Discount{})
+	if err != nil {
+		return err
+	}
+
+	purchase.Number, err = NextDocumentNumber(tx, models.DocumentPurchaseOrder, config.AppConfig.PurchaseNumberFormat, purchase.Date)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Create(purchase).Error; err != nil {
+		return err
+	}
+	if err := RecordPurchaseStatus(tx, purchase.ID, "", models.PurchaseStatusDraft, userID, "Suggested by reorder check"); err != nil {
+		return err
+	}
+
+	for i := range details {
+		details[i].PurchasingID = purchase.ID
+	}
+	if err := tx.Create(&details).Error; err != nil {
+		return err
+	}
+
+	err = tx.Preload("Supplier").
+		Preload("Warehouse").
+		Preload("User").
+		Preload("PurchasingDetails.Item").
+		Preload("PurchasingDetails.TaxCode").
+		First(purchase, purchase.ID).Error
+	if err != nil {
+		return err
+	}
+	return webhooks.Publish(tx, webhooks.EventPurchaseCreated, webhooks.PurchasePayload(*purchase))
+}
+
+// StartReorderCheck suggests purchases for items at or below their reorder
+// point every REORDER_CHECK_INTERVAL in the background
+func StartReorderCheck(db *gorm.DB) {
+	interval := config.AppConfig.ReorderCheckInterval
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			var purchases []models.Purchasing
+			err := db.Transaction(func(tx *gorm.DB) error {
+				var err error
+				purchases, err = SuggestPurchases(tx)
+				return err
+			})
+			var conflict *ConflictError
+			if errors.As(err, &conflict) {
+				continue
+			}
+			if err != nil {
+				log.Printf("Reorder check error: %v", err)
+				continue
+			}
+			for _, purchase := range purchases {
+				log.Printf("Reorder check: suggested purchase %s", purchase.Number)
+			}
+		}
+	}()
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"procurement-system/config"
+	"procurement-system/database"
+	"procurement-system/models"
+	"testing"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// createTestRate records the rate of currency from 2000-01-01 on. Reruns
+// find it already there.
+func createTestRate(t *testing.T, currency string, rate models.Rate) {
+	t.Helper()
+	entry := models.ExchangeRate{
+		Currency:      currency,
+		EffectiveDate: time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC),
+		Rate:          rate,
+	}
+	if err := database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&entry).Error; err != nil {
+		t.Fatalf("create exchange rate: %v", err)
+	}
+}
+
+// TestReorderSource checks that offers in different currencies are compared
+// in the base currency and that offers that cannot be converted are skipped
+func TestReorderSource(t *testing.T) {
+	setupTestDB(t)
+	run := testRun(t)
+	item := createTestItem(t, run)
+
+	// XTA and XTB are test currencies worth 100 and 15,000 base units; XTC
+	// has no rate at all
+	createTestRate(t, "XTA", 100*models.OneRate)
+	createTestRate(t, "XTB", 15000*models.OneRate)
+
+	offers := []struct {
+		currency string
+		price    models.Money
+	}{
+		{"XTB", 500},   // 5.00 XTB is 75,000.00 in the base currency
+		{"XTA", 10000}, // 100.00 XTA is 10,000.00
+		{"XTC", 1},     // cheapest by number, but cannot be converted
+	}
+	suppliers := make(map[string]models.Supplier)
+	for _, offer := range offers {
+		supplier := models.Supplier{Name: run + "-" + offer.currency, Currency: offer.currency}
+		if err := database.DB.Create(&supplier).Error; err != nil {
+			t.Fatalf("create supplier: %v", err)
+		}
+		suppliers[offer.currency] = supplier
+
+		entry := models.SupplierItem{SupplierID: supplier.ID, ItemID: item.ID, UnitPrice: offer.price, Currency: offer.currency, MinOrderQty: 1}
+		if err := SaveSupplierItem(database.DB, &entry); err != nil {
+			t.Fatalf("save catalog entry: %v", err)
+		}
+	}
+
+	entry, err := reorderSource(database.DB, ReorderLine{ItemID: item.ID}, time.Now())
+	if err != nil {
+		t.Fatalf("reorder source: %v", err)
+	}
+	if entry == nil || entry.SupplierID != suppliers["XTA"].ID {
+		t.Errorf("reorder source = %+v, want the XTA supplier %d", entry, suppliers["XTA"].ID)
+	}
+
+	// A preferred supplier wins regardless of price
+	preferred := suppliers["XTB"].ID
+	entry, err = reorderSource(database.DB, ReorderLine{ItemID: item.ID, PreferredSupplierID: &preferred}, time.Now())
+	if err != nil {
+		t.Fatalf("reorder source: %v", err)
+	}
+	if entry == nil || entry.SupplierID != preferred {
+		t.Errorf("reorder source = %+v, want the preferred supplier %d", entry, preferred)
+	}
+
+	unlisted := createTestItem(t, run+"-unlisted")
+	entry, err = reorderSource(database.DB, ReorderLine{ItemID: unlisted.ID}, time.Now())
+	if err != nil || entry != nil {
+		t.Errorf("reorder source of an unlisted item = %+v, %v; want none", entry, err)
+	}
+}
+
+func TestLowStockThreshold(t *testing.T) {
+	useConfig(t, &config.Config{LowStockThreshold: 10})
+	for reorderPoint, want := range map[int]int{0: 10, 1: 1, 25: 25} {
+		if got := LowStockThreshold(reorderPoint); got != want {
+			t.Errorf("LowStockThreshold(%d) = %d, want %d", reorderPoint, got, want)
+		}
+	}
+}
+
+// TestLowStockItems checks that the low stock report, the item list filter
+// and the reorder suggestions agree on which items are low
+func TestLowStockItems(t *testing.T) {
+	setupTestDB(t)
+	run := testRun(t)
+
+	items := map[string]models.Item{
+		"unset, low":          {Name: run + "-unset-low", Stock: 10},
+		"unset, fine":         {Name: run + "-unset-fine", Stock: 11},
+		"reorder point, low":  {Name: run + "-point-low", Stock: 5, ReorderPoint: 5, ReorderQty: 20},
+		"reorder point, fine": {Name: run + "-point-fine", Stock: 6, ReorderPoint: 5},
+	}
+	for name, item := range items {
+		if err := database.DB.Create(&item).Error; err != nil {
+			t.Fatalf("create item: %v", err)
+		}
+		items[name] = item
+	}
+
+	lines, err := LowStockItems(database.DB)
+	if err != nil {
+		t.Fatalf("low stock items: %v", err)
+	}
+	listed := make(map[uint]ReorderLine)
+	for _, line := range lines {
+		listed[line.ItemID] = line
+	}
+
+	var filtered []uint
+	err = database.DB.Model(&models.Item{}).Scopes(LowStockCondition).Where("name LIKE ?", run+"-%").Pluck("id", &filtered).Error
+	if err != nil {
+		t.Fatalf("filter low items: %v", err)
+	}
+	inFilter := make(map[uint]bool)
+	for _, id := range filtered {
+		inFilter[id] = true
+	}
+
+	want := map[string]struct {
+		low       bool
+		threshold int
+		suggested int
+	}{
+		"unset, low":          {true, 10, 0},
+		"unset, fine":         {false, 0, 0},
+		"reorder point, low":  {true, 5, 20},
+		"reorder point, fine": {false, 0, 0},
+	}
+	for name, w := range want {
+		item := items[name]
+		line, ok := listed[item.ID]
+		if ok != w.low || inFilter[item.ID] != w.low {
+			t.Errorf("%s: in report = %v, in filter = %v; want %v", name, ok, inFilter[item.ID], w.low)
+			continue
+		}
+		if ok && (line.Threshold != w.threshold || line.SuggestedQty != w.suggested) {
+			t.Errorf("%s: threshold %d, suggested %d; want %d and %d", name, line.Threshold, line.SuggestedQty, w.threshold, w.suggested)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"procurement-system/models"
+	"procurement-system/webhooks"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// StockChange describes a change of an item's stock in a warehouse
+type StockChange struct {
+	ItemID        uint
+	WarehouseID   uint // zero for the default warehouse
+	Type          string
+	Qty           int // positive adds stock, negative removes it
+	ReferenceType string
+	ReferenceID   uint
+	UserID        uint // zero for changes made by the system
+	Note          string
+}
+
+// MoveStock applies change to the item's stock in the warehouse and to
+// Item.Stock, its total, and writes the matching ledger entry. Every change
+// of stock must go through this function so that the ledger always explains
+// the current stock levels.
+func MoveStock(tx *gorm.DB, change StockChange) (*models.StockMovement, error) {
+	return moveStock(tx, change, true)
+}
+
+// moveStock applies change to the item's stock in the warehouse, and to its
+// total unless the change is one leg of a transfer between warehouses
+func moveStock(tx *gorm.DB, change StockChange, changesTotal bool) (*models.StockMovement, error) {
+	if change.Qty == 0 {
+		return nil, &ValidationError{Message: "Stock movement qty cannot be zero"}
+	}
+
+	warehouse, err := stockWarehouse(tx, change.WarehouseID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply the change atomically: the condition is evaluated against the
+	// current row, so concurrent movements can neither lose an update nor
+	// take stock below zero. The item row stays locked until the
+	// transaction ends, which also serializes changes of its warehouse
+	// balances.
+	var item models.Item
+	if changesTotal {
+		result := tx.Model(&item).
+			Clauses(clause.Returning{Columns: []clause.Column{{Name: "id"}, {Name: "stock"}, {Name: "reorder_point"}}}).
+			Where("id = ? AND stock + ? >= 0", change.ItemID, change.Qty).
+			Update("stock", gorm.Expr("stock + ?", change.Qty))
+		if result.Error != nil {
+			return nil, result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			var current models.Item
+			if err := tx.Select("id", "name", "stock").First(&current, change.ItemID).Error; err != nil {
+				return nil, &ValidationError{Message: fmt.Sprintf("Item with ID %d not found", change.ItemID)}
+			}
+			return nil, &ConflictError{Message: fmt.Sprintf("Insufficient stock for item '%s'. Available: %d, Requested: %d", current.Name, current.Stock, -change.Qty)}
+		}
+	} else {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "stock").First(&item, change.ItemID).Error; err != nil {
+			return nil, &ValidationError{Message: fmt.Sprintf("Item with ID %d not found", change.ItemID)}
+		}
+	}
+
+	balance, err := moveWarehouseStock(tx, change, warehouse)
+	if err != nil {
+		return nil, err
+	}
+
+	movement := models.StockMovement{
+		ItemID:                change.ItemID,
+		WarehouseID:           warehouse.ID,
+		Type:                  change.Type,
+		Qty:                   change.Qty,
+		BalanceAfter:          item.Stock,
+		WarehouseBalanceAfter: balance,
+		ReferenceType:         change.ReferenceType,
+		ReferenceID:           change.ReferenceID,
+		Note:                  change.Note,
+	}
+	if change.UserID != 0 {
+		movement.UserID = &change.UserID
+	}
+
+	if err := tx.Create(&movement).Error; err != nil {
+		return nil, err
+	}
+
+	// Notify once when total stock drops to or below the item's reorder
+	// point, or the low stock threshold for items without one
+	threshold := LowStockThreshold(item.ReorderPoint)
+	if changesTotal && item.Stock <= threshold && item.Stock-change.Qty > threshold {
+		var lowItem models.Item
+		if err := tx.First(&lowItem, change.ItemID).Error; err != nil {
+			return nil, err
+		}
+
+		data := webhooks.ItemPayload(lowItem)
+		data["threshold"] = threshold
+		if err := webhooks.Publish(tx, webhooks.EventStockLow, data); err != nil {
+			return nil, err
+		}
+	}
+
+	return &movement, nil
+}
+
+// moveWarehouseStock applies change to the item's stock in warehouse and
+// returns the new balance there
+func moveWarehouseStock(tx *gorm.DB, change StockChange, warehouse *models.Warehouse) (int, error) {
+	var balances []int
+	if change.Qty > 0 {
+		err := tx.Raw(`INSERT INTO item_stocks (item_id, warehouse_id, stock, updated_at)
+			VALUES (?, ?, ?, NOW())
+			ON CONFLICT (item_id, warehouse_id) DO UPDATE
+			SET stock = item_stocks.stock + EXCLUDED.stock, updated_at = NOW()
+			RETURNING stock`, change.ItemID, warehouse.ID, change.Qty).Scan(&balances).Error
+		if err != nil {
+			return 0, err
+		}
+		return balances[0], nil
+	}
+
+	err := tx.Raw(`UPDATE item_stocks SET stock = stock + ?, updated_at = NOW()
+		WHERE item_id = ? AND warehouse_id = ? AND stock + ? >= 0
+		RETURNING stock`, change.Qty, change.ItemID, warehouse.ID, change.Qty).Scan(&balances).Error
+	if err != nil {
+		return 0, err
+	}
+	if len(balances) == 0 {
+		var item models.Item
+		if err := tx.Select("id", "name").First(&item, change.ItemID).Error; err != nil {
+			return 0, err
+		}
+		var current models.ItemStock
+		if err := tx.Where("item_id = ? AND warehouse_id = ?", change.ItemID, warehouse.ID).Find(&current).Error; err != nil {
+			return 0, err
+		}
+		return 0, &ConflictError{Message: fmt.Sprintf("Insufficient stock for item '%s' in warehouse %s. Available: %d, Requested: %d", item.Name, warehouse.Code, current.Stock, -change.Qty)}
+	}
+	return balances[0], nil
+}
+
+// stockWarehouse returns the warehouse identified by id, or the default
+// warehouse for a zero id
+func stockWarehouse(tx *gorm.DB, id uint) (*models.Warehouse, error) {
+	if id == 0 {
+		return DefaultWarehouse(tx)
+	}
+
+	var warehouse models.Warehouse
+	if err := tx.First(&warehouse, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &ValidationError{Message: fmt.Sprintf("Warehouse with ID %d not found", id)}
+		}
+		return nil, err
+	}
+	return &warehouse, nil
+}
+
+// StockDiscrepancy reports an item whose stock, in total or in one
+// warehouse, does not match its ledger
+type StockDiscrepancy struct {
+	ItemID      uint   `json:"item_id"`
+	Name        string `json:"name"`
+	WarehouseID *uint  `json:"warehouse_id,omitempty"`
+	Warehouse   string `json:"warehouse,omitempty"`
+	Stock       int    `json:"stock"`
+	LedgerStock int    `json:"ledger_stock"`
+	Difference  int    `json:"difference"`
+}
+
+// ReconcileStock compares Item.Stock of every item, and its stock in every
+// warehouse, with the sum of its ledger entries and returns those that
+// disagree
+func ReconcileStock(db *gorm.DB) ([]StockDiscrepancy, error) {
+	var discrepancies []StockDiscrepancy
+	err := db.Model(&models.Item{}).
+		Select("items.id AS item_id, items.name, items.stock, COALESCE(SUM(stock_movements.qty), 0) AS ledger_stock, items.stock - COALESCE(SUM(stock_movements.qty), 0) AS difference").
+		Joins("LEFT JOIN stock_movements ON stock_movements.item_id = items.id").
+		Group("items.id, items.name, items.stock").
+		Having("items.stock <> COALESCE(SUM(stock_movements.qty), 0)").
+		Order("items.id").
+		Scan(&discrepancies).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var locations []StockDiscrepancy
+	err = db.Raw(`SELECT i.id AS item_id, i.name, w.id AS warehouse_id, w.code AS warehouse,
+			COALESCE(s.stock, 0) AS stock, COALESCE(l.qty, 0) AS ledger_stock,
+			COALESCE(s.stock, 0) - COALESCE(l.qty, 0) AS difference
+		FROM item_stocks s
+		FULL OUTER JOIN (SELECT item_id, warehouse_id, SUM(qty) AS qty FROM stock_movements GROUP BY item_id, warehouse_id) l
+			ON l.item_id = s.item_id AND l.warehouse_id = s.warehouse_id
+		JOIN items i ON i.id = COALESCE(s.item_id, l.item_id)
+		LEFT JOIN warehouses w ON w.id = COALESCE(s.warehouse_id, l.warehouse_id)
+		WHERE COALESCE(s.stock, 0) <> COALESCE(l.qty, 0)
+		ORDER BY i.id, w.id`).Scan(&locations).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return append(discrepancies, locations...), nil
+}
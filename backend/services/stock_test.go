@@ -0,0 +1,279 @@
+package services
+
+import (
+	"errors"
+	"math/rand"
+	"procurement-system/database"
+	"procurement-system/models"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// concurrentWorkers is how many goroutines the concurrency tests use
+const concurrentWorkers = 32
+
+// TestMoveStockRecordsLedger checks that every accepted movement writes a
+// ledger entry with the running balance and that rejected ones write none
+func TestMoveStockRecordsLedger(t *testing.T) {
+	setupTestDB(t)
+	run := testRun(t)
+	user := createTestUser(t, run)
+	item := createTestItem(t, run)
+
+	changes := []struct {
+		qty     int
+		balance int
+	}{{10, 10}, {-4, 6}, {3, 9}}
+	for _, c := range changes {
+		change := StockChange{
+			ItemID:        item.ID,
+			Type:          models.MovementAdjustment,
+			Qty:           c.qty,
+			ReferenceType: models.MovementRefItem,
+			ReferenceID:   item.ID,
+			UserID:        user.ID,
+		}
+		movement, err := MoveStock(database.DB, change)
+		if err != nil {
+			t.Fatalf("move %d: %v", c.qty, err)
+		}
+		if movement.BalanceAfter != c.balance || movement.WarehouseBalanceAfter != c.balance {
+			t.Errorf("move %d: balance after = %d/%d, want %d", c.qty, movement.BalanceAfter, movement.WarehouseBalanceAfter, c.balance)
+		}
+	}
+
+	for _, qty := range []int{0, -10} {
+		_, err := MoveStock(database.DB, StockChange{ItemID: item.ID, Type: models.MovementIssue, Qty: qty})
+		if !isRejection(err) {
+			t.Errorf("move %d: err = %v, want a rejection", qty, err)
+		}
+	}
+
+	var count int64
+	database.DB.Model(&models.StockMovement{}).Where("item_id = ?", item.ID).Count(&count)
+	if count != int64(len(changes)) {
+		t.Errorf("%d ledger entries, want %d", count, len(changes))
+	}
+	checkStock(t, item.ID, 9)
+
+	var movement models.StockMovement
+	database.DB.Where("item_id = ?", item.ID).First(&movement)
+	if err := database.DB.Model(&movement).Update("qty", 100).Error; !errors.Is(err, models.ErrImmutableMovement) {
+		t.Errorf("update ledger entry: err = %v, want %v", err, models.ErrImmutableMovement)
+	}
+}
+
+// TestReconcileStock checks that stock changed behind the ledger's back is
+// reported
+func TestReconcileStock(t *testing.T) {
+	setupTestDB(t)
+	run := testRun(t)
+	item := createTestItem(t, run)
+
+	if _, err := MoveStock(database.DB, StockChange{ItemID: item.ID, Type: models.MovementReceipt, Qty: 5}); err != nil {
+		t.Fatalf("move stock: %v", err)
+	}
+	if found := findDiscrepancies(t, item.ID); len(found) != 0 {
+		t.Fatalf("discrepancies before tampering = %+v, want none", found)
+	}
+
+	database.DB.Model(&models.Item{}).Where("id = ?", item.ID).Update("stock", 7)
+	found := findDiscrepancies(t, item.ID)
+	if len(found) != 1 || found[0].WarehouseID != nil || found[0].Difference != 2 {
+		t.Errorf("discrepancies = %+v, want one total difference of 2", found)
+	}
+}
+
+// findDiscrepancies returns the reconciliation results for one item
+func findDiscrepancies(t *testing.T, itemID uint) []StockDiscrepancy {
+	t.Helper()
+	all, err := ReconcileStock(database.DB)
+	if err != nil {
+		t.Fatalf("reconcile stock: %v", err)
+	}
+
+	var found []StockDiscrepancy
+	for _, d := range all {
+		if d.ItemID == itemID {
+			found = append(found, d)
+		}
+	}
+	return found
+}
+
+// TestReceiveGoodsConcurrently receives one unit at a time from many
+// workers against a single purchase line; exactly its qty may succeed
+func TestReceiveGoodsConcurrently(t *testing.T) {
+	setupTestDB(t)
+	run := testRun(t)
+	user := createTestUser(t, run)
+	supplier := createTestSupplier(t, run)
+
+	const qty = 20
+	item := createTestItem(t, run)
+	purchase := createOrderedPurchase(t, user, supplier, map[uint]int{item.ID: qty})
+
+	var detail models.PurchasingDetail
+	database.DB.Where("purchasing_id = ?", purchase.ID).First(&detail)
+
+	var succeeded int64
+	attempts := qty * 3
+	parallel(concurrentWorkers, attempts, func(int) {
+		err := database.Transaction(func(tx *gorm.DB) error {
+			_, err := ReceivePurchaseGoods(tx, purchase.ID, []ReceiptLine{{PurchasingDetailID: detail.ID, Qty: 1}}, time.Now(), user.ID, "")
+			return err
+		})
+		if err == nil {
+			atomic.AddInt64(&succeeded, 1)
+		} else if !isRejection(err) {
+			t.Errorf("unexpected receipt error: %v", err)
+		}
+	})
+
+	if succeeded != qty {
+		t.Errorf("%d of %d receipts succeeded, want %d", succeeded, attempts, qty)
+	}
+
+	database.DB.First(&detail, detail.ID)
+	if detail.ReceivedQty != qty {
+		t.Errorf("received_qty = %d, want %d", detail.ReceivedQty, qty)
+	}
+
+	database.DB.First(&purchase, purchase.ID)
+	if purchase.Status != models.PurchaseStatusReceived {
+		t.Errorf("purchase status = %s, want %s", purchase.Status, models.PurchaseStatusReceived)
+	}
+
+	checkStock(t, item.ID, qty)
+}
+
+// TestReceiveCrossedPurchasesConcurrently receives purchases that contain
+// the same pair of items at the same time, which deadlocks unless rows are
+// locked in order
+func TestReceiveCrossedPurchasesConcurrently(t *testing.T) {
+	setupTestDB(t)
+	run := testRun(t)
+	user := createTestUser(t, run)
+	supplier := createTestSupplier(t, run)
+
+	first := createTestItem(t, run+"-a")
+	second := createTestItem(t, run+"-b")
+
+	purchases := make([]models.Purchasing, concurrentWorkers)
+	for i := range purchases {
+		purchases[i] = createOrderedPurchase(t, user, supplier, map[uint]int{first.ID: 1, second.ID: 1})
+	}
+
+	parallel(concurrentWorkers, len(purchases), func(i int) {
+		err := database.Transaction(func(tx *gorm.DB) error {
+			_, err := ReceivePurchaseGoods(tx, purchases[i].ID, nil, time.Now(), user.ID, "")
+			return err
+		})
+		if err != nil {
+			t.Errorf("receive purchase %d: %v", purchases[i].ID, err)
+		}
+	})
+
+	checkStock(t, first.ID, len(purchases))
+	checkStock(t, second.ID, len(purchases))
+}
+
+// TestMoveStockConcurrently mixes receipts and issues on one item. Issues
+// that would take stock below zero must be rejected, and every accepted
+// movement must be reflected in the final stock.
+func TestMoveStockConcurrently(t *testing.T) {
+	setupTestDB(t)
+	run := testRun(t)
+	user := createTestUser(t, run)
+	item := createTestItem(t, run)
+
+	const ops = 50
+	var expected int64
+	parallel(concurrentWorkers, concurrentWorkers*ops, func(int) {
+		qty := rand.Intn(5) + 1
+		change := StockChange{
+			ItemID:        item.ID,
+			Type:          models.MovementReceipt,
+			Qty:           qty,
+			ReferenceType: models.MovementRefItem,
+			ReferenceID:   item.ID,
+			UserID:        user.ID,
+		}
+		if rand.Intn(2) == 0 {
+			change.Type = models.MovementIssue
+			change.Qty = -qty
+		}
+
+		err := database.Transaction(func(tx *gorm.DB) error {
+			_, err := MoveStock(tx, change)
+			return err
+		})
+		if err == nil {
+			atomic.AddInt64(&expected, int64(change.Qty))
+		} else if !isRejection(err) {
+			t.Errorf("unexpected movement error: %v", err)
+		}
+	})
+
+	checkStock(t, item.ID, int(expected))
+}
+
+// checkStock verifies the final stock of an item against the expected value
+// and its ledger
+func checkStock(t *testing.T, itemID uint, expected int) {
+	t.Helper()
+
+	var item models.Item
+	database.DB.First(&item, itemID)
+	if item.Stock != expected {
+		t.Errorf("item %d: stock = %d, want %d", itemID, item.Stock, expected)
+	}
+
+	var ledger struct {
+		Total      int
+		MinBalance int
+	}
+	database.DB.Model(&models.StockMovement{}).
+		Select("COALESCE(SUM(qty), 0) AS total, COALESCE(MIN(balance_after), 0) AS min_balance").
+		Where("item_id = ?", itemID).
+		Scan(&ledger)
+	if ledger.Total != item.Stock {
+		t.Errorf("item %d: ledger sum = %d, stock = %d", itemID, ledger.Total, item.Stock)
+	}
+	if ledger.MinBalance < 0 {
+		t.Errorf("item %d: stock went negative (%d)", itemID, ledger.MinBalance)
+	}
+}
+
+// isRejection reports whether err is a business rule rejection rather than
+// a failure
+func isRejection(err error) bool {
+	var conflictErr *ConflictError
+	var validationErr *ValidationError
+	return errors.As(err, &conflictErr) || errors.As(err, &validationErr)
+}
+
+// parallel runs fn for every index in [0, n) using the given number of workers
+func parallel(workers, n int, fn func(i int)) {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"fmt"
+	"procurement-system/config"
+	"procurement-system/models"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+// TransferLine is the quantity of one item moved between warehouses
+type TransferLine struct {
+	ItemID uint
+	Qty    int
+}
+
+// TransferStock records transfer and moves the quantities of lines out of
+// its source warehouse and into its destination. The item totals do not
+// change. Fails without moving anything if the source lacks any of the
+// quantities.
+func TransferStock(tx *gorm.DB, transfer *models.StockTransfer, lines []TransferLine) error {
+	if len(lines) == 0 {
+		return &ValidationError{Message: "At least one item is required"}
+	}
+	if transfer.FromWarehouseID == 0 || transfer.ToWarehouseID == 0 {
+		return &ValidationError{Message: "Source and destination warehouses are required"}
+	}
+	if transfer.FromWarehouseID == transfer.ToWarehouseID {
+		return &ValidationError{Message: "Source and destination warehouses must differ"}
+	}
+
+	from, err := stockWarehouse(tx, transfer.FromWarehouseID)
+	if err != nil {
+		return err
+	}
+	to, err := stockWarehouse(tx, transfer.ToWarehouseID)
+	if err != nil {
+		return err
+	}
+
+	// Touch items in a consistent order so that concurrent stock changes
+	// lock item rows in the same sequence and cannot deadlock each other
+	lines = append([]TransferLine(nil), lines...)
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].ItemID < lines[j].ItemID })
+
+	seen := make(map[uint]bool, len(lines))
+	for _, line := range lines {
+		if line.Qty <= 0 {
+			return &ValidationError{Message: "Transferred qty must be positive"}
+		}
+		if seen[line.ItemID] {
+			return &ValidationError{Message: fmt.Sprintf("Item with ID %d is listed twice", line.ItemID)}
+		}
+		seen[line.ItemID] = true
+	}
+
+	number, err := NextDocumentNumber(tx, models.DocumentStockTransfer, config.AppConfig.StockTransferNumberFormat, transfer.Date)
+	if err != nil {
+		return err
+	}
+	transfer.Number = number
+
+	if err := tx.Create(transfer).Error; err != nil {
+		return err
+	}
+
+	for _, line := range lines {
+		detail := models.StockTransferDetail{
+			StockTransferID: transfer.ID,
+			ItemID:          line.ItemID,
+			Qty:             line.Qty,
+		}
+		if err := tx.Create(&detail).Error; err != nil {
+			return err
+		}
+
+		legs := []StockChange{
+			{WarehouseID: from.ID, Qty: -line.Qty, Note: fmt.Sprintf("Transfer %s to %s", transfer.Number, to.Code)},
+			{WarehouseID: to.ID, Qty: line.Qty, Note: fmt.Sprintf("Transfer %s from %s", transfer.Number, from.Code)},
+		}
+		for _, leg := range legs {
+			leg.ItemID = line.ItemID
+			leg.Type = models.MovementTransfer
+			leg.ReferenceType = models.MovementRefStockTransfer
+			leg.ReferenceID = transfer.ID
+			leg.UserID = transfer.UserID
+			if _, err := moveStock(tx, leg, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
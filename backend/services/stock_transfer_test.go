@@ -0,0 +1,157 @@
+package services
+
+import (
+	"fmt"
+	"procurement-system/database"
+	"procurement-system/models"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// warehouseStock returns the stock of item in the warehouse identified by
+// warehouseID
+func warehouseStock(t *testing.T, itemID, warehouseID uint) int {
+	t.Helper()
+	var stock models.ItemStock
+	err := database.DB.Where("item_id = ? AND warehouse_id = ?", itemID, warehouseID).Limit(1).Find(&stock).Error
+	if err != nil {
+		t.Fatalf("warehouse stock: %v", err)
+	}
+	return stock.Stock
+}
+
+// transferTestStock moves lines from one warehouse to another
+func transferTestStock(fromID, toID, userID uint, lines []TransferLine) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		transfer := models.StockTransfer{Date: time.Now(), FromWarehouseID: fromID, ToWarehouseID: toID, UserID: userID}
+		return TransferStock(tx, &transfer, lines)
+	})
+}
+
+// TestTransferStock moves stock between the default warehouse and a new
+// one and checks that the item total never changes
+func TestTransferStock(t *testing.T) {
+	setupTestDB(t)
+	run := testRun(t)
+	user := createTestUser(t, run)
+	item := createTestItem(t, run)
+
+	main, err := DefaultWarehouse(database.DB)
+	if err != nil {
+		t.Fatalf("default warehouse: %v", err)
+	}
+	// Codes are short, so they are made unique by time alone
+	code := fmt.Sprintf("t%d", time.Now().UnixNano()%1e15)
+	annex := models.Warehouse{Code: " " + code + " ", Name: "Annex"}
+	if err := SaveWarehouse(database.DB, &annex); err != nil {
+		t.Fatalf("save warehouse: %v", err)
+	}
+
+	invalid := map[string]models.Warehouse{
+		"no code":        {Name: "Annex"},
+		"no name":        {Code: code + "x"},
+		"duplicate code": {Code: code, Name: "Annex"},
+	}
+	for name, warehouse := range invalid {
+		if err := SaveWarehouse(database.DB, &warehouse); !isRejection(err) {
+			t.Errorf("%s: err = %v, want a rejection", name, err)
+		}
+	}
+
+	if _, err := MoveStock(database.DB, StockChange{ItemID: item.ID, Type: models.MovementReceipt, Qty: 5}); err != nil {
+		t.Fatalf("receive stock: %v", err)
+	}
+	if err := transferTestStock(main.ID, annex.ID, user.ID, []TransferLine{{ItemID: item.ID, Qty: 3}}); err != nil {
+		t.Fatalf("transfer stock: %v", err)
+	}
+	checkStock(t, item.ID, 5)
+	if inMain, inAnnex := warehouseStock(t, item.ID, main.ID), warehouseStock(t, item.ID, annex.ID); inMain != 2 || inAnnex != 3 {
+		t.Errorf("stock after transfer = %d and %d, want 2 and 3", inMain, inAnnex)
+	}
+
+	rejected := map[string]struct {
+		from, to uint
+		lines    []TransferLine
+	}{
+		"no lines":          {main.ID, annex.ID, nil},
+		"same warehouse":    {annex.ID, annex.ID, []TransferLine{{ItemID: item.ID, Qty: 1}}},
+		"zero qty":          {annex.ID, main.ID, []TransferLine{{ItemID: item.ID, Qty: 0}}},
+		"item listed twice": {annex.ID, main.ID, []TransferLine{{ItemID: item.ID, Qty: 1}, {ItemID: item.ID, Qty: 1}}},
+		"more than held":    {annex.ID, main.ID, []TransferLine{{ItemID: item.ID, Qty: 4}}},
+	}
+	for name, transfer := range rejected {
+		if err := transferTestStock(transfer.from, transfer.to, user.ID, transfer.lines); !isRejection(err) {
+			t.Errorf("%s: err = %v, want a rejection", name, err)
+		}
+	}
+	if inMain, inAnnex := warehouseStock(t, item.ID, main.ID), warehouseStock(t, item.ID, annex.ID); inMain != 2 || inAnnex != 3 {
+		t.Errorf("stock after rejected transfers = %d and %d, want 2 and 3", inMain, inAnnex)
+	}
+
+	if err := DeleteWarehouse(database.DB, &annex); !isRejection(err) {
+		t.Errorf("delete a warehouse holding stock: err = %v, want a rejection", err)
+	}
+	if err := transferTestStock(annex.ID, main.ID, user.ID, []TransferLine{{ItemID: item.ID, Qty: 3}}); err != nil {
+		t.Fatalf("transfer stock back: %v", err)
+	}
+	if err := DeleteWarehouse(database.DB, &annex); err != nil {
+		t.Errorf("delete an empty warehouse: %v", err)
+	}
+	checkStock(t, item.ID, 5)
+
+	if err := DeleteWarehouse(database.DB, main); !isRejection(err) {
+		t.Errorf("delete the default warehouse: err = %v, want a rejection", err)
+	}
+	undefaulted := *main
+	undefaulted.IsDefault = false
+	if err := SaveWarehouse(database.DB, &undefaulted); !isRejection(err) {
+		t.Errorf("take the default flag away: err = %v, want a rejection", err)
+	}
+}
+
+// TestSaveDefaultWarehouseConcurrently makes several warehouses the default
+// at once and checks that exactly one of them ends up with the flag
+func TestSaveDefaultWarehouseConcurrently(t *testing.T) {
+	setupTestDB(t)
+
+	main, err := DefaultWarehouse(database.DB)
+	if err != nil {
+		t.Fatalf("default warehouse: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := SaveWarehouse(database.DB, main); err != nil {
+			t.Errorf("restore the default warehouse: %v", err)
+		}
+	})
+
+	warehouses := make([]models.Warehouse, 4)
+	for i := range warehouses {
+		warehouses[i] = models.Warehouse{Code: fmt.Sprintf("d%d%d", time.Now().UnixNano()%1e15, i), Name: "Candidate"}
+		if err := SaveWarehouse(database.DB, &warehouses[i]); err != nil {
+			t.Fatalf("save warehouse: %v", err)
+		}
+	}
+
+	errs := make([]error, len(warehouses))
+	parallel(len(warehouses), len(warehouses), func(i int) {
+		errs[i] = database.DB.Transaction(func(tx *gorm.DB) error {
+			warehouses[i].IsDefault = true
+			return SaveWarehouse(tx, &warehouses[i])
+		})
+	})
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("make warehouse %d the default: %v", i, err)
+		}
+	}
+
+	var defaults int64
+	if err := database.DB.Model(&models.Warehouse{}).Where("is_default = ?", true).Count(&defaults).Error; err != nil {
+		t.Fatalf("count defaults: %v", err)
+	}
+	if defaults != 1 {
+		t.Errorf("%d default warehouses, want 1", defaults)
+	}
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"procurement-system/models"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// SaveTaxCode validates a tax code and creates or updates it
+func SaveTaxCode(tx *gorm.DB, code *models.TaxCode) error {
+	code.Code = strings.ToUpper(strings.TrimSpace(code.Code))
+	code.Name = strings.TrimSpace(code.Name)
+
+	if code.Code == "" {
+		return &ValidationError{Message: "Tax code is required"}
+	}
+	if code.Name == "" {
+		return &ValidationError{Message: "Tax code name is required"}
+	}
+	if code.Rate < 0 || code.Rate.Rat().Cmp(hundred) > 0 {
+		return &ValidationError{Message: "Tax rate must be a percentage between 0 and 100"}
+	}
+	if code.Inclusive && code.Withholding {
+		return &ValidationError{Message: "A withholding tax cannot be included in prices"}
+	}
+
+	var count int64
+	if err := tx.Unscoped().Model(&models.TaxCode{}).Where("code = ? AND id <> ?", code.Code, code.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return &ConflictError{Message: fmt.Sprintf("Tax code %s already exists", code.Code)}
+	}
+
+	return tx.Save(code).Error
+}
+
+// CheckTaxCode returns a ValidationError unless id is nil or refers to an
+// existing tax code
+func CheckTaxCode(tx *gorm.DB, id *uint) error {
+	if id == nil {
+		return nil
+	}
+
+	var code models.TaxCode
+	if err := tx.First(&code, *id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &ValidationError{Message: fmt.Sprintf("Tax code with ID %d not found", *id)}
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"procurement-system/config"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minSecretLength is the shortest JWT_SECRET accepted, in bytes
+const minSecretLength = 32
+
+// insecureSecrets are JWT_SECRET values from defaults and examples that must
+// never sign tokens
+var insecureSecrets = []string{
+	"secret",
+	"change-me",
+	"changeme",
+	"your-super-secret-jwt-key",
+	"your-super-secret-jwt-key-change-in-production",
+}
+
+// tokenKey is a key access tokens are signed or verified with
+type tokenKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	// Private signs tokens; it is nil for keys that only verify
+	Private crypto.PrivateKey
+	// Public verifies tokens; for HMAC it is the secret
+	Public interface{}
+	// JWK is the public key as published in the JWKS; nil for HMAC
+	JWK map[string]string
+}
+
+// tokenKeys are the keys loaded by LoadTokenKeys
+var tokenKeys struct {
+	signing *tokenKey
+	// keys are every key tokens are verified with, the signing key first
+	keys []*tokenKey
+	byID map[string]*tokenKey
+}
+
+// LoadTokenKeys loads the key access tokens are signed with and the keys
+// they are verified with. With JWT_SIGNING_KEY set, tokens are signed with
+// that RSA (RS256) or Ed25519 (EdDSA) private key and verified with it or any
+// of JWT_VERIFICATION_KEYS, which keeps tokens signed with a previous key
+// valid while keys are rotated. Otherwise tokens are signed with JWT_SECRET
+// (HS256), which must not be a known default and must be long enough.
+func LoadTokenKeys() error {
+	byID := make(map[string]*tokenKey)
+
+	if config.AppConfig.JWTSigningKey == "" {
+		secret := config.AppConfig.JWTSecret
+		if err := checkSecret(secret); err != nil {
+			return err
+		}
+		if len(config.AppConfig.JWTVerificationKeys) > 0 {
+			return errors.New("JWT_VERIFICATION_KEYS requires JWT_SIGNING_KEY")
+		}
+		key := &tokenKey{Method: jwt.SigningMethodHS256, Private: []byte(secret), Public: []byte(secret)}
+		byID[key.ID] = key
+		tokenKeys.signing, tokenKeys.keys, tokenKeys.byID = key, []*tokenKey{key}, byID
+		return nil
+	}
+
+	if config.AppConfig.JWTSecret != "" {
+		log.Println("Warning: JWT_SECRET is ignored as JWT_SIGNING_KEY is set")
+	}
+
+	signing, err := loadKeyFile(config.AppConfig.JWTSigningKey)
+	if err != nil {
+		return fmt.Errorf("JWT_SIGNING_KEY: %w", err)
+	}
+	if signing.Private == nil {
+		return errors.New("JWT_SIGNING_KEY must be a private key")
+	}
+	byID[signing.ID] = signing
+	keys := []*tokenKey{signing}
+
+	for _, path := range config.AppConfig.JWTVerificationKeys {
+		key, err := loadKeyFile(path)
+		if err != nil {
+			return fmt.Errorf("JWT_VERIFICATION_KEYS: %w", err)
+		}
+		// Only the signing key signs; the others merely verify
+		key.Private = nil
+		if _, ok := byID[key.ID]; !ok {
+			byID[key.ID] = key
+			keys = append(keys, key)
+		}
+	}
+
+	tokenKeys.signing, tokenKeys.keys, tokenKeys.byID = signing, keys, byID
+	return nil
+}
+
+// checkSecret returns an error unless secret is fit to sign HS256 tokens
+func checkSecret(secret string) error {
+	for _, insecure := range insecureSecrets {
+		if strings.EqualFold(secret, insecure) {
+			return errors.New("JWT_SECRET is set to an insecure default; set JWT_SIGNING_KEY or a random JWT_SECRET")
+		}
+	}
+	if len(secret) < minSecretLength {
+		return fmt.Errorf("JWT_SECRET must be at least %d bytes; set JWT_SIGNING_KEY or a longer JWT_SECRET", minSecretLength)
+	}
+	return nil
+}
+
+// loadKeyFile reads an RSA or Ed25519 key from a PEM file. Private keys may
+// be PKCS #8 or PKCS #1, public keys PKIX or PKCS #1.
+func loadKeyFile(path string) (*tokenKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	key := &tokenKey{}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Private, key.Public = k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Public = k
+	case ed25519.PrivateKey:
+		key.Private, key.Public = k, k.Public()
+	case ed25519.PublicKey:
+		key.Public = k
+	default:
+		return nil, fmt.Errorf("%s: only RSA and Ed25519 keys are supported", path)
+	}
+
+	switch pub := key.Public.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("%s: RSA keys must be at least 2048 bits", path)
+		}
+		key.Method = jwt.SigningMethodRS256
+		key.JWK = map[string]string{
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+		key.JWK = map[string]string{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"x":   base64.RawURLEncoding.EncodeToString(pub),
+		}
+	}
+
+	key.ID = thumbprint(key.JWK)
+	key.JWK["kid"] = key.ID
+	key.JWK["alg"] = key.Method.Alg()
+	key.JWK["use"] = "sig"
+	return key, nil
+}
+
+// thumbprint returns the RFC 7638 thumbprint of a public JWK, which serves
+// as its key ID. json.Marshal sorts map keys, giving the canonical form.
+func thumbprint(jwk map[string]string) string {
+	data, _ := json.Marshal(jwk)
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// signToken signs claims with the signing key, naming it in the kid header
+func signToken(claims jwt.Claims) (string, error) {
+	key := tokenKeys.signing
+	if key == nil {
+		return "", errors.New("token keys are not loaded")
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+	return token.SignedString(key.Private)
+}
+
+// verificationKey is the jwt.Keyfunc that picks the key a token is verified
+// with by its kid header and refuses algorithms the key is not for
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := tokenKeys.byID[kid]
+	if !ok {
+		return nil, errors.New("unknown signing key")
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, errors.New("invalid signing method")
+	}
+	return key.Public, nil
+}
+
+// JWKS returns the public keys access tokens are verified with as a JSON
+// Web Key Set, for other services to verify tokens with. It is empty when
+// tokens are signed with JWT_SECRET.
+func JWKS() map[string]interface{} {
+	keys := make([]map[string]string, 0, len(tokenKeys.keys))
+	for _, key := range tokenKeys.keys {
+		if key.JWK != nil {
+			keys = append(keys, key.JWK)
+		}
+	}
+	return map[string]interface{}{"keys": keys}
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"procurement-system/config"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// useTokenConfig makes cfg the configuration token keys are loaded from
+// until t ends, and restores the keys loaded before
+func useTokenConfig(t *testing.T, cfg *config.Config) {
+	t.Helper()
+	previous := tokenKeys
+	t.Cleanup(func() { tokenKeys = previous })
+	useConfig(t, cfg)
+}
+
+// writeKey writes a PEM block of type blockType holding der to a file in
+// dir and returns its path
+func writeKey(t *testing.T, dir, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return path
+}
+
+// writeEd25519Key writes a new Ed25519 key pair to dir and returns the
+// paths of its private and public key files
+func writeEd25519Key(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	return writeKey(t, dir, name+".pem", "PRIVATE KEY", privateDER), writeKey(t, dir, name+".pub", "PUBLIC KEY", publicDER)
+}
+
+// verifyTestToken parses raw the way ParseAccessToken does, without
+// looking up the revocation list
+func verifyTestToken(raw string) error {
+	_, err := jwt.Parse(raw, verificationKey)
+	return err
+}
+
+func TestLoadTokenKeysSecret(t *testing.T) {
+	secrets := map[string]bool{
+		"change-me":                         false,
+		"Your-Super-Secret-JWT-Key":         false,
+		"too-short":                         false,
+		"a sufficiently long random secret": true,
+	}
+	for secret, valid := range secrets {
+		useTokenConfig(t, &config.Config{JWTSecret: secret})
+		if err := LoadTokenKeys(); (err == nil) != valid {
+			t.Errorf("secret %q: err = %v, want valid %v", secret, err, valid)
+		}
+	}
+
+	useTokenConfig(t, &config.Config{JWTSecret: "a sufficiently long random secret"})
+	if err := LoadTokenKeys(); err != nil {
+		t.Fatalf("load secret: %v", err)
+	}
+	raw, err := signToken(jwt.MapClaims{"sub": "test"})
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	if err := verifyTestToken(raw); err != nil {
+		t.Errorf("verify token: %v", err)
+	}
+	if keys := JWKS()["keys"].([]map[string]string); len(keys) != 0 {
+		t.Errorf("JWKS of a secret lists %d keys, want none", len(keys))
+	}
+
+	useTokenConfig(t, &config.Config{JWTSecret: "a sufficiently long random secret", JWTVerificationKeys: []string{"old.pub"}})
+	if err := LoadTokenKeys(); err == nil {
+		t.Error("verification keys without a signing key were accepted")
+	}
+}
+
+// TestLoadTokenKeysRotation checks that tokens signed with a previous key
+// stay valid while it is listed as a verification key
+func TestLoadTokenKeysRotation(t *testing.T) {
+	dir := t.TempDir()
+	oldPrivate, oldPublic := writeEd25519Key(t, dir, "old")
+	newPrivate, _ := writeEd25519Key(t, dir, "new")
+
+	useTokenConfig(t, &config.Config{JWTSigningKey: oldPrivate})
+	if err := LoadTokenKeys(); err != nil {
+		t.Fatalf("load old key: %v", err)
+	}
+	oldToken, err := signToken(jwt.MapClaims{"sub": "test"})
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+
+	useTokenConfig(t, &config.Config{JWTSigningKey: newPrivate, JWTVerificationKeys: []string{oldPublic}})
+	if err := LoadTokenKeys(); err != nil {
+		t.Fatalf("load new key: %v", err)
+	}
+	newToken, err := signToken(jwt.MapClaims{"sub": "test"})
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	for name, raw := range map[string]string{"old": oldToken, "new": newToken} {
+		if err := verifyTestToken(raw); err != nil {
+			t.Errorf("verify token signed with the %s key: %v", name, err)
+		}
+	}
+
+	keys := JWKS()["keys"].([]map[string]string)
+	if len(keys) != 2 || keys[0]["kid"] != tokenKeys.signing.ID || keys[0]["alg"] != "EdDSA" {
+		t.Errorf("JWKS = %v, want the new and the old key, new first", keys)
+	}
+	for _, key := range keys {
+		if _, ok := key["d"]; ok {
+			t.Errorf("JWKS publishes the private part of key %s", key["kid"])
+		}
+	}
+
+	// Once the old key is dropped its tokens are refused
+	useTokenConfig(t, &config.Config{JWTSigningKey: newPrivate})
+	if err := LoadTokenKeys(); err != nil {
+		t.Fatalf("load new key: %v", err)
+	}
+	if err := verifyTestToken(oldToken); err == nil {
+		t.Error("token signed with a dropped key was accepted")
+	}
+}
+
+// TestVerificationKeyAlgorithm checks that a token cannot pass off the
+// public key as an HMAC secret
+func TestVerificationKeyAlgorithm(t *testing.T) {
+	dir := t.TempDir()
+	private, public := writeEd25519Key(t, dir, "key")
+	useTokenConfig(t, &config.Config{JWTSigningKey: private})
+	if err := LoadTokenKeys(); err != nil {
+		t.Fatalf("load key: %v", err)
+	}
+
+	publicPEM, err := os.ReadFile(public)
+	if err != nil {
+		t.Fatalf("read key: %v", err)
+	}
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "test"})
+	forged.Header["kid"] = tokenKeys.signing.ID
+	raw, err := forged.SignedString(publicPEM)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	if err := verifyTestToken(raw); err == nil {
+		t.Error("HS256 token under an EdDSA key ID was accepted")
+	}
+}
+
+func TestLoadTokenKeysInvalid(t *testing.T) {
+	dir := t.TempDir()
+	_, public := writeEd25519Key(t, dir, "key")
+
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	weakPath := writeKey(t, dir, "weak.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(weak))
+	garbage := filepath.Join(dir, "garbage.pem")
+	if err := os.WriteFile(garbage, []byte("not a key"), 0600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+
+	invalid := map[string]*config.Config{
+		"public signing key":   {JWTSigningKey: public},
+		"short RSA key":        {JWTSigningKey: weakPath},
+		"no PEM data":          {JWTSigningKey: garbage},
+		"missing file":         {JWTSigningKey: filepath.Join(dir, "missing.pem")},
+		"bad verification key": {JWTSigningKey: filepath.Join(dir, "key.pem"), JWTVerificationKeys: []string{garbage}},
+	}
+	for name, cfg := range invalid {
+		useTokenConfig(t, cfg)
+		if err := LoadTokenKeys(); err == nil {
+			t.Errorf("%s was accepted", name)
+		}
+	}
+}
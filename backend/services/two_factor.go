@@ -0,0 +1,446 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"procurement-system/config"
+	"procurement-system/models"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TOTP parameters (RFC 6238 defaults, which authenticator apps assume)
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	// totpSkew is how many periods a code may be early or late, to allow
+	// for clock drift
+	totpSkew = 1
+)
+
+const (
+	// recoveryCodeCount is how many recovery codes a user gets at a time
+	recoveryCodeCount = 10
+
+	// loginChallengeTTL is how long the second step of a login may take
+	loginChallengeTTL = 5 * time.Minute
+
+	// maxChallengeAttempts is how many codes one login challenge accepts
+	maxChallengeAttempts = 5
+)
+
+// totpEncoding encodes TOTP secrets the way otpauth URIs expect
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPEnrollment is a TOTP secret to be added to an authenticator app,
+// either by scanning OTPAuthURI as a QR code or by typing in Secret
+type TOTPEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// LoginChallenge is the first step of a two-factor login
+type LoginChallenge struct {
+	Token     string    `json:"challenge_token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// BeginTOTPEnrollment generates a new TOTP secret for a user. It only takes
+// effect once confirmed with a code from the authenticator app.
+func BeginTOTPEnrollment(tx *gorm.DB, userID uint) (*TOTPEnrollment, error) {
+	user, err := lockUser(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, &ConflictError{Message: "Two-factor authentication is already enabled"}
+	}
+
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	encoded := totpEncoding.EncodeToString(secret)
+
+	err = tx.Model(user).Updates(map[string]interface{}{"totp_secret": encoded, "totp_last_step": 0}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	label := config.AppConfig.TOTPIssuer + ":" + user.Username
+	query := url.Values{}
+	query.Set("secret", encoded)
+	query.Set("issuer", config.AppConfig.TOTPIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	uri := url.URL{Scheme: "otpauth", Host: "totp", Path: "/" + label, RawQuery: query.Encode()}
+
+	return &TOTPEnrollment{Secret: encoded, OTPAuthURI: uri.String()}, nil
+}
+
+// ConfirmTOTPEnrollment enables two-factor authentication for a user once
+// they give a code generated from the secret of BeginTOTPEnrollment, and
+// returns their recovery codes
+func ConfirmTOTPEnrollment(tx *gorm.DB, userID uint, code string) ([]string, error) {
+	user, err := lockUser(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, &ConflictError{Message: "Two-factor authentication is already enabled"}
+	}
+	if user.TOTPSecret == "" {
+		return nil, &ConflictError{Message: "Two-factor enrollment has not been started"}
+	}
+
+	step, ok := verifyTOTP(user, code, time.Now())
+	if !ok {
+		return nil, &ValidationError{Message: "Invalid verification code"}
+	}
+
+	err = tx.Model(user).Updates(map[string]interface{}{"totp_enabled": true, "totp_last_step": step}).Error
+	if err != nil {
+		return nil, err
+	}
+	return newRecoveryCodes(tx, user.ID)
+}
+
+// DisableTOTP turns two-factor authentication off for a user who gives
+// their password and a current code. Users whose role requires two-factor
+// authentication cannot turn it off.
+func DisableTOTP(tx *gorm.DB, userID uint, password, code string) error {
+	user, err := lockUser(tx, userID)
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return &ConflictError{Message: "Two-factor authentication is not enabled"}
+	}
+
+	required, err := TwoFactorRequired(tx, user.Role)
+	if err != nil {
+		return err
+	}
+	if required {
+		return &ConflictError{Message: "Your role requires two-factor authentication"}
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return &ValidationError{Message: "Password is incorrect"}
+	}
+	if ok, err := verifySecondFactor(tx, user, code, time.Now()); err != nil {
+		return err
+	} else if !ok {
+		return &ValidationError{Message: "Invalid verification code"}
+	}
+
+	return clearTwoFactor(tx, user)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of a user who gives a
+// current code
+func RegenerateRecoveryCodes(tx *gorm.DB, userID uint, code string) ([]string, error) {
+	user, err := lockUser(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.TOTPEnabled {
+		return nil, &ConflictError{Message: "Two-factor authentication is not enabled"}
+	}
+	if ok, err := verifySecondFactor(tx, user, code, time.Now()); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, &ValidationError{Message: "Invalid verification code"}
+	}
+	return newRecoveryCodes(tx, user.ID)
+}
+
+// ResetTwoFactor turns two-factor authentication off for a user who has
+// lost both their authenticator and recovery codes, and logs them out
+// everywhere. If their role requires it, they must enroll again on their
+// next login.
+func ResetTwoFactor(tx *gorm.DB, user *models.User) error {
+	if err := clearTwoFactor(tx, user); err != nil {
+		return err
+	}
+	user.TOTPEnabled = false
+	return RevokeUserSessions(tx, user.ID)
+}
+
+// RecoveryCodesLeft counts the unused recovery codes of a user
+func RecoveryCodesLeft(db *gorm.DB, userID uint) (int64, error) {
+	var count int64
+	err := db.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+// StartLoginChallenge begins the second step of a login by a user with
+// two-factor authentication, whose password has been checked
+func StartLoginChallenge(tx *gorm.DB, user *models.User) (*LoginChallenge, error) {
+	now := time.Now()
+	if err := tx.Where("expires_at <= ?", now).Delete(&models.LoginChallenge{}).Error; err != nil {
+		return nil, err
+	}
+
+	challenge := LoginChallenge{Token: randomToken(), ExpiresAt: now.Add(loginChallengeTTL)}
+	err := tx.Create(&models.LoginChallenge{
+		UserID:    user.ID,
+		TokenHash: hashToken(challenge.Token),
+		ExpiresAt: challenge.ExpiresAt,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+// CompleteLoginChallenge finishes a two-factor login with a TOTP or
+// recovery code. Wrong codes count as failed logins of the account, and a
+// challenge accepts only a few. Like Authenticate, the attempt is recorded
+// in tx even when the login is refused, so callers must commit tx on
+// UnauthorizedError and TooManyRequestsError.
+func CompleteLoginChallenge(tx *gorm.DB, raw, code string, client ClientInfo) (*models.User, error) {
+	invalid := &UnauthorizedError{Message: "Invalid or expired login challenge, please log in again"}
+
+	var challenge models.LoginChallenge
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token_hash = ?", hashToken(raw)).
+		First(&challenge).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, invalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if challenge.UsedAt != nil || !challenge.ExpiresAt.After(now) {
+		return nil, invalid
+	}
+
+	var user models.User
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, challenge.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, invalid
+		}
+		return nil, err
+	}
+	if !user.TOTPEnabled {
+		return nil, invalid
+	}
+
+	attempt := models.LoginAttempt{
+		UserID:    &user.ID,
+		Username:  user.Username,
+		IP:        truncate(client.IP, 64),
+		UserAgent: truncate(client.UserAgent, 255),
+	}
+	if err := checkLoginIP(tx, &attempt, now); err != nil {
+		return nil, err
+	}
+	if err := checkLoginAccount(tx, &user, &attempt, now); err != nil {
+		return nil, err
+	}
+
+	ok, err := verifySecondFactor(tx, &user, code, now)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		updates := map[string]interface{}{"attempts": challenge.Attempts + 1}
+		if challenge.Attempts+1 >= maxChallengeAttempts {
+			updates["used_at"] = now
+		}
+		if err := tx.Model(&challenge).Updates(updates).Error; err != nil {
+			return nil, err
+		}
+		return nil, failLogin(tx, &user, &attempt, models.LoginInvalidOTP, now, &UnauthorizedError{Message: "Invalid verification code"})
+	}
+
+	if err := tx.Model(&challenge).Update("used_at", now).Error; err != nil {
+		return nil, err
+	}
+	if err := succeedLogin(tx, &user, &attempt); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// TwoFactorRequired reports whether users of role must use two-factor
+// authentication
+func TwoFactorRequired(db *gorm.DB, role string) (bool, error) {
+	var count int64
+	err := db.Model(&models.TwoFactorRole{}).Where("role = ?", role).Count(&count).Error
+	return count > 0, err
+}
+
+// TwoFactorRoles returns the roles that must use two-factor authentication
+func TwoFactorRoles(db *gorm.DB) ([]string, error) {
+	roles := []string{}
+	err := db.Model(&models.TwoFactorRole{}).Order("role").Pluck("role", &roles).Error
+	return roles, err
+}
+
+// SetTwoFactorRoles replaces the roles that must use two-factor
+// authentication. Users of those roles without it can only set it up until
+// they have; sessions started before take effect on their next refresh.
+func SetTwoFactorRoles(tx *gorm.DB, roles []string) ([]string, error) {
+	seen := make(map[string]bool, len(roles))
+	for _, role := range roles {
+		if !models.IsValidRole(role) {
+			return nil, &ValidationError{Message: fmt.Sprintf("Invalid role '%s'", role)}
+		}
+		seen[role] = true
+	}
+
+	if err := tx.Where("1 = 1").Delete(&models.TwoFactorRole{}).Error; err != nil {
+		return nil, err
+	}
+	for _, role := range models.Roles {
+		if !seen[role] {
+			continue
+		}
+		if err := tx.Create(&models.TwoFactorRole{Role: role}).Error; err != nil {
+			return nil, err
+		}
+	}
+	return TwoFactorRoles(tx)
+}
+
+// verifySecondFactor checks a TOTP code or, failing that, spends a recovery
+// code of user. Accepted TOTP codes cannot be used again.
+func verifySecondFactor(tx *gorm.DB, user *models.User, code string, now time.Time) (bool, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return false, nil
+	}
+
+	if step, ok := verifyTOTP(user, code, now); ok {
+		if err := tx.Model(user).Update("totp_last_step", step).Error; err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	var recovery models.RecoveryCode
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashToken(normalizeRecoveryCode(code))).
+		Limit(1).
+		Find(&recovery).Error
+	if err != nil {
+		return false, err
+	}
+	if recovery.ID == 0 {
+		return false, nil
+	}
+	return true, tx.Model(&recovery).Update("used_at", now).Error
+}
+
+// verifyTOTP checks code against the TOTP secret of user, allowing for
+// clock drift, and returns the time step it matched. Codes of steps up to
+// the last one accepted are refused, so that each code works once.
+func verifyTOTP(user *models.User, code string, now time.Time) (int64, bool) {
+	secret, err := totpEncoding.DecodeString(user.TOTPSecret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= user.TOTPLastStep {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(secret, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpCode computes the RFC 6238 code of a time step
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulus := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		modulus *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%modulus)
+}
+
+// newRecoveryCodes replaces the recovery codes of a user and returns the
+// new ones, formatted as xxxx-xxxx
+func newRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	rows := make([]models.RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(b))
+		codes = append(codes, code[:4]+"-"+code[4:])
+		rows = append(rows, models.RecoveryCode{UserID: userID, CodeHash: hashToken(code)})
+	}
+
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode strips the separators and case of a recovery code
+// as typed by a user
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+// clearTwoFactor removes the TOTP secret and recovery codes of a user
+func clearTwoFactor(tx *gorm.DB, user *models.User) error {
+	err := tx.Model(user).Updates(map[string]interface{}{
+		"totp_secret":    "",
+		"totp_enabled":   false,
+		"totp_last_step": 0,
+	}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+}
+
+// lockUser loads a user for update
+func lockUser(tx *gorm.DB, userID uint) (*models.User, error) {
+	var user models.User
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &NotFoundError{Message: "User not found"}
+		}
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"procurement-system/database"
+	"procurement-system/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTOTPCode checks codes against the SHA-1 test vectors of RFC 6238,
+// whose eight-digit codes end in the six digits used here
+func TestTOTPCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, want := range vectors {
+		if got := totpCode(secret, unix/totpPeriod); got != want {
+			t.Errorf("code at %d = %s, want %s", unix, got, want)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	user := &models.User{TOTPSecret: totpEncoding.EncodeToString(secret)}
+	now := time.Unix(1234567890, 0)
+	current := now.Unix() / totpPeriod
+
+	for _, step := range []int64{current - 1, current, current + 1} {
+		if got, ok := verifyTOTP(user, totpCode(secret, step), now); !ok || got != step {
+			t.Errorf("code of step %+d = %d, %v; want accepted", step-current, got, ok)
+		}
+	}
+	for _, step := range []int64{current - 2, current + 2} {
+		if _, ok := verifyTOTP(user, totpCode(secret, step), now); ok {
+			t.Errorf("code of step %+d was accepted", step-current)
+		}
+	}
+	for _, code := range []string{"", "12345", "1234567"} {
+		if _, ok := verifyTOTP(user, code, now); ok {
+			t.Errorf("code %q was accepted", code)
+		}
+	}
+
+	// Each code works once
+	user.TOTPLastStep = current
+	if _, ok := verifyTOTP(user, totpCode(secret, current), now); ok {
+		t.Error("code of the last accepted step was accepted again")
+	}
+	if _, ok := verifyTOTP(user, totpCode(secret, current+1), now); !ok {
+		t.Error("code of the next step was refused")
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	for _, code := range []string{"abcd-efgh", "ABCD-EFGH", " abcd efgh ", "abcdefgh"} {
+		if got := normalizeRecoveryCode(code); got != "abcdefgh" {
+			t.Errorf("normalizeRecoveryCode(%q) = %q, want %q", code, got, "abcdefgh")
+		}
+	}
+}
+
+// TestTwoFactorLogin enrolls a user and logs them in with a TOTP code and
+// with a recovery code
+func TestTwoFactorLogin(t *testing.T) {
+	setupTestDB(t)
+	useLoginLimits(t, 100, 100, 0)
+	user := createLoginUser(t, testRun(t))
+	client := testClient()
+
+	enrollment, err := BeginTOTPEnrollment(database.DB, user.ID)
+	if err != nil {
+		t.Fatalf("begin enrollment: %v", err)
+	}
+	secret, err := totpEncoding.DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatalf("decode secret: %v", err)
+	}
+	if !strings.HasPrefix(enrollment.OTPAuthURI, "otpauth://totp/") {
+		t.Errorf("otpauth URI = %q", enrollment.OTPAuthURI)
+	}
+	step := time.Now().Unix() / totpPeriod
+
+	if _, err := ConfirmTOTPEnrollment(database.DB, user.ID, totpCode(secret, step+5)); !isRejection(err) {
+		t.Errorf("confirm with a wrong code: err = %v, want a rejection", err)
+	}
+	codes, err := ConfirmTOTPEnrollment(database.DB, user.ID, totpCode(secret, step))
+	if err != nil {
+		t.Fatalf("confirm enrollment: %v", err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Errorf("%d recovery codes, want %d", len(codes), recoveryCodeCount)
+	}
+	if _, err := BeginTOTPEnrollment(database.DB, user.ID); !isRejection(err) {
+		t.Errorf("enroll twice: err = %v, want a rejection", err)
+	}
+
+	challenge := func() string {
+		t.Helper()
+		authenticated, err := Authenticate(database.DB, user.Username, loginTestPassword, client)
+		if err != nil {
+			t.Fatalf("authenticate: %v", err)
+		}
+		started, err := StartLoginChallenge(database.DB, authenticated)
+		if err != nil {
+			t.Fatalf("start challenge: %v", err)
+		}
+		return started.Token
+	}
+
+	// The code used to confirm the enrollment is spent
+	token := challenge()
+	if _, err := CompleteLoginChallenge(database.DB, token, totpCode(secret, step), client); !isUnauthorized(err) {
+		t.Errorf("login with a spent code: err = %v, want a refusal", err)
+	}
+	recovery := strings.ToUpper(strings.Replace(codes[0], "-", " ", 1))
+	if loggedIn, err := CompleteLoginChallenge(database.DB, token, recovery, client); err != nil || loggedIn.ID != user.ID {
+		t.Errorf("login with a recovery code = %v, %v; want user %d", loggedIn, err, user.ID)
+	}
+	if _, err := CompleteLoginChallenge(database.DB, token, codes[1], client); !isUnauthorized(err) {
+		t.Errorf("reuse a completed challenge: err = %v, want a refusal", err)
+	}
+
+	token = challenge()
+	if _, err := CompleteLoginChallenge(database.DB, token, codes[0], client); !isUnauthorized(err) {
+		t.Errorf("login with a spent recovery code: err = %v, want a refusal", err)
+	}
+	if left, err := RecoveryCodesLeft(database.DB, user.ID); err != nil || left != recoveryCodeCount-1 {
+		t.Errorf("recovery codes left = %d, %v; want %d", left, err, recoveryCodeCount-1)
+	}
+
+	// A challenge accepts only a few wrong codes
+	for i := 1; i < maxChallengeAttempts; i++ {
+		if _, err := CompleteLoginChallenge(database.DB, token, "wrong", client); !isUnauthorized(err) {
+			t.Fatalf("wrong code %d: err = %v, want a refusal", i+1, err)
+		}
+	}
+	if _, err := CompleteLoginChallenge(database.DB, token, codes[1], client); !isUnauthorized(err) {
+		t.Errorf("login after too many wrong codes: err = %v, want a refusal", err)
+	}
+
+	if err := DisableTOTP(database.DB, user.ID, "wrong", totpCode(secret, step+1)); !isRejection(err) {
+		t.Errorf("disable with a wrong password: err = %v, want a refusal", err)
+	}
+	if err := DisableTOTP(database.DB, user.ID, loginTestPassword, totpCode(secret, step+1)); err != nil {
+		t.Errorf("disable two-factor authentication: %v", err)
+	}
+	if left, _ := RecoveryCodesLeft(database.DB, user.ID); left != 0 {
+		t.Errorf("recovery codes left after disabling = %d, want 0", left)
+	}
+}
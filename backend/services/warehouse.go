@@ -0,0 +1,107 @@
+package services
+
+import (
+	"fmt"
+	"procurement-system/models"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultWarehouse returns the warehouse stock changes without a warehouse
+// are booked to
+func DefaultWarehouse(tx *gorm.DB) (*models.Warehouse, error) {
+	var warehouse models.Warehouse
+	if err := tx.Where("is_default = ?", true).First(&warehouse).Error; err != nil {
+		return nil, fmt.Errorf("default warehouse: %w", err)
+	}
+	return &warehouse, nil
+}
+
+// ResolveWarehouse returns the ID of the warehouse identified by id, or of
+// the default warehouse for a zero id
+func ResolveWarehouse(tx *gorm.DB, id uint) (uint, error) {
+	warehouse, err := stockWarehouse(tx, id)
+	if err != nil {
+		return 0, err
+	}
+	return warehouse.ID, nil
+}
+
+// SaveWarehouse validates a warehouse and creates or updates it. Making a
+// warehouse the default takes the flag away from the previous default; the
+// default itself cannot give it up.
+func SaveWarehouse(tx *gorm.DB, warehouse *models.Warehouse) error {
+	warehouse.Code = strings.ToUpper(strings.TrimSpace(warehouse.Code))
+	warehouse.Name = strings.TrimSpace(warehouse.Name)
+
+	if warehouse.Code == "" {
+		return &ValidationError{Message: "Warehouse code is required"}
+	}
+	if warehouse.Name == "" {
+		return &ValidationError{Message: "Warehouse name is required"}
+	}
+
+	var count int64
+	if err := tx.Unscoped().Model(&models.Warehouse{}).Where("code = ? AND id <> ?", warehouse.Code, warehouse.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return &ConflictError{Message: fmt.Sprintf("Warehouse %s already exists", warehouse.Code)}
+	}
+
+	if warehouse.ID != 0 && !warehouse.IsDefault {
+		var current models.Warehouse
+		if err := tx.First(&current, warehouse.ID).Error; err != nil {
+			return err
+		}
+		if current.IsDefault {
+			return &ValidationError{Message: "Make another warehouse the default instead"}
+		}
+	}
+
+	if warehouse.IsDefault {
+		// Lock the current default so that concurrent changes of the
+		// default wait for each other; the next statement then sees the
+		// default the other one set
+		var current []models.Warehouse
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("is_default = ?", true).Find(&current).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Warehouse{}).Where("is_default = ? AND id <> ?", true, warehouse.ID).Update("is_default", false).Error; err != nil {
+			return err
+		}
+	}
+
+	return tx.Save(warehouse).Error
+}
+
+// DeleteWarehouse deletes an empty warehouse that is neither the default
+// nor the destination of purchases still to be received
+func DeleteWarehouse(tx *gorm.DB, warehouse *models.Warehouse) error {
+	if warehouse.IsDefault {
+		return &ConflictError{Message: "The default warehouse cannot be deleted"}
+	}
+
+	var stock int64
+	if err := tx.Model(&models.ItemStock{}).Where("warehouse_id = ? AND stock <> 0", warehouse.ID).Count(&stock).Error; err != nil {
+		return err
+	}
+	if stock > 0 {
+		return &ConflictError{Message: fmt.Sprintf("Warehouse %s still holds stock", warehouse.Code)}
+	}
+
+	var open int64
+	err := tx.Model(&models.Purchasing{}).
+		Where("warehouse_id = ? AND status IN ?", warehouse.ID, openPurchaseStatuses).
+		Count(&open).Error
+	if err != nil {
+		return err
+	}
+	if open > 0 {
+		return &ConflictError{Message: fmt.Sprintf("Warehouse %s is the destination of %d open purchases", warehouse.Code, open)}
+	}
+
+	return tx.Delete(warehouse).Error
+}
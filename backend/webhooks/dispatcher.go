@@ -0,0 +1,223 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"procurement-system/config"
+	"procurement-system/database"
+	"procurement-system/models"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// batchSize is the number of due deliveries claimed per poll
+	batchSize = 20
+
+	// Retry backoff doubles from baseBackoff up to maxBackoff
+	baseBackoff = 30 * time.Second
+	maxBackoff  = 6 * time.Hour
+)
+
+// Headers sent with every delivery
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// StartDispatcher starts the background worker that sends due deliveries
+// from the outbox. Several application instances may run it at once: each
+// delivery is claimed with SKIP LOCKED so it is only sent by one of them.
+func StartDispatcher() {
+	if config.AppConfig.WebhookURL != "" && config.AppConfig.WebhookSecret == "" {
+		log.Println("Warning: WEBHOOK_SECRET is not set, webhook payloads will not be signed")
+	}
+
+	client := &http.Client{Timeout: config.AppConfig.WebhookTimeout}
+
+	go func() {
+		ticker := time.NewTicker(config.AppConfig.WebhookPollInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			for {
+				sent, err := dispatchBatch(client)
+				if err != nil {
+					log.Printf("Webhook dispatcher error: %v", err)
+					break
+				}
+				if sent < batchSize {
+					break
+				}
+			}
+		}
+	}()
+}
+
+// dispatchBatch claims and sends up to batchSize due deliveries and
+// returns how many were attempted
+func dispatchBatch(client *http.Client) (int, error) {
+	deliveries, err := claim()
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range deliveries {
+		if err := attempt(client, &deliveries[i]); err != nil {
+			return i, err
+		}
+	}
+	return len(deliveries), nil
+}
+
+// claim leases due deliveries to this dispatcher by pushing their next
+// attempt past the send timeout. If the process dies mid-send, the lease
+// runs out and another dispatcher retries the delivery.
+func claim() ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", models.DeliveryStatusPending, time.Now()).
+			Order("next_attempt_at, id").
+			Limit(batchSize).
+			Find(&deliveries).Error
+		if err != nil || len(deliveries) == 0 {
+			return err
+		}
+
+		ids := make([]uint, len(deliveries))
+		for i, delivery := range deliveries {
+			ids[i] = delivery.ID
+		}
+
+		lease := time.Now().Add(2*config.AppConfig.WebhookTimeout + time.Minute)
+		return tx.Model(&models.WebhookDelivery{}).Where("id IN ?", ids).Update("next_attempt_at", lease).Error
+	})
+	return deliveries, err
+}
+
+// attempt sends one delivery and records the outcome
+func attempt(client *http.Client, delivery *models.WebhookDelivery) error {
+	updates := map[string]interface{}{
+		"attempts":   delivery.Attempts + 1,
+		"last_error": "",
+	}
+
+	secret, active, err := deliverySecret(delivery)
+	if err != nil {
+		return err
+	}
+	if !active {
+		// Never retry deliveries for removed or disabled subscriptions
+		updates["status"] = models.DeliveryStatusDead
+		updates["last_error"] = "subscription deleted or disabled"
+		return database.DB.Model(delivery).Updates(updates).Error
+	}
+
+	statusCode, sendErr := send(client, delivery, secret)
+	updates["last_status_code"] = statusCode
+
+	switch {
+	case sendErr == nil:
+		now := time.Now()
+		updates["status"] = models.DeliveryStatusDelivered
+		updates["delivered_at"] = &now
+		log.Printf("Webhook %s #%d delivered", delivery.Event, delivery.ID)
+	case delivery.Attempts+1 >= config.AppConfig.WebhookMaxAttempts:
+		updates["status"] = models.DeliveryStatusDead
+		updates["last_error"] = sendErr.Error()
+		log.Printf("Webhook %s #%d dead-lettered after %d attempts: %v", delivery.Event, delivery.ID, delivery.Attempts+1, sendErr)
+	default:
+		updates["next_attempt_at"] = time.Now().Add(backoff(delivery.Attempts + 1))
+		updates["last_error"] = sendErr.Error()
+		log.Printf("Webhook %s #%d failed (attempt %d): %v", delivery.Event, delivery.ID, delivery.Attempts+1, sendErr)
+	}
+
+	return database.DB.Model(delivery).Updates(updates).Error
+}
+
+// deliverySecret returns the signing secret of a delivery and whether its
+// subscription is still active
+func deliverySecret(delivery *models.WebhookDelivery) (string, bool, error) {
+	if delivery.SubscriptionID == nil {
+		return config.AppConfig.WebhookSecret, true, nil
+	}
+
+	var subscription models.WebhookSubscription
+	err := database.DB.Unscoped().First(&subscription, *delivery.SubscriptionID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	return subscription.Secret, subscription.Enabled && !subscription.DeletedAt.Valid, nil
+}
+
+// send posts the payload, signed with secret if set, and returns the
+// response status code
+func send(client *http.Client, delivery *models.WebhookDelivery, secret string) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	if secret != "" {
+		req.Header.Set(HeaderSignature, "sha256="+Sign(secret, timestamp, delivery.Payload))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of "<timestamp>.<body>". Receivers
+// recompute it with the shared secret and compare it to the signature header.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff returns the delay before retrying after the given number of attempts
+func backoff(attempts int) time.Duration {
+	d := baseBackoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+
+	// Spread retries of deliveries that failed together
+	return d + time.Duration(rand.Int63n(int64(d/10)+1))
+}
@@ -0,0 +1,86 @@
+package webhooks
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"procurement-system/models"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	got := Sign("secret", "1700000000", []byte(`{"event":"item_created"}`))
+	want := "2e419b52f4026e617d6938e5406df93894d60167ae15468c64ce6de195601e73"
+	if got != want {
+		t.Errorf("Sign = %s, want %s", got, want)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		base     time.Duration
+	}{
+		{1, baseBackoff},
+		{2, 2 * baseBackoff},
+		{4, 8 * baseBackoff},
+		{20, maxBackoff},
+		{1000, maxBackoff},
+	}
+	for _, tt := range tests {
+		// Retries are spread by up to a tenth of the delay
+		got := backoff(tt.attempts)
+		if got < tt.base || got > tt.base+tt.base/10 {
+			t.Errorf("backoff(%d) = %s, want %s plus at most a tenth", tt.attempts, got, tt.base)
+		}
+	}
+}
+
+// TestSend checks that a delivery is posted with its headers and a
+// signature the receiver can check
+func TestSend(t *testing.T) {
+	var received http.Header
+	var body []byte
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	delivery := &models.WebhookDelivery{ID: 42, Event: EventItemCreated, URL: server.URL, Payload: []byte(`{"event":"item_created"}`)}
+	code, err := send(server.Client(), delivery, "secret")
+	if err != nil || code != http.StatusOK {
+		t.Fatalf("send = %d, %v; want 200", code, err)
+	}
+
+	if string(body) != string(delivery.Payload) {
+		t.Errorf("body = %s, want %s", body, delivery.Payload)
+	}
+	headers := map[string]string{
+		"Content-Type":  "application/json",
+		HeaderEvent:     EventItemCreated,
+		HeaderDelivery:  "42",
+		HeaderSignature: "sha256=" + Sign("secret", received.Get(HeaderTimestamp), delivery.Payload),
+	}
+	for name, want := range headers {
+		if got := received.Get(name); got != want {
+			t.Errorf("header %s = %q, want %q", name, got, want)
+		}
+	}
+
+	// Without a secret the payload goes unsigned
+	if _, err := send(server.Client(), delivery, ""); err != nil {
+		t.Fatalf("send unsigned: %v", err)
+	}
+	if got := received.Get(HeaderSignature); got != "" {
+		t.Errorf("unsigned delivery has signature %q", got)
+	}
+
+	status = http.StatusServiceUnavailable
+	if code, err := send(server.Client(), delivery, "secret"); err == nil || code != status {
+		t.Errorf("send to a failing receiver = %d, %v; want %d and an error", code, err, status)
+	}
+}
@@ -0,0 +1,114 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"procurement-system/config"
+	"procurement-system/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Events
+const (
+	EventItemCreated           = "item_created"
+	EventItemUpdated           = "item_updated"
+	EventItemDeleted           = "item_deleted"
+	EventSupplierCreated       = "supplier_created"
+	EventSupplierUpdated       = "supplier_updated"
+	EventSupplierDeleted       = "supplier_deleted"
+	EventPurchaseCreated       = "purchase_created"
+	EventPurchaseUpdated       = "purchase_updated"
+	EventPurchaseStatusChanged = "purchase_status_changed"
+	EventPurchaseCancelled     = "purchase_cancelled"
+	EventPurchaseReturned      = "purchase_returned"
+	EventInvoiceCreated        = "supplier_invoice_created"
+	EventInvoiceApproved       = "supplier_invoice_approved"
+	EventInvoiceDeleted        = "supplier_invoice_deleted"
+	EventPaymentRecorded       = "supplier_payment_recorded"
+	EventStockLow              = "stock_low"
+	EventStockTransferred      = "stock_transferred"
+)
+
+// Events lists every event subscriptions can receive
+var Events = []string{
+	EventItemCreated, EventItemUpdated, EventItemDeleted,
+	EventSupplierCreated, EventSupplierUpdated, EventSupplierDeleted,
+	EventPurchaseCreated, EventPurchaseUpdated, EventPurchaseStatusChanged,
+	EventPurchaseCancelled, EventPurchaseReturned,
+	EventInvoiceCreated, EventInvoiceApproved, EventInvoiceDeleted, EventPaymentRecorded,
+	EventStockLow, EventStockTransferred,
+}
+
+// IsValidEvent reports whether event can be subscribed to
+func IsValidEvent(event string) bool {
+	if event == "*" {
+		return true
+	}
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Publish writes event to the outbox inside tx, with one delivery for every
+// enabled subscription that receives it and one for WEBHOOK_URL if set.
+// Deliveries are only sent once tx commits, and are discarded together with
+// the change if tx rolls back.
+func Publish(tx *gorm.DB, event string, data map[string]interface{}) error {
+	payload := map[string]interface{}{
+		"event":     event,
+		"timestamp": time.Now().Format(time.RFC3339),
+	}
+	for k, v := range data {
+		payload[k] = v
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	var deliveries []models.WebhookDelivery
+	newDelivery := func(subscriptionID *uint, url string) models.WebhookDelivery {
+		return models.WebhookDelivery{
+			Event:          event,
+			Payload:        body,
+			SubscriptionID: subscriptionID,
+			URL:            url,
+			Status:         models.DeliveryStatusPending,
+			NextAttemptAt:  time.Now(),
+		}
+	}
+
+	if url := config.AppConfig.WebhookURL; url != "" {
+		deliveries = append(deliveries, newDelivery(nil, url))
+	}
+
+	var subscriptions []models.WebhookSubscription
+	if err := tx.Where("enabled = ?", true).Find(&subscriptions).Error; err != nil {
+		return err
+	}
+	for i := range subscriptions {
+		if subscriptions[i].Subscribes(event) {
+			deliveries = append(deliveries, newDelivery(&subscriptions[i].ID, subscriptions[i].URL))
+		}
+	}
+
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return tx.Create(&deliveries).Error
+}
+
+// Replay schedules a delivery to be sent again immediately, with a fresh
+// retry budget, whatever its current status
+func Replay(db *gorm.DB, delivery *models.WebhookDelivery) error {
+	return db.Model(delivery).Updates(map[string]interface{}{
+		"status":          models.DeliveryStatusPending,
+		"attempts":        0,
+		"next_attempt_at": time.Now(),
+	}).Error
+}
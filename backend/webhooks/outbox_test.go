@@ -0,0 +1,38 @@
+package webhooks
+
+import (
+	"procurement-system/models"
+	"testing"
+)
+
+func TestIsValidEvent(t *testing.T) {
+	for _, event := range append(Events, "*") {
+		if !IsValidEvent(event) {
+			t.Errorf("IsValidEvent(%q) = false, want true", event)
+		}
+	}
+	for _, event := range []string{"", "item", "ITEM_CREATED", "item_created "} {
+		if IsValidEvent(event) {
+			t.Errorf("IsValidEvent(%q) = true, want false", event)
+		}
+	}
+}
+
+func TestSubscribes(t *testing.T) {
+	tests := []struct {
+		events []string
+		event  string
+		want   bool
+	}{
+		{[]string{EventItemCreated, EventItemDeleted}, EventItemDeleted, true},
+		{[]string{EventItemCreated}, EventItemUpdated, false},
+		{[]string{"*"}, EventStockLow, true},
+		{nil, EventStockLow, false},
+	}
+	for _, tt := range tests {
+		subscription := models.WebhookSubscription{Events: tt.events}
+		if got := subscription.Subscribes(tt.event); got != tt.want {
+			t.Errorf("subscription to %v receives %s = %v, want %v", tt.events, tt.event, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package webhooks
+
+import "procurement-system/models"
+
+// PurchasePayload describes a purchase loaded with supplier, user and detail items
+func PurchasePayload(purchase models.Purchasing) map[string]interface{} {
+	items := make([]map[string]interface{}, 0, len(purchase.PurchasingDetails))
+	for _, detail := range purchase.PurchasingDetails {
+		var taxCode interface{}
+		if detail.TaxCode != nil {
+			taxCode = detail.TaxCode.Code
+		}
+		items = append(items, map[string]interface{}{
+			"item_id":       detail.ItemID,
+			"item_name":     detail.Item.Name,
+			"qty":           detail.Qty,
+			"price":         detail.UnitPrice,
+			"sub_total":     detail.SubTotal,
+			"discount":      detail.Discount,
+			"tax_base":      detail.TaxBase,
+			"tax_code":      taxCode,
+			"tax_rate":      detail.TaxRate,
+			"tax_inclusive": detail.TaxInclusive,
+			"tax":           detail.Tax,
+			"line_total":    detail.LineTotal,
+		})
+	}
+
+	var warehouse interface{}
+	if purchase.Warehouse != nil {
+		warehouse = purchase.Warehouse.Code
+	}
+
+	return map[string]interface{}{
+		"order_id":         purchase.ID,
+		"order_number":     purchase.Number,
+		"date":             purchase.Date.Format("2006-01-02"),
+		"supplier":         purchase.Supplier.Name,
+		"warehouse_id":     purchase.WarehouseID,
+		"warehouse":        warehouse,
+		"user":             purchase.User.Username,
+		"currency":         purchase.Currency,
+		"exchange_rate":    purchase.ExchangeRate,
+		"subtotal":         purchase.Subtotal,
+		"header_discount":  purchase.HeaderDiscount,
+		"discount_total":   purchase.DiscountTotal,
+		"tax_total":        purchase.TaxTotal,
+		"grand_total":      purchase.GrandTotal,
+		"base_grand_total": purchase.BaseGrandTotal,
+		"status":           purchase.Status,
+		"cancel_reason":    purchase.CancelReason,
+		"suggested":        purchase.Suggested,
+		"items":            items,
+	}
+}
+
+// ItemPayload describes an item
+func ItemPayload(item models.Item) map[string]interface{} {
+	return map[string]interface{}{
+		"item_id":   item.ID,
+		"item_name": item.Name,
+		"stock":     item.Stock,
+		"price":     item.Price,
+	}
+}
+
+// SupplierPayload describes a supplier
+func SupplierPayload(supplier models.Supplier) map[string]interface{} {
+	return map[string]interface{}{
+		"supplier_id":       supplier.ID,
+		"name":              supplier.Name,
+		"email":             supplier.Email,
+		"address":           supplier.Address,
+		"currency":          supplier.Currency,
+		"payment_term_days": supplier.PaymentTermDays,
+	}
+}
+
+// PurchaseReturnPayload describes a purchase return loaded with purchase,
+// supplier and detail items
+func PurchaseReturnPayload(ret models.PurchaseReturn) map[string]interface{} {
+	items := make([]map[string]interface{}, 0, len(ret.Details))
+	for _, detail := range ret.Details {
+		items = append(items, map[string]interface{}{
+			"purchasing_detail_id": detail.PurchasingDetailID,
+			"item_id":              detail.ItemID,
+			"item_name":            detail.Item.Name,
+			"qty":                  detail.Qty,
+			"credit_amount":        detail.CreditAmount,
+		})
+	}
+
+	data := map[string]interface{}{
+		"return_id":     ret.ID,
+		"return_number": ret.Number,
+		"order_id":      ret.PurchasingID,
+		"date":          ret.Date.Format("2006-01-02"),
+		"supplier_id":   ret.SupplierID,
+		"reason":        ret.Reason,
+		"currency":      ret.Currency,
+		"credit_amount": ret.CreditAmount,
+		"items":         items,
+	}
+	if ret.Purchasing != nil {
+		data["order_number"] = ret.Purchasing.Number
+	}
+	if ret.Supplier != nil {
+		data["supplier"] = ret.Supplier.Name
+	}
+	return data
+}
+
+// SupplierInvoicePayload describes a supplier invoice loaded with supplier
+// and purchases
+func SupplierInvoicePayload(invoice models.SupplierInvoice) map[string]interface{} {
+	orders := make([]map[string]interface{}, 0, len(invoice.Purchases))
+	for _, purchase := range invoice.Purchases {
+		orders = append(orders, map[string]interface{}{
+			"order_id":     purchase.ID,
+			"order_number": purchase.Number,
+			"grand_total":  purchase.GrandTotal,
+		})
+	}
+
+	data := map[string]interface{}{
+		"invoice_id":     invoice.ID,
+		"invoice_number": invoice.Number,
+		"supplier_id":    invoice.SupplierID,
+		"invoice_date":   invoice.InvoiceDate.Format("2006-01-02"),
+		"due_date":       invoice.DueDate.Format("2006-01-02"),
+		"currency":       invoice.Currency,
+		"amount":         invoice.Amount,
+		"paid_amount":    invoice.PaidAmount,
+		"outstanding":    invoice.Outstanding,
+		"status":         invoice.Status,
+		"match_status":   invoice.MatchStatus,
+		"approved":       invoice.ApprovedAt != nil,
+		"orders":         orders,
+	}
+	if invoice.Supplier != nil {
+		data["supplier"] = invoice.Supplier.Name
+	}
+	return data
+}
+
+// SupplierPaymentPayload describes a payment and the invoice it was made
+// against
+func SupplierPaymentPayload(invoice models.SupplierInvoice, payment models.SupplierPayment) map[string]interface{} {
+	data := SupplierInvoicePayload(invoice)
+	data["payment"] = map[string]interface{}{
+		"payment_id": payment.ID,
+		"date":       payment.Date.Format("2006-01-02"),
+		"amount":     payment.Amount,
+		"method":     payment.Method,
+		"reference":  payment.Reference,
+	}
+	return data
+}
+
+// StockTransferPayload describes a transfer of stock between warehouses
+func StockTransferPayload(transfer models.StockTransfer) map[string]interface{} {
+	items := make([]map[string]interface{}, 0, len(transfer.Details))
+	for _, detail := range transfer.Details {
+		items = append(items, map[string]interface{}{
+			"item_id":   detail.ItemID,
+			"item_name": detail.Item.Name,
+			"qty":       detail.Qty,
+		})
+	}
+
+	data := map[string]interface{}{
+		"transfer_id":       transfer.ID,
+		"transfer_number":   transfer.Number,
+		"date":              transfer.Date.Format("2006-01-02"),
+		"from_warehouse_id": transfer.FromWarehouseID,
+		"to_warehouse_id":   transfer.ToWarehouseID,
+		"user":              transfer.User.Username,
+		"note":              transfer.Note,
+		"items":             items,
+	}
+	if transfer.FromWarehouse != nil {
+		data["from_warehouse"] = transfer.FromWarehouse.Code
+	}
+	if transfer.ToWarehouse != nil {
+		data["to_warehouse"] = transfer.ToWarehouse.Code
+	}
+	return data
+}
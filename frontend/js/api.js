@@ -16,6 +16,24 @@ function removeToken() {
   localStorage.removeItem("jwt_token");
 }
 
+function getRefreshToken() {
+  return localStorage.getItem("refresh_token");
+}
+
+function setRefreshToken(token) {
+  localStorage.setItem("refresh_token", token);
+}
+
+function removeRefreshToken() {
+  localStorage.removeItem("refresh_token");
+}
+
+// Store the tokens of a login or refresh response
+function setSession(data) {
+  setToken(data.token);
+  setRefreshToken(data.refresh_token);
+}
+
 function getUser() {
   const user = localStorage.getItem("user");
   return user ? JSON.parse(user) : null;
@@ -30,11 +48,60 @@ function removeUser() {
 }
 
 function logout() {
+  const token = getToken();
+  if (token) {
+    // Revoke the session server-side; keepalive lets the request outlive
+    // the page
+    fetch(API_BASE_URL + "/auth/logout", {
+      method: "POST",
+      headers: { Authorization: "Bearer " + token },
+      keepalive: true,
+    }).catch(function () {});
+  }
+
   removeToken();
+  removeRefreshToken();
   removeUser();
   window.location.href = "index.html";
 }
 
+function sessionExpired() {
+  toastr.error("Session expired. Please login again.");
+  logout();
+}
+
+// Pending refresh request, shared by requests that fail at the same time
+let refreshing = null;
+
+/**
+ * Exchange the refresh token for a new access token
+ * @returns {jqXHR} jQuery AJAX promise
+ */
+function refreshSession() {
+  if (!refreshing) {
+    refreshing = $.ajax({
+      url: API_BASE_URL + "/auth/refresh",
+      method: "POST",
+      contentType: "application/json",
+      dataType: "json",
+      data: JSON.stringify({ refresh_token: getRefreshToken() }),
+    })
+      .done(function (response) {
+        setSession(response.data);
+        setUser(response.data.user);
+      })
+      .always(function () {
+        refreshing = null;
+      });
+  }
+  return refreshing;
+}
+
+// Whether a request that failed with 401 should be retried after refreshing
+function canRefresh(endpoint, retried) {
+  return !retried && getRefreshToken() && !endpoint.startsWith("/auth/");
+}
+
 // Check if user is authenticated
 function requireAuth() {
   if (!getToken()) {
@@ -87,14 +154,63 @@ const api = {
     return this.request("DELETE", endpoint);
   },
 
+  /**
+   * Download a file (e.g. a CSV export) with the Authorization header
+   * @param {string} endpoint - API endpoint (without base URL)
+   * @param {string} filename - Name to save the file as
+   * @returns {Promise} Resolves once the download has started
+   */
+  download: function (endpoint, filename) {
+    return this.fetchBlob(endpoint).then(function (blob) {
+      const link = document.createElement("a");
+      link.href = URL.createObjectURL(blob);
+      link.download = filename;
+      link.click();
+      URL.revokeObjectURL(link.href);
+    });
+  },
+
+  /**
+   * Fetch a file with the Authorization header, refreshing the session once
+   * if the access token has expired
+   * @param {string} endpoint - API endpoint (without base URL)
+   * @param {boolean} retried - Whether this is the retry after a refresh
+   * @returns {Promise<Blob>} The file contents
+   */
+  fetchBlob: function (endpoint, retried = false) {
+    return fetch(API_BASE_URL + endpoint, {
+      headers: { Authorization: "Bearer " + getToken() },
+    }).then(function (response) {
+      if (response.status === 401) {
+        if (canRefresh(endpoint, retried)) {
+          return Promise.resolve(refreshSession()).then(
+            function () {
+              return api.fetchBlob(endpoint, true);
+            },
+            function () {
+              sessionExpired();
+              throw new Error("Download failed");
+            },
+          );
+        }
+        sessionExpired();
+      }
+      if (!response.ok) {
+        throw new Error("Download failed");
+      }
+      return response.blob();
+    });
+  },
+
   /**
    * Core AJAX request function
    * @param {string} method - HTTP method
    * @param {string} endpoint - API endpoint
    * @param {object} data - Optional request body
-   * @returns {jqXHR} jQuery AJAX promise
+   * @param {boolean} retried - Whether this is the retry after a refresh
+   * @returns {Promise} jQuery promise
    */
-  request: function (method, endpoint, data = null) {
+  request: function (method, endpoint, data = null, retried = false) {
     const url = API_BASE_URL + endpoint;
     const token = getToken();
 
@@ -118,21 +234,41 @@ const api = {
     }
 
     // Create AJAX request with error handling
-    return $.ajax(options).fail(function (xhr) {
-      // Handle 401 Unauthorized - redirect to login
-      if (xhr.status === 401) {
-        toastr.error("Session expired. Please login again.");
-        logout();
-      }
-    });
+    const deferred = $.Deferred();
+    $.ajax(options)
+      .done(deferred.resolve)
+      .fail(function (xhr, status, error) {
+        // Handle 401 Unauthorized - refresh the session once and retry,
+        // otherwise redirect to login
+        if (xhr.status === 401 && canRefresh(endpoint, retried)) {
+          refreshSession()
+            .done(function () {
+              api
+                .request(method, endpoint, data, true)
+                .done(deferred.resolve)
+                .fail(deferred.reject);
+            })
+            .fail(function () {
+              sessionExpired();
+              deferred.reject(xhr, status, error);
+            });
+          return;
+        }
+        // Requests made without a session, like logins, just fail
+        if (xhr.status === 401 && token) {
+          sessionExpired();
+        }
+        deferred.reject(xhr, status, error);
+      });
+    return deferred.promise();
   },
 };
 
-// Format currency (IDR)
-function formatCurrency(amount) {
+// Format currency (IDR unless another ISO 4217 code is given)
+function formatCurrency(amount, currency = "IDR") {
   return new Intl.NumberFormat("id-ID", {
     style: "currency",
-    currency: "IDR",
+    currency: currency,
     minimumFractionDigits: 0,
   }).format(amount);
 }